@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 
 	"github.com/safepointcloud/safepanel/internal/rpc"
@@ -15,9 +16,12 @@ var (
 )
 
 func main() {
+	socketPath := flag.String("socket", "/var/run/safepanel.sock", "path of the safepaneld RPC unix socket")
+	flag.Parse()
+
 	log.Printf("SafePanel Blocker %s (%s) built at %s", version, commit, date)
 
-	client, err := rpc.NewClient("/var/run/safepanel.sock")
+	client, err := rpc.NewClient(*socketPath)
 	if err != nil {
 		log.Fatalf("Failed to connect to safepanel daemon: %v", err)
 	}
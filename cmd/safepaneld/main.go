@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,12 +13,14 @@ import (
 
 	_ "net/http/pprof"
 
+	loganalyzer "github.com/safepointcloud/safepanel/internal/analyzer/log"
 	"github.com/safepointcloud/safepanel/internal/analyzer/network"
 	"github.com/safepointcloud/safepanel/internal/blocker"
 	"github.com/safepointcloud/safepanel/internal/config"
 	"github.com/safepointcloud/safepanel/internal/rpc"
 	"github.com/safepointcloud/safepanel/pkg/ipdb"
 	"github.com/safepointcloud/safepanel/pkg/mmdb"
+	"github.com/safepointcloud/safepanel/pkg/models"
 )
 
 var (
@@ -27,6 +30,12 @@ var (
 )
 
 func main() {
+	replayFile := flag.String("replay", "", "replay packets from a pcap/pcapng file instead of capturing live")
+	replayRealtime := flag.Bool("replay-realtime", false, "replay packets at their original timing")
+	replayExit := flag.Bool("replay-exit", false, "exit once the replay file has been consumed")
+	socketPath := flag.String("socket", "/var/run/safepanel.sock", "path of the RPC unix socket")
+	flag.Parse()
+
 	log.Printf("SafePaneld %s (%s) built at %s", version, commit, date)
 
 	// Initialize config
@@ -52,9 +61,22 @@ func main() {
 
 	// initialize IP analyzer
 	analyzerConfig := &network.Config{
-		Interface:   cfg.Analyzer.Network.IP.Interface,
-		BufferSize:  cfg.Analyzer.Network.IP.BufferSize,
-		Promiscuous: cfg.Analyzer.Network.IP.Promiscuous,
+		Interfaces:    captureInterfaces(cfg),
+		Filter:        cfg.Analyzer.Network.IP.Filter,
+		SYNFlood:      synFloodConfig(cfg),
+		SSHBruteForce: sshBruteForceConfig(cfg),
+		SSHPorts:      cfg.Analyzer.Network.SSH.Ports,
+		TLSPorts:      cfg.Analyzer.Network.TLS.Ports,
+		HTTPPorts:     cfg.Analyzer.Network.HTTP.Ports,
+		MySQLPorts:    cfg.Analyzer.Network.Database.MySQLPorts,
+		PostgresPorts: cfg.Analyzer.Network.Database.PostgresPorts,
+		RedisPorts:    cfg.Analyzer.Network.Database.RedisPorts,
+
+		ReplayFile:     *replayFile,
+		ReplayRealtime: *replayRealtime,
+	}
+	if *replayFile != "" {
+		log.Printf("Replaying packets from %s", *replayFile)
 	}
 
 	analyzer, err := network.NewIPAnalyzer(analyzerConfig)
@@ -68,26 +90,159 @@ func main() {
 		NFTables:   cfg.Blocker.IP.NFTables,
 		Whitelist:  cfg.Blocker.IP.Whitelist,
 		DefaultTTL: time.Hour,
+		Escalation: escalationConfig(cfg),
 	}
 	blocker := blocker.NewIPBlocker(blockerConfig)
+	// threat databases are optional when replaying so the pipeline can run in CI
 	ipdb, err := ipdb.NewIPDB(cfg.Checker.IPDBPath)
 	if err != nil {
-		log.Fatalf("Failed to load IPDB: %v", err)
+		if *replayFile == "" {
+			log.Fatalf("Failed to load IPDB: %v", err)
+		}
+		log.Printf("Failed to load IPDB, IP checks disabled: %v", err)
 	}
 	mmdb, err := mmdb.NewMMDB(cfg.Checker.MMDBPath)
 	if err != nil {
-		log.Fatalf("Failed to load MMDB: %v", err)
+		if *replayFile == "" {
+			log.Fatalf("Failed to load MMDB: %v", err)
+		}
+		log.Printf("Failed to load MMDB, country lookups disabled: %v", err)
 	}
 	checker := network.NewIPChecker(ipdb, mmdb)
 
 	manager := network.NewAnalyzerManager(analyzer, blocker, checker)
+	if err := manager.SetJails(jailConfigs(cfg, blockerConfig)); err != nil {
+		log.Fatalf("Failed to create jails: %v", err)
+	}
+	if synFlood := cfg.Analyzer.Network.SYNFlood; synFlood.AutoBlock {
+		manager.SetAutoBlock(models.EventSYNFlood, blockDuration(synFlood.BlockDuration, blockerConfig))
+	}
+	if sshCfg := cfg.Analyzer.Network.SSH; sshCfg.AutoBlock {
+		manager.SetAutoBlock(models.EventSSHBruteForce, blockDuration(sshCfg.BlockDuration, blockerConfig))
+	}
+	manager.SetScanDetection(scanConfig(cfg))
+	if portScan := cfg.Analyzer.Network.PortScan; portScan.AutoBlock {
+		duration := blockDuration(portScan.BlockDuration, blockerConfig)
+		manager.SetAutoBlock(models.EventVerticalScan, duration)
+		manager.SetAutoBlock(models.EventHorizontalScan, duration)
+		manager.SetAutoBlock(models.EventSlowScan, duration)
+	}
+	manager.SetDNSTunnelDetection(dnsTunnelConfig(cfg))
+	manager.SetHTTPProbeDetection(httpProbeConfig(cfg))
+	if httpCfg := cfg.Analyzer.Network.HTTP; httpCfg.AutoBlock {
+		manager.SetAutoBlock(models.EventHTTPProbe, blockDuration(httpCfg.BlockDuration, blockerConfig))
+	}
+	manager.SetDBDetection(dbConfig(cfg))
+	if dbCfg := cfg.Analyzer.Network.Database; dbCfg.AutoBlock {
+		duration := blockDuration(dbCfg.BlockDuration, blockerConfig)
+		manager.SetAutoBlock(models.EventDBBruteForce, duration)
+		manager.SetAutoBlock(models.EventDBExploit, duration)
+	}
+	manager.SetDGADetection(dgaConfig(cfg))
+	if sshLog := cfg.Analyzer.Log.SSH; sshLog.AutoBlock {
+		duration := blockDuration(sshLog.BlockDuration, blockerConfig)
+		manager.SetAutoBlock(models.EventSSHAuthFailure, duration)
+		manager.SetAutoBlock(models.EventSSHBruteForceSuccess, duration)
+	}
+	if webLog := cfg.Analyzer.Log.Web; webLog.AutoBlock {
+		duration := blockDuration(webLog.BlockDuration, blockerConfig)
+		manager.SetAutoBlock(models.EventWebRequestFlood, duration)
+		manager.SetAutoBlock(models.EventWebErrorSpike, duration)
+	}
+	if path := cfg.Checker.TLSBlocklistPath; path != "" {
+		blocklist, err := network.LoadFingerprintBlocklist(path)
+		if err != nil {
+			log.Fatalf("Failed to load TLS fingerprint blocklist: %v", err)
+		}
+		log.Printf("Loaded %d TLS fingerprints from %s", len(blocklist), path)
+		manager.SetFingerprintBlocklist(blocklist)
+	}
+	if path := cfg.Analyzer.WebAttack.RulesPath; path != "" {
+		rules, err := network.LoadSignatureRules(path)
+		if err != nil {
+			log.Fatalf("Failed to load web attack rules: %v", err)
+		}
+		log.Printf("Loaded %d web attack rules from %s", rules.Len(), path)
+		manager.SetWebAttackDetection(rules, webAttackConfig(cfg))
+		if webAttack := cfg.Analyzer.WebAttack; webAttack.AutoBlock {
+			manager.SetAutoBlock(models.EventWebAttack, blockDuration(webAttack.BlockDuration, blockerConfig))
+		}
+	}
 	if err := manager.Start(ctx); err != nil {
 		log.Fatalf("Failed to start analyzer manager: %v", err)
 	}
 
+	// the log analyzers are optional, a missing log does not stop the daemon
+	var handlers []loganalyzer.MessageHandler
+	if cfg.Analyzer.Log.SSH.Enabled {
+		sshLog := loganalyzer.NewSSHAnalyzer(sshLogConfig(cfg))
+		sshLog.SetAuthCallback(manager.AddSSHAuthEvent)
+		sshLog.SetEventCallback(manager.HandleEvent)
+		if err := sshLog.Start(ctx); err != nil {
+			log.Printf("Failed to start SSH log analyzer: %v", err)
+		}
+		handlers = append(handlers, sshLog)
+	}
+	if cfg.Analyzer.Log.Web.Enabled {
+		webLog, err := loganalyzer.NewWebAnalyzer(webLogConfig(cfg))
+		if err != nil {
+			log.Fatalf("Failed to create web log analyzer: %v", err)
+		}
+		webLog.SetRequestCallback(manager.AddWebLogRequest)
+		webLog.SetEventCallback(manager.HandleEvent)
+		if err := webLog.Start(ctx); err != nil {
+			log.Printf("Failed to start web log analyzer: %v", err)
+		}
+		handlers = append(handlers, webLog)
+	}
+
+	for _, source := range cfg.Analyzer.Log.Custom {
+		custom, err := loganalyzer.NewCustomAnalyzer(customLogConfig(source))
+		if err != nil {
+			log.Fatalf("Failed to create log analyzer: %v", err)
+		}
+		custom.SetMatchCallback(manager.AddLogMatch)
+		custom.SetEventCallback(manager.HandleEvent)
+		if source.AutoBlock {
+			manager.SetAutoBlock(models.LogEventType(source.Name), blockDuration(source.BlockDuration, blockerConfig))
+		}
+		if err := custom.Start(ctx); err != nil {
+			log.Printf("Failed to start log analyzer: %v", err)
+		}
+		handlers = append(handlers, custom)
+	}
+	if cfg.Analyzer.Log.Journal.Enabled || cfg.Analyzer.Log.Syslog.Enabled {
+		system := loganalyzer.NewSystemAnalyzer()
+		system.SetEventCallback(manager.HandleEvent)
+		handlers = append(handlers, system)
+	}
+	if cfg.Analyzer.Log.Journal.Enabled {
+		journal := loganalyzer.NewJournalReader(journalConfig(cfg))
+		for _, handler := range handlers {
+			journal.AddHandler(handler)
+		}
+		if err := journal.Start(ctx); err != nil {
+			log.Printf("Failed to start journal reader: %v", err)
+		} else {
+			defer journal.Stop()
+		}
+	}
+	if cfg.Analyzer.Log.Syslog.Enabled {
+		syslog, err := loganalyzer.NewSyslogServer(syslogConfig(cfg))
+		if err != nil {
+			log.Fatalf("Failed to create syslog receiver: %v", err)
+		}
+		for _, handler := range handlers {
+			syslog.AddHandler(handler)
+		}
+		if err := syslog.Start(ctx); err != nil {
+			log.Printf("Failed to start syslog receiver: %v", err)
+		}
+	}
+
 	// start RPC server
 	server := rpc.NewStatsServer(manager)
-	if err := server.Start("/var/run/safepanel.sock"); err != nil {
+	if err := server.Start(*socketPath); err != nil {
 		log.Fatalf("Failed to start RPC server: %v", err)
 	}
 	defer server.Stop()
@@ -95,5 +250,412 @@ func main() {
 	// wait for signal
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+
+	// only exit at the end of a replay when asked to, so the results can
+	// still be inspected with the TUI tools otherwise
+	var replayDone <-chan struct{}
+	if *replayFile != "" && *replayExit {
+		replayDone = analyzer.Done()
+	}
+
+	select {
+	case <-sigChan:
+	case <-replayDone:
+		connections, _ := manager.GetNewConnections()
+		queries, _ := manager.GetDNSQueries()
+		log.Printf("Replay finished: %d connections, %d DNS queries", len(connections), len(queries))
+	}
+}
+
+// captureInterfaces merges the per-interface settings with the defaults of
+// the ip section; the single interface setting is used if none are listed
+func captureInterfaces(cfg *config.Config) []network.InterfaceConfig {
+	ipCfg := cfg.Analyzer.Network.IP
+
+	ifaces := ipCfg.Interfaces
+	if len(ifaces) == 0 && ipCfg.Interface != "" {
+		ifaces = []config.InterfaceConfig{{Name: ipCfg.Interface}}
+	}
+
+	defaultSnapLen := snapLen("analyzer.network.ip", ipCfg.SnapLen, ipCfg.BufferSize)
+	result := make([]network.InterfaceConfig, 0, len(ifaces))
+	for _, iface := range ifaces {
+		ifaceConfig := network.InterfaceConfig{
+			Name:        iface.Name,
+			SnapLen:     defaultSnapLen,
+			Promiscuous: ipCfg.Promiscuous,
+			Filter:      iface.Filter,
+		}
+		if n := snapLen("interface "+iface.Name, iface.SnapLen, iface.BufferSize); n > 0 {
+			ifaceConfig.SnapLen = n
+		}
+		if iface.Promiscuous != nil {
+			ifaceConfig.Promiscuous = iface.Promiscuous
+		}
+		result = append(result, ifaceConfig)
+	}
+	return result
+}
+
+// snapLen returns the snaplen of a section, falling back to buffer_size, the
+// name snaplen had before it was renamed. The socket buffer of the capture
+// cannot be set, so buffer_size never configured anything else.
+func snapLen(section string, snapLen, bufferSize int32) int32 {
+	if snapLen > 0 || bufferSize <= 0 {
+		return snapLen
+	}
+	log.Printf("Warning: buffer_size of %s is deprecated, use snaplen instead", section)
+	return bufferSize
+}
+
+// synFloodConfig applies the syn_flood section on top of the detector defaults
+func synFloodConfig(cfg *config.Config) network.SYNFloodConfig {
+	synCfg := cfg.Analyzer.Network.SYNFlood
+
+	result := network.DefaultSYNFloodConfig()
+	if synCfg.Enabled != nil {
+		result.Enabled = *synCfg.Enabled
+	}
+	if synCfg.Window > 0 {
+		result.Window = synCfg.Window
+	}
+	if synCfg.MinSYNs > 0 {
+		result.MinSYNs = synCfg.MinSYNs
+	}
+	if synCfg.PortMinSYNs > 0 {
+		result.PortMinSYNs = synCfg.PortMinSYNs
+	}
+	if synCfg.HalfOpenRatio > 0 {
+		result.HalfOpenRatio = synCfg.HalfOpenRatio
+	}
+	return result
+}
+
+// sshBruteForceConfig applies the ssh section on top of the detector defaults
+func sshBruteForceConfig(cfg *config.Config) network.SSHBruteForceConfig {
+	sshCfg := cfg.Analyzer.Network.SSH
+
+	result := network.DefaultSSHBruteForceConfig()
+	if sshCfg.BruteForceEnabled != nil {
+		result.Enabled = *sshCfg.BruteForceEnabled
+	}
+	if sshCfg.Window > 0 {
+		result.Window = sshCfg.Window
+	}
+	if sshCfg.MinAttempts > 0 {
+		result.MinAttempts = sshCfg.MinAttempts
+	}
+	if sshCfg.MaxDuration > 0 {
+		result.MaxDuration = sshCfg.MaxDuration
+	}
+	if sshCfg.MaxBytes > 0 {
+		result.MaxBytes = sshCfg.MaxBytes
+	}
+	return result
+}
+
+// webAttackConfig applies the web_attack section on top of the detector
+// defaults
+func webAttackConfig(cfg *config.Config) network.WebAttackConfig {
+	webAttack := cfg.Analyzer.WebAttack
+
+	result := network.DefaultWebAttackConfig()
+	if webAttack.Window > 0 {
+		result.Window = webAttack.Window
+	}
+	if webAttack.MinScore > 0 {
+		result.MinScore = webAttack.MinScore
+	}
+	return result
+}
+
+// sshLogConfig applies the log ssh section on top of the analyzer defaults
+func sshLogConfig(cfg *config.Config) loganalyzer.SSHConfig {
+	sshLog := cfg.Analyzer.Log.SSH
+
+	result := loganalyzer.DefaultSSHConfig()
+	result.Enabled = sshLog.Enabled
+	result.FromStart = sshLog.FromStart
+	if len(sshLog.Paths) > 0 {
+		result.Paths = sshLog.Paths
+	} else if cfg.Analyzer.Log.Journal.Enabled {
+		// sshd messages are read from the journal instead
+		result.Paths = nil
+	}
+	if sshLog.Window > 0 {
+		result.Window = sshLog.Window
+	}
+	if sshLog.MaxFailures > 0 {
+		result.MaxFailures = sshLog.MaxFailures
+	}
+	return result
+}
+
+// journalConfig applies the log journal section on top of the reader defaults
+func journalConfig(cfg *config.Config) loganalyzer.JournalConfig {
+	journal := cfg.Analyzer.Log.Journal
+
+	result := loganalyzer.DefaultJournalConfig()
+	result.Enabled = journal.Enabled
+	result.Directory = journal.Directory
+	result.Files = journal.Files
+	result.FromStart = journal.FromStart
+	if len(journal.Command) > 0 {
+		result.Command = journal.Command
+	}
+	if len(journal.Identifiers) > 0 {
+		result.Identifiers = journal.Identifiers
+	}
+	if journal.Kernel != nil {
+		result.Kernel = *journal.Kernel
+	}
+	if journal.CursorFile != "" {
+		result.CursorFile = journal.CursorFile
+	}
+	return result
+}
+
+// syslogConfig applies the log syslog section on top of the receiver defaults
+func syslogConfig(cfg *config.Config) loganalyzer.SyslogConfig {
+	syslog := cfg.Analyzer.Log.Syslog
+
+	result := loganalyzer.DefaultSyslogConfig()
+	result.Enabled = syslog.Enabled
+	result.Allow = syslog.Allow
+	if syslog.UDP != nil {
+		result.UDP = *syslog.UDP
+	}
+	if syslog.TCP != nil {
+		result.TCP = *syslog.TCP
+	}
+	if syslog.MaxConnections > 0 {
+		result.MaxConnections = syslog.MaxConnections
+	}
+	return result
+}
+
+// customLogConfig applies a log custom entry on top of the analyzer defaults
+func customLogConfig(source config.CustomLogConfig) loganalyzer.CustomConfig {
+	result := loganalyzer.DefaultCustomConfig()
+	result.Name = source.Name
+	result.Path = source.Path
+	result.Program = source.Program
+	result.FromStart = source.FromStart
+	result.Patterns = source.Patterns
+	if source.IPField != "" {
+		result.IPField = source.IPField
+	}
+	if source.Window > 0 {
+		result.Window = source.Window
+	}
+	if source.MaxMatches > 0 {
+		result.MaxMatches = source.MaxMatches
+	}
+	if source.Severity != "" {
+		result.Severity = models.Severity(source.Severity)
+	}
+	return result
+}
+
+// webLogConfig applies the log web section on top of the analyzer defaults
+func webLogConfig(cfg *config.Config) loganalyzer.WebConfig {
+	webLog := cfg.Analyzer.Log.Web
+
+	result := loganalyzer.DefaultWebConfig()
+	result.Enabled = webLog.Enabled
+	result.FromStart = webLog.FromStart
+	if len(webLog.Logs) > 0 {
+		result.Logs = make([]loganalyzer.WebLog, len(webLog.Logs))
+		for i, l := range webLog.Logs {
+			result.Logs[i] = loganalyzer.WebLog{Name: l.Name, Path: l.Path, Program: l.Program, Format: l.Format}
+		}
+	}
+	if webLog.Window > 0 {
+		result.Window = webLog.Window
+	}
+	if webLog.MaxRequests > 0 {
+		result.MaxRequests = webLog.MaxRequests
+	}
+	if webLog.MinErrors > 0 {
+		result.MinErrors = webLog.MinErrors
+	}
+	if webLog.SpikeFactor > 1 {
+		result.SpikeFactor = webLog.SpikeFactor
+	}
+	return result
+}
+
+// scanConfig applies the port_scan section on top of the detector defaults
+func scanConfig(cfg *config.Config) network.ScanConfig {
+	scanCfg := cfg.Analyzer.Network.PortScan
+
+	result := network.DefaultScanConfig()
+	if scanCfg.Enabled != nil {
+		result.Enabled = *scanCfg.Enabled
+	}
+	if scanCfg.Interval > 0 {
+		result.Interval = scanCfg.Interval
+	}
+	if scanCfg.VerticalPorts > 0 {
+		result.VerticalPorts = scanCfg.VerticalPorts
+	}
+	if scanCfg.HorizontalHosts > 0 {
+		result.HorizontalHosts = scanCfg.HorizontalHosts
+	}
+	if scanCfg.SlowTargets > 0 {
+		result.SlowTargets = scanCfg.SlowTargets
+	}
+	if scanCfg.SlowHorizon > 0 {
+		result.SlowHorizon = scanCfg.SlowHorizon
+	}
+	result.IncludeOutbound = scanCfg.IncludeOutbound
+	return result
+}
+
+// dnsTunnelConfig applies the dns_tunnel section on top of the detector defaults
+func dnsTunnelConfig(cfg *config.Config) network.DNSTunnelConfig {
+	tunnelCfg := cfg.Analyzer.Network.DNSTunnel
+
+	result := network.DefaultDNSTunnelConfig()
+	if tunnelCfg.Enabled != nil {
+		result.Enabled = *tunnelCfg.Enabled
+	}
+	if tunnelCfg.Window > 0 {
+		result.Window = tunnelCfg.Window
+	}
+	if tunnelCfg.MinQueries > 0 {
+		result.MinQueries = tunnelCfg.MinQueries
+	}
+	if tunnelCfg.Entropy > 0 {
+		result.Entropy = tunnelCfg.Entropy
+	}
+	if tunnelCfg.LabelLength > 0 {
+		result.LabelLength = tunnelCfg.LabelLength
+	}
+	if tunnelCfg.ScoreThreshold > 0 {
+		result.ScoreThreshold = tunnelCfg.ScoreThreshold
+	}
+	result.Ignore = tunnelCfg.Ignore
+	return result
+}
+
+// dgaConfig applies the dga section on top of the classifier defaults
+func dgaConfig(cfg *config.Config) network.DGAConfig {
+	dgaCfg := cfg.Analyzer.Network.DGA
+
+	result := network.DefaultDGAConfig()
+	if dgaCfg.Enabled != nil {
+		result.Enabled = *dgaCfg.Enabled
+	}
+	if dgaCfg.Threshold > 0 {
+		result.Threshold = dgaCfg.Threshold
+	}
+	if dgaCfg.Window > 0 {
+		result.Window = dgaCfg.Window
+	}
+	if dgaCfg.NXDomainBurst > 0 {
+		result.NXDomainBurst = dgaCfg.NXDomainBurst
+	}
+	if dgaCfg.MinSuspicious > 0 {
+		result.MinSuspicious = dgaCfg.MinSuspicious
+	}
+	result.Ignore = dgaCfg.Ignore
+	return result
+}
+
+// httpProbeConfig applies the http section on top of the detector defaults
+func httpProbeConfig(cfg *config.Config) network.HTTPProbeConfig {
+	httpCfg := cfg.Analyzer.Network.HTTP
+
+	result := network.DefaultHTTPProbeConfig()
+	if httpCfg.ProbeEnabled != nil {
+		result.Enabled = *httpCfg.ProbeEnabled
+	}
+	if httpCfg.ProbeWindow > 0 {
+		result.Window = httpCfg.ProbeWindow
+	}
+	if httpCfg.MinProbes > 0 {
+		result.MinProbes = httpCfg.MinProbes
+	}
+	result.Paths = httpCfg.ProbePaths
+	return result
+}
+
+// dbConfig applies the database section on top of the detector defaults
+func dbConfig(cfg *config.Config) network.DBConfig {
+	dbCfg := cfg.Analyzer.Network.Database
+
+	result := network.DefaultDBConfig()
+	if dbCfg.DetectionEnabled != nil {
+		result.Enabled = *dbCfg.DetectionEnabled
+	}
+	if dbCfg.Window > 0 {
+		result.Window = dbCfg.Window
+	}
+	if dbCfg.MaxFailedLogins > 0 {
+		result.MaxFailedLogins = dbCfg.MaxFailedLogins
+	}
+	result.Patterns = dbCfg.ExploitPatterns
+	return result
+}
+
+// escalationConfig applies the escalation section on top of the blocker
+// defaults
+func escalationConfig(cfg *config.Config) blocker.EscalationConfig {
+	escalation := cfg.Blocker.IP.Escalation
+
+	result := blocker.DefaultEscalationConfig()
+	if escalation.Enabled != nil {
+		result.Enabled = *escalation.Enabled
+	}
+	if escalation.Factor >= 1 {
+		result.Factor = escalation.Factor
+	}
+	if escalation.NetworkWeight != nil {
+		result.NetworkWeight = *escalation.NetworkWeight
+	}
+	if escalation.MaxDuration > 0 {
+		result.MaxDuration = escalation.MaxDuration
+	}
+	if escalation.HistoryTTL > 0 {
+		result.HistoryTTL = escalation.HistoryTTL
+	}
+	if escalation.HistoryFile != nil {
+		result.HistoryFile = *escalation.HistoryFile
+	}
+	return result
+}
+
+// jailConfigs applies the jails of the blocker section on top of the jail
+// defaults
+func jailConfigs(cfg *config.Config, blockerConfig *blocker.BlockerConfig) []network.JailConfig {
+	result := make([]network.JailConfig, 0, len(cfg.Blocker.Jails))
+	for _, jailCfg := range cfg.Blocker.Jails {
+		jail := network.DefaultJailConfig()
+		jail.Name = jailCfg.Name
+		jail.Events = jailCfg.Events
+		jail.Details = jailCfg.Details
+		jail.Whitelist = jailCfg.Whitelist
+		jail.BanTime = blockDuration(jailCfg.BanTime, blockerConfig)
+		if jailCfg.MinSeverity != "" {
+			jail.MinSeverity = models.Severity(jailCfg.MinSeverity)
+		}
+		if jailCfg.MaxRetry > 0 {
+			jail.MaxRetry = jailCfg.MaxRetry
+		}
+		if jailCfg.FindTime > 0 {
+			jail.FindTime = jailCfg.FindTime
+		}
+		result = append(result, jail)
+	}
+	return result
+}
+
+// blockDuration returns the configured duration of an automatic block, or
+// the blocker default if unset
+func blockDuration(duration time.Duration, blockerConfig *blocker.BlockerConfig) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	return blockerConfig.DefaultTTL
 }
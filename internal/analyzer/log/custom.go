@@ -0,0 +1,290 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// CustomConfig configures a user-defined log source, a log file or the
+// messages of a program, whose lines matching a pattern are counted per
+// offending address
+type CustomConfig struct {
+	Name       string
+	Path       string          // log file to follow
+	Program    string          // syslog tag of the messages received over syslog or from the journal
+	FromStart  bool            // read the lines already in the file at startup
+	Patterns   []string        // regular expressions with named captures
+	IPField    string          // capture holding the offending address
+	Window     time.Duration   // window the matches of an address are counted in
+	MaxMatches int             // matches per address and window that trigger an event
+	Severity   models.Severity // severity of the events
+}
+
+// DefaultCustomConfig returns the settings used for unset values
+func DefaultCustomConfig() CustomConfig {
+	return CustomConfig{
+		IPField:    "ip",
+		Window:     10 * time.Minute,
+		MaxMatches: 5,
+		Severity:   models.SeverityMedium,
+	}
+}
+
+const (
+	// maxCustomSources bounds the number of addresses tracked per log source
+	maxCustomSources = 10000
+	// maxCustomEvidence bounds the hosts listed in an event
+	maxCustomEvidence = 50
+)
+
+// customMatchStats counts the matches of an address within the window
+type customMatchStats struct {
+	start     time.Time
+	last      time.Time
+	matches   int
+	hosts     map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// CustomAnalyzer matches the lines of a user-defined log source against its
+// patterns, reports every match and raises an event of the type
+// models.LogEventType(name) for addresses matching too often
+type CustomAnalyzer struct {
+	config   CustomConfig
+	patterns []*regexp.Regexp
+	onMatch  func(*models.LogMatch)
+	onEvent  func(*models.Event)
+	sources  map[string]*customMatchStats
+	mutex    sync.Mutex
+}
+
+func NewCustomAnalyzer(config CustomConfig) (*CustomAnalyzer, error) {
+	defaults := DefaultCustomConfig()
+	if config.IPField == "" {
+		config.IPField = defaults.IPField
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MaxMatches <= 0 {
+		config.MaxMatches = defaults.MaxMatches
+	}
+	if config.Severity == "" {
+		config.Severity = defaults.Severity
+	}
+
+	switch {
+	case config.Name == "":
+		return nil, fmt.Errorf("log source without name")
+	case config.Path == "" && config.Program == "":
+		return nil, fmt.Errorf("log source %s: neither path nor program set", config.Name)
+	case len(config.Patterns) == 0:
+		return nil, fmt.Errorf("log source %s: no patterns", config.Name)
+	}
+	config.Severity = models.Severity(strings.ToLower(string(config.Severity)))
+	switch config.Severity {
+	case models.SeverityLow, models.SeverityMedium, models.SeverityHigh, models.SeverityCritical:
+	default:
+		return nil, fmt.Errorf("log source %s: invalid severity %q", config.Name, config.Severity)
+	}
+
+	a := &CustomAnalyzer{
+		config:  config,
+		sources: make(map[string]*customMatchStats),
+	}
+	for _, pattern := range config.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("log source %s: invalid pattern %q: %v", config.Name, pattern, err)
+		}
+		if re.SubexpIndex(config.IPField) < 0 {
+			return nil, fmt.Errorf("log source %s: pattern %q does not capture %s", config.Name, pattern, config.IPField)
+		}
+		a.patterns = append(a.patterns, re)
+	}
+	return a, nil
+}
+
+func (a *CustomAnalyzer) SetMatchCallback(callback func(*models.LogMatch)) {
+	a.onMatch = callback
+}
+
+func (a *CustomAnalyzer) SetEventCallback(callback func(*models.Event)) {
+	a.onEvent = callback
+}
+
+// Start follows the log file until ctx is done, without a path the analyzer
+// only handles the messages routed to it by other sources
+func (a *CustomAnalyzer) Start(ctx context.Context) error {
+	go a.runCleanup(ctx)
+	if a.config.Path == "" {
+		return nil
+	}
+	if _, err := os.Stat(a.config.Path); err != nil {
+		return fmt.Errorf("log source %s: %v", a.config.Name, err)
+	}
+
+	go tail(ctx, a.config.Path, a.config.FromStart, func(line string) {
+		a.handleLine(line, "", time.Now())
+	})
+	return nil
+}
+
+// Accepts reports whether a program logs the messages of the source
+func (a *CustomAnalyzer) Accepts(program string) bool {
+	return a.config.Program != "" && program == a.config.Program
+}
+
+func (a *CustomAnalyzer) HandleMessage(m *Message) {
+	a.handleLine(m.Message, m.Host, m.Timestamp)
+}
+
+// handleLine matches a line against the patterns, the first matching one
+// with a valid address counts
+func (a *CustomAnalyzer) handleLine(line, host string, ts time.Time) {
+	for _, re := range a.patterns {
+		m := re.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		ip := m[re.SubexpIndex(a.config.IPField)]
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+
+		fields := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" && m[i] != "" {
+				fields[name] = m[i]
+			}
+		}
+		match := &models.LogMatch{
+			Source:    a.config.Name,
+			SrcIP:     ip,
+			Host:      host,
+			Fields:    fields,
+			Line:      line,
+			Timestamp: ts,
+		}
+		if a.onMatch != nil {
+			a.onMatch(match)
+		}
+		if event := a.addMatch(match); event != nil && a.onEvent != nil {
+			a.onEvent(event)
+		}
+		return
+	}
+}
+
+// addMatch counts the match for the address and returns an event once the
+// matches exceed the limit within the window, and again when they have
+// doubled since
+func (a *CustomAnalyzer) addMatch(match *models.LogMatch) *models.Event {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	ts, window := match.Timestamp, a.config.Window
+	stats, ok := a.sources[match.SrcIP]
+	if !ok {
+		if len(a.sources) >= maxCustomSources {
+			return nil
+		}
+		stats = &customMatchStats{}
+		a.sources[match.SrcIP] = stats
+	}
+	if stats.start.IsZero() || ts.Sub(stats.start) >= window {
+		*stats = customMatchStats{
+			start:     ts,
+			hosts:     make(map[string]struct{}),
+			lastAlert: stats.lastAlert,
+			alerted:   stats.alerted,
+		}
+	}
+	stats.last = ts
+	stats.matches++
+	if match.Host != "" && len(stats.hosts) < maxCustomEvidence {
+		stats.hosts[match.Host] = struct{}{}
+	}
+
+	if stats.matches < a.config.MaxMatches {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < window && stats.matches < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = stats.matches
+
+	hosts := make([]string, 0, len(stats.hosts))
+	for host := range stats.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	target := a.config.Name
+	if len(hosts) == 1 {
+		target = hosts[0]
+	}
+
+	// the captures of the last match are set first, so captures named like
+	// the counts cannot hide them
+	details := make(map[string]string, len(match.Fields)+5)
+	for name, value := range match.Fields {
+		details[name] = value
+	}
+	details["source"] = a.config.Name
+	details["matches"] = strconv.Itoa(stats.matches)
+	details["hosts"] = strings.Join(hosts, ",")
+	details["sample"] = match.Line
+	details["window"] = window.String()
+
+	return &models.Event{
+		Type:     models.LogEventType(a.config.Name),
+		Severity: a.config.Severity,
+		SrcIP:    match.SrcIP,
+		Sources:  []string{match.SrcIP},
+		Target:   target,
+		Score:    min(1, float64(stats.matches)/float64(5*a.config.MaxMatches)),
+		Count:    stats.matches,
+		Message: fmt.Sprintf("%s: %d matching lines from %s in %s",
+			a.config.Name, stats.matches, match.SrcIP, ts.Sub(stats.start).Round(time.Second)),
+		Details:   details,
+		Timestamp: ts,
+	}
+}
+
+func (a *CustomAnalyzer) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup forgets addresses without matches for two windows
+func (a *CustomAnalyzer) cleanup(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for ip, stats := range a.sources {
+		if now.Sub(stats.last) >= 2*a.config.Window {
+			delete(a.sources, ip)
+		}
+	}
+}
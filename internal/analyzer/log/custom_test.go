@@ -0,0 +1,106 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestNewCustomAnalyzer(t *testing.T) {
+	pattern := `auth failure from (?P<ip>\S+)`
+	tests := []struct {
+		name    string
+		config  CustomConfig
+		wantErr bool
+	}{
+		{"valid", CustomConfig{Name: "app", Path: "/var/log/app.log", Patterns: []string{pattern}, Severity: "HIGH"}, false},
+		{"program", CustomConfig{Name: "app", Program: "app", Patterns: []string{pattern}}, false},
+		{"custom ip field", CustomConfig{Name: "app", Program: "app", Patterns: []string{`from (?P<client>\S+)`}, IPField: "client"}, false},
+		{"no name", CustomConfig{Program: "app", Patterns: []string{pattern}}, true},
+		{"no source", CustomConfig{Name: "app", Patterns: []string{pattern}}, true},
+		{"no patterns", CustomConfig{Name: "app", Program: "app"}, true},
+		{"invalid pattern", CustomConfig{Name: "app", Program: "app", Patterns: []string{`from (?P<ip>\S+`}}, true},
+		{"missing ip capture", CustomConfig{Name: "app", Program: "app", Patterns: []string{pattern, `denied (\S+)`}}, true},
+		{"ip capture of another name", CustomConfig{Name: "app", Program: "app", Patterns: []string{pattern}, IPField: "client"}, true},
+		{"invalid severity", CustomConfig{Name: "app", Program: "app", Patterns: []string{pattern}, Severity: "urgent"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewCustomAnalyzer(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCustomAnalyzer() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (a.config.Window != 10*time.Minute || a.config.MaxMatches != 5 || a.config.Severity == "") {
+				t.Errorf("defaults not applied: %+v", a.config)
+			}
+		})
+	}
+}
+
+func TestCustomAnalyzerThreshold(t *testing.T) {
+	a, err := NewCustomAnalyzer(CustomConfig{
+		Name:       "app",
+		Program:    "app",
+		Patterns:   []string{`login failed for (?P<user>\w+) from (?P<ip>\S+)`, `blocked (?P<ip>\S+)`},
+		Window:     time.Minute,
+		MaxMatches: 3,
+		Severity:   models.SeverityHigh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var matches []*models.LogMatch
+	var events []*models.Event
+	a.SetMatchCallback(func(match *models.LogMatch) { matches = append(matches, match) })
+	a.SetEventCallback(func(event *models.Event) { events = append(events, event) })
+
+	start := time.Date(2024, 3, 2, 15, 4, 5, 0, time.UTC)
+	send := func(offset time.Duration, line string) {
+		a.HandleMessage(&Message{Timestamp: start.Add(offset), Host: "web1", Program: "app", Message: line})
+	}
+
+	send(0, "login failed for bob from 203.0.113.5")
+	send(10*time.Second, "login failed for bob from not-an-ip")
+	send(20*time.Second, "blocked 203.0.113.5")
+	send(30*time.Second, "login failed for eve from 203.0.113.6")
+	if len(events) != 0 {
+		t.Fatalf("event below the threshold: %+v", events)
+	}
+	if len(matches) != 3 || matches[0].Fields["user"] != "bob" || matches[1].SrcIP != "203.0.113.5" {
+		t.Fatalf("matches %+v", matches)
+	}
+
+	// the third match within the window raises the event
+	send(40*time.Second, "login failed for alice from 203.0.113.5")
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	event := events[0]
+	if event.Type != models.LogEventType("app") || event.Severity != models.SeverityHigh || event.SrcIP != "203.0.113.5" ||
+		event.Count != 3 || event.Target != "web1" || event.Details["user"] != "alice" || event.Details["matches"] != "3" {
+		t.Errorf("event %+v", event)
+	}
+
+	// further matches alert again only once they doubled
+	send(45*time.Second, "blocked 203.0.113.5")
+	send(50*time.Second, "blocked 203.0.113.5")
+	if len(events) != 1 {
+		t.Fatalf("%d events before the matches doubled", len(events))
+	}
+	send(55*time.Second, "blocked 203.0.113.5")
+	if len(events) != 2 || events[1].Count != 6 {
+		t.Fatalf("events %+v, want a second one for 6 matches", events)
+	}
+
+	// matches of an earlier window do not count
+	send(2*time.Minute, "blocked 203.0.113.6")
+	send(2*time.Minute+time.Second, "blocked 203.0.113.6")
+	if len(events) != 2 {
+		t.Errorf("event for matches of two windows: %+v", events[len(events)-1])
+	}
+	send(2*time.Minute+2*time.Second, "blocked 203.0.113.6")
+	if len(events) != 3 || events[2].SrcIP != "203.0.113.6" {
+		t.Errorf("events %+v, want one for 203.0.113.6", events)
+	}
+}
@@ -0,0 +1,341 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// webField is a field of an access log line the analyzer reads
+type webField int
+
+const (
+	fieldIgnore webField = iota
+	fieldClientIP
+	fieldUser
+	fieldTimeLocal   // "02/Jan/2006:15:04:05 -0700"
+	fieldTimeISO8601 // "2006-01-02T15:04:05-07:00"
+	fieldTimeMsec    // seconds since the epoch with milliseconds
+	fieldRequest     // "GET /path?query HTTP/1.1"
+	fieldMethod
+	fieldURI // path and query
+	fieldPath
+	fieldQuery
+	fieldVersion
+	fieldStatus
+	fieldBytes
+	fieldReferer
+	fieldUserAgent
+	fieldHost
+	fieldSeconds      // request time in seconds
+	fieldMicroseconds // request time in microseconds
+)
+
+// nginxFields maps the nginx variables to fields, others are matched but
+// ignored
+var nginxFields = map[string]webField{
+	"remote_addr":        fieldClientIP,
+	"realip_remote_addr": fieldClientIP,
+	"remote_user":        fieldUser,
+	"time_local":         fieldTimeLocal,
+	"time_iso8601":       fieldTimeISO8601,
+	"msec":               fieldTimeMsec,
+	"request":            fieldRequest,
+	"request_method":     fieldMethod,
+	"request_uri":        fieldURI,
+	"uri":                fieldPath,
+	"document_uri":       fieldPath,
+	"args":               fieldQuery,
+	"query_string":       fieldQuery,
+	"server_protocol":    fieldVersion,
+	"status":             fieldStatus,
+	"body_bytes_sent":    fieldBytes,
+	"bytes_sent":         fieldBytes,
+	"http_referer":       fieldReferer,
+	"http_user_agent":    fieldUserAgent,
+	"host":               fieldHost,
+	"http_host":          fieldHost,
+	"server_name":        fieldHost,
+	"request_time":       fieldSeconds,
+}
+
+// apacheFields maps the Apache LogFormat directives to fields, others are
+// matched but ignored
+var apacheFields = map[string]webField{
+	"h":             fieldClientIP,
+	"a":             fieldClientIP,
+	"{c}a":          fieldClientIP,
+	"u":             fieldUser,
+	"t":             fieldTimeLocal,
+	"r":             fieldRequest,
+	"m":             fieldMethod,
+	"U":             fieldPath,
+	"q":             fieldQuery,
+	"H":             fieldVersion,
+	"s":             fieldStatus,
+	">s":            fieldStatus,
+	"b":             fieldBytes,
+	"B":             fieldBytes,
+	"O":             fieldBytes,
+	"{Referer}i":    fieldReferer,
+	"{User-Agent}i": fieldUserAgent,
+	"v":             fieldHost,
+	"V":             fieldHost,
+	"T":             fieldSeconds,
+	"D":             fieldMicroseconds,
+}
+
+// webLogFormats are the predefined formats of nginx and Apache that may be
+// configured by name
+var webLogFormats = map[string]string{
+	"combined":       `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"`,
+	"common":         `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`,
+	"main":           `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent" "$http_x_forwarded_for"`,
+	"vhost_combined": `%v:%p %h %l %u %t "%r" %>s %O "%{Referer}i" "%{User-Agent}i"`,
+}
+
+var (
+	nginxVariableRe   = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+	apacheDirectiveRe = regexp.MustCompile(`%(?:%|[<>]?(?:\{[^}]*\})?[a-zA-Z])`)
+)
+
+// webLogFormat parses the lines of an access log
+type webLogFormat struct {
+	re     *regexp.Regexp
+	fields []webField // field of each submatch
+}
+
+// formatToken is a literal or a field of a log format
+type formatToken struct {
+	literal string
+	field   webField
+	isField bool
+}
+
+// parseWebLogFormat compiles a predefined format name, an nginx log_format
+// or an Apache LogFormat definition
+func parseWebLogFormat(format string) (*webLogFormat, error) {
+	if predefined, ok := webLogFormats[format]; ok {
+		format = predefined
+	}
+
+	var tokens []formatToken
+	if strings.Contains(format, "$") {
+		tokens = nginxTokens(format)
+	} else {
+		tokens = apacheTokens(format)
+	}
+
+	f := &webLogFormat{}
+	hasClient := false
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i, token := range tokens {
+		if !token.isField {
+			expr.WriteString(regexp.QuoteMeta(token.literal))
+			continue
+		}
+		hasClient = hasClient || token.field == fieldClientIP
+
+		next := ""
+		if i+1 < len(tokens) && !tokens[i+1].isField {
+			next = tokens[i+1].literal
+		}
+		switch {
+		case strings.HasPrefix(next, `"`):
+			// nginx escapes quotes as \x22, Apache as \"
+			expr.WriteString(`((?:[^"\\]|\\.)*)`)
+		case strings.HasPrefix(next, "]"):
+			expr.WriteString(`([^\]]*)`)
+		case i == len(tokens)-1:
+			expr.WriteString(`(.*)`)
+		default:
+			expr.WriteString(`(.*?)`)
+		}
+		f.fields = append(f.fields, token.field)
+	}
+	expr.WriteString("$")
+
+	if !hasClient {
+		return nil, fmt.Errorf("log format %q has no client address", format)
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid log format %q: %v", format, err)
+	}
+	f.re = re
+	return f, nil
+}
+
+func nginxTokens(format string) []formatToken {
+	var tokens []formatToken
+	last := 0
+	for _, m := range nginxVariableRe.FindAllStringSubmatchIndex(format, -1) {
+		if m[0] > last {
+			tokens = append(tokens, formatToken{literal: format[last:m[0]]})
+		}
+		name := ""
+		if m[2] >= 0 {
+			name = format[m[2]:m[3]]
+		} else {
+			name = format[m[4]:m[5]]
+		}
+		tokens = append(tokens, formatToken{field: nginxFields[name], isField: true})
+		last = m[1]
+	}
+	if last < len(format) {
+		tokens = append(tokens, formatToken{literal: format[last:]})
+	}
+	return tokens
+}
+
+func apacheTokens(format string) []formatToken {
+	// LogFormat definitions are usually copied with their escaped quotes
+	format = strings.NewReplacer(`\"`, `"`, `\t`, "\t", `\\`, `\`).Replace(format)
+
+	var tokens []formatToken
+	literal := func(s string) {
+		if n := len(tokens); n > 0 && !tokens[n-1].isField {
+			tokens[n-1].literal += s
+		} else if s != "" {
+			tokens = append(tokens, formatToken{literal: s})
+		}
+	}
+
+	last := 0
+	for _, m := range apacheDirectiveRe.FindAllStringIndex(format, -1) {
+		literal(format[last:m[0]])
+		last = m[1]
+
+		directive := format[m[0]+1 : m[1]]
+		switch {
+		case directive == "%":
+			literal("%")
+		case directive == "t":
+			// %t includes the brackets around the time
+			literal("[")
+			tokens = append(tokens, formatToken{field: fieldTimeLocal, isField: true})
+			literal("]")
+		default:
+			// the < and > modifiers select the original or final request
+			field, ok := apacheFields[directive]
+			if !ok {
+				field = apacheFields[strings.TrimLeft(directive, "<>")]
+			}
+			tokens = append(tokens, formatToken{field: field, isField: true})
+		}
+	}
+	literal(format[last:])
+	return tokens
+}
+
+// parse returns the request a line records, lines not matching the format
+// are rejected
+func (f *webLogFormat) parse(line string) (*models.WebLogRequest, bool) {
+	m := f.re.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+
+	req := &models.WebLogRequest{}
+	for i, field := range f.fields {
+		value := m[i+1]
+		if value == "-" || value == "" {
+			continue
+		}
+		switch field {
+		case fieldClientIP:
+			req.ClientIP = value
+		case fieldUser:
+			req.User = value
+		case fieldTimeLocal:
+			req.Timestamp, _ = time.Parse("02/Jan/2006:15:04:05 -0700", value)
+		case fieldTimeISO8601:
+			req.Timestamp, _ = time.Parse(time.RFC3339, value)
+		case fieldTimeMsec:
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				req.Timestamp = time.UnixMilli(int64(seconds * 1000))
+			}
+		case fieldRequest:
+			parseRequestLine(req, unescapeLogValue(value))
+		case fieldMethod:
+			req.Method = value
+		case fieldURI:
+			req.Path, req.Query, _ = strings.Cut(value, "?")
+		case fieldPath:
+			req.Path = value
+		case fieldQuery:
+			req.Query = strings.TrimPrefix(value, "?")
+		case fieldVersion:
+			req.Version = value
+		case fieldStatus:
+			req.Status, _ = strconv.Atoi(value)
+		case fieldBytes:
+			req.Bytes, _ = strconv.ParseInt(value, 10, 64)
+		case fieldReferer:
+			req.Referer = unescapeLogValue(value)
+		case fieldUserAgent:
+			req.UserAgent = unescapeLogValue(value)
+		case fieldHost:
+			req.Host = value
+		case fieldSeconds:
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				req.Latency = time.Duration(seconds * float64(time.Second))
+			}
+		case fieldMicroseconds:
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil {
+				req.Latency = time.Duration(us) * time.Microsecond
+			}
+		}
+	}
+	if req.ClientIP == "" {
+		return nil, false
+	}
+	return req, true
+}
+
+// parseRequestLine splits the request line, malformed requests like TLS
+// handshakes sent to a plaintext port are kept as the path
+func parseRequestLine(req *models.WebLogRequest, line string) {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/") {
+		req.Path = line
+		return
+	}
+	req.Method, req.Version = parts[0], parts[2]
+	req.Path, req.Query, _ = strings.Cut(parts[1], "?")
+}
+
+// unescapeLogValue decodes the \xHH escapes of nginx and the \" and \\
+// escapes of Apache
+func unescapeLogValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			b.WriteByte(c)
+			continue
+		}
+		if value[i+1] == 'x' && i+3 < len(value) {
+			if n, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		if value[i+1] == '"' || value[i+1] == '\\' {
+			b.WriteByte(value[i+1])
+			i++
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
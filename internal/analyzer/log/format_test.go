@@ -0,0 +1,173 @@
+package log
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestParseWebLogFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		expr    string
+		fields  []webField
+		wantErr bool
+	}{
+		{
+			format: "common",
+			expr:   `^(.*?) - (.*?) \[([^\]]*)\] "((?:[^"\\]|\\.)*)" (.*?) (.*)$`,
+			fields: []webField{fieldClientIP, fieldUser, fieldTimeLocal, fieldRequest, fieldStatus, fieldBytes},
+		},
+		{
+			format: `$http_host ${remote_addr}:$remote_port "$request_uri" $upstream_addr`,
+			expr:   `^(.*?) (.*?):(.*?) "((?:[^"\\]|\\.)*)" (.*)$`,
+			fields: []webField{fieldHost, fieldClientIP, fieldIgnore, fieldURI, fieldIgnore},
+		},
+		{
+			format: `%h %l %u %t \"%r\" %>s %b 100%%`,
+			expr:   `^(.*?) (.*?) (.*?) \[([^\]]*)\] "((?:[^"\\]|\\.)*)" (.*?) (.*?) 100%$`,
+			fields: []webField{fieldClientIP, fieldIgnore, fieldUser, fieldTimeLocal, fieldRequest, fieldStatus, fieldBytes},
+		},
+		{
+			format: `%{c}a %<s %{X-Request-Id}i %D`,
+			expr:   `^(.*?) (.*?) (.*?) (.*)$`,
+			fields: []webField{fieldClientIP, fieldStatus, fieldIgnore, fieldMicroseconds},
+		},
+		{format: `$request $status`, wantErr: true},
+		{format: `%r %s`, wantErr: true},
+	}
+	for _, tt := range tests {
+		f, err := parseWebLogFormat(tt.format)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseWebLogFormat(%q) error = %v, want error %v", tt.format, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if f.re.String() != tt.expr {
+			t.Errorf("parseWebLogFormat(%q) = %s, want %s", tt.format, f.re, tt.expr)
+		}
+		if !reflect.DeepEqual(f.fields, tt.fields) {
+			t.Errorf("parseWebLogFormat(%q) fields %v, want %v", tt.format, f.fields, tt.fields)
+		}
+	}
+}
+
+func TestWebLogFormatParse(t *testing.T) {
+	stamp := time.Date(2024, 3, 2, 15, 4, 5, 0, time.FixedZone("", 3600))
+	tests := []struct {
+		name   string
+		format string
+		line   string
+		want   *models.WebLogRequest
+	}{
+		{
+			name:   "nginx combined",
+			format: "combined",
+			line:   `203.0.113.5 - - [02/Mar/2024:15:04:05 +0100] "GET /index.php?id=1 HTTP/1.1" 200 612 "-" "Mozilla/5.0 (X11; Linux x86_64)"`,
+			want: &models.WebLogRequest{ClientIP: "203.0.113.5", Method: "GET", Path: "/index.php", Query: "id=1",
+				Version: "HTTP/1.1", Status: 200, Bytes: 612, UserAgent: "Mozilla/5.0 (X11; Linux x86_64)", Timestamp: stamp},
+		},
+		{
+			name:   "no bytes sent",
+			format: "combined",
+			line:   `203.0.113.5 - - [02/Mar/2024:15:04:05 +0100] "GET /logo.png HTTP/1.1" 304 - "https://example.com/" "curl/8.5.0"`,
+			want: &models.WebLogRequest{ClientIP: "203.0.113.5", Method: "GET", Path: "/logo.png", Version: "HTTP/1.1",
+				Status: 304, Referer: "https://example.com/", UserAgent: "curl/8.5.0", Timestamp: stamp},
+		},
+		{
+			name:   "nginx escaped quotes",
+			format: "combined",
+			line:   `203.0.113.5 - - [02/Mar/2024:15:04:05 +0100] "GET /?q=\x22><script> HTTP/1.1" 400 0 "-" "sqlmap \x22test\x22"`,
+			want: &models.WebLogRequest{ClientIP: "203.0.113.5", Method: "GET", Path: "/", Query: `q="><script>`,
+				Version: "HTTP/1.1", Status: 400, UserAgent: `sqlmap "test"`, Timestamp: stamp},
+		},
+		{
+			name:   "apache escaped quotes",
+			format: `%h %l %u %t \"%r\" %>s %b \"%{Referer}i\" \"%{User-Agent}i\"`,
+			line:   `198.51.100.4 - - [02/Mar/2024:15:04:05 +0100] "GET /a\"b HTTP/1.0" 404 196 "-" "Mozilla \"evil\" \\agent"`,
+			want: &models.WebLogRequest{ClientIP: "198.51.100.4", Method: "GET", Path: `/a"b`, Version: "HTTP/1.0",
+				Status: 404, Bytes: 196, UserAgent: `Mozilla "evil" \agent`, Timestamp: stamp},
+		},
+		{
+			name:   "common with user",
+			format: "common",
+			line:   `192.0.2.1 - bob [02/Mar/2024:15:04:05 +0100] "POST /login HTTP/1.1" 401 -`,
+			want: &models.WebLogRequest{ClientIP: "192.0.2.1", User: "bob", Method: "POST", Path: "/login",
+				Version: "HTTP/1.1", Status: 401, Timestamp: stamp},
+		},
+		{
+			name:   "TLS handshake on a plaintext port",
+			format: "combined",
+			line:   `192.0.2.1 - - [02/Mar/2024:15:04:05 +0100] "\x16\x03\x01\x00\xa5\x01" 400 157 "-" "-"`,
+			want: &models.WebLogRequest{ClientIP: "192.0.2.1", Path: "\x16\x03\x01\x00\xa5\x01", Status: 400,
+				Bytes: 157, Timestamp: stamp},
+		},
+		{
+			name:   "nginx custom format",
+			format: `$host $remote_addr [$time_iso8601] "$request_method $request_uri $server_protocol" $status $request_time`,
+			line:   `example.com 2001:db8::5 [2024-03-02T15:04:05+01:00] "GET /wp-login.php?x=1 HTTP/2.0" 200 0.125`,
+			want: &models.WebLogRequest{ClientIP: "2001:db8::5", Host: "example.com", Method: "GET", Path: "/wp-login.php",
+				Query: "x=1", Version: "HTTP/2.0", Status: 200, Latency: 125 * time.Millisecond, Timestamp: stamp},
+		},
+		{
+			name:   "nginx msec",
+			format: `$msec $remote_addr "$request" $uri $args`,
+			line:   `1709388245.250 203.0.113.9 "GET /search?q=x HTTP/1.1" /search ?q=x`,
+			want: &models.WebLogRequest{ClientIP: "203.0.113.9", Method: "GET", Path: "/search", Query: "q=x",
+				Version: "HTTP/1.1", Timestamp: time.UnixMilli(1709388245250)},
+		},
+		{
+			name:   "apache vhost_combined",
+			format: "vhost_combined",
+			line:   `www.example.com:443 198.51.100.4 - - [02/Mar/2024:15:04:05 +0100] "GET / HTTP/1.1" 200 5120 "-" "Go-http-client/1.1"`,
+			want: &models.WebLogRequest{ClientIP: "198.51.100.4", Host: "www.example.com", Method: "GET", Path: "/",
+				Version: "HTTP/1.1", Status: 200, Bytes: 5120, UserAgent: "Go-http-client/1.1", Timestamp: stamp},
+		},
+		{
+			name:   "apache request time",
+			format: `%{c}a %D \"%r\" %s`,
+			line:   `10.0.0.9 1500 "GET /x HTTP/1.1" 500`,
+			want: &models.WebLogRequest{ClientIP: "10.0.0.9", Method: "GET", Path: "/x", Version: "HTTP/1.1",
+				Status: 500, Latency: 1500 * time.Microsecond},
+		},
+		{
+			name:   "not matching",
+			format: "combined",
+			line:   `2024/03/02 15:04:05 [error] 1234#0: *1 open() "/var/www/x" failed`,
+		},
+		{
+			name:   "no client address",
+			format: "common",
+			line:   `- - - [02/Mar/2024:15:04:05 +0100] "GET / HTTP/1.1" 200 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseWebLogFormat(tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := f.parse(tt.line)
+			if tt.want == nil {
+				if ok {
+					t.Errorf("parsed %+v, want rejection", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("rejected")
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// journalSaveInterval is how often the cursor is persisted
+	journalSaveInterval = 5 * time.Second
+	// journalRestartDelay is the pause before journalctl is run again
+	// after it exited
+	journalRestartDelay = 10 * time.Second
+	// maxJournalField bounds a binary field, larger fields are skipped
+	maxJournalField = 1 << 20
+)
+
+// JournalConfig configures the reader of the systemd journal
+type JournalConfig struct {
+	Enabled     bool
+	Command     []string // journalctl and its leading arguments, e.g. to run it on another host
+	Directory   string   // read the journal files of this directory instead of the system journal
+	Files       []string // read these journal files instead of the system journal
+	Identifiers []string // syslog identifiers of the messages read
+	Kernel      bool     // also read the kernel messages
+	CursorFile  string   // keeps the position across restarts, not kept if empty
+	FromStart   bool     // without a kept position, read the entries already in the journal
+}
+
+// DefaultJournalConfig returns the settings used for unset values
+func DefaultJournalConfig() JournalConfig {
+	return JournalConfig{
+		Enabled:     true,
+		Command:     []string{"journalctl"},
+		Identifiers: []string{"sshd", "sshd-session", "sudo"},
+		Kernel:      true,
+		CursorFile:  "/var/lib/safepanel/journal.cursor",
+	}
+}
+
+// JournalReader follows the systemd journal through the export format of
+// journalctl and routes the messages to the handlers accepting their
+// program. The cursor of the last entry handled is persisted, so after a
+// restart reading resumes right after it.
+type JournalReader struct {
+	config   JournalConfig
+	handlers []MessageHandler
+	cursor   string
+	saved    string
+	mutex    sync.Mutex
+}
+
+func NewJournalReader(config JournalConfig) *JournalReader {
+	defaults := DefaultJournalConfig()
+	if len(config.Command) == 0 {
+		config.Command = defaults.Command
+	}
+	if len(config.Identifiers) == 0 && !config.Kernel {
+		config.Identifiers = defaults.Identifiers
+	}
+
+	return &JournalReader{config: config}
+}
+
+// AddHandler routes the messages of the programs h accepts to h, handlers
+// must be added before Start
+func (r *JournalReader) AddHandler(h MessageHandler) {
+	r.handlers = append(r.handlers, h)
+}
+
+// Start runs journalctl until ctx is done, it is run again whenever it exits
+func (r *JournalReader) Start(ctx context.Context) error {
+	if _, err := exec.LookPath(r.config.Command[0]); err != nil {
+		return err
+	}
+	if err := r.loadCursor(); err != nil {
+		return err
+	}
+
+	go r.run(ctx)
+	go r.runSave(ctx)
+	return nil
+}
+
+// Stop persists the cursor, entries handled later are read again after a
+// restart
+func (r *JournalReader) Stop() {
+	if err := r.saveCursor(); err != nil {
+		stdlog.Printf("Failed to save journal cursor: %v", err)
+	}
+}
+
+func (r *JournalReader) run(ctx context.Context) {
+	for {
+		err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		stdlog.Printf("journalctl exited: %v, running it again in %s", err, journalRestartDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(journalRestartDelay):
+		}
+	}
+}
+
+// follow runs journalctl once and handles its entries until it exits
+func (r *JournalReader) follow(ctx context.Context) error {
+	r.mutex.Lock()
+	cursor := r.cursor
+	r.mutex.Unlock()
+
+	cmd := exec.CommandContext(ctx, r.config.Command[0], r.args(cursor)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(stdout, 64<<10)
+	read := 0
+	for {
+		entry, err := readJournalEntry(reader)
+		if err != nil {
+			break
+		}
+		read++
+		r.handleEntry(entry)
+	}
+
+	err = cmd.Wait()
+	if err == nil {
+		return io.EOF
+	}
+	// a cursor of entries removed by journal rotation cannot be resumed from
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		if read == 0 && cursor != "" && strings.Contains(strings.ToLower(msg), "cursor") {
+			stdlog.Printf("Journal cursor %s is no longer valid, following new entries", cursor)
+			r.mutex.Lock()
+			r.cursor = ""
+			r.mutex.Unlock()
+		}
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+	return err
+}
+
+// args returns the journalctl arguments, reading resumes after cursor if set
+func (r *JournalReader) args(cursor string) []string {
+	args := append([]string{}, r.config.Command[1:]...)
+	args = append(args, "--output=export", "--follow", "--no-pager")
+	if r.config.Directory != "" {
+		args = append(args, "--directory="+r.config.Directory)
+	}
+	for _, file := range r.config.Files {
+		args = append(args, "--file="+file)
+	}
+
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case r.config.FromStart:
+		args = append(args, "--lines=all")
+	default:
+		args = append(args, "--lines=0")
+	}
+
+	// matches of the same field are ORed, "+" ORs the kernel transport
+	for _, identifier := range r.config.Identifiers {
+		args = append(args, "SYSLOG_IDENTIFIER="+identifier)
+	}
+	if r.config.Kernel {
+		if len(r.config.Identifiers) > 0 {
+			args = append(args, "+")
+		}
+		args = append(args, "_TRANSPORT=kernel")
+	}
+	return args
+}
+
+func (r *JournalReader) handleEntry(entry journalEntry) {
+	if m := entry.message(); m != nil {
+		for _, h := range r.handlers {
+			if h.Accepts(m.Program) {
+				h.HandleMessage(m)
+			}
+		}
+	}
+
+	if cursor := entry["__CURSOR"]; cursor != "" {
+		r.mutex.Lock()
+		r.cursor = cursor
+		r.mutex.Unlock()
+	}
+}
+
+func (r *JournalReader) runSave(ctx context.Context) {
+	ticker := time.NewTicker(journalSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.Stop()
+			return
+		case <-ticker.C:
+			if err := r.saveCursor(); err != nil {
+				stdlog.Printf("Failed to save journal cursor: %v", err)
+			}
+		}
+	}
+}
+
+func (r *JournalReader) loadCursor() error {
+	if r.config.CursorFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(r.config.CursorFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.cursor = strings.TrimSpace(string(data))
+	r.saved = r.cursor
+	return nil
+}
+
+// saveCursor writes the cursor if it changed since it was last written,
+// through a rename so a crash never leaves a partial cursor behind
+func (r *JournalReader) saveCursor() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.config.CursorFile == "" || r.cursor == r.saved {
+		return nil
+	}
+	dir := filepath.Dir(r.config.CursorFile)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".journal-cursor-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(r.cursor + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.config.CursorFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	r.saved = r.cursor
+	return nil
+}
+
+// journalEntry holds the fields of a journal entry
+type journalEntry map[string]string
+
+// readJournalEntry reads an entry of the journal export format. Fields are
+// KEY=VALUE lines, values with control characters are written as the key
+// line followed by the little endian 64 bit length of the value, the value
+// and a newline. Entries end with an empty line.
+func readJournalEntry(r *bufio.Reader) (journalEntry, error) {
+	entry := make(journalEntry)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if line == "" {
+			if len(entry) == 0 {
+				continue
+			}
+			return entry, nil
+		}
+
+		if key, value, ok := strings.Cut(line, "="); ok {
+			entry[key] = value
+			continue
+		}
+		var size uint64
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if size > maxJournalField {
+			if _, err := io.CopyN(io.Discard, r, int64(size)+1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		value := make([]byte, size+1)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		entry[line] = string(value[:size])
+	}
+}
+
+// message returns the message of the entry, nil for entries without one
+func (e journalEntry) message() *Message {
+	text, ok := e["MESSAGE"]
+	if !ok {
+		return nil
+	}
+	text = strings.TrimRight(text, "\n")
+	if len(text) > maxLineLength {
+		text = text[:maxLineLength]
+	}
+
+	m := &Message{
+		Host:    e["_HOSTNAME"],
+		Program: e["SYSLOG_IDENTIFIER"],
+		Message: text,
+	}
+	if e["_TRANSPORT"] == "kernel" {
+		m.Program = "kernel"
+	} else if m.Program == "" {
+		m.Program = e["_COMM"]
+	}
+	if usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		m.Timestamp = time.UnixMicro(usec)
+	} else {
+		m.Timestamp = time.Now()
+	}
+	pid := e["SYSLOG_PID"]
+	if pid == "" {
+		pid = e["_PID"]
+	}
+	m.PID, _ = strconv.Atoi(pid)
+	return m
+}
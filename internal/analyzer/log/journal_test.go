@@ -0,0 +1,242 @@
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// binaryJournalField encodes a field the way journalctl exports values with
+// control characters
+func binaryJournalField(key, value string) string {
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	return key + "\n" + string(size[:]) + value + "\n"
+}
+
+func TestReadJournalEntry(t *testing.T) {
+	oversized := strings.Repeat("x", maxJournalField+1)
+	stream := "\n" +
+		"__CURSOR=s=1;i=1\n" +
+		"__REALTIME_TIMESTAMP=1709391845123456\n" +
+		"SYSLOG_IDENTIFIER=sshd\n" +
+		"MESSAGE=Accepted publickey for alice from 192.0.2.1 port 51234 ssh2\n" +
+		"\n" +
+		"__CURSOR=s=1;i=2\n" +
+		binaryJournalField("MESSAGE", "line one\nline two\x00") +
+		binaryJournalField("COREDUMP", oversized) +
+		"_COMM=kernel-thing\n" +
+		"EMPTY=\n" +
+		"\n" +
+		"__CURSOR=s=1;i=3\n" +
+		"MESSAGE=cut"
+	r := bufio.NewReaderSize(strings.NewReader(stream), 64<<10)
+
+	want := []journalEntry{
+		{
+			"__CURSOR":             "s=1;i=1",
+			"__REALTIME_TIMESTAMP": "1709391845123456",
+			"SYSLOG_IDENTIFIER":    "sshd",
+			"MESSAGE":              "Accepted publickey for alice from 192.0.2.1 port 51234 ssh2",
+		},
+		{
+			"__CURSOR": "s=1;i=2",
+			"MESSAGE":  "line one\nline two\x00",
+			"_COMM":    "kernel-thing",
+			"EMPTY":    "",
+		},
+	}
+	for i, w := range want {
+		entry, err := readJournalEntry(r)
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(entry, w) {
+			t.Errorf("entry %d = %q, want %q", i, entry, w)
+		}
+	}
+	// the stream ends within an entry
+	if entry, err := readJournalEntry(r); err != io.EOF {
+		t.Errorf("truncated entry = %q, %v, want io.EOF", entry, err)
+	}
+
+	// a binary field cut short
+	r = bufio.NewReader(strings.NewReader(binaryJournalField("MESSAGE", "complete")[:15]))
+	if _, err := readJournalEntry(r); err == nil {
+		t.Error("read a truncated binary field")
+	}
+}
+
+func TestJournalEntryMessage(t *testing.T) {
+	ts := time.UnixMicro(1709391845123456)
+	tests := []struct {
+		name  string
+		entry journalEntry
+		want  *Message
+	}{
+		{
+			name: "syslog identifier",
+			entry: journalEntry{"MESSAGE": "Invalid user admin from 203.0.113.5 port 4250\n", "SYSLOG_IDENTIFIER": "sshd",
+				"_COMM": "sshd-session", "_HOSTNAME": "web1", "SYSLOG_PID": "812", "_PID": "813", "__REALTIME_TIMESTAMP": "1709391845123456"},
+			want: &Message{Timestamp: ts, Host: "web1", Program: "sshd", PID: 812, Message: "Invalid user admin from 203.0.113.5 port 4250"},
+		},
+		{
+			name:  "command name",
+			entry: journalEntry{"MESSAGE": "pam_unix(sudo:auth): authentication failure", "_COMM": "sudo", "_PID": "99", "__REALTIME_TIMESTAMP": "1709391845123456"},
+			want:  &Message{Timestamp: ts, Program: "sudo", PID: 99, Message: "pam_unix(sudo:auth): authentication failure"},
+		},
+		{
+			name: "kernel",
+			entry: journalEntry{"MESSAGE": "IN=eth0 OUT= SRC=203.0.113.5 DST=198.51.100.1 PROTO=TCP DPT=22", "_TRANSPORT": "kernel",
+				"SYSLOG_IDENTIFIER": "kernel-firewall", "__REALTIME_TIMESTAMP": "1709391845123456"},
+			want: &Message{Timestamp: ts, Program: "kernel", Message: "IN=eth0 OUT= SRC=203.0.113.5 DST=198.51.100.1 PROTO=TCP DPT=22"},
+		},
+		{
+			name:  "overlong message",
+			entry: journalEntry{"MESSAGE": strings.Repeat("m", maxLineLength+1), "SYSLOG_IDENTIFIER": "app", "__REALTIME_TIMESTAMP": "1709391845123456"},
+			want:  &Message{Timestamp: ts, Program: "app", Message: strings.Repeat("m", maxLineLength)},
+		},
+		{
+			name:  "no message",
+			entry: journalEntry{"SYSLOG_IDENTIFIER": "sshd", "__CURSOR": "s=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.entry.message()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// entries without a timestamp are dated when read
+	before := time.Now()
+	if m := (journalEntry{"MESSAGE": "x"}).message(); m.Timestamp.Before(before) {
+		t.Errorf("timestamp %v before %v", m.Timestamp, before)
+	}
+}
+
+func TestJournalArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config JournalConfig
+		cursor string
+		want   []string
+	}{
+		{
+			name:   "identifiers or kernel",
+			config: JournalConfig{Identifiers: []string{"sshd", "sudo"}, Kernel: true},
+			want: []string{"--output=export", "--follow", "--no-pager", "--lines=0",
+				"SYSLOG_IDENTIFIER=sshd", "SYSLOG_IDENTIFIER=sudo", "+", "_TRANSPORT=kernel"},
+		},
+		{
+			name:   "kernel only",
+			config: JournalConfig{Kernel: true, FromStart: true},
+			want:   []string{"--output=export", "--follow", "--no-pager", "--lines=all", "_TRANSPORT=kernel"},
+		},
+		{
+			name:   "resume after cursor",
+			config: JournalConfig{Identifiers: []string{"sshd"}, FromStart: true},
+			cursor: "s=abc;i=42",
+			want:   []string{"--output=export", "--follow", "--no-pager", "--after-cursor=s=abc;i=42", "SYSLOG_IDENTIFIER=sshd"},
+		},
+		{
+			name: "remote journal files",
+			config: JournalConfig{Command: []string{"ssh", "web1", "journalctl"}, Directory: "/var/log/journal/remote",
+				Files: []string{"a.journal", "b.journal"}, Identifiers: []string{"sshd"}},
+			want: []string{"web1", "journalctl", "--output=export", "--follow", "--no-pager",
+				"--directory=/var/log/journal/remote", "--file=a.journal", "--file=b.journal", "--lines=0", "SYSLOG_IDENTIFIER=sshd"},
+		},
+		{
+			name:   "defaults",
+			config: JournalConfig{},
+			want: []string{"--output=export", "--follow", "--no-pager", "--lines=0",
+				"SYSLOG_IDENTIFIER=sshd", "SYSLOG_IDENTIFIER=sshd-session", "SYSLOG_IDENTIFIER=sudo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewJournalReader(tt.config)
+			if got := r.args(tt.cursor); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("args %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeJournalctl exports one entry, it fails like journalctl for the cursor
+// s=gone as if the entry was rotated away
+const fakeJournalctl = `
+case "$*" in
+*--after-cursor=s=gone*)
+	echo "Failed to seek to cursor: Invalid argument" >&2
+	exit 1;;
+esac
+printf '__CURSOR=s=2\nSYSLOG_IDENTIFIER=sshd\nMESSAGE=Invalid user admin from 203.0.113.5\n\n'
+`
+
+func TestJournalCursor(t *testing.T) {
+	cursorFile := filepath.Join(t.TempDir(), "state", "journal.cursor")
+	if err := os.MkdirAll(filepath.Dir(cursorFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cursorFile, []byte("s=gone\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := JournalConfig{
+		Command:     []string{"sh", "-c", fakeJournalctl, "journalctl"},
+		Identifiers: []string{"sshd"},
+		CursorFile:  cursorFile,
+	}
+	r := NewJournalReader(config)
+	recorder := &messageRecorder{programs: []string{"sshd"}}
+	r.AddHandler(recorder)
+	if err := r.loadCursor(); err != nil {
+		t.Fatal(err)
+	}
+	if r.cursor != "s=gone" {
+		t.Fatalf("loaded cursor %q", r.cursor)
+	}
+
+	// a cursor journalctl cannot seek to is dropped
+	ctx := context.Background()
+	if err := r.follow(ctx); err == nil || !strings.Contains(err.Error(), "cursor") {
+		t.Errorf("follow with a rotated cursor: %v", err)
+	}
+	if r.cursor != "" || len(recorder.messages) != 0 {
+		t.Fatalf("cursor %q kept, %d messages", r.cursor, len(recorder.messages))
+	}
+
+	// the next run follows new entries and moves the cursor on
+	if err := r.follow(ctx); err != io.EOF {
+		t.Errorf("follow: %v", err)
+	}
+	if r.cursor != "s=2" || len(recorder.messages) != 1 || recorder.messages[0].Message != "Invalid user admin from 203.0.113.5" {
+		t.Fatalf("cursor %q, messages %+v", r.cursor, recorder.messages)
+	}
+	if err := r.saveCursor(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(cursorFile); !bytes.Equal(data, []byte("s=2\n")) {
+		t.Errorf("saved cursor %q", data)
+	}
+
+	// a restart resumes after the saved cursor
+	resumed := NewJournalReader(config)
+	if err := resumed.loadCursor(); err != nil {
+		t.Fatal(err)
+	}
+	args := resumed.args(resumed.cursor)
+	if !reflect.DeepEqual(args[len(args)-2:], []string{"--after-cursor=s=2", "SYSLOG_IDENTIFIER=sshd"}) {
+		t.Errorf("resumed with %q", args)
+	}
+}
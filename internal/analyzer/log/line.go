@@ -0,0 +1,91 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a message of a system log, read from a log file or from the
+// systemd journal
+type Message struct {
+	Timestamp time.Time
+	Host      string
+	Program   string // syslog identifier, "kernel" for kernel messages
+	PID       int
+	Message   string
+}
+
+// MessageHandler analyzes the messages of some programs, messages of a
+// source are routed to the handlers accepting their program
+type MessageHandler interface {
+	Accepts(program string) bool
+	HandleMessage(m *Message)
+}
+
+// parseLogLine splits a line written by syslog, either with the traditional
+// timestamp ("Jan  2 15:04:05 host sshd[123]: message") or with the
+// RFC 3339 timestamp rsyslog writes on newer distributions. Lines in
+// neither format are rejected.
+func parseLogLine(line string, now time.Time) (*Message, bool) {
+	ts, rest, ok := parseLogTimestamp(line, now)
+	if !ok {
+		return nil, false
+	}
+	host, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return nil, false
+	}
+	return parseLogTag(rest, ts, host)
+}
+
+// parseLogTimestamp splits the timestamp in either format off a line
+func parseLogTimestamp(line string, now time.Time) (time.Time, string, bool) {
+	if len(line) > 16 && line[3] == ' ' && line[15] == ' ' {
+		t, err := time.ParseInLocation(time.Stamp, line[:15], time.Local)
+		if err != nil {
+			return time.Time{}, "", false
+		}
+		return stampYear(t, now), line[16:], true
+	}
+
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return time.Time{}, "", false
+	}
+	t, err := time.Parse(time.RFC3339Nano, line[:end])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return t, line[end+1:], true
+}
+
+// parseLogTag splits the "program[pid]: " tag off the rest of a line
+func parseLogTag(rest string, ts time.Time, host string) (*Message, bool) {
+	tag, message, ok := strings.Cut(rest, ": ")
+	if !ok || tag == "" || strings.ContainsRune(tag, ' ') {
+		return nil, false
+	}
+
+	l := &Message{
+		Timestamp: ts,
+		Host:      host,
+		Program:   tag,
+		Message:   message,
+	}
+	if start := strings.IndexByte(tag, '['); start > 0 && strings.HasSuffix(tag, "]") {
+		l.Program = tag[:start]
+		l.PID, _ = strconv.Atoi(tag[start+1 : len(tag)-1])
+	}
+	return l, true
+}
+
+// stampYear completes a timestamp without year, lines from late December
+// read in early January belong to the previous year
+func stampYear(t, now time.Time) time.Time {
+	year := now.Year()
+	if t.Month() > now.Month()+1 {
+		year--
+	}
+	return time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
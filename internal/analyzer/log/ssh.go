@@ -1 +1,338 @@
 package log
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// DefaultSSHLogPaths are the authentication logs of Debian and Red Hat
+// based distributions
+var DefaultSSHLogPaths = []string{"/var/log/auth.log", "/var/log/secure"}
+
+var (
+	// "Accepted publickey for alice from 192.0.2.1 port 51234 ssh2: ED25519 SHA256:..."
+	// "Failed password for invalid user admin from 192.0.2.1 port 51234 ssh2"
+	sshAuthRe = regexp.MustCompile(`^(Accepted|Failed) (\S+) for (invalid user )?(.*) from (\S+) port (\d+)`)
+	// "Invalid user admin from 192.0.2.1 port 51234"
+	sshInvalidUserRe = regexp.MustCompile(`^Invalid user (.*) from (\S+)(?: port (\d+))?`)
+	// "Connection closed by authenticating user root 192.0.2.1 port 51234 [preauth]"
+	// "Disconnected from invalid user admin 192.0.2.1 port 51234 [preauth]"
+	sshPreauthRe = regexp.MustCompile(`^(?:Connection (?:closed|reset) by|Disconnected from) (authenticating|invalid) user (.*) (\S+) port (\d+) \[preauth\]`)
+	// "error: maximum authentication attempts exceeded for root from 192.0.2.1 port 51234 ssh2 [preauth]"
+	sshMaxAttemptsRe = regexp.MustCompile(`^(?:error: )?maximum authentication attempts exceeded for (invalid user )?(.*) from (\S+) port (\d+)`)
+	// "message repeated 3 times: [ Failed password for root from 192.0.2.1 port 51234 ssh2]"
+	sshRepeatedRe = regexp.MustCompile(`^message repeated (\d+) times: \[ ?(.*)\]$`)
+)
+
+// SSHConfig configures the analyzer of the sshd authentication log
+type SSHConfig struct {
+	Enabled     bool
+	Paths       []string      // candidate logs, the first existing one is followed; none when fed by the journal
+	FromStart   bool          // read the lines already in the log at startup
+	Window      time.Duration // window the failures of a source are counted in
+	MaxFailures int           // failures per source and window that trigger an event
+}
+
+// DefaultSSHConfig returns the settings used for unset values
+func DefaultSSHConfig() SSHConfig {
+	return SSHConfig{
+		Enabled:     true,
+		Paths:       DefaultSSHLogPaths,
+		Window:      10 * time.Minute,
+		MaxFailures: 10,
+	}
+}
+
+// maxSSHSources bounds the number of sources tracked
+const maxSSHSources = 10000
+
+// maxSSHEvidence bounds the users listed in an event
+const maxSSHEvidence = 50
+
+// sshFailureStats counts the failures of a source within the window
+type sshFailureStats struct {
+	start     time.Time
+	last      time.Time
+	failed    int
+	users     map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// SSHAnalyzer follows the sshd log, reports every authentication attempt
+// and raises events for sources guessing passwords. Like fail2ban every
+// logged failure counts, so a rejected password for an unknown user may
+// count twice.
+type SSHAnalyzer struct {
+	config  SSHConfig
+	onAuth  func(*models.SSHAuthEvent)
+	onEvent func(*models.Event)
+	sources map[string]*sshFailureStats
+	mutex   sync.Mutex
+}
+
+func NewSSHAnalyzer(config SSHConfig) *SSHAnalyzer {
+	defaults := DefaultSSHConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = defaults.MaxFailures
+	}
+
+	return &SSHAnalyzer{
+		config:  config,
+		sources: make(map[string]*sshFailureStats),
+	}
+}
+
+func (a *SSHAnalyzer) SetAuthCallback(callback func(*models.SSHAuthEvent)) {
+	a.onAuth = callback
+}
+
+func (a *SSHAnalyzer) SetEventCallback(callback func(*models.Event)) {
+	a.onEvent = callback
+}
+
+// Start follows the first existing log of the configured paths until ctx
+// is done. Without paths the analyzer only handles the messages routed to
+// it by other sources.
+func (a *SSHAnalyzer) Start(ctx context.Context) error {
+	go a.runCleanup(ctx)
+	if len(a.config.Paths) == 0 {
+		return nil
+	}
+
+	path := ""
+	for _, candidate := range a.config.Paths {
+		if _, err := os.Stat(candidate); err == nil {
+			path = candidate
+			break
+		}
+	}
+	if path == "" {
+		return fmt.Errorf("no SSH log found in %s", strings.Join(a.config.Paths, ", "))
+	}
+
+	go tail(ctx, path, a.config.FromStart, a.handleLine)
+	return nil
+}
+
+func (a *SSHAnalyzer) handleLine(line string) {
+	l, ok := parseLogLine(line, time.Now())
+	if !ok {
+		return
+	}
+	if a.Accepts(l.Program) {
+		a.HandleMessage(l)
+	}
+}
+
+// Accepts reports whether a program is sshd, sshd-session logs the
+// authentication since OpenSSH 9.8
+func (a *SSHAnalyzer) Accepts(program string) bool {
+	return strings.HasPrefix(program, "sshd")
+}
+
+// HandleMessage parses a message logged by sshd
+func (a *SSHAnalyzer) HandleMessage(l *Message) {
+
+	message, count := l.Message, 1
+	if m := sshRepeatedRe.FindStringSubmatch(message); m != nil {
+		count, _ = strconv.Atoi(m[1])
+		message = m[2]
+	}
+
+	auth := parseSSHAuth(message)
+	if auth == nil {
+		return
+	}
+	auth.Host = l.Host
+	auth.Count = max(count, 1)
+	auth.Timestamp = l.Timestamp
+
+	if a.onAuth != nil {
+		a.onAuth(auth)
+	}
+	if event := a.addAuth(auth); event != nil && a.onEvent != nil {
+		a.onEvent(event)
+	}
+}
+
+// parseSSHAuth returns the authentication attempt a message records, nil
+// for other messages and attempts from hosts logged by name
+func parseSSHAuth(message string) *models.SSHAuthEvent {
+	var auth *models.SSHAuthEvent
+	var ip, port string
+
+	if m := sshAuthRe.FindStringSubmatch(message); m != nil {
+		auth = &models.SSHAuthEvent{
+			Result:      models.SSHAuthAccepted,
+			Method:      m[2],
+			User:        m[4],
+			InvalidUser: m[3] != "",
+		}
+		if m[1] == "Failed" {
+			auth.Result = models.SSHAuthFailed
+		}
+		ip, port = m[5], m[6]
+	} else if m := sshInvalidUserRe.FindStringSubmatch(message); m != nil {
+		auth = &models.SSHAuthEvent{
+			Result:      models.SSHAuthInvalidUser,
+			User:        m[1],
+			InvalidUser: true,
+		}
+		ip, port = m[2], m[3]
+	} else if m := sshPreauthRe.FindStringSubmatch(message); m != nil {
+		auth = &models.SSHAuthEvent{
+			Result:      models.SSHAuthDisconnect,
+			User:        m[2],
+			InvalidUser: m[1] == "invalid",
+		}
+		ip, port = m[3], m[4]
+	} else if m := sshMaxAttemptsRe.FindStringSubmatch(message); m != nil {
+		auth = &models.SSHAuthEvent{
+			Result:      models.SSHAuthFailed,
+			User:        m[2],
+			InvalidUser: m[1] != "",
+		}
+		ip, port = m[3], m[4]
+	} else {
+		return nil
+	}
+
+	if net.ParseIP(ip) == nil {
+		return nil
+	}
+	auth.SrcIP = ip
+	if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+		auth.SrcPort = uint16(p)
+	}
+	return auth
+}
+
+// addAuth counts the failures of the source and returns an event once they
+// exceed the limit within the window, and again when they have doubled
+// since. A login accepted after the limit was exceeded is reported as a
+// likely successful brute force.
+func (a *SSHAnalyzer) addAuth(auth *models.SSHAuthEvent) *models.Event {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	ts := auth.Timestamp
+	stats, ok := a.sources[auth.SrcIP]
+	if ok && ts.Sub(stats.start) >= a.config.Window {
+		*stats = sshFailureStats{
+			lastAlert: stats.lastAlert,
+			alerted:   stats.alerted,
+		}
+	}
+
+	if auth.Result == models.SSHAuthAccepted {
+		if !ok || stats.failed < a.config.MaxFailures {
+			return nil
+		}
+		event := a.event(auth, stats, models.EventSSHBruteForceSuccess, models.SeverityCritical,
+			fmt.Sprintf("SSH login of %s from %s after %d failures in %s",
+				auth.User, auth.SrcIP, stats.failed, ts.Sub(stats.start).Round(time.Second)))
+		delete(a.sources, auth.SrcIP)
+		return event
+	}
+
+	if !ok {
+		if len(a.sources) >= maxSSHSources {
+			return nil
+		}
+		stats = &sshFailureStats{}
+		a.sources[auth.SrcIP] = stats
+	}
+	if stats.start.IsZero() {
+		stats.start = ts
+		stats.users = make(map[string]struct{})
+	}
+	stats.last = ts
+	stats.failed += auth.Count
+	if len(stats.users) < maxSSHEvidence {
+		stats.users[auth.User] = struct{}{}
+	}
+
+	if stats.failed < a.config.MaxFailures {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < a.config.Window && stats.failed < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = stats.failed
+
+	severity := models.SeverityMedium
+	if stats.failed >= 5*a.config.MaxFailures {
+		severity = models.SeverityHigh
+	}
+	return a.event(auth, stats, models.EventSSHAuthFailure, severity,
+		fmt.Sprintf("SSH authentication failures from %s: %d failures for %d users in %s",
+			auth.SrcIP, stats.failed, len(stats.users), ts.Sub(stats.start).Round(time.Second)))
+}
+
+func (a *SSHAnalyzer) event(auth *models.SSHAuthEvent, stats *sshFailureStats, eventType models.EventType, severity models.Severity, message string) *models.Event {
+	users := make([]string, 0, len(stats.users))
+	for user := range stats.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	return &models.Event{
+		Type:     eventType,
+		Severity: severity,
+		SrcIP:    auth.SrcIP,
+		Sources:  []string{auth.SrcIP},
+		Target:   auth.Host,
+		Score:    min(1, float64(stats.failed)/float64(5*a.config.MaxFailures)),
+		Count:    stats.failed,
+		Message:  message,
+		Details: map[string]string{
+			"failed": strconv.Itoa(stats.failed),
+			"users":  strings.Join(users, ","),
+			"user":   auth.User,
+			"method": auth.Method,
+			"window": a.config.Window.String(),
+		},
+		Timestamp: auth.Timestamp,
+	}
+}
+
+func (a *SSHAnalyzer) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup forgets sources without failures for two windows
+func (a *SSHAnalyzer) cleanup(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for ip, stats := range a.sources {
+		if now.Sub(stats.last) >= 2*a.config.Window {
+			delete(a.sources, ip)
+		}
+	}
+}
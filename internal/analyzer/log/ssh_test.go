@@ -0,0 +1,125 @@
+package log
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestParseSSHAuth(t *testing.T) {
+	tests := []struct {
+		message string
+		want    *models.SSHAuthEvent
+	}{
+		{
+			"Accepted password for alice from 192.0.2.1 port 51234 ssh2",
+			&models.SSHAuthEvent{Result: models.SSHAuthAccepted, Method: "password", User: "alice", SrcIP: "192.0.2.1", SrcPort: 51234},
+		},
+		{
+			"Accepted publickey for deploy from 2001:db8::7 port 40022 ssh2: ED25519 SHA256:p3NJTKGnHR2ZWQ4v0j4mJbQxJ5Fq3S1pmSlg8z6aL8Y",
+			&models.SSHAuthEvent{Result: models.SSHAuthAccepted, Method: "publickey", User: "deploy", SrcIP: "2001:db8::7", SrcPort: 40022},
+		},
+		{
+			"Failed password for root from 203.0.113.5 port 4242 ssh2",
+			&models.SSHAuthEvent{Result: models.SSHAuthFailed, Method: "password", User: "root", SrcIP: "203.0.113.5", SrcPort: 4242},
+		},
+		{
+			"Failed publickey for git from 203.0.113.5 port 4243 ssh2: RSA SHA256:0nBq2ZU6ZVKVxW5IKe2mXHnfnVGcv0dA1OQK9gq7jZ0",
+			&models.SSHAuthEvent{Result: models.SSHAuthFailed, Method: "publickey", User: "git", SrcIP: "203.0.113.5", SrcPort: 4243},
+		},
+		{
+			"Failed password for invalid user admin from 203.0.113.5 port 4244 ssh2",
+			&models.SSHAuthEvent{Result: models.SSHAuthFailed, Method: "password", User: "admin", InvalidUser: true, SrcIP: "203.0.113.5", SrcPort: 4244},
+		},
+		{
+			"Failed none for invalid user  from 203.0.113.5 port 4245 ssh2",
+			&models.SSHAuthEvent{Result: models.SSHAuthFailed, Method: "none", User: "", InvalidUser: true, SrcIP: "203.0.113.5", SrcPort: 4245},
+		},
+		{
+			"Invalid user oracle from 203.0.113.6 port 50000",
+			&models.SSHAuthEvent{Result: models.SSHAuthInvalidUser, User: "oracle", InvalidUser: true, SrcIP: "203.0.113.6", SrcPort: 50000},
+		},
+		{
+			"Invalid user test from 203.0.113.6",
+			&models.SSHAuthEvent{Result: models.SSHAuthInvalidUser, User: "test", InvalidUser: true, SrcIP: "203.0.113.6"},
+		},
+		{
+			"Connection closed by authenticating user root 203.0.113.7 port 33000 [preauth]",
+			&models.SSHAuthEvent{Result: models.SSHAuthDisconnect, User: "root", SrcIP: "203.0.113.7", SrcPort: 33000},
+		},
+		{
+			"Disconnected from invalid user ubnt 203.0.113.7 port 33001 [preauth]",
+			&models.SSHAuthEvent{Result: models.SSHAuthDisconnect, User: "ubnt", InvalidUser: true, SrcIP: "203.0.113.7", SrcPort: 33001},
+		},
+		{
+			"Connection reset by invalid user pi 203.0.113.7 port 33002 [preauth]",
+			&models.SSHAuthEvent{Result: models.SSHAuthDisconnect, User: "pi", InvalidUser: true, SrcIP: "203.0.113.7", SrcPort: 33002},
+		},
+		{
+			"error: maximum authentication attempts exceeded for root from 203.0.113.8 port 60000 ssh2 [preauth]",
+			&models.SSHAuthEvent{Result: models.SSHAuthFailed, User: "root", SrcIP: "203.0.113.8", SrcPort: 60000},
+		},
+		{"Failed password for root from host.example.com port 4242 ssh2", nil},
+		{"Received disconnect from 203.0.113.7 port 33000:11: Bye Bye [preauth]", nil},
+		{"Connection closed by 203.0.113.7 port 33003 [preauth]", nil},
+		{"pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)", nil},
+		{"Server listening on 0.0.0.0 port 22.", nil},
+	}
+	for _, tt := range tests {
+		if got := parseSSHAuth(tt.message); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSSHAuth(%q) = %+v, want %+v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestSSHAnalyzerLines(t *testing.T) {
+	a := NewSSHAnalyzer(SSHConfig{MaxFailures: 5})
+	var auths []*models.SSHAuthEvent
+	var events []*models.Event
+	a.SetAuthCallback(func(auth *models.SSHAuthEvent) { auths = append(auths, auth) })
+	a.SetEventCallback(func(event *models.Event) { events = append(events, event) })
+
+	lines := []string{
+		"Mar  2 15:04:05 web1 sshd[812]: Failed password for root from 203.0.113.5 port 4242 ssh2",
+		"Mar  2 15:04:07 web1 sshd[812]: message repeated 3 times: [ Failed password for root from 203.0.113.5 port 4242 ssh2]",
+		"Mar  2 15:04:08 web1 sshd-session[813]: Invalid user admin from 203.0.113.5 port 4250",
+		"Mar  2 15:04:09 web1 systemd[1]: Invalid user admin from 203.0.113.5 port 4251",
+		"Mar  2 15:04:10 web1 sshd[814]: Accepted password for root from 203.0.113.5 port 4260 ssh2",
+		"2024-03-02T15:04:11.000000+00:00 web1 sshd[815]: Accepted publickey for alice from 192.0.2.1 port 51234 ssh2",
+		"not a syslog line",
+	}
+	for _, line := range lines {
+		a.handleLine(line)
+	}
+
+	var got []string
+	for _, auth := range auths {
+		got = append(got, string(auth.Result)+" "+auth.User+" "+auth.SrcIP+" "+auth.Host)
+	}
+	want := []string{
+		string(models.SSHAuthFailed) + " root 203.0.113.5 web1",
+		string(models.SSHAuthFailed) + " root 203.0.113.5 web1",
+		string(models.SSHAuthInvalidUser) + " admin 203.0.113.5 web1",
+		string(models.SSHAuthAccepted) + " root 203.0.113.5 web1",
+		string(models.SSHAuthAccepted) + " alice 192.0.2.1 web1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("auth events %q, want %q", got, want)
+	}
+	if auths[1].Count != 3 {
+		t.Errorf("repeated message counts %d, want 3", auths[1].Count)
+	}
+
+	// 1 + 3 + 1 failures reach the limit, the accepted login follows
+	if len(events) != 2 || events[0].Type != models.EventSSHAuthFailure || events[1].Type != models.EventSSHBruteForceSuccess {
+		t.Fatalf("events %+v, want a failure and a success event", events)
+	}
+	if events[0].Details["users"] != "admin,root" || events[0].Count != 5 {
+		t.Errorf("failure event %+v", events[0])
+	}
+	if want := time.Date(events[1].Timestamp.Year(), 3, 2, 15, 4, 10, 0, time.Local); !events[1].Timestamp.Equal(want) {
+		t.Errorf("success event at %v, want %v", events[1].Timestamp, want)
+	}
+}
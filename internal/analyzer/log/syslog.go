@@ -0,0 +1,389 @@
+package log
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSyslogMessage bounds a message, longer UDP messages are cut and TCP
+// connections framing longer ones are closed
+const maxSyslogMessage = 64 << 10
+
+// SyslogConfig configures the syslog receiver
+type SyslogConfig struct {
+	Enabled        bool
+	UDP            string   // UDP listen address, disabled if empty
+	TCP            string   // TCP listen address, disabled if empty
+	Allow          []string // IPs and networks messages are accepted from, all if empty
+	MaxConnections int      // concurrent TCP connections
+}
+
+// DefaultSyslogConfig returns the settings used for unset values
+func DefaultSyslogConfig() SyslogConfig {
+	return SyslogConfig{
+		Enabled:        true,
+		UDP:            ":514",
+		TCP:            ":514",
+		MaxConnections: 100,
+	}
+}
+
+// SyslogServer receives RFC 3164 and RFC 5424 messages over UDP and TCP and
+// routes them to the handlers accepting their program. Over TCP both octet
+// counting and newline framing (RFC 6587) are accepted. Messages without a
+// hostname are attributed to the address of their sender.
+type SyslogServer struct {
+	config   SyslogConfig
+	allow    []*net.IPNet
+	handlers []MessageHandler
+	conns    map[net.Conn]struct{}
+	mutex    sync.Mutex
+}
+
+func NewSyslogServer(config SyslogConfig) (*SyslogServer, error) {
+	defaults := DefaultSyslogConfig()
+	if config.MaxConnections <= 0 {
+		config.MaxConnections = defaults.MaxConnections
+	}
+	if config.UDP == "" && config.TCP == "" {
+		return nil, fmt.Errorf("no syslog listen address")
+	}
+
+	s := &SyslogServer{
+		config: config,
+		conns:  make(map[net.Conn]struct{}),
+	}
+	for _, value := range config.Allow {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid syslog allow address %q", value)
+			}
+			bits := net.IPv6len * 8
+			if ip.To4() != nil {
+				bits = net.IPv4len * 8
+			}
+			s.allow = append(s.allow, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid syslog allow network %q", value)
+		}
+		s.allow = append(s.allow, ipNet)
+	}
+	return s, nil
+}
+
+// AddHandler routes the messages of the programs h accepts to h, handlers
+// must be added before Start
+func (s *SyslogServer) AddHandler(h MessageHandler) {
+	s.handlers = append(s.handlers, h)
+}
+
+// Start listens on the configured addresses until ctx is done
+func (s *SyslogServer) Start(ctx context.Context) error {
+	var packetConn net.PacketConn
+	if s.config.UDP != "" {
+		conn, err := net.ListenPacket("udp", s.config.UDP)
+		if err != nil {
+			return err
+		}
+		packetConn = conn
+	}
+	var listener net.Listener
+	if s.config.TCP != "" {
+		l, err := net.Listen("tcp", s.config.TCP)
+		if err != nil {
+			if packetConn != nil {
+				packetConn.Close()
+			}
+			return err
+		}
+		listener = l
+	}
+
+	if packetConn != nil {
+		stdlog.Printf("Receiving syslog messages on udp %s", packetConn.LocalAddr())
+		go s.serveUDP(packetConn)
+	}
+	if listener != nil {
+		stdlog.Printf("Receiving syslog messages on tcp %s", listener.Addr())
+		go s.serveTCP(listener)
+	}
+
+	go func() {
+		<-ctx.Done()
+		if packetConn != nil {
+			packetConn.Close()
+		}
+		if listener != nil {
+			listener.Close()
+		}
+		s.mutex.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mutex.Unlock()
+	}()
+	return nil
+}
+
+func (s *SyslogServer) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				stdlog.Printf("Failed to receive syslog message: %v", err)
+			}
+			return
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || !s.allowed(udpAddr.IP) {
+			continue
+		}
+		s.handle(string(buf[:n]), udpAddr.IP)
+	}
+}
+
+func (s *SyslogServer) serveTCP(listener net.Listener) {
+	slots := make(chan struct{}, s.config.MaxConnections)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				stdlog.Printf("Failed to accept syslog connection: %v", err)
+			}
+			return
+		}
+		tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok || !s.allowed(tcpAddr.IP) {
+			conn.Close()
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			stdlog.Printf("Too many syslog connections, rejected %s", tcpAddr)
+			conn.Close()
+			continue
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+		go func() {
+			defer func() { <-slots }()
+			s.serveConn(conn, tcpAddr.IP)
+		}()
+	}
+}
+
+// serveConn reads the messages of a connection, a frame starting with a
+// digit is octet counted ("LEN MSG"), other frames end with a newline
+func (s *SyslogServer) serveConn(conn net.Conn, ip net.IP) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, 64<<10)
+	for {
+		message, err := readSyslogFrame(reader)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				stdlog.Printf("Closing syslog connection of %s: %v", ip, err)
+			}
+			return
+		}
+		s.handle(message, ip)
+	}
+}
+
+// readSyslogFrame reads the next message of a TCP stream
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	for {
+		first, err := r.Peek(1)
+		if err != nil {
+			return "", err
+		}
+
+		if first[0] >= '0' && first[0] <= '9' {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return "", err
+			}
+			n, err := strconv.Atoi(length[:len(length)-1])
+			if err != nil || n > maxSyslogMessage {
+				return "", fmt.Errorf("invalid frame length %q", length)
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return "", err
+			}
+			return string(buf), nil
+		}
+
+		var line []byte
+		for {
+			chunk, isPrefix, err := r.ReadLine()
+			if err != nil {
+				return "", err
+			}
+			if len(line)+len(chunk) > maxSyslogMessage {
+				return "", fmt.Errorf("message longer than %d bytes", maxSyslogMessage)
+			}
+			line = append(line, chunk...)
+			if !isPrefix {
+				break
+			}
+		}
+		// skip the empty lines and NUL bytes some senders add
+		if message := strings.Trim(string(line), "\x00\r "); message != "" {
+			return message, nil
+		}
+	}
+}
+
+func (s *SyslogServer) allowed(ip net.IP) bool {
+	if len(s.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range s.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SyslogServer) handle(data string, ip net.IP) {
+	m, ok := parseSyslogMessage(data, time.Now())
+	if !ok {
+		return
+	}
+	if m.Host == "" {
+		m.Host = ip.String()
+	}
+	for _, h := range s.handlers {
+		if h.Accepts(m.Program) {
+			h.HandleMessage(m)
+		}
+	}
+}
+
+// parseSyslogMessage parses a message in the RFC 5424 format
+// ("<34>1 2024-01-02T15:04:05Z host sshd 123 - - message") or in the BSD
+// format of RFC 3164 ("<34>Jan  2 15:04:05 host sshd[123]: message"), where
+// the hostname may be missing
+func parseSyslogMessage(data string, now time.Time) (*Message, bool) {
+	data = strings.TrimRight(data, "\x00\r\n")
+	if !strings.HasPrefix(data, "<") {
+		return nil, false
+	}
+	end := strings.IndexByte(data, '>')
+	if end < 2 || end > 4 {
+		return nil, false
+	}
+	if pri, err := strconv.Atoi(data[1:end]); err != nil || pri > 191 {
+		return nil, false
+	}
+	rest := data[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		return parseSyslog5424(rest[2:], now)
+	}
+
+	ts, rest, ok := parseLogTimestamp(rest, now)
+	if !ok {
+		return nil, false
+	}
+	// without a hostname the tag directly follows the timestamp
+	if first, _, _ := strings.Cut(rest, " "); strings.HasSuffix(first, ":") {
+		return parseLogTag(rest, ts, "")
+	}
+	host, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return nil, false
+	}
+	return parseLogTag(rest, ts, host)
+}
+
+// parseSyslog5424 parses the part of an RFC 5424 message after the version
+func parseSyslog5424(rest string, now time.Time) (*Message, bool) {
+	fields := strings.SplitN(rest, " ", 6)
+	if len(fields) < 6 {
+		return nil, false
+	}
+	timestamp, host, program, pid := fields[0], fields[1], fields[2], fields[3]
+
+	m := &Message{Timestamp: now}
+	if timestamp != "-" {
+		ts, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return nil, false
+		}
+		m.Timestamp = ts
+	}
+	if host != "-" {
+		m.Host = host
+	}
+	if program != "-" {
+		m.Program = program
+	}
+	if pid != "-" {
+		m.PID, _ = strconv.Atoi(pid)
+	}
+
+	message, ok := skipStructuredData(fields[5])
+	if !ok {
+		return nil, false
+	}
+	m.Message = strings.TrimPrefix(message, "\ufeff")
+	return m, true
+}
+
+// skipStructuredData returns the message following the structured data,
+// which is either "-" or elements like [id param="value"] where quoted
+// values may contain escaped quotes and brackets
+func skipStructuredData(rest string) (string, bool) {
+	if strings.HasPrefix(rest, "-") {
+		return strings.TrimPrefix(rest[1:], " "), true
+	}
+
+	i := 0
+	for i < len(rest) && rest[i] == '[' {
+		quoted := false
+		for i++; i < len(rest); i++ {
+			if quoted && rest[i] == '\\' {
+				i++
+				continue
+			}
+			if rest[i] == '"' {
+				quoted = !quoted
+			} else if rest[i] == ']' && !quoted {
+				break
+			}
+		}
+		if i >= len(rest) {
+			return "", false
+		}
+		i++
+	}
+	if i == 0 {
+		return "", false
+	}
+	return strings.TrimPrefix(rest[i:], " "), true
+}
@@ -0,0 +1,233 @@
+package log
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// messageRecorder records the messages of the programs it accepts
+type messageRecorder struct {
+	programs []string
+	mutex    sync.Mutex
+	messages []*Message
+}
+
+func (r *messageRecorder) Accepts(program string) bool {
+	for _, p := range r.programs {
+		if p == program {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *messageRecorder) HandleMessage(m *Message) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.messages = append(r.messages, m)
+}
+
+func TestParseSyslogMessage(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	stamp := time.Date(2024, 3, 2, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		name string
+		data string
+		want *Message
+	}{
+		{
+			name: "rfc 3164",
+			data: "<38>Mar  2 15:04:05 web1 sshd[123]: Failed password for root from 203.0.113.5 port 4242 ssh2",
+			want: &Message{Timestamp: stamp, Host: "web1", Program: "sshd", PID: 123,
+				Message: "Failed password for root from 203.0.113.5 port 4242 ssh2"},
+		},
+		{
+			name: "rfc 3164 without hostname",
+			data: "<13>Mar  2 15:04:05 sshd[123]: Connection closed",
+			want: &Message{Timestamp: stamp, Program: "sshd", PID: 123, Message: "Connection closed"},
+		},
+		{
+			name: "rfc 3164 without pid, trailing CRLF and NUL",
+			data: "<78>Mar  2 15:04:05 web1 CRON: job done\r\n\x00",
+			want: &Message{Timestamp: stamp, Host: "web1", Program: "CRON", Message: "job done"},
+		},
+		{
+			name: "rfc 3164 with rfc 3339 timestamp",
+			data: "<38>2024-03-02T15:04:05+01:00 web1 sshd[7]: Accepted publickey",
+			want: &Message{Timestamp: time.Date(2024, 3, 2, 15, 4, 5, 0, time.FixedZone("", 3600)),
+				Host: "web1", Program: "sshd", PID: 7, Message: "Accepted publickey"},
+		},
+		{
+			name: "rfc 5424",
+			data: "<86>1 2024-03-02T15:04:05.123Z web1 sshd 123 ID47 - Accepted password for bob",
+			want: &Message{Timestamp: time.Date(2024, 3, 2, 15, 4, 5, 123000000, time.UTC),
+				Host: "web1", Program: "sshd", PID: 123, Message: "Accepted password for bob"},
+		},
+		{
+			name: "rfc 5424 with structured data and BOM",
+			data: `<165>1 2024-03-02T15:04:05Z - nginx - - [ex@32473 iut="3" note="a \"]\" b"][meta x="1"] ` + "\ufeffhello",
+			want: &Message{Timestamp: time.Date(2024, 3, 2, 15, 4, 5, 0, time.UTC), Program: "nginx", Message: "hello"},
+		},
+		{
+			name: "rfc 5424 nil values",
+			data: "<14>1 - - - - - -",
+			want: &Message{Timestamp: now},
+		},
+		{name: "no priority", data: "Mar  2 15:04:05 web1 sshd[1]: x"},
+		{name: "priority too large", data: "<192>Mar  2 15:04:05 web1 sshd[1]: x"},
+		{name: "priority not closed", data: "<38Mar  2 15:04:05 web1 sshd[1]: x"},
+		{name: "empty priority", data: "<>Mar  2 15:04:05 web1 sshd[1]: x"},
+		{name: "bad rfc 3164 timestamp", data: "<38>Foo 99 99:99:99 web1 sshd[1]: x"},
+		{name: "rfc 3164 without tag", data: "<38>Mar  2 15:04:05 web1 just some text"},
+		{name: "rfc 5424 too few fields", data: "<14>1 2024-03-02T15:04:05Z web1 sshd"},
+		{name: "bad rfc 5424 timestamp", data: "<14>1 yesterday web1 sshd - - - x"},
+		{name: "unterminated structured data", data: `<14>1 - web1 sshd - - [ex a="]`},
+		{name: "bad structured data", data: "<14>1 - web1 sshd - - x message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseSyslogMessage(tt.data, now)
+			if tt.want == nil {
+				if ok {
+					t.Errorf("parsed %+v, want rejection", got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("rejected")
+			}
+			if !got.Timestamp.Equal(tt.want.Timestamp) {
+				t.Errorf("timestamp %v, want %v", got.Timestamp, tt.want.Timestamp)
+			}
+			got.Timestamp, tt.want.Timestamp = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSyslogFrame(t *testing.T) {
+	long := strings.Repeat("a", maxSyslogMessage)
+	tests := []struct {
+		name    string
+		stream  string
+		want    []string
+		wantErr bool // the stream ends with an error other than io.EOF
+	}{
+		{
+			name:   "octet counting",
+			stream: "5 hello12 with\nnewline",
+			want:   []string{"hello", "with\nnewline"},
+		},
+		{
+			name:   "newline framing",
+			stream: "first\r\n\n\x00\nsecond\nlast",
+			want:   []string{"first", "second", "last"},
+		},
+		{
+			name:   "mixed",
+			stream: "<13>one\n8 <13>two\n\n<13>three\n",
+			want:   []string{"<13>one", "<13>two\n", "<13>three"},
+		},
+		{
+			name:   "longest messages",
+			stream: long + "\n" + strconv.Itoa(maxSyslogMessage) + " " + long,
+			want:   []string{long, long},
+		},
+		{
+			name:    "oversized octet count",
+			stream:  "3 one" + strconv.Itoa(maxSyslogMessage+1) + " " + long + "a",
+			want:    []string{"one"},
+			wantErr: true,
+		},
+		{
+			name:    "oversized line",
+			stream:  "one\n" + long + "a\nthree\n",
+			want:    []string{"one"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid length",
+			stream:  "12x message",
+			wantErr: true,
+		},
+		{
+			name:    "truncated frame",
+			stream:  "10 short",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReaderSize(strings.NewReader(tt.stream), 64<<10)
+			var got []string
+			var err error
+			for {
+				var message string
+				if message, err = readSyslogFrame(r); err != nil {
+					break
+				}
+				got = append(got, message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("frames %.40q, want %.40q", got, tt.want)
+			}
+			if (err != io.EOF) != tt.wantErr {
+				t.Errorf("stream ended with %v", err)
+			}
+		})
+	}
+}
+
+func TestSyslogServeConn(t *testing.T) {
+	s, err := NewSyslogServer(SyslogConfig{TCP: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := &messageRecorder{programs: []string{"sshd"}}
+	s.AddHandler(recorder)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.serveConn(server, net.ParseIP("192.0.2.7"))
+		close(done)
+	}()
+
+	counted := "<38>Mar  2 15:04:05 sshd[1]: counted"
+	frames := strconv.Itoa(len(counted)) + " " + counted +
+		"<38>Mar  2 15:04:05 web1 sshd[2]: newline\n" +
+		"<38>Mar  2 15:04:05 web1 cron[3]: ignored\n" +
+		strconv.Itoa(maxSyslogMessage+1) + " <38>Mar  2 15:04:05 web1 sshd[4]: too long"
+	// the server closes the connection at the oversized frame, so the write
+	// of its remainder fails
+	if _, err := client.Write([]byte(frames)); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed after an oversized frame")
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("connection still open")
+	}
+
+	var got []string
+	for _, m := range recorder.messages {
+		got = append(got, m.Host+" "+m.Message)
+	}
+	want := []string{"192.0.2.7 counted", "web1 newline"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("messages %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package log
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+var (
+	// "alice : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/bash"
+	// "alice : user NOT in sudoers ; TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/bin/ls"
+	sudoFailureRe = regexp.MustCompile(`^\s*(\S+) : (?:(\d+ incorrect password attempts?)|(user NOT in sudoers|user NOT authorized on host|command not allowed)) ; (.*)$`)
+	// "TCP: request_sock_TCP: Possible SYN flooding on port 80. Sending cookies.  Check SNMP counters."
+	kernelSYNFloodRe = regexp.MustCompile(`Possible SYN flooding on port (?:\[?[0-9a-fA-F.:]*\]?:)?(\d+)\. (\w+ \w+)`)
+	// "nginx[1234]: segfault at 0 ip 00007f1c2a4b5c6d sp 00007ffd1e2f3a40 error 4 in libc.so.6[7f1c2a400000+195000]"
+	kernelSegfaultRe = regexp.MustCompile(`^(\S+)\[(\d+)\]: segfault at (\S+) ip (\S+) sp \S+ error (\d+)(?: in (\S+?)\[)?`)
+)
+
+// SystemAnalyzer raises events for failed sudo attempts and for kernel
+// messages about SYN floods and crashing processes
+type SystemAnalyzer struct {
+	onEvent func(*models.Event)
+}
+
+func NewSystemAnalyzer() *SystemAnalyzer {
+	return &SystemAnalyzer{}
+}
+
+func (a *SystemAnalyzer) SetEventCallback(callback func(*models.Event)) {
+	a.onEvent = callback
+}
+
+// Accepts reports whether a program is sudo or the kernel
+func (a *SystemAnalyzer) Accepts(program string) bool {
+	return program == "sudo" || program == "kernel"
+}
+
+func (a *SystemAnalyzer) HandleMessage(m *Message) {
+	var event *models.Event
+	if m.Program == "sudo" {
+		event = parseSudoFailure(m)
+	} else {
+		event = parseKernelMessage(m)
+	}
+	if event != nil && a.onEvent != nil {
+		a.onEvent(event)
+	}
+}
+
+// parseSudoFailure returns an event for sudo rejecting a user, nil for other
+// messages
+func parseSudoFailure(m *Message) *models.Event {
+	match := sudoFailureRe.FindStringSubmatch(m.Message)
+	if match == nil {
+		return nil
+	}
+
+	user, reason := match[1], match[2]
+	severity := models.SeverityMedium
+	if reason == "" {
+		// users without sudo rights trying it are more suspicious than typos
+		reason = match[3]
+		severity = models.SeverityHigh
+	}
+	details := map[string]string{
+		"user":   user,
+		"reason": reason,
+	}
+	for _, field := range strings.Split(match[4], " ; ") {
+		if key, value, ok := strings.Cut(field, "="); ok {
+			if key == "USER" {
+				key = "run_as"
+			}
+			details[strings.ToLower(key)] = value
+		}
+	}
+
+	return &models.Event{
+		Type:      models.EventSudoFailure,
+		Severity:  severity,
+		Target:    m.Host,
+		Score:     1,
+		Count:     1,
+		Message:   fmt.Sprintf("sudo by %s as %s on %s failed: %s (%s)", user, details["run_as"], m.Host, reason, details["command"]),
+		Details:   details,
+		Timestamp: m.Timestamp,
+	}
+}
+
+// parseKernelMessage returns an event for the kernel detecting a SYN flood
+// or a process crashing on a memory access, nil for other messages
+func parseKernelMessage(m *Message) *models.Event {
+	if match := kernelSYNFloodRe.FindStringSubmatch(m.Message); match != nil {
+		return &models.Event{
+			Type:     models.EventKernelSYNFlood,
+			Severity: models.SeverityHigh,
+			Target:   m.Host + ":" + match[1],
+			Score:    1,
+			Count:    1,
+			Message:  fmt.Sprintf("Kernel reports a possible SYN flood on port %s of %s: %s", match[1], m.Host, strings.ToLower(match[2])),
+			Details: map[string]string{
+				"port":   match[1],
+				"action": strings.ToLower(match[2]),
+			},
+			Timestamp: m.Timestamp,
+		}
+	}
+
+	if match := kernelSegfaultRe.FindStringSubmatch(m.Message); match != nil {
+		return &models.Event{
+			Type:     models.EventProcessCrash,
+			Severity: models.SeverityMedium,
+			Target:   m.Host,
+			Score:    0.5,
+			Count:    1,
+			Message:  fmt.Sprintf("%s[%s] crashed on %s accessing %s", match[1], match[2], m.Host, match[3]),
+			Details: map[string]string{
+				"program": match[1],
+				"pid":     match[2],
+				"address": match[3],
+				"ip":      match[4],
+				"error":   match[5],
+				"object":  match[6],
+			},
+			Timestamp: m.Timestamp,
+		}
+	}
+	return nil
+}
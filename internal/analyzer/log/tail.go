@@ -0,0 +1,150 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"io"
+	stdlog "log"
+	"os"
+	"time"
+)
+
+const (
+	// tailPollInterval is how often followed files are checked for new
+	// lines and rotation
+	tailPollInterval = time.Second
+	// maxLineLength bounds a line, longer lines are cut
+	maxLineLength = 64 << 10
+)
+
+// tailer follows a log file across rotation. A file renamed away is read to
+// its end before the new file at the path is followed from its start, a
+// file truncated in place is read again from its start.
+type tailer struct {
+	path      string
+	file      *os.File
+	info      os.FileInfo
+	offset    int64
+	partial   []byte
+	skipFirst bool // start at the end of the file found at startup
+	missing   bool // the file was missing at the last attempt to open it
+}
+
+// tail calls handle with each line appended to the file at path until ctx
+// is done. Lines already in the file at startup are skipped unless
+// fromStart is set.
+func tail(ctx context.Context, path string, fromStart bool, handle func(line string)) {
+	t := &tailer{
+		path:      path,
+		skipFirst: !fromStart,
+	}
+	defer t.close()
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.poll(handle)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *tailer) poll(handle func(string)) {
+	if t.file == nil && !t.open() {
+		return
+	}
+	t.read(handle)
+
+	info, err := os.Stat(t.path)
+	switch {
+	case err != nil:
+		// rotated away and not yet recreated, the old file may still grow
+	case !os.SameFile(info, t.info):
+		stdlog.Printf("Log file %s was rotated", t.path)
+		t.close()
+		if t.open() {
+			t.read(handle)
+		}
+	case info.Size() < t.offset:
+		stdlog.Printf("Log file %s was truncated", t.path)
+		if _, err := t.file.Seek(0, io.SeekStart); err == nil {
+			t.offset, t.partial = 0, nil
+			t.read(handle)
+		}
+	}
+}
+
+// open opens the file at the path, only the file found at startup is
+// followed from its end
+func (t *tailer) open() bool {
+	skip := t.skipFirst
+	t.skipFirst = false
+
+	file, err := os.Open(t.path)
+	if err != nil {
+		if !t.missing {
+			stdlog.Printf("Failed to open log file %s: %v", t.path, err)
+			t.missing = true
+		}
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return false
+	}
+	t.missing = false
+
+	t.offset = 0
+	if skip {
+		if t.offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return false
+		}
+	}
+	t.file, t.info, t.partial = file, info, nil
+	return true
+}
+
+// read hands out the complete lines appended since the last read
+func (t *tailer) read(handle func(string)) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := t.file.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			t.lines(buf[:n], handle)
+		}
+		if err != nil || n == 0 {
+			return
+		}
+	}
+}
+
+func (t *tailer) lines(data []byte, handle func(string)) {
+	data = append(t.partial, data...)
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			break
+		}
+		handle(string(bytes.TrimSuffix(data[:end], []byte("\r"))))
+		data = data[end+1:]
+	}
+	if len(data) > maxLineLength {
+		handle(string(data[:maxLineLength]))
+		data = nil
+	}
+	t.partial = append([]byte(nil), data...)
+}
+
+func (t *tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
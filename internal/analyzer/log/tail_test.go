@@ -0,0 +1,81 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTailerRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.log")
+	appendFile := func(path, data string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lines []string
+	handle := func(line string) { lines = append(lines, line) }
+	tl := &tailer{path: path, skipFirst: true}
+	defer func() { tl.close() }()
+	poll := func(want ...string) {
+		t.Helper()
+		lines = nil
+		tl.poll(handle)
+		if !reflect.DeepEqual(lines, want) {
+			t.Errorf("lines %q, want %q", lines, want)
+		}
+	}
+
+	// lines of the file found at startup are skipped, later ones are read
+	// complete only
+	appendFile(path, "old 1\nold 2\n")
+	poll()
+	appendFile(path, "new 1\r\nnew ")
+	poll("new 1")
+	appendFile(path, "2\n")
+	poll("new 2")
+
+	// the renamed file is read to its end before the new one is followed
+	// from its start
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(path+".1", "late\n")
+	poll("late")
+	appendFile(path, "rotated 1\n")
+	poll("rotated 1")
+	appendFile(path+".1", "lost\n")
+	appendFile(path, "rotated 2\n")
+	poll("rotated 2")
+
+	// a file truncated in place is read again from its start
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(path, "t 1\n")
+	poll("t 1")
+	appendFile(path, "t 2\n")
+	poll("t 2")
+
+	// overlong lines are cut
+	appendFile(path, strings.Repeat("x", maxLineLength+10))
+	poll(strings.Repeat("x", maxLineLength))
+
+	// a file missing at startup is read from its start once it appears
+	missing := filepath.Join(dir, "secure")
+	tl.close()
+	tl = &tailer{path: missing, skipFirst: true}
+	poll()
+	appendFile(missing, "first\n")
+	poll("first")
+}
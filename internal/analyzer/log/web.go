@@ -1 +1,452 @@
 package log
+
+import (
+	"context"
+	"fmt"
+	stdlog "log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// DefaultWebLogs are the access logs of nginx and Apache on Debian and Red
+// Hat based distributions
+var DefaultWebLogs = []WebLog{
+	{Path: "/var/log/nginx/access.log", Format: "combined"},
+	{Path: "/var/log/apache2/access.log", Format: "combined"},
+	{Path: "/var/log/httpd/access_log", Format: "combined"},
+}
+
+// WebLog is an access log to follow, or to receive as the messages of a
+// program over syslog or from the journal
+type WebLog struct {
+	Name    string // shown in the stats and events, defaults to the path or program
+	Path    string
+	Program string // syslog tag the lines are logged under instead of written to Path
+	Format  string // predefined format name, nginx log_format or Apache LogFormat definition
+}
+
+// WebConfig configures the analyzer of web server access logs
+type WebConfig struct {
+	Enabled     bool
+	Logs        []WebLog // log files that do not exist are skipped
+	FromStart   bool     // read the lines already in the logs at startup
+	Window      time.Duration
+	MaxRequests int     // requests per client and window that trigger an event
+	MinErrors   int     // 4xx or 5xx responses per log and window a spike needs
+	SpikeFactor float64 // a spike exceeds the average errors of the previous windows by this factor
+}
+
+// DefaultWebConfig returns the settings used for unset values
+func DefaultWebConfig() WebConfig {
+	return WebConfig{
+		Enabled:     true,
+		Logs:        DefaultWebLogs,
+		Window:      time.Minute,
+		MaxRequests: 600,
+		MinErrors:   50,
+		SpikeFactor: 4,
+	}
+}
+
+const (
+	// maxWebClients bounds the number of clients tracked
+	maxWebClients = 10000
+	// maxWebEvidence bounds the clients listed in an event
+	maxWebEvidence = 10
+	// spikeHistory is the number of windows the error average adapts over
+	spikeHistory = 30
+)
+
+// webLog is an access log with its compiled format
+type webLog struct {
+	name    string
+	path    string
+	program string
+	format  *webLogFormat
+	failed  atomic.Bool // a line did not match the format
+}
+
+// webClientStats counts the requests of a client within the window
+type webClientStats struct {
+	start     time.Time
+	last      time.Time
+	requests  int
+	logs      map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// webErrorStats counts the 4xx or 5xx responses of a log per window and
+// their average over the previous windows
+type webErrorStats struct {
+	window  time.Time // start of the current window
+	errors  int
+	clients map[string]int
+	average float64
+	windows int
+	alerted bool
+}
+
+// WebAnalyzer follows web server access logs, reports every request and
+// raises events for clients exceeding the request rate and for spikes of
+// client or server errors
+type WebAnalyzer struct {
+	config    WebConfig
+	logs      []*webLog
+	onRequest func(*models.WebLogRequest)
+	onEvent   func(*models.Event)
+	clients   map[string]*webClientStats
+	errors    map[string]*webErrorStats // "log class" -> errors
+	mutex     sync.Mutex
+}
+
+func NewWebAnalyzer(config WebConfig) (*WebAnalyzer, error) {
+	defaults := DefaultWebConfig()
+	if len(config.Logs) == 0 {
+		config.Logs = defaults.Logs
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MaxRequests <= 0 {
+		config.MaxRequests = defaults.MaxRequests
+	}
+	if config.MinErrors <= 0 {
+		config.MinErrors = defaults.MinErrors
+	}
+	if config.SpikeFactor <= 1 {
+		config.SpikeFactor = defaults.SpikeFactor
+	}
+
+	a := &WebAnalyzer{
+		config:  config,
+		clients: make(map[string]*webClientStats),
+		errors:  make(map[string]*webErrorStats),
+	}
+	for _, l := range config.Logs {
+		if l.Format == "" {
+			l.Format = "combined"
+		}
+		name := l.Name
+		if name == "" {
+			name = l.Path
+		}
+		if name == "" {
+			name = l.Program
+		}
+		if l.Path == "" && l.Program == "" {
+			return nil, fmt.Errorf("web log %s: neither path nor program set", name)
+		}
+		format, err := parseWebLogFormat(l.Format)
+		if err != nil {
+			return nil, fmt.Errorf("web log %s: %v", name, err)
+		}
+		a.logs = append(a.logs, &webLog{
+			name:    name,
+			path:    l.Path,
+			program: l.Program,
+			format:  format,
+		})
+	}
+	return a, nil
+}
+
+func (a *WebAnalyzer) SetRequestCallback(callback func(*models.WebLogRequest)) {
+	a.onRequest = callback
+}
+
+func (a *WebAnalyzer) SetEventCallback(callback func(*models.Event)) {
+	a.onEvent = callback
+}
+
+// Start follows the configured log files that exist until ctx is done, the
+// lines of logs with a program are routed to the analyzer by other sources
+func (a *WebAnalyzer) Start(ctx context.Context) error {
+	followed := 0
+	for _, l := range a.logs {
+		if l.program != "" {
+			followed++
+		}
+		if l.path == "" {
+			continue
+		}
+		if _, err := os.Stat(l.path); err != nil {
+			stdlog.Printf("Web log %s not found, skipped", l.path)
+			continue
+		}
+		go tail(ctx, l.path, a.config.FromStart, func(line string) {
+			a.handleLine(l, l.name, line, time.Now())
+		})
+		followed++
+	}
+	if followed == 0 {
+		return fmt.Errorf("no web log found")
+	}
+
+	go a.runCleanup(ctx)
+	return nil
+}
+
+// Accepts reports whether a program logs one of the configured logs
+func (a *WebAnalyzer) Accepts(program string) bool {
+	for _, l := range a.logs {
+		if l.program == program {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleMessage parses a line of a log received as a message, the stats of
+// each host logging it are kept apart as "name@host"
+func (a *WebAnalyzer) HandleMessage(m *Message) {
+	for _, l := range a.logs {
+		if l.program != m.Program {
+			continue
+		}
+		name := l.name
+		if m.Host != "" {
+			name += "@" + m.Host
+		}
+		a.handleLine(l, name, m.Message, m.Timestamp)
+	}
+}
+
+// handleLine parses a line of the log, ts is used for formats without time
+func (a *WebAnalyzer) handleLine(l *webLog, name, line string, ts time.Time) {
+	req, ok := l.format.parse(line)
+	if !ok {
+		if line != "" && l.failed.CompareAndSwap(false, true) {
+			stdlog.Printf("Line of web log %s does not match its format: %q", name, line)
+		}
+		return
+	}
+	req.Log = name
+	if req.Timestamp.IsZero() {
+		req.Timestamp = ts
+	}
+
+	if a.onRequest != nil {
+		a.onRequest(req)
+	}
+	for _, event := range a.addRequest(req) {
+		if a.onEvent != nil {
+			a.onEvent(event)
+		}
+	}
+}
+
+func (a *WebAnalyzer) addRequest(req *models.WebLogRequest) []*models.Event {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	var events []*models.Event
+	if event := a.addClientRequest(req); event != nil {
+		events = append(events, event)
+	}
+	if req.Status >= 400 && req.Status < 600 {
+		if event := a.addError(req); event != nil {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// addClientRequest counts the request for the client and returns an event
+// once it exceeds the limit within the window, and again when the requests
+// have doubled since
+func (a *WebAnalyzer) addClientRequest(req *models.WebLogRequest) *models.Event {
+	ts, window := req.Timestamp, a.config.Window
+	stats, ok := a.clients[req.ClientIP]
+	if !ok {
+		if len(a.clients) >= maxWebClients {
+			return nil
+		}
+		stats = &webClientStats{}
+		a.clients[req.ClientIP] = stats
+	}
+	if stats.start.IsZero() || ts.Sub(stats.start) >= window {
+		*stats = webClientStats{
+			start:     ts,
+			logs:      make(map[string]struct{}),
+			lastAlert: stats.lastAlert,
+			alerted:   stats.alerted,
+		}
+	}
+	stats.last = ts
+	stats.requests++
+	stats.logs[req.Log] = struct{}{}
+
+	if stats.requests < a.config.MaxRequests {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < window && stats.requests < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = stats.requests
+
+	severity := models.SeverityMedium
+	if stats.requests >= 5*a.config.MaxRequests {
+		severity = models.SeverityHigh
+	}
+	logs := make([]string, 0, len(stats.logs))
+	for name := range stats.logs {
+		logs = append(logs, name)
+	}
+	sort.Strings(logs)
+
+	return &models.Event{
+		Type:     models.EventWebRequestFlood,
+		Severity: severity,
+		SrcIP:    req.ClientIP,
+		Sources:  []string{req.ClientIP},
+		Target:   webTarget(req),
+		Score:    min(1, float64(stats.requests)/float64(5*a.config.MaxRequests)),
+		Count:    stats.requests,
+		Message: fmt.Sprintf("Request flood from %s: %d requests in %s",
+			req.ClientIP, stats.requests, ts.Sub(stats.start).Round(time.Second)),
+		Details: map[string]string{
+			"requests": strconv.Itoa(stats.requests),
+			"logs":     strings.Join(logs, ","),
+			"window":   window.String(),
+		},
+		Timestamp: ts,
+	}
+}
+
+// addError counts a 4xx or 5xx response for the log and returns an event
+// once the errors of the window exceed their average over the previous
+// windows by the spike factor
+func (a *WebAnalyzer) addError(req *models.WebLogRequest) *models.Event {
+	class := "4xx"
+	if req.Status >= 500 {
+		class = "5xx"
+	}
+	key := req.Log + " " + class
+	stats, ok := a.errors[key]
+	if !ok {
+		stats = &webErrorStats{}
+		a.errors[key] = stats
+	}
+
+	window := req.Timestamp.Truncate(a.config.Window)
+	if stats.window.IsZero() {
+		stats.window = window
+		stats.clients = make(map[string]int)
+	}
+	if window.After(stats.window) {
+		// fold the finished window and the idle ones since into the average
+		count := float64(stats.errors)
+		for idle := 0; window.After(stats.window) && idle < spikeHistory; idle++ {
+			stats.windows = min(stats.windows+1, spikeHistory)
+			stats.average += (count - stats.average) / float64(stats.windows)
+			stats.window = stats.window.Add(a.config.Window)
+			count = 0
+		}
+		stats.window = window
+		stats.errors = 0
+		stats.clients = make(map[string]int)
+		stats.alerted = false
+	}
+
+	stats.errors++
+	if _, ok := stats.clients[req.ClientIP]; ok || len(stats.clients) < maxWebClients {
+		stats.clients[req.ClientIP]++
+	}
+
+	if stats.alerted || stats.errors < a.config.MinErrors ||
+		float64(stats.errors) < a.config.SpikeFactor*stats.average {
+		return nil
+	}
+	stats.alerted = true
+
+	clients := make([]string, 0, len(stats.clients))
+	for ip := range stats.clients {
+		clients = append(clients, ip)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if stats.clients[clients[i]] == stats.clients[clients[j]] {
+			return clients[i] < clients[j]
+		}
+		return stats.clients[clients[i]] > stats.clients[clients[j]]
+	})
+	if len(clients) > maxWebEvidence {
+		clients = clients[:maxWebEvidence]
+	}
+	top := make([]string, len(clients))
+	for i, ip := range clients {
+		top[i] = fmt.Sprintf("%s(%d)", ip, stats.clients[ip])
+	}
+
+	// a single client causing most errors is likely probing the server
+	srcIP := ""
+	if 2*stats.clients[clients[0]] >= stats.errors {
+		srcIP = clients[0]
+	}
+	severity := models.SeverityMedium
+	if class == "5xx" {
+		severity = models.SeverityHigh
+	}
+
+	return &models.Event{
+		Type:     models.EventWebErrorSpike,
+		Severity: severity,
+		SrcIP:    srcIP,
+		Sources:  clients,
+		Target:   req.Log,
+		Score:    min(1, float64(stats.errors)/(a.config.SpikeFactor*max(stats.average, float64(a.config.MinErrors)))),
+		Count:    stats.errors,
+		Message: fmt.Sprintf("Spike of %s responses in %s: %d within %s, %.1f on average",
+			class, req.Log, stats.errors, a.config.Window, stats.average),
+		Details: map[string]string{
+			"class":   class,
+			"errors":  strconv.Itoa(stats.errors),
+			"average": strconv.FormatFloat(stats.average, 'f', 1, 64),
+			"clients": strings.Join(top, ","),
+			"window":  a.config.Window.String(),
+		},
+		Timestamp: req.Timestamp,
+	}
+}
+
+// webTarget returns the site a request was sent to
+func webTarget(req *models.WebLogRequest) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.Log
+}
+
+func (a *WebAnalyzer) runCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup forgets clients without requests for two windows
+func (a *WebAnalyzer) cleanup(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for ip, stats := range a.clients {
+		if now.Sub(stats.last) >= 2*a.config.Window {
+			delete(a.clients, ip)
+		}
+	}
+}
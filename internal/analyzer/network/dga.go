@@ -0,0 +1,358 @@
+package network
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// DGAConfig configures the classifier for algorithmically generated domains
+type DGAConfig struct {
+	Enabled       bool
+	Threshold     float64       // score from 0 to 1 a domain is considered generated at
+	Window        time.Duration // window NXDOMAIN responses are counted in per client
+	NXDomainBurst int           // NXDOMAIN responses per client and window that trigger an event
+	MinSuspicious float64       // share of generated names among the NXDOMAIN responses of a burst
+	Ignore        []string      // base domains never classified
+}
+
+// DefaultDGAConfig returns the settings used for unset values
+func DefaultDGAConfig() DGAConfig {
+	return DGAConfig{
+		Enabled:       true,
+		Threshold:     0.7,
+		Window:        time.Minute,
+		NXDomainBurst: 20,
+		MinSuspicious: 0.3,
+	}
+}
+
+// dgaCorpus holds the benign labels the bigram model is built from
+//
+//go:embed dga_corpus.txt
+var dgaCorpus string
+
+const (
+	// dgaAlphabet is a-z, 0-9, '-' and a boundary symbol for label start and end
+	dgaAlphabet = 38
+	dgaBoundary = dgaAlphabet - 1
+	// minDGALength is the label length below which names are not classified,
+	// short labels carry too little information
+	minDGALength = 7
+	// maxDGAClients bounds the number of clients NXDOMAIN bursts are tracked for
+	maxDGAClients = 10000
+	// maxDGAEvidence bounds the number of domains listed in an event
+	maxDGAEvidence = 20
+)
+
+// weights of the logistic function over the label features. They are set
+// by hand so the names of the corpus and of popular sites score well below
+// the default threshold and random labels well above it, they are not a
+// fitted model; tune DGAConfig.Threshold against local traffic instead.
+const (
+	dgaBias          = -9.5
+	dgaWeightBigram  = 1.1 // mean negative log2 likelihood per bigram
+	dgaWeightDigits  = 4.0 // share of digits
+	dgaWeightRun     = 0.45
+	dgaWeightEntropy = 0.6
+)
+
+// bigramModel holds the log2 probabilities of a character following another
+type bigramModel [dgaAlphabet][dgaAlphabet]float64
+
+var dgaModel = newBigramModel(dgaCorpus)
+
+func dgaIndex(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= '0' && c <= '9':
+		return 26 + int(c-'0')
+	case c == '-':
+		return 36
+	default:
+		return -1
+	}
+}
+
+// newBigramModel counts the bigrams of the corpus labels, unseen bigrams get
+// a small probability through additive smoothing
+func newBigramModel(corpus string) *bigramModel {
+	var counts [dgaAlphabet][dgaAlphabet]float64
+	for _, line := range strings.Split(corpus, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, label := range strings.Fields(strings.ToLower(line)) {
+			prev := dgaBoundary
+			for i := 0; i < len(label); i++ {
+				cur := dgaIndex(label[i])
+				if cur < 0 {
+					continue
+				}
+				counts[prev][cur]++
+				prev = cur
+			}
+			counts[prev][dgaBoundary]++
+		}
+	}
+
+	const smoothing = 0.1
+	model := &bigramModel{}
+	for i := range counts {
+		total := 0.0
+		for _, count := range counts[i] {
+			total += count + smoothing
+		}
+		for j, count := range counts[i] {
+			model[i][j] = math.Log2((count + smoothing) / total)
+		}
+	}
+	return model
+}
+
+// surprise returns the mean negative log2 likelihood per bigram of a label
+func (m *bigramModel) surprise(label string) float64 {
+	prev, sum, n := dgaBoundary, 0.0, 0
+	for i := 0; i < len(label); i++ {
+		cur := dgaIndex(label[i])
+		if cur < 0 {
+			continue
+		}
+		sum -= m[prev][cur]
+		prev = cur
+		n++
+	}
+	sum -= m[prev][dgaBoundary]
+	return sum / float64(n+1)
+}
+
+// DGAScore rates from 0 to 1 how likely the registered label of a domain
+// ("xkqjzvbw" for "www.xkqjzvbw.com") was generated by an algorithm
+func DGAScore(domain string) float64 {
+	label := dgaLabel(domain)
+	// punycode encodes internationalised names, its characters say nothing
+	// about whether the name was generated
+	if len(label) < minDGALength || strings.HasPrefix(label, "xn--") {
+		return 0
+	}
+
+	digits, run, maxRun := 0, 0, 0
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+			run = 0
+		case strings.IndexByte("aeiouy-", c) >= 0:
+			run = 0
+		default:
+			run++
+			maxRun = max(maxRun, run)
+		}
+	}
+
+	z := dgaBias +
+		dgaWeightBigram*dgaModel.surprise(label) +
+		dgaWeightDigits*float64(digits)/float64(len(label)) +
+		dgaWeightRun*float64(maxRun) +
+		dgaWeightEntropy*shannonEntropy(label)
+	return math.Round(100/(1+math.Exp(-z))) / 100
+}
+
+// dgaLabel returns the label left of the public suffix, which is the part
+// domain generation algorithms randomise
+func dgaLabel(domain string) string {
+	base := utils.BaseDomain(domain)
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		return base[:i]
+	}
+	return base
+}
+
+// nxdomainStats counts the NXDOMAIN responses of a client within the window
+type nxdomainStats struct {
+	start      time.Time
+	last       time.Time
+	nxdomains  int
+	suspicious int
+	domains    []string
+	lastAlert  time.Time
+}
+
+// dgaDetector scores queried domains and reports clients whose NXDOMAIN
+// responses burst, as bots cycle through generated names until one resolves
+type dgaDetector struct {
+	config  DGAConfig
+	ignore  map[string]struct{}
+	clients map[string]*nxdomainStats
+	alerted map[string]time.Time // client and base domain of reported names
+	mutex   sync.Mutex
+}
+
+func newDGADetector(config DGAConfig) *dgaDetector {
+	defaults := DefaultDGAConfig()
+	if config.Threshold <= 0 {
+		config.Threshold = defaults.Threshold
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.NXDomainBurst <= 0 {
+		config.NXDomainBurst = defaults.NXDomainBurst
+	}
+	if config.MinSuspicious <= 0 {
+		config.MinSuspicious = defaults.MinSuspicious
+	}
+
+	ignore := make(map[string]struct{}, len(config.Ignore))
+	for _, domain := range config.Ignore {
+		ignore[utils.BaseDomain(domain)] = struct{}{}
+	}
+
+	return &dgaDetector{
+		config:  config,
+		ignore:  ignore,
+		clients: make(map[string]*nxdomainStats),
+		alerted: make(map[string]time.Time),
+	}
+}
+
+// score returns the DGA score of a domain, 0 for ignored domains and
+// reverse lookups
+func (d *dgaDetector) score(domain string) float64 {
+	name := strings.ToLower(strings.TrimSuffix(domain, "."))
+	if _, ok := d.ignore[utils.BaseDomain(name)]; ok || isReverseLookup(name) {
+		return 0
+	}
+	return DGAScore(name)
+}
+
+// addQuery scores a query and returns an event for generated names that
+// were not reported for the client within the window
+func (d *dgaDetector) addQuery(query *models.DNSQueryStats) *models.Event {
+	query.DGAScore = d.score(query.Domain)
+	if query.DGAScore < d.config.Threshold {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	base := utils.BaseDomain(query.Domain)
+	key := query.SrcIP + " " + base
+	if last, ok := d.alerted[key]; ok && query.Timestamp.Sub(last) < d.config.Window {
+		return nil
+	}
+	if len(d.alerted) >= maxDGAClients {
+		return nil
+	}
+	d.alerted[key] = query.Timestamp
+
+	return &models.Event{
+		Type:     models.EventDGADomain,
+		Severity: models.SeverityLow,
+		SrcIP:    query.SrcIP,
+		Sources:  []string{query.SrcIP},
+		Target:   base,
+		Score:    query.DGAScore,
+		Count:    1,
+		Message:  fmt.Sprintf("%s queried likely generated domain %s", query.SrcIP, query.Domain),
+		Details: map[string]string{
+			"domain": query.Domain,
+			"type":   query.QueryType,
+		},
+		Timestamp: query.Timestamp,
+	}
+}
+
+// addResponse counts NXDOMAIN responses and returns an event once a client
+// exceeds the burst threshold within the window
+func (d *dgaDetector) addResponse(response *models.DNSResponse) *models.Event {
+	if response.Rcode != models.DNSRcodeNXDomain {
+		return nil
+	}
+	score := d.score(response.Domain)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats, ok := d.clients[response.ClientIP]
+	if !ok {
+		if len(d.clients) >= maxDGAClients {
+			return nil
+		}
+		stats = &nxdomainStats{}
+		d.clients[response.ClientIP] = stats
+	}
+
+	ts := response.Timestamp
+	if stats.start.IsZero() || ts.Sub(stats.start) >= d.config.Window {
+		*stats = nxdomainStats{start: ts, lastAlert: stats.lastAlert}
+	}
+	stats.last = ts
+	stats.nxdomains++
+	if score >= d.config.Threshold {
+		stats.suspicious++
+		if len(stats.domains) < maxDGAEvidence {
+			stats.domains = append(stats.domains, response.Domain)
+		}
+	}
+
+	if stats.nxdomains < d.config.NXDomainBurst || ts.Sub(stats.lastAlert) < d.config.Window {
+		return nil
+	}
+	share := float64(stats.suspicious) / float64(stats.nxdomains)
+	if share < d.config.MinSuspicious {
+		return nil
+	}
+	stats.lastAlert = ts
+
+	severity := models.SeverityMedium
+	if stats.nxdomains >= 5*d.config.NXDomainBurst {
+		severity = models.SeverityHigh
+	}
+
+	return &models.Event{
+		Type:     models.EventNXDomainBurst,
+		Severity: severity,
+		SrcIP:    response.ClientIP,
+		Sources:  []string{response.ClientIP},
+		Target:   response.ServerIP,
+		Score:    math.Round(share*100) / 100,
+		Count:    stats.nxdomains,
+		Message: fmt.Sprintf("NXDOMAIN burst from %s: %d responses, %d likely generated, in %s",
+			response.ClientIP, stats.nxdomains, stats.suspicious, ts.Sub(stats.start).Round(time.Second)),
+		Details: map[string]string{
+			"nxdomain":   strconv.Itoa(stats.nxdomains),
+			"suspicious": strconv.Itoa(stats.suspicious),
+			"domains":    strings.Join(stats.domains, ","),
+			"window":     d.config.Window.String(),
+		},
+		Timestamp: ts,
+	}
+}
+
+// cleanup forgets clients and reported names without activity for two windows
+func (d *dgaDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for ip, stats := range d.clients {
+		if now.Sub(stats.last) >= 2*d.config.Window {
+			delete(d.clients, ip)
+		}
+	}
+	for key, last := range d.alerted {
+		if now.Sub(last) >= 2*d.config.Window {
+			delete(d.alerted, key)
+		}
+	}
+}
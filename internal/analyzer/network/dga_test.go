@@ -0,0 +1,31 @@
+package network
+
+import "testing"
+
+func TestDGAScore(t *testing.T) {
+	threshold := DefaultDGAConfig().Threshold
+	for _, domain := range []string{
+		"www.wikipedia.org",
+		"stackoverflow.com",
+		"googleusercontent.com",
+		"cloudflareinsights.com",
+		"1password.com",
+		"twitchcdn.net",
+		"xn--80ak6aa92e.com",
+		"xn--mgbh0fb.xn--kgbechtv",
+	} {
+		if score := DGAScore(domain); score >= threshold {
+			t.Errorf("%s scores %.2f, at or above the threshold %.2f", domain, score, threshold)
+		}
+	}
+	for _, domain := range []string{
+		"xkqjzvbwpt.com",
+		"qwhrtzkpl.net",
+		"a1b2c3d4e5f6.info",
+		"ehcmbnfxgsg.biz",
+	} {
+		if score := DGAScore(domain); score < threshold {
+			t.Errorf("%s scores %.2f, below the threshold %.2f", domain, score, threshold)
+		}
+	}
+}
@@ -1 +1,313 @@
 package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+const (
+	// dnsQueryTimeout is how long a query waits for its response
+	dnsQueryTimeout = 5 * time.Second
+	// maxPendingDNSQueries bounds the number of queries waiting for a response
+	maxPendingDNSQueries = 10000
+)
+
+// zone transfer query types, not defined by gopacket
+const (
+	dnsTypeIXFR layers.DNSType = 251
+	dnsTypeAXFR layers.DNSType = 252
+)
+
+// dnsKey identifies a query by everything its response has to echo
+type dnsKey struct {
+	id       uint16
+	client   netip.AddrPort
+	server   netip.AddrPort
+	question string
+}
+
+func newDNSKey(id uint16, client, server netip.AddrPort, question *layers.DNSQuestion) dnsKey {
+	return dnsKey{
+		id:       id,
+		client:   client,
+		server:   server,
+		question: fmt.Sprintf("%s %s %s", strings.ToLower(string(question.Name)), question.Type, question.Class),
+	}
+}
+
+// dnsTracker correlates DNS queries with their responses
+type dnsTracker struct {
+	pending  map[dnsKey]*models.DNSQueryStats
+	lastSeen time.Time
+	mutex    sync.Mutex
+}
+
+func newDNSTracker() *dnsTracker {
+	return &dnsTracker{
+		pending: make(map[dnsKey]*models.DNSQueryStats),
+	}
+}
+
+// addQuery starts tracking the questions of a query and returns them.
+// Retransmissions of a pending query and queries beyond the pending limit
+// are returned marked with their pseudo response code instead.
+func (t *dnsTracker) addQuery(info *packetInfo, srcPort, dstPort uint16, dns *layers.DNS) []*models.DNSQueryStats {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.touch(info.Timestamp)
+	client, server := addrPort(info.SrcIP, srcPort), addrPort(info.DstIP, dstPort)
+
+	var queries []*models.DNSQueryStats
+	for i := range dns.Questions {
+		question := &dns.Questions[i]
+		key := newDNSKey(dns.ID, client, server, question)
+		query := &models.DNSQueryStats{
+			ID:         dns.ID,
+			Domain:     string(question.Name),
+			SrcIP:      info.SrcIP.String(),
+			SrcPort:    srcPort,
+			DNSServer:  info.DstIP.String(),
+			ServerPort: dstPort,
+			QueryType:  dnsTypeName(question.Type),
+			Interface:  info.Interface,
+			Timestamp:  info.Timestamp,
+		}
+		_, exists := t.pending[key]
+		switch {
+		case exists:
+			query.Rcode = models.DNSRcodeRetransmit
+		case len(t.pending) >= maxPendingDNSQueries:
+			query.Rcode = models.DNSRcodeUntracked
+		default:
+			t.pending[key] = query
+		}
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+// addResponse matches a response with its pending queries, responses
+// without a query are dropped
+func (t *dnsTracker) addResponse(info *packetInfo, srcPort, dstPort uint16, dns *layers.DNS) []*models.DNSResponse {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.touch(info.Timestamp)
+	client, server := addrPort(info.DstIP, dstPort), addrPort(info.SrcIP, srcPort)
+
+	answers := make([]models.DNSAnswer, 0, len(dns.Answers))
+	response := make([]string, 0, len(dns.Answers))
+	for i := range dns.Answers {
+		answer := &dns.Answers[i]
+		answers = append(answers, models.DNSAnswer{
+			Name: string(answer.Name),
+			Type: dnsTypeName(answer.Type),
+			TTL:  answer.TTL,
+			Data: answerData(answer),
+		})
+		response = append(response, answer.String())
+	}
+
+	var responses []*models.DNSResponse
+	for i := range dns.Questions {
+		key := newDNSKey(dns.ID, client, server, &dns.Questions[i])
+		query, ok := t.pending[key]
+		if !ok {
+			continue
+		}
+		delete(t.pending, key)
+
+		result := newDNSResponse(query, rcodeName(dns.ResponseCode), info.Timestamp)
+		result.Answers = answers
+		result.Response = response
+		responses = append(responses, result)
+	}
+	return responses
+}
+
+// expire returns the queries that have not been answered in time
+func (t *dnsTracker) expire(now time.Time) []*models.DNSResponse {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.lastSeen.After(now) {
+		now = t.lastSeen
+	}
+
+	var responses []*models.DNSResponse
+	for key, query := range t.pending {
+		if now.Sub(query.Timestamp) > dnsQueryTimeout {
+			delete(t.pending, key)
+			responses = append(responses, newDNSResponse(query, models.DNSRcodeTimeout, now))
+		}
+	}
+	return responses
+}
+
+// touch advances the tracker clock, see flowTable.touch
+func (t *dnsTracker) touch(ts time.Time) {
+	if ts.After(t.lastSeen) {
+		t.lastSeen = ts
+	}
+}
+
+func newDNSResponse(query *models.DNSQueryStats, rcode models.DNSRcode, ts time.Time) *models.DNSResponse {
+	response := &models.DNSResponse{
+		QueryID:    query.ID,
+		ClientIP:   query.SrcIP,
+		ClientPort: query.SrcPort,
+		ServerIP:   query.DNSServer,
+		ServerPort: query.ServerPort,
+		Domain:     query.Domain,
+		QueryType:  query.QueryType,
+		Rcode:      rcode,
+		Timestamp:  ts,
+	}
+	if rcode != models.DNSRcodeTimeout {
+		response.Latency = ts.Sub(query.Timestamp)
+	}
+	return response
+}
+
+func addrPort(ip []byte, port uint16) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr.Unmap(), port)
+}
+
+// dnsStreamParser splits DNS over TCP streams into their length prefixed
+// messages, see RFC 7766
+type dnsStreamParser struct {
+	analyzer *ipAnalyzer
+	conn     *streamConn
+	buf      [2][]byte // per direction, indexed by toServer
+}
+
+func (a *ipAnalyzer) newDNSStreamParser(conn *streamConn) streamParser {
+	return &dnsStreamParser{
+		analyzer: a,
+		conn:     conn,
+	}
+}
+
+func (p *dnsStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	buf := append(p.buf[dirIndex(toServer)], data...)
+	srcPort, dstPort := p.conn.ports(toServer)
+
+	for len(buf) >= 2 {
+		length := int(binary.BigEndian.Uint16(buf))
+		if len(buf) < 2+length {
+			break
+		}
+
+		dns := &layers.DNS{}
+		if err := dns.DecodeFromBytes(buf[2:2+length], gopacket.NilDecodeFeedback); err != nil {
+			// not DNS or out of sync, the rest of the stream is ignored
+			return false
+		}
+		p.analyzer.handleDNS(p.conn.packetInfo(toServer, 2+length, ts), srcPort, dstPort, dns)
+		buf = buf[2+length:]
+	}
+
+	// keep the incomplete message, zone transfers consist of many messages
+	// so consumed bytes are not kept around
+	p.buf[dirIndex(toServer)] = append([]byte(nil), buf...)
+	return true
+}
+
+func (p *dnsStreamParser) close() {}
+
+// zoneTransferEvent returns an event for AXFR and IXFR queries against
+// local hosts
+func zoneTransferEvent(info *packetInfo, question *layers.DNSQuestion) *models.Event {
+	if question.Type != dnsTypeAXFR && question.Type != dnsTypeIXFR {
+		return nil
+	}
+
+	src, dst := info.SrcIP.String(), info.DstIP.String()
+	zone := string(question.Name)
+	return &models.Event{
+		Type:     models.EventZoneTransfer,
+		Severity: models.SeverityMedium,
+		SrcIP:    src,
+		Sources:  []string{src},
+		Target:   dst,
+		Score:    1,
+		Count:    1,
+		Message:  fmt.Sprintf("%s zone transfer of %s requested by %s from %s", dnsTypeName(question.Type), zone, src, dst),
+		Details: map[string]string{
+			"zone": zone,
+			"type": dnsTypeName(question.Type),
+		},
+		Timestamp: info.Timestamp,
+	}
+}
+
+// dnsTypeName returns the mnemonic of a record or query type
+func dnsTypeName(t layers.DNSType) string {
+	switch t {
+	case dnsTypeAXFR:
+		return "AXFR"
+	case dnsTypeIXFR:
+		return "IXFR"
+	default:
+		return t.String()
+	}
+}
+
+// rcodeName returns the mnemonic of a response code
+func rcodeName(code layers.DNSResponseCode) models.DNSRcode {
+	switch code {
+	case layers.DNSResponseCodeNoErr:
+		return models.DNSRcodeNoError
+	case layers.DNSResponseCodeFormErr:
+		return models.DNSRcodeFormErr
+	case layers.DNSResponseCodeServFail:
+		return models.DNSRcodeServFail
+	case layers.DNSResponseCodeNXDomain:
+		return models.DNSRcodeNXDomain
+	case layers.DNSResponseCodeNotImp:
+		return models.DNSRcodeNotImp
+	case layers.DNSResponseCodeRefused:
+		return models.DNSRcodeRefused
+	default:
+		return models.DNSRcode(fmt.Sprintf("RCODE%d", code))
+	}
+}
+
+// answerData returns the record data of an answer in presentation format
+func answerData(answer *layers.DNSResourceRecord) string {
+	switch answer.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		return answer.IP.String()
+	case layers.DNSTypeCNAME:
+		return string(answer.CNAME)
+	case layers.DNSTypeNS:
+		return string(answer.NS)
+	case layers.DNSTypePTR:
+		return string(answer.PTR)
+	case layers.DNSTypeMX:
+		return fmt.Sprintf("%d %s", answer.MX.Preference, answer.MX.Name)
+	case layers.DNSTypeSRV:
+		return fmt.Sprintf("%d %d %d %s", answer.SRV.Priority, answer.SRV.Weight, answer.SRV.Port, answer.SRV.Name)
+	case layers.DNSTypeSOA:
+		return fmt.Sprintf("%s %s %d", answer.SOA.MName, answer.SOA.RName, answer.SOA.Serial)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(answer.TXTs))
+		for i, txt := range answer.TXTs {
+			txts[i] = string(txt)
+		}
+		return strings.Join(txts, " ")
+	default:
+		return answer.String()
+	}
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestDNSTrackerUntracked(t *testing.T) {
+	tracker := newDNSTracker()
+	info := &packetInfo{
+		SrcIP:     net.ParseIP("198.51.100.1"),
+		DstIP:     net.ParseIP("192.0.2.53"),
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	query := func(id uint16, name string) *models.DNSQueryStats {
+		dns := &layers.DNS{
+			ID:        id,
+			Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		}
+		queries := tracker.addQuery(info, 40000, 53, dns)
+		if len(queries) != 1 {
+			t.Fatalf("query %s reported %d times", name, len(queries))
+		}
+		return queries[0]
+	}
+
+	if q := query(1, "example.com"); q.Rcode != "" {
+		t.Errorf("first query marked %s", q.Rcode)
+	}
+	if q := query(1, "example.com"); q.Rcode != models.DNSRcodeRetransmit {
+		t.Errorf("retransmission marked %q", q.Rcode)
+	}
+	for i := len(tracker.pending); i < maxPendingDNSQueries; i++ {
+		query(uint16(i), fmt.Sprintf("host%d.example.com", i))
+	}
+	if q := query(0, "overflow.example.com"); q.Rcode != models.DNSRcodeUntracked {
+		t.Errorf("query beyond the pending limit marked %q", q.Rcode)
+	}
+	if len(tracker.pending) != maxPendingDNSQueries {
+		t.Errorf("%d pending queries, want %d", len(tracker.pending), maxPendingDNSQueries)
+	}
+
+	// the response of the retransmitted query completes the original
+	response := &layers.DNS{
+		ID: 1, QR: true,
+		Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	reply := &packetInfo{SrcIP: info.DstIP, DstIP: info.SrcIP, Timestamp: info.Timestamp.Add(time.Millisecond)}
+	if responses := tracker.addResponse(reply, 53, 40000, response); len(responses) != 1 {
+		t.Errorf("response matched %d queries, want 1", len(responses))
+	}
+}
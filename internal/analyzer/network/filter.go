@@ -0,0 +1,739 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/bpf"
+)
+
+// compileFilter compiles a capture filter expression into a classic BPF
+// program for Ethernet frames. It supports the commonly used subset of the
+// tcpdump syntax:
+//
+//	ip | ip6 | arp | tcp | udp | icmp | icmp6
+//	ip6 unknown, IPv6 packets whose transport header is behind more
+//	    extension headers than are skipped
+//	[src|dst] host ADDR
+//	[src|dst] net CIDR
+//	[tcp|udp] [src|dst] port N
+//	tcp[tcpflags] & FLAGS (==|!=) FLAGS, where FLAGS combines tcp-syn, tcp-ack,
+//	    ... or numbers with '|'; tcp[N] reads byte N of the TCP header
+//	not EXPR | EXPR and EXPR | EXPR or EXPR | ( EXPR )
+//
+// Frames may carry up to two VLAN tags, the transport header of IPv6
+// packets is found behind up to four extension headers. Behind more only
+// ip6 and ip6 unknown match, the transport primitives do not.
+func compileFilter(expr string) ([]bpf.RawInstruction, error) {
+	p := &filterParser{tokens: tokenizeFilter(expr)}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("unexpected %q in filter", tok)
+	}
+
+	c := &filterCompiler{}
+	accept, reject := c.newLabel(), c.newLabel()
+	c.emitPrologue()
+	c.compile(node, accept, reject)
+	c.place(accept)
+	c.emit(bpf.RetConstant{Val: filterSnapLen})
+	c.place(reject)
+	c.emit(bpf.RetConstant{Val: 0})
+
+	insns, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	return bpf.Assemble(insns)
+}
+
+// DefaultFilter delivers only the traffic the analyzers inspect: TCP, UDP
+// and ICMP for flow tracking. IPv6 packets the filter cannot see the
+// transport of are delivered as well, the analyzer follows the whole header
+// chain and stacking extension headers must not hide traffic from it.
+const DefaultFilter = "tcp or udp or icmp or icmp6 or ip6 unknown"
+
+// filterSnapLen is the number of bytes accepted from a matching frame
+const filterSnapLen = 262144
+
+const (
+	// frame offsets of untagged Ethernet frames
+	offEtherType = 12
+	offNetwork   = 14
+
+	// offsets within the IPv4 header
+	ipv4Frag  = 6
+	ipv4Proto = 9
+	ipv4Src   = 12
+	ipv4Dst   = 16
+
+	// offsets within the IPv6 header
+	ipv6Next      = 6
+	ipv6Src       = 8
+	ipv6Dst       = 24
+	ipv6HeaderLen = 40
+
+	// maxVLANTags and maxIPv6ExtHeaders bound the headers the prologue
+	// skips, as classic BPF cannot loop
+	maxVLANTags       = 2
+	maxIPv6ExtHeaders = 4
+)
+
+const (
+	etherTypeIPv4  = 0x0800
+	etherTypeIPv6  = 0x86dd
+	etherTypeARP   = 0x0806
+	etherTypeVLAN  = 0x8100
+	etherTypeQinQ  = 0x88a8
+	ipProtoICMP    = 1
+	ipProtoTCP     = 6
+	ipProtoUDP     = 17
+	ipProtoICMPv6  = 58
+	ipv6HopByHop   = 0
+	ipv6Routing    = 43
+	ipv6Fragment   = 44
+	ipv6AuthHeader = 51
+	ipv6DestOpts   = 60
+	// noProto is the transport protocol of frames without one
+	noProto = 0x100
+)
+
+// scratch memory slots filled by the prologue of every filter program
+const (
+	memEtherType    = iota // EtherType behind the VLAN tags
+	memNetwork             // offset of the network header
+	memProto               // transport protocol, noProto for non-IP frames
+	memTransport           // offset of the transport header
+	memHasTransport        // 1 unless a fragment other than the first
+	memHeaderLen           // length of the IPv6 extension header being skipped
+)
+
+// filterNode is a node of the parsed filter expression
+type filterNode interface{}
+
+type andNode struct{ left, right filterNode }
+
+type orNode struct{ left, right filterNode }
+
+type notNode struct{ node filterNode }
+
+// testNode runs the load instructions and then branches on cond/val
+type testNode struct {
+	loads []bpf.Instruction
+	cond  bpf.JumpTest
+	val   uint32
+}
+
+func allOf(nodes ...filterNode) filterNode {
+	node := nodes[0]
+	for _, n := range nodes[1:] {
+		node = &andNode{node, n}
+	}
+	return node
+}
+
+func anyOf(nodes ...filterNode) filterNode {
+	node := nodes[0]
+	for _, n := range nodes[1:] {
+		node = &orNode{node, n}
+	}
+	return node
+}
+
+// scratchTest tests a slot filled by the prologue
+func scratchTest(slot int, cond bpf.JumpTest, val uint32) filterNode {
+	return &testNode{
+		loads: []bpf.Instruction{bpf.LoadScratch{Dst: bpf.RegA, N: slot}},
+		cond:  cond,
+		val:   val,
+	}
+}
+
+// headerTest tests a field of the header starting at the offset held in
+// the given slot, masked with mask
+func headerTest(slot int, off uint32, size int, mask uint32, val uint32) filterNode {
+	loads := []bpf.Instruction{
+		bpf.LoadScratch{Dst: bpf.RegX, N: slot},
+		bpf.LoadIndirect{Off: off, Size: size},
+	}
+	if uint64(mask) != 1<<(8*size)-1 {
+		loads = append(loads, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask})
+	}
+	return &testNode{loads: loads, cond: bpf.JumpEqual, val: val}
+}
+
+func etherTypeTest(etherType uint32) filterNode {
+	return scratchTest(memEtherType, bpf.JumpEqual, etherType)
+}
+
+func ipv4ProtoTest(proto uint32) filterNode {
+	return allOf(etherTypeTest(etherTypeIPv4), scratchTest(memProto, bpf.JumpEqual, proto))
+}
+
+func ipv6ProtoTest(proto uint32) filterNode {
+	return allOf(etherTypeTest(etherTypeIPv6), scratchTest(memProto, bpf.JumpEqual, proto))
+}
+
+// protoTest matches IPv4 and IPv6 packets of a transport protocol,
+// including all their fragments
+func protoTest(proto uint32) filterNode {
+	return scratchTest(memProto, bpf.JumpEqual, proto)
+}
+
+// transportTest matches packets of the given protocols carrying a
+// transport header, fragments other than the first carry none
+func transportTest(protos ...uint32) filterNode {
+	var tests []filterNode
+	for _, proto := range protos {
+		tests = append(tests, protoTest(proto))
+	}
+	return allOf(anyOf(tests...), scratchTest(memHasTransport, bpf.JumpEqual, 1))
+}
+
+// portTest matches a TCP or UDP port
+func portTest(protos []uint32, dir string, port uint32) filterNode {
+	var ports []filterNode
+	for _, off := range portOffsets(dir) {
+		ports = append(ports, headerTest(memTransport, off, 2, 0xffff, port))
+	}
+	return allOf(transportTest(protos...), anyOf(ports...))
+}
+
+// portOffsets returns the offsets of the source and/or destination port
+// within the transport header
+func portOffsets(dir string) []uint32 {
+	switch dir {
+	case "src":
+		return []uint32{0}
+	case "dst":
+		return []uint32{2}
+	default:
+		return []uint32{0, 2}
+	}
+}
+
+// netTest matches IPv4 or IPv6 addresses within the given network
+func netTest(dir string, ipNet *net.IPNet) filterNode {
+	var srcOff, dstOff, etherType uint32
+	addr := ipNet.IP.To4()
+	mask := ipNet.Mask
+	if addr != nil {
+		srcOff, dstOff, etherType = ipv4Src, ipv4Dst, etherTypeIPv4
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+	} else {
+		addr = ipNet.IP.To16()
+		srcOff, dstOff, etherType = ipv6Src, ipv6Dst, etherTypeIPv6
+	}
+
+	match := func(off uint32) filterNode {
+		var words []filterNode
+		for i := 0; i < len(addr); i += 4 {
+			m := binary.BigEndian.Uint32(mask[i : i+4])
+			if m == 0 {
+				break
+			}
+			words = append(words, headerTest(memNetwork, off+uint32(i), 4, m,
+				binary.BigEndian.Uint32(addr[i:i+4])&m))
+		}
+		if len(words) == 0 {
+			// a /0 network matches every address
+			return etherTypeTest(etherType)
+		}
+		return allOf(words...)
+	}
+
+	var matches []filterNode
+	switch dir {
+	case "src":
+		matches = append(matches, match(srcOff))
+	case "dst":
+		matches = append(matches, match(dstOff))
+	default:
+		matches = append(matches, match(srcOff), match(dstOff))
+	}
+	return allOf(etherTypeTest(etherType), anyOf(matches...))
+}
+
+// tcpFlagOffset is the offset of the flags byte within the TCP header
+const tcpFlagOffset = 13
+
+var tcpFlagNames = map[string]uint32{
+	"tcp-fin":  0x01,
+	"tcp-syn":  0x02,
+	"tcp-rst":  0x04,
+	"tcp-push": 0x08,
+	"tcp-ack":  0x10,
+	"tcp-urg":  0x20,
+	"tcp-ece":  0x40,
+	"tcp-cwr":  0x80,
+}
+
+// tcpByteTest matches TCP packets whose header byte at off, masked with
+// mask, equals val
+func tcpByteTest(off, mask, val uint32) filterNode {
+	return allOf(transportTest(ipProtoTCP), headerTest(memTransport, off, 1, mask, val))
+}
+
+func tokenizeFilter(expr string) []string {
+	expr = strings.NewReplacer(
+		"(", " ( ", ")", " ) ",
+		"&&", " and ", "||", " or ",
+		"!=", " != ", "==", " == ", "=", " == ",
+		"!", " not ", "&", " & ", "|", " | ",
+	).Replace(expr)
+	return strings.Fields(expr)
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	switch p.peek() {
+	case "not":
+		p.next()
+		node, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node}, nil
+	case "(":
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing ')' in filter")
+		}
+		return node, nil
+	}
+	return p.parsePrimitive()
+}
+
+func (p *filterParser) parsePrimitive() (filterNode, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of filter")
+	case "ip":
+		return etherTypeTest(etherTypeIPv4), nil
+	case "ip6":
+		if p.peek() == "unknown" {
+			p.next()
+			return ipv6ProtoTest(noProto), nil
+		}
+		return etherTypeTest(etherTypeIPv6), nil
+	case "arp":
+		return etherTypeTest(etherTypeARP), nil
+	case "icmp":
+		return ipv4ProtoTest(ipProtoICMP), nil
+	case "icmp6":
+		return ipv6ProtoTest(ipProtoICMPv6), nil
+	case "tcp", "udp":
+		proto := uint32(ipProtoTCP)
+		if tok == "udp" {
+			proto = ipProtoUDP
+		}
+		switch p.peek() {
+		case "src", "dst", "port":
+			return p.parseQualified([]uint32{proto})
+		}
+		return protoTest(proto), nil
+	case "src", "dst", "host", "net", "port":
+		p.pos--
+		return p.parseQualified(nil)
+	}
+	if strings.HasPrefix(tok, "tcp[") && strings.HasSuffix(tok, "]") {
+		return p.parseTCPByte(strings.TrimSuffix(strings.TrimPrefix(tok, "tcp["), "]"))
+	}
+	return nil, fmt.Errorf("unknown filter primitive %q", tok)
+}
+
+// parseTCPByte parses the "& MASK (==|!=) VALUE" comparison following tcp[index]
+func (p *filterParser) parseTCPByte(index string) (filterNode, error) {
+	off := uint32(tcpFlagOffset)
+	if index != "tcpflags" {
+		n, err := strconv.ParseUint(index, 10, 8)
+		if err != nil || n >= 60 {
+			return nil, fmt.Errorf("invalid tcp header offset %q in filter", index)
+		}
+		off = uint32(n)
+	}
+
+	mask := uint32(0xff)
+	if p.peek() == "&" {
+		p.next()
+		value, err := p.parseByteValue()
+		if err != nil {
+			return nil, err
+		}
+		mask = value
+	}
+
+	op := p.next()
+	if op != "==" && op != "!=" {
+		return nil, fmt.Errorf("expected == or != in filter, got %q", op)
+	}
+	val, err := p.parseByteValue()
+	if err != nil {
+		return nil, err
+	}
+
+	node := tcpByteTest(off, mask, val&mask)
+	if op == "!=" {
+		// non-TCP packets must not match a negated comparison either
+		node = allOf(transportTest(ipProtoTCP), &notNode{node})
+	}
+	return node, nil
+}
+
+// parseByteValue parses numbers and tcp flag names combined with '|',
+// optionally enclosed in parentheses
+func (p *filterParser) parseByteValue() (uint32, error) {
+	paren := p.peek() == "("
+	if paren {
+		p.next()
+	}
+
+	var value uint32
+	for {
+		tok := p.next()
+		if flag, ok := tcpFlagNames[tok]; ok {
+			value |= flag
+		} else if n, err := strconv.ParseUint(tok, 0, 8); err == nil {
+			value |= uint32(n)
+		} else {
+			return 0, fmt.Errorf("invalid value %q in filter", tok)
+		}
+		if p.peek() != "|" {
+			break
+		}
+		p.next()
+	}
+
+	if paren && p.next() != ")" {
+		return 0, fmt.Errorf("missing ')' in filter")
+	}
+	return value, nil
+}
+
+// parseQualified parses "[src|dst] host|net|port VALUE", where ports may be
+// restricted to the given transport protocols
+func (p *filterParser) parseQualified(protos []uint32) (filterNode, error) {
+	dir := ""
+	if tok := p.peek(); tok == "src" || tok == "dst" {
+		dir = p.next()
+	}
+
+	kind, value := p.next(), p.next()
+	if protos != nil && kind != "port" {
+		return nil, fmt.Errorf("expected port in filter, got %q", kind)
+	}
+
+	switch kind {
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in filter", value)
+		}
+		if protos == nil {
+			protos = []uint32{ipProtoTCP, ipProtoUDP}
+		}
+		return portTest(protos, dir, uint32(port)), nil
+	case "host":
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid host %q in filter", value)
+		}
+		bits := net.IPv6len * 8
+		if ip.To4() != nil {
+			bits = net.IPv4len * 8
+		}
+		return netTest(dir, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}), nil
+	case "net":
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid net %q in filter", value)
+		}
+		return netTest(dir, ipNet), nil
+	default:
+		return nil, fmt.Errorf("expected host, net or port in filter, got %q", kind)
+	}
+}
+
+// filterOp is either an instruction or, when label is set, a jump target
+type filterOp struct {
+	ins    bpf.Instruction
+	label  int
+	jt, jf int
+}
+
+type filterCompiler struct {
+	ops    []filterOp
+	labels int
+}
+
+func (c *filterCompiler) newLabel() int {
+	c.labels++
+	return c.labels
+}
+
+func (c *filterCompiler) place(label int) {
+	c.ops = append(c.ops, filterOp{label: label})
+}
+
+func (c *filterCompiler) emit(ins bpf.Instruction) {
+	c.ops = append(c.ops, filterOp{ins: ins})
+}
+
+// jumpIf emits a conditional jump to the given labels
+func (c *filterCompiler) jumpIf(cond bpf.JumpTest, val uint32, onTrue, onFalse int) {
+	c.ops = append(c.ops, filterOp{ins: bpf.JumpIf{Cond: cond, Val: val}, jt: onTrue, jf: onFalse})
+}
+
+// jumpIfAny jumps to onTrue if A equals any of the values
+func (c *filterCompiler) jumpIfAny(vals []uint32, onTrue, onFalse int) {
+	for _, val := range vals[:len(vals)-1] {
+		next := c.newLabel()
+		c.jumpIf(bpf.JumpEqual, val, onTrue, next)
+		c.place(next)
+	}
+	c.jumpIf(bpf.JumpEqual, vals[len(vals)-1], onTrue, onFalse)
+}
+
+// jump emits an unconditional jump to the label
+func (c *filterCompiler) jump(label int) {
+	c.ops = append(c.ops, filterOp{ins: bpf.Jump{}, jt: label})
+}
+
+// emitPrologue emits the code filling the scratch memory slots the tests
+// read: it skips the VLAN tags and, for IPv6, the extension headers
+func (c *filterCompiler) emitPrologue() {
+	ipv4, ipv6, done := c.newLabel(), c.newLabel(), c.newLabel()
+
+	c.emit(bpf.LoadConstant{Dst: bpf.RegX, Val: offNetwork})
+	c.emit(bpf.LoadAbsolute{Off: offEtherType, Size: 2})
+	for i := uint32(1); i <= maxVLANTags; i++ {
+		tagged, untagged := c.newLabel(), c.newLabel()
+		c.jumpIfAny([]uint32{etherTypeVLAN, etherTypeQinQ}, tagged, untagged)
+		c.place(tagged)
+		c.emit(bpf.LoadAbsolute{Off: offEtherType + 4*i, Size: 2})
+		c.emit(bpf.LoadConstant{Dst: bpf.RegX, Val: offNetwork + 4*i})
+		c.place(untagged)
+	}
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memEtherType})
+	c.emit(bpf.StoreScratch{Src: bpf.RegX, N: memNetwork})
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: noProto})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memProto})
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 0})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHasTransport})
+	c.emit(bpf.LoadScratch{Dst: bpf.RegA, N: memEtherType})
+	notIPv4 := c.newLabel()
+	c.jumpIf(bpf.JumpEqual, etherTypeIPv4, ipv4, notIPv4)
+	c.place(notIPv4)
+	c.jumpIf(bpf.JumpEqual, etherTypeIPv6, ipv6, done)
+
+	// IPv4: the header length is given in 32 bit words
+	c.place(ipv4)
+	first := c.newLabel()
+	c.emit(bpf.LoadIndirect{Off: ipv4Proto, Size: 1})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memProto})
+	c.emit(bpf.LoadIndirect{Off: 0, Size: 1})
+	c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0x0f})
+	c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2})
+	c.emit(bpf.ALUOpX{Op: bpf.ALUOpAdd})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memTransport})
+	c.emit(bpf.LoadIndirect{Off: ipv4Frag, Size: 2})
+	c.jumpIf(bpf.JumpBitsSet, 0x1fff, done, first)
+	c.place(first)
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 1})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHasTransport})
+	c.jump(done)
+
+	// IPv6: X holds the offset of the header whose next header is in A
+	c.place(ipv6)
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 1})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHasTransport})
+	c.emit(bpf.TXA{})
+	c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: ipv6HeaderLen})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memTransport})
+	c.emit(bpf.LoadIndirect{Off: ipv6Next, Size: 1})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memProto})
+	c.emit(bpf.LoadScratch{Dst: bpf.RegX, N: memTransport})
+	extHeaders := []uint32{ipv6HopByHop, ipv6Routing, ipv6DestOpts, ipv6Fragment, ipv6AuthHeader}
+	for i := 0; i < maxIPv6ExtHeaders; i++ {
+		options, notOptions := c.newLabel(), c.newLabel()
+		fragment, notFragment := c.newLabel(), c.newLabel()
+		auth, skip := c.newLabel(), c.newLabel()
+		c.jumpIfAny([]uint32{ipv6HopByHop, ipv6Routing, ipv6DestOpts}, options, notOptions)
+		c.place(notOptions)
+		c.jumpIf(bpf.JumpEqual, ipv6Fragment, fragment, notFragment)
+		c.place(notFragment)
+		c.jumpIf(bpf.JumpEqual, ipv6AuthHeader, auth, done)
+
+		// a fragment header is 8 bytes, only the first fragment has an offset of 0
+		c.place(fragment)
+		later, firstFragment := c.newLabel(), c.newLabel()
+		c.emit(bpf.LoadIndirect{Off: 2, Size: 2})
+		c.jumpIf(bpf.JumpBitsSet, 0xfff8, later, firstFragment)
+		c.place(later)
+		c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 0})
+		c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHasTransport})
+		c.place(firstFragment)
+		c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 8})
+		c.jump(skip)
+
+		// the authentication header length is given in 4 byte units minus 2
+		c.place(auth)
+		c.emit(bpf.LoadIndirect{Off: 1, Size: 1})
+		c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 2})
+		c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2})
+		c.jump(skip)
+
+		// option and routing header lengths are given in 8 byte units minus 1
+		c.place(options)
+		c.emit(bpf.LoadIndirect{Off: 1, Size: 1})
+		c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 1})
+		c.emit(bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 3})
+
+		// A holds the length of the header at X
+		c.place(skip)
+		c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHeaderLen})
+		c.emit(bpf.LoadIndirect{Off: 0, Size: 1})
+		c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memProto})
+		c.emit(bpf.LoadScratch{Dst: bpf.RegA, N: memHeaderLen})
+		c.emit(bpf.ALUOpX{Op: bpf.ALUOpAdd})
+		c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memTransport})
+		c.emit(bpf.TAX{})
+		c.emit(bpf.LoadScratch{Dst: bpf.RegA, N: memProto})
+	}
+	// more extension headers than are skipped, the transport is unknown
+	unknown := c.newLabel()
+	c.jumpIfAny(extHeaders, unknown, done)
+	c.place(unknown)
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: noProto})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memProto})
+	c.emit(bpf.LoadConstant{Dst: bpf.RegA, Val: 0})
+	c.emit(bpf.StoreScratch{Src: bpf.RegA, N: memHasTransport})
+
+	c.place(done)
+}
+
+// compile emits code that jumps to onTrue if the node matches and to onFalse otherwise
+func (c *filterCompiler) compile(node filterNode, onTrue, onFalse int) {
+	switch n := node.(type) {
+	case *andNode:
+		next := c.newLabel()
+		c.compile(n.left, next, onFalse)
+		c.place(next)
+		c.compile(n.right, onTrue, onFalse)
+	case *orNode:
+		next := c.newLabel()
+		c.compile(n.left, onTrue, next)
+		c.place(next)
+		c.compile(n.right, onTrue, onFalse)
+	case *notNode:
+		c.compile(n.node, onFalse, onTrue)
+	case *testNode:
+		for _, ins := range n.loads {
+			c.emit(ins)
+		}
+		c.ops = append(c.ops, filterOp{
+			ins: bpf.JumpIf{Cond: n.cond, Val: n.val},
+			jt:  onTrue,
+			jf:  onFalse,
+		})
+	}
+}
+
+// resolve drops the label markers and turns label references into jump offsets
+func (c *filterCompiler) resolve() ([]bpf.Instruction, error) {
+	positions := make(map[int]int)
+	count := 0
+	for _, op := range c.ops {
+		if op.label != 0 {
+			positions[op.label] = count
+			continue
+		}
+		count++
+	}
+
+	insns := make([]bpf.Instruction, 0, count)
+	for _, op := range c.ops {
+		if op.label != 0 {
+			continue
+		}
+		if jump, ok := op.ins.(bpf.Jump); ok {
+			jump.Skip = uint32(positions[op.jt] - len(insns) - 1)
+			op.ins = jump
+		}
+		if jump, ok := op.ins.(bpf.JumpIf); ok {
+			skipTrue := positions[op.jt] - len(insns) - 1
+			skipFalse := positions[op.jf] - len(insns) - 1
+			if skipTrue < 0 || skipFalse < 0 || skipTrue > 255 || skipFalse > 255 {
+				return nil, fmt.Errorf("filter is too complex")
+			}
+			jump.SkipTrue, jump.SkipFalse = uint8(skipTrue), uint8(skipFalse)
+			op.ins = jump
+		}
+		insns = append(insns, op.ins)
+	}
+	return insns, nil
+}
@@ -0,0 +1,113 @@
+package network
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+// readFrames returns the frames of a capture file in testdata
+func readFrames(t *testing.T, name string) [][]byte {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames [][]byte
+	for {
+		data, _, err := r.ReadPacketData()
+		if err == io.EOF {
+			return frames
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, data)
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	// frames of ipv6_ext.pcap, see testdata/gen.go:
+	//  0 IPv6 hop-by-hop, TCP SYN 2001:db8::10 -> 2001:db8::1 port 22
+	//  1 IPv6 destination options, UDP to port 53
+	//  2 IPv6 hop-by-hop, destination options and routing, UDP to port 53
+	//  3 IPv6 first fragment, UDP to port 53
+	//  4 IPv6 second fragment of a UDP datagram
+	//  5 IPv6 authentication header, TCP SYN to port 443
+	//  6 VLAN, IPv4 TCP SYN 192.0.2.10 -> 198.51.100.1 port 443
+	//  7 QinQ, IPv6 UDP to port 53
+	//  8 ARP
+	//  9 IPv4 second fragment of a UDP datagram
+	// 10 IPv4 with options, TCP SYN to port 80
+	// 11 IPv6 with five extension headers, TCP SYN to port 22
+	frames := readFrames(t, "ipv6_ext.pcap")
+
+	tests := []struct {
+		filter string
+		want   []int
+	}{
+		{"tcp", []int{0, 5, 6, 10}},
+		{"udp", []int{1, 2, 3, 4, 7, 9}},
+		{"ip", []int{6, 9, 10}},
+		{"ip6", []int{0, 1, 2, 3, 4, 5, 7, 11}},
+		{"arp", []int{8}},
+		{"port 53", []int{1, 2, 3, 7}},
+		{"udp dst port 53", []int{1, 2, 3, 7}},
+		{"not port 53", []int{0, 4, 5, 6, 8, 9, 10, 11}},
+		{"tcp dst port 22", []int{0}},
+		{"tcp port 443", []int{5, 6}},
+		{"src port 40010", []int{10}},
+		{"tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-syn", []int{0, 5, 6, 10}},
+		{"tcp[tcpflags] & tcp-ack != 0", nil},
+		{"src host 2001:db8::10", []int{0, 1, 2, 3, 4, 5, 7, 11}},
+		{"dst host 198.51.100.1", []int{6, 9, 10}},
+		{"net 192.0.2.0/24 and tcp", []int{6, 10}},
+		{DefaultFilter, []int{0, 1, 2, 3, 4, 5, 6, 7, 9, 10, 11}},
+		{"ip6 unknown", []int{11}},
+	}
+	for _, tt := range tests {
+		vm, err := newFilterVM(tt.filter)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.filter, err)
+		}
+		var got []int
+		for i, frame := range frames {
+			n, err := vm.Run(frame)
+			if err != nil {
+				t.Fatalf("%q, frame %d: %v", tt.filter, i, err)
+			}
+			if n > 0 {
+				got = append(got, i)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q matches frames %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"tcp or",
+		"(tcp",
+		"tcp host 192.0.2.1",
+		"port 70000",
+		"host 192.0.2",
+		"tcp[60] == 0",
+		"vlan",
+	} {
+		if _, err := compileFilter(filter); err == nil {
+			t.Errorf("%q compiled", filter)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package network
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ja3 returns the JA3 fingerprint of a ClientHello, the MD5 of its version,
+// cipher suites, extensions, groups and point formats with GREASE removed
+func ja3(hello *clientHello) string {
+	formats := make([]uint16, len(hello.pointFormats))
+	for i, format := range hello.pointFormats {
+		formats[i] = uint16(format)
+	}
+
+	fields := []string{
+		strconv.Itoa(int(hello.version)),
+		joinDecimal(hello.cipherSuites),
+		joinDecimal(hello.extensions),
+		joinDecimal(hello.groups),
+		joinDecimal(formats),
+	}
+	sum := md5.Sum([]byte(strings.Join(fields, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello seen over TCP
+// ("t13d1516h2_8daaf6152771_e5627efa2ab1"), see the FoxIO JA4 specification
+func ja4(hello *clientHello) string {
+	version := hello.version
+	for _, v := range hello.versions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+
+	sni := "i"
+	if hello.serverName != "" {
+		sni = "d"
+	}
+
+	alpn := "00"
+	if len(hello.alpn) > 0 && hello.alpn[0] != "" {
+		alpn = ja4ALPN(hello.alpn[0])
+	}
+
+	ciphers := withoutGREASE(hello.cipherSuites)
+	extensions := withoutGREASE(hello.extensions)
+
+	// the extension hash leaves out SNI and ALPN, which the prefix covers
+	var hashed []uint16
+	for _, ext := range extensions {
+		if ext != tlsExtServerName && ext != tlsExtALPN {
+			hashed = append(hashed, ext)
+		}
+	}
+	extHash := joinHex(sortedCopy(hashed))
+	if sigAlgs := withoutGREASE(hello.sigAlgs); len(sigAlgs) > 0 {
+		extHash += "_" + joinHex(sigAlgs)
+	}
+
+	return fmt.Sprintf("t%s%s%02d%02d%s_%s_%s",
+		ja4Version(version), sni, min(len(ciphers), 99), min(len(extensions), 99), alpn,
+		truncatedHash(joinHex(sortedCopy(ciphers)), len(ciphers) == 0),
+		truncatedHash(extHash, len(hashed) == 0))
+}
+
+func ja4Version(version uint16) string {
+	switch version {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN returns the first and last character of an ALPN value, or of its
+// hex representation if either is not alphanumeric
+func ja4ALPN(protocol string) string {
+	first, last := protocol[0], protocol[len(protocol)-1]
+	if isAlphanumeric(first) && isAlphanumeric(last) {
+		return string([]byte{first, last})
+	}
+	encoded := hex.EncodeToString([]byte(protocol))
+	return encoded[:1] + encoded[len(encoded)-1:]
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// truncatedHash returns the first 12 hex digits of the SHA-256 of s, or
+// zeros if there was nothing to hash
+func truncatedHash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func withoutGREASE(values []uint16) []uint16 {
+	result := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func sortedCopy(values []uint16) []uint16 {
+	result := append([]uint16(nil), values...)
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func joinDecimal(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// FingerprintBlocklist maps JA3 or JA4 fingerprints to the reason they are listed
+type FingerprintBlocklist map[string]string
+
+// LoadFingerprintBlocklist reads a blocklist with one fingerprint per line,
+// optionally followed by a description separated by whitespace or a comma as
+// in the abuse.ch SSLBL JA3 CSV. Empty lines and lines starting with # are skipped.
+func LoadFingerprintBlocklist(path string) (FingerprintBlocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocklist := make(FingerprintBlocklist)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fingerprint, reason := line, ""
+		if i := strings.IndexAny(line, ", \t"); i >= 0 {
+			fingerprint, reason = line[:i], line[i+1:]
+		}
+		fingerprint = strings.ToLower(fingerprint)
+		if !isFingerprint(fingerprint) {
+			return nil, fmt.Errorf("%s:%d: invalid fingerprint %q", path, lineNo, fingerprint)
+		}
+		blocklist[fingerprint] = strings.TrimSpace(reason)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return blocklist, nil
+}
+
+// isFingerprint reports whether s looks like a JA3 hash or a JA4 fingerprint
+func isFingerprint(s string) bool {
+	if len(s) == 32 {
+		_, err := hex.DecodeString(s)
+		return err == nil
+	}
+	parts := strings.Split(s, "_")
+	return len(parts) == 3 && len(parts[0]) == 10 && len(parts[1]) == 12 && len(parts[2]) == 12
+}
+
+// match returns the listed fingerprint of the session and its reason
+func (b FingerprintBlocklist) match(ja3, ja4 string) (string, string, bool) {
+	if reason, ok := b[ja3]; ok && ja3 != "" {
+		return ja3, reason, true
+	}
+	if reason, ok := b[ja4]; ok && ja4 != "" {
+		return ja4, reason, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,316 @@
+package network
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket/layers"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+const (
+	// flowHandshakeTimeout expires flows whose handshake never completed
+	flowHandshakeTimeout = 30 * time.Second
+	// flowIdleTimeout expires established flows without traffic
+	flowIdleTimeout = 5 * time.Minute
+	// flowClosingTimeout expires flows where only one side sent a FIN
+	flowClosingTimeout = 30 * time.Second
+	// flowUDPTimeout expires UDP pseudo-flows without traffic
+	flowUDPTimeout = time.Minute
+	// flowICMPTimeout expires ICMP pseudo-flows without traffic
+	flowICMPTimeout = 30 * time.Second
+	// flowSweepInterval is how often idle flows are expired
+	flowSweepInterval = 5 * time.Second
+	// maxFlows bounds the number of tracked flows
+	maxFlows = 100000
+	// flowEvictBatch is the number of flows evicted at once from a full table
+	flowEvictBatch = maxFlows / 100
+)
+
+// flowKey identifies a flow from the point of view of the side that opened it
+type flowKey struct {
+	protocol models.Protocol
+	srcIP    netip.Addr
+	dstIP    netip.Addr
+	srcPort  uint16
+	dstPort  uint16
+}
+
+func newFlowKey(protocol models.Protocol, info *packetInfo, srcPort, dstPort uint16) flowKey {
+	srcIP, _ := netip.AddrFromSlice(info.SrcIP)
+	dstIP, _ := netip.AddrFromSlice(info.DstIP)
+	return flowKey{
+		protocol: protocol,
+		srcIP:    srcIP.Unmap(),
+		dstIP:    dstIP.Unmap(),
+		srcPort:  srcPort,
+		dstPort:  dstPort,
+	}
+}
+
+func (k flowKey) reverse() flowKey {
+	return flowKey{
+		protocol: k.protocol,
+		srcIP:    k.dstIP,
+		dstIP:    k.srcIP,
+		srcPort:  k.dstPort,
+		dstPort:  k.srcPort,
+	}
+}
+
+type flow struct {
+	key      flowKey
+	record   *models.FlowRecord
+	lastSeen time.Time
+	srcFin   bool
+	dstFin   bool
+}
+
+// flowTable tracks active flows and hands out their records once closed
+type flowTable struct {
+	flows    map[flowKey]*flow
+	lastSeen time.Time
+	mutex    sync.Mutex
+}
+
+func newFlowTable() *flowTable {
+	return &flowTable{
+		flows: make(map[flowKey]*flow),
+	}
+}
+
+// lookup returns the flow the packet belongs to and whether it was sent by
+// the side that opened the flow
+func (t *flowTable) lookup(key flowKey) (*flow, bool) {
+	if f, ok := t.flows[key]; ok {
+		return f, true
+	}
+	if f, ok := t.flows[key.reverse()]; ok {
+		return f, false
+	}
+	return nil, false
+}
+
+// tcpTransition reports how a packet changed the state of its flow
+type tcpTransition struct {
+	opened      bool               // the packet was the initial SYN
+	established bool               // the packet completed the handshake
+	closed      *models.FlowRecord // set if the packet closed the flow
+}
+
+// trackTCP updates the TCP flow of the packet. Flows are only tracked from
+// their initial SYN.
+func (t *flowTable) trackTCP(info *packetInfo, tcp *layers.TCP, direction models.Direction) tcpTransition {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.touch(info.Timestamp)
+
+	var transition tcpTransition
+	key := newFlowKey(models.ProtocolTCP, info, uint16(tcp.SrcPort), uint16(tcp.DstPort))
+	f, fromSrc := t.lookup(key)
+	if f == nil {
+		if !tcp.SYN || tcp.ACK {
+			return transition
+		}
+		f = t.add(key, info, direction, models.FlowStateSynSent)
+		fromSrc = true
+		transition.opened = true
+	}
+
+	f.count(info, fromSrc)
+	record := f.record
+
+	switch {
+	case tcp.RST:
+		record.State = models.FlowStateReset
+		transition.closed = t.close(f, models.FlowCloseRST)
+		return transition
+	case tcp.SYN && tcp.ACK && !fromSrc && record.State == models.FlowStateSynSent:
+		record.State = models.FlowStateSynReceived
+	case tcp.ACK && fromSrc && record.State == models.FlowStateSynReceived:
+		record.State = models.FlowStateEstablished
+		record.Handshake = true
+		transition.established = true
+	}
+
+	if tcp.FIN {
+		if fromSrc {
+			f.srcFin = true
+		} else {
+			f.dstFin = true
+		}
+		record.State = models.FlowStateClosing
+		if f.srcFin && f.dstFin {
+			record.State = models.FlowStateClosed
+			transition.closed = t.close(f, models.FlowCloseFIN)
+		}
+	}
+
+	return transition
+}
+
+// trackDatagram updates the UDP or ICMP pseudo-flow of the packet and
+// returns the new record if the packet started a flow. Pseudo-flows have no
+// connection state and are closed once idle.
+func (t *flowTable) trackDatagram(key flowKey, info *packetInfo, direction models.Direction, icmpType, icmpCode uint8) *models.FlowRecord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.touch(info.Timestamp)
+
+	f, fromSrc := t.lookup(key)
+	if f != nil {
+		f.count(info, fromSrc)
+		return nil
+	}
+
+	f = t.add(key, info, direction, models.FlowStateActive)
+	if key.protocol != models.ProtocolUDP {
+		// the key ports only group ICMP messages, type and code describe them
+		f.record.SrcPort, f.record.DstPort = 0, 0
+		f.record.ICMPType = icmpType
+		f.record.ICMPCode = icmpCode
+	}
+	f.count(info, true)
+
+	// hand out a copy, the tracked record keeps changing until it is closed
+	record := *f.record
+	return &record
+}
+
+// setTLS attaches the TLS metadata of a connection to its flow, flows that
+// are already closed are left alone
+func (t *flowTable) setTLS(key flowKey, info *models.TLSInfo) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if f, _ := t.lookup(key); f != nil {
+		f.record.TLS = info
+	}
+}
+
+// setSSH attaches the SSH banners of a connection to its flow, flows that
+// are already closed are left alone
+func (t *flowTable) setSSH(key flowKey, info *models.SSHInfo) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if f, _ := t.lookup(key); f != nil {
+		f.record.SSH = info
+	}
+}
+
+// add starts tracking a new flow, the caller holds the lock
+func (t *flowTable) add(key flowKey, info *packetInfo, direction models.Direction, state models.FlowState) *flow {
+	if len(t.flows) >= maxFlows {
+		t.evict()
+	}
+
+	f := &flow{
+		key: key,
+		record: &models.FlowRecord{
+			SrcIP:     info.SrcIP.String(),
+			SrcPort:   key.srcPort,
+			DstIP:     info.DstIP.String(),
+			DstPort:   key.dstPort,
+			Protocol:  key.protocol,
+			Direction: direction,
+			Interface: info.Interface,
+			State:     state,
+			StartTime: info.Timestamp,
+		},
+	}
+	t.flows[key] = f
+	return f
+}
+
+// evict makes room in a full table. Half-open flows go first, a flood of
+// spoofed SYNs fills the table with them, then the least recently seen
+// flows. Evicted flows are dropped without a record. The caller holds the
+// lock.
+func (t *flowTable) evict() {
+	var others []*flow
+	evicted := 0
+	for key, f := range t.flows {
+		switch f.record.State {
+		case models.FlowStateSynSent, models.FlowStateSynReceived:
+			delete(t.flows, key)
+			if evicted++; evicted == flowEvictBatch {
+				return
+			}
+		default:
+			others = append(others, f)
+		}
+	}
+
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].lastSeen.Before(others[j].lastSeen)
+	})
+	for _, f := range others[:min(len(others), flowEvictBatch-evicted)] {
+		delete(t.flows, f.key)
+	}
+}
+
+// touch advances the table clock, packet timestamps may be ahead of the
+// wall clock when replaying captures
+func (t *flowTable) touch(ts time.Time) {
+	if ts.After(t.lastSeen) {
+		t.lastSeen = ts
+	}
+}
+
+func (f *flow) count(info *packetInfo, fromSrc bool) {
+	if fromSrc {
+		f.record.SrcPackets++
+		f.record.SrcBytes += int64(info.Length)
+	} else {
+		f.record.DstPackets++
+		f.record.DstBytes += int64(info.Length)
+	}
+	f.lastSeen = info.Timestamp
+}
+
+// close removes the flow and finalizes its record, the caller holds the lock
+func (t *flowTable) close(f *flow, reason models.FlowCloseReason) *models.FlowRecord {
+	record := f.record
+	delete(t.flows, f.key)
+
+	record.EndTime = f.lastSeen
+	record.Duration = record.EndTime.Sub(record.StartTime)
+	record.CloseReason = reason
+	return record
+}
+
+// expire closes the flows that have been idle for too long
+func (t *flowTable) expire(now time.Time) []*models.FlowRecord {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.lastSeen.After(now) {
+		now = t.lastSeen
+	}
+
+	var records []*models.FlowRecord
+	for _, f := range t.flows {
+		timeout := flowIdleTimeout
+		switch f.record.State {
+		case models.FlowStateSynSent, models.FlowStateSynReceived:
+			timeout = flowHandshakeTimeout
+		case models.FlowStateClosing:
+			timeout = flowClosingTimeout
+		case models.FlowStateActive:
+			timeout = flowUDPTimeout
+			if f.key.protocol != models.ProtocolUDP {
+				timeout = flowICMPTimeout
+			}
+		}
+		if now.Sub(f.lastSeen) > timeout {
+			records = append(records, t.close(f, models.FlowCloseIdle))
+		}
+	}
+	return records
+}
@@ -0,0 +1,51 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestFlowTableEviction(t *testing.T) {
+	table := newFlowTable()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	add := func(i int, state models.FlowState) flowKey {
+		info := &packetInfo{
+			SrcIP:     net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)),
+			DstIP:     net.IPv4(198, 51, 100, 1),
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+		}
+		key := newFlowKey(models.ProtocolTCP, info, 40000, 443)
+		table.add(key, info, models.DirectionInbound, state).lastSeen = info.Timestamp
+		return key
+	}
+
+	// the table fills up with established flows and a few half-open ones
+	halfOpen := flowEvictBatch / 2
+	var keys []flowKey
+	for i := 0; i < maxFlows; i++ {
+		state := models.FlowStateEstablished
+		if i >= maxFlows-halfOpen {
+			state = models.FlowStateSynSent
+		}
+		keys = append(keys, add(i, state))
+	}
+	if len(table.flows) != maxFlows {
+		t.Fatalf("table holds %d flows, want %d", len(table.flows), maxFlows)
+	}
+
+	add(maxFlows, models.FlowStateSynSent)
+	if want := maxFlows - flowEvictBatch + 1; len(table.flows) != want {
+		t.Fatalf("table holds %d flows after eviction, want %d", len(table.flows), want)
+	}
+	// all half-open flows are gone before any established one, the
+	// remaining room is made by the least recently seen flows
+	for i, key := range keys {
+		_, ok := table.flows[key]
+		if want := i >= flowEvictBatch-halfOpen && i < maxFlows-halfOpen; ok != want {
+			t.Fatalf("flow %d tracked %v, want %v", i, ok, want)
+		}
+	}
+}
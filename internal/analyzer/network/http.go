@@ -0,0 +1,282 @@
+package network
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// DefaultHTTPPorts are the server ports whose plaintext HTTP is parsed if
+// none are configured
+var DefaultHTTPPorts = []uint16{80, 8080}
+
+const (
+	// maxHTTPHead bounds the size of a request or response head
+	maxHTTPHead = 16 << 10
+	// maxHTTPPending bounds the pipelined requests waiting for a response
+	maxHTTPPending = 32
+	// maxHTTPField bounds the length of the recorded path and headers
+	maxHTTPField = 512
+)
+
+var httpMethods = map[string]struct{}{
+	"GET": {}, "HEAD": {}, "POST": {}, "PUT": {}, "DELETE": {}, "OPTIONS": {},
+	"PATCH": {}, "TRACE": {}, "CONNECT": {}, "PROPFIND": {},
+}
+
+// httpBody tracks the body of a message that is being skipped
+type httpBody struct {
+	remaining  int64 // bytes left of the body or current chunk including its CRLF
+	chunked    bool
+	trailers   bool // the last chunk was read, trailers follow
+	untilClose bool // the body is delimited by the end of the connection
+}
+
+func (b *httpBody) active() bool {
+	return b.remaining > 0 || b.chunked || b.trailers || b.untilClose
+}
+
+// httpStreamParser extracts the request and response heads of a plaintext
+// HTTP/1.x connection and skips the bodies in between. Requests are matched
+// with responses in order, as HTTP/1.1 pipelining requires.
+type httpStreamParser struct {
+	analyzer *ipAnalyzer
+	conn     *streamConn
+	buf      [2][]byte   // unparsed data per direction, indexed by toServer
+	body     [2]httpBody // body being skipped per direction
+	pending  []*models.HTTPRequest
+}
+
+func (a *ipAnalyzer) newHTTPStreamParser(conn *streamConn) streamParser {
+	return &httpStreamParser{
+		analyzer: a,
+		conn:     conn,
+	}
+}
+
+func (p *httpStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	buf := append(p.buf[dir], data...)
+
+	for len(buf) > 0 {
+		if body := &p.body[dir]; body.active() {
+			var ok bool
+			if buf, ok = body.skip(buf); !ok {
+				p.flush()
+				return false
+			}
+			if body.active() {
+				break
+			}
+			continue
+		}
+
+		end := bytes.Index(buf, []byte("\r\n\r\n"))
+		if end < 0 {
+			if len(buf) > maxHTTPHead {
+				p.flush()
+				return false
+			}
+			break
+		}
+		head := string(buf[:end])
+		buf = buf[end+4:]
+
+		var ok bool
+		if toServer {
+			ok = p.request(head, ts)
+		} else {
+			ok = p.response(head, ts)
+		}
+		if !ok {
+			// not HTTP or out of sync, the rest of the stream is ignored
+			p.flush()
+			return false
+		}
+	}
+
+	p.buf[dir] = append([]byte(nil), buf...)
+	return true
+}
+
+// request parses a request head and queues it for its response
+func (p *httpStreamParser) request(head string, ts time.Time) bool {
+	line, headers, ok := parseHTTPHead(head)
+	if !ok {
+		return false
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "HTTP/1.") {
+		return false
+	}
+	if _, ok := httpMethods[parts[0]]; !ok {
+		return false
+	}
+
+	req := &models.HTTPRequest{
+		ClientIP:   p.conn.ClientIP.String(),
+		ClientPort: p.conn.ClientPort,
+		ServerIP:   p.conn.ServerIP.String(),
+		ServerPort: p.conn.ServerPort,
+		Direction:  p.conn.Direction,
+		Interface:  p.conn.Interface,
+		Method:     parts[0],
+		Path:       truncateField(parts[1]),
+		Version:    parts[2],
+		Host:       truncateField(headers["host"]),
+		UserAgent:  truncateField(headers["user-agent"]),
+		Referer:    truncateField(headers["referer"]),
+		Timestamp:  ts,
+	}
+	if len(p.pending) >= maxHTTPPending {
+		p.report(p.pending[0])
+		p.pending = p.pending[1:]
+	}
+	p.pending = append(p.pending, req)
+
+	p.body[dirIndex(true)] = messageBody(headers, false)
+	return true
+}
+
+// response parses a response head and completes the oldest pending request
+func (p *httpStreamParser) response(head string, ts time.Time) bool {
+	line, headers, ok := parseHTTPHead(head)
+	if !ok {
+		return false
+	}
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/1.") {
+		return false
+	}
+	status, err := strconv.Atoi(parts[1])
+	if err != nil || status < 100 || status > 999 {
+		return false
+	}
+
+	// interim responses precede the final response of the same request
+	if status < 200 {
+		return true
+	}
+
+	var req *models.HTTPRequest
+	if len(p.pending) > 0 {
+		req = p.pending[0]
+		p.pending = p.pending[1:]
+		req.Status = status
+		req.ContentType = truncateField(headers["content-type"])
+		req.Latency = ts.Sub(req.Timestamp)
+		p.report(req)
+	}
+
+	p.body[dirIndex(false)] = httpBody{}
+	if status != 204 && status != 304 && (req == nil || req.Method != "HEAD") {
+		p.body[dirIndex(false)] = messageBody(headers, true)
+	}
+	return true
+}
+
+// report hands a request to the analyzer
+func (p *httpStreamParser) report(req *models.HTTPRequest) {
+	p.analyzer.httpRequest(req)
+}
+
+// flush reports the requests that got no response
+func (p *httpStreamParser) flush() {
+	for _, req := range p.pending {
+		p.report(req)
+	}
+	p.pending = nil
+}
+
+func (p *httpStreamParser) close() {
+	p.flush()
+}
+
+// parseHTTPHead splits a message head into its start line and headers,
+// header names are lower case and repeated headers keep the first value
+func parseHTTPHead(head string) (string, map[string]string, bool) {
+	lines := strings.Split(head, "\r\n")
+	headers := make(map[string]string, len(lines)-1)
+	for _, line := range lines[1:] {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return "", nil, false
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := headers[name]; !exists {
+			headers[name] = strings.TrimSpace(value)
+		}
+	}
+	return lines[0], headers, true
+}
+
+// messageBody returns how the body of a message is delimited, responses
+// without a length run until the connection is closed
+func messageBody(headers map[string]string, response bool) httpBody {
+	if strings.Contains(strings.ToLower(headers["transfer-encoding"]), "chunked") {
+		return httpBody{chunked: true}
+	}
+	if length, err := strconv.ParseInt(headers["content-length"], 10, 64); err == nil && length > 0 {
+		return httpBody{remaining: length}
+	}
+	if response && headers["content-length"] == "" {
+		return httpBody{untilClose: true}
+	}
+	return httpBody{}
+}
+
+// skip consumes body bytes from buf and returns the rest, it fails on
+// malformed chunks
+func (b *httpBody) skip(buf []byte) ([]byte, bool) {
+	for len(buf) > 0 {
+		switch {
+		case b.untilClose:
+			return nil, true
+		case b.remaining > 0:
+			n := min(b.remaining, int64(len(buf)))
+			b.remaining -= n
+			buf = buf[n:]
+		case b.trailers:
+			// the trailer section ends with an empty line
+			if bytes.HasPrefix(buf, []byte("\r\n")) {
+				b.trailers = false
+				return buf[2:], true
+			}
+			end := bytes.Index(buf, []byte("\r\n\r\n"))
+			if end < 0 {
+				return buf, len(buf) <= maxHTTPHead
+			}
+			b.trailers = false
+			return buf[end+4:], true
+		case b.chunked:
+			end := bytes.Index(buf, []byte("\r\n"))
+			if end < 0 {
+				return buf, len(buf) <= maxHTTPHead
+			}
+			sizeField, _, _ := strings.Cut(string(buf[:end]), ";")
+			size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+			if err != nil || size < 0 {
+				return nil, false
+			}
+			buf = buf[end+2:]
+			if size == 0 {
+				b.chunked, b.trailers = false, true
+			} else {
+				b.remaining = size + 2
+			}
+		default:
+			return buf, true
+		}
+	}
+	return buf, true
+}
+
+func truncateField(s string) string {
+	if len(s) > maxHTTPField {
+		return s[:maxHTTPField]
+	}
+	return s
+}
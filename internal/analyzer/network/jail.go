@@ -0,0 +1,219 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// JailConfig configures a jail in the manner of fail2ban: the source of
+// events matching the filter is banned once it caused MaxRetry of them
+// within FindTime. Events already sum up attempts, so a MaxRetry of 1 bans
+// on the first matching event.
+type JailConfig struct {
+	Name        string
+	Events      []string          // event types, * matches any characters ("log:*")
+	MinSeverity models.Severity   // events of lower severity are ignored
+	Details     map[string]string // regular expressions the event details must match
+	MaxRetry    int
+	FindTime    time.Duration
+	BanTime     time.Duration // defaults to the blocker default
+	Whitelist   []string      // IPs and networks never banned by the jail
+}
+
+// DefaultJailConfig returns the settings used for unset values
+func DefaultJailConfig() JailConfig {
+	return JailConfig{
+		MinSeverity: models.SeverityLow,
+		MaxRetry:    1,
+		FindTime:    10 * time.Minute,
+	}
+}
+
+// maxJailSources bounds the number of sources tracked per jail
+const maxJailSources = 10000
+
+// jail counts the matching events of each source within FindTime
+type jail struct {
+	config    JailConfig
+	details   map[string]*regexp.Regexp
+	whitelist []*net.IPNet
+	hits      map[string][]time.Time // source -> times of the latest matching events
+	bans      int
+	lastBan   time.Time
+	lastIP    string
+	mutex     sync.Mutex
+}
+
+func newJail(config JailConfig) (*jail, error) {
+	defaults := DefaultJailConfig()
+	if config.MinSeverity == "" {
+		config.MinSeverity = defaults.MinSeverity
+	}
+	if config.MaxRetry <= 0 {
+		config.MaxRetry = defaults.MaxRetry
+	}
+	if config.FindTime <= 0 {
+		config.FindTime = defaults.FindTime
+	}
+
+	if config.Name == "" {
+		return nil, fmt.Errorf("jail without name")
+	}
+	if len(config.Events) == 0 {
+		return nil, fmt.Errorf("jail %s: no events", config.Name)
+	}
+	for _, pattern := range config.Events {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("jail %s: invalid event pattern %q", config.Name, pattern)
+		}
+	}
+	config.MinSeverity = models.Severity(strings.ToLower(string(config.MinSeverity)))
+	if _, ok := severityScores[config.MinSeverity]; !ok {
+		return nil, fmt.Errorf("jail %s: invalid severity %q", config.Name, config.MinSeverity)
+	}
+
+	j := &jail{
+		config:  config,
+		details: make(map[string]*regexp.Regexp, len(config.Details)),
+		hits:    make(map[string][]time.Time),
+	}
+	for key, pattern := range config.Details {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("jail %s: invalid pattern %q for %s: %v", config.Name, pattern, key, err)
+		}
+		j.details[key] = re
+	}
+	for _, value := range config.Whitelist {
+		ipNet, err := parseNetwork(value)
+		if err != nil {
+			return nil, fmt.Errorf("jail %s: %v", config.Name, err)
+		}
+		j.whitelist = append(j.whitelist, ipNet)
+	}
+	return j, nil
+}
+
+// parseNetwork parses a network in CIDR notation or a single address
+func parseNetwork(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", value)
+	}
+	bits := net.IPv6len * 8
+	if ip.To4() != nil {
+		bits = net.IPv4len * 8
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// matches reports whether the event passes the filter of the jail
+func (j *jail) matches(event *models.Event) bool {
+	matched := false
+	for _, pattern := range j.config.Events {
+		if ok, _ := path.Match(pattern, string(event.Type)); ok {
+			matched = true
+			break
+		}
+	}
+	if !matched || severityScores[event.Severity] < severityScores[j.config.MinSeverity] {
+		return false
+	}
+	for key, re := range j.details {
+		if !re.MatchString(event.Details[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (j *jail) whitelisted(ip string) bool {
+	addr := net.ParseIP(ip)
+	for _, ipNet := range j.whitelist {
+		if ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addEvent counts a matching event for its source and reports whether the
+// source is to be banned, the count starts over after a ban
+func (j *jail) addEvent(event *models.Event) bool {
+	if event.SrcIP == "" || !j.matches(event) || j.whitelisted(event.SrcIP) {
+		return false
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	hits, ok := j.hits[event.SrcIP]
+	if !ok && len(j.hits) >= maxJailSources {
+		return false
+	}
+	// keep the hits within FindTime, at most MaxRetry of them
+	ts := event.Timestamp
+	kept := hits[:0]
+	for _, hit := range hits {
+		if ts.Sub(hit) < j.config.FindTime {
+			kept = append(kept, hit)
+		}
+	}
+	kept = append(kept, ts)
+	if len(kept) > j.config.MaxRetry {
+		kept = kept[len(kept)-j.config.MaxRetry:]
+	}
+
+	if len(kept) < j.config.MaxRetry {
+		j.hits[event.SrcIP] = kept
+		return false
+	}
+	delete(j.hits, event.SrcIP)
+	j.bans++
+	j.lastBan = ts
+	j.lastIP = event.SrcIP
+	return true
+}
+
+func (j *jail) stats() *models.JailStats {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	return &models.JailStats{
+		Name:     j.config.Name,
+		Events:   append([]string(nil), j.config.Events...),
+		MaxRetry: j.config.MaxRetry,
+		FindTime: j.config.FindTime,
+		BanTime:  j.config.BanTime,
+		Tracked:  len(j.hits),
+		Bans:     j.bans,
+		LastBan:  j.lastBan,
+		LastIP:   j.lastIP,
+	}
+}
+
+// cleanup forgets sources without matching events for FindTime
+func (j *jail) cleanup(now time.Time) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for ip, hits := range j.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= j.config.FindTime {
+			delete(j.hits, ip)
+		}
+	}
+}
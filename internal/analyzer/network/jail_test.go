@@ -0,0 +1,229 @@
+package network
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestNewJail(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  JailConfig
+		wantErr bool
+	}{
+		{"valid", JailConfig{Name: "ssh", Events: []string{"log:ssh*"}, MinSeverity: "High"}, false},
+		{"no name", JailConfig{Events: []string{"syn_flood"}}, true},
+		{"no events", JailConfig{Name: "empty"}, true},
+		{"bad pattern", JailConfig{Name: "bad", Events: []string{"log:["}}, true},
+		{"bad severity", JailConfig{Name: "bad", Events: []string{"*"}, MinSeverity: "urgent"}, true},
+		{"bad details", JailConfig{Name: "bad", Events: []string{"*"}, Details: map[string]string{"user": "("}}, true},
+		{"bad whitelist", JailConfig{Name: "bad", Events: []string{"*"}, Whitelist: []string{"10.0.0.0/33"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := newJail(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newJail() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (j.config.MaxRetry != 1 || j.config.FindTime != 10*time.Minute) {
+				t.Errorf("defaults not applied: %+v", j.config)
+			}
+		})
+	}
+}
+
+func TestJailAddEvent(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	type hit struct {
+		offset   time.Duration
+		src      string
+		typ      models.EventType
+		severity models.Severity
+		details  map[string]string
+		ban      bool
+	}
+	tests := []struct {
+		name   string
+		config JailConfig
+		hits   []hit
+	}{
+		{
+			name:   "maxretry within findtime",
+			config: JailConfig{Events: []string{"ssh_brute_force"}, MaxRetry: 3, FindTime: time.Minute},
+			hits: []hit{
+				{offset: 0, ban: false},
+				{offset: 20 * time.Second, ban: false},
+				{offset: 40 * time.Second, ban: true},
+				// the count starts over after a ban
+				{offset: 50 * time.Second, ban: false},
+			},
+		},
+		{
+			name:   "hits older than findtime expire",
+			config: JailConfig{Events: []string{"ssh_brute_force"}, MaxRetry: 3, FindTime: time.Minute},
+			hits: []hit{
+				{offset: 0, ban: false},
+				{offset: 30 * time.Second, ban: false},
+				{offset: 61 * time.Second, ban: false},
+				{offset: 80 * time.Second, ban: true},
+			},
+		},
+		{
+			name:   "sources count apart",
+			config: JailConfig{Events: []string{"ssh_brute_force"}, MaxRetry: 2},
+			hits: []hit{
+				{offset: 0, ban: false},
+				{offset: time.Second, src: "203.0.113.2", ban: false},
+				{offset: 2 * time.Second, ban: true},
+			},
+		},
+		{
+			name:   "event patterns",
+			config: JailConfig{Events: []string{"log:*", "vertical_scan"}},
+			hits: []hit{
+				{typ: "log:ssh_auth", ban: true},
+				{typ: models.EventVerticalScan, ban: true},
+				{typ: models.EventSYNFlood, ban: false},
+				{typ: "log", ban: false},
+			},
+		},
+		{
+			name:   "minimum severity",
+			config: JailConfig{Events: []string{"*"}, MinSeverity: models.SeverityHigh},
+			hits: []hit{
+				{severity: models.SeverityMedium, ban: false},
+				{severity: models.SeverityHigh, ban: true},
+				{severity: models.SeverityCritical, ban: true},
+			},
+		},
+		{
+			name:   "details",
+			config: JailConfig{Events: []string{"*"}, Details: map[string]string{"user": "^root$"}},
+			hits: []hit{
+				{details: map[string]string{"user": "admin"}, ban: false},
+				{details: map[string]string{"user": "root"}, ban: true},
+				{ban: false},
+			},
+		},
+		{
+			name:   "whitelist",
+			config: JailConfig{Events: []string{"*"}, Whitelist: []string{"203.0.113.0/24", "2001:db8::1"}},
+			hits: []hit{
+				{src: "203.0.113.9", ban: false},
+				{src: "2001:db8::1", ban: false},
+				{src: "2001:db8::2", ban: true},
+				{src: "198.51.100.1", ban: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = "test"
+			j, err := newJail(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, h := range tt.hits {
+				event := &models.Event{
+					Type:      h.typ,
+					Severity:  h.severity,
+					SrcIP:     h.src,
+					Details:   h.details,
+					Timestamp: start.Add(h.offset),
+				}
+				if event.Type == "" {
+					event.Type = models.EventSSHBruteForce
+				}
+				if event.Severity == "" {
+					event.Severity = models.SeverityMedium
+				}
+				if event.SrcIP == "" {
+					event.SrcIP = "203.0.113.1"
+				}
+				if got := j.addEvent(event); got != h.ban {
+					t.Errorf("event %d bans %v, want %v", i, got, h.ban)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEventMergesBans(t *testing.T) {
+	tests := []struct {
+		name         string
+		autoBlock    time.Duration // no auto block if negative
+		jails        []JailConfig
+		wantDuration time.Duration
+		wantReason   string
+	}{
+		{
+			name:         "auto block only",
+			autoBlock:    time.Hour,
+			wantDuration: time.Hour,
+			wantReason:   "syn_flood",
+		},
+		{
+			name:         "longest ban wins",
+			autoBlock:    time.Hour,
+			jails:        []JailConfig{{Name: "flood", BanTime: 2 * time.Hour}, {Name: "any", BanTime: 30 * time.Minute}},
+			wantDuration: 2 * time.Hour,
+			wantReason:   "syn_flood, flood, any",
+		},
+		{
+			name:         "permanent jail",
+			autoBlock:    time.Hour,
+			jails:        []JailConfig{{Name: "forever"}},
+			wantDuration: 0,
+			wantReason:   "syn_flood, forever",
+		},
+		{
+			name:         "jails only",
+			autoBlock:    -1,
+			jails:        []JailConfig{{Name: "short", BanTime: time.Minute}, {Name: "long", BanTime: time.Hour}},
+			wantDuration: time.Hour,
+			wantReason:   "short, long",
+		},
+		{
+			name:         "jail not filled",
+			autoBlock:    time.Hour,
+			jails:        []JailConfig{{Name: "flood", MaxRetry: 2, BanTime: 2 * time.Hour}},
+			wantDuration: time.Hour,
+			wantReason:   "syn_flood",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &testBlocker{blocked: make(map[string]time.Duration), reasons: make(map[string][]string)}
+			m := NewAnalyzerManager(nil, b, testChecker{})
+			if tt.autoBlock >= 0 {
+				m.SetAutoBlock(models.EventSYNFlood, tt.autoBlock)
+			}
+			for i := range tt.jails {
+				tt.jails[i].Events = []string{string(models.EventSYNFlood)}
+			}
+			if err := m.SetJails(tt.jails); err != nil {
+				t.Fatal(err)
+			}
+
+			m.handleEvent(&models.Event{
+				Type:      models.EventSYNFlood,
+				Severity:  models.SeverityHigh,
+				SrcIP:     "203.0.113.7",
+				Timestamp: time.Now(),
+			})
+			waitBlocked(t, b, "203.0.113.7")
+
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			if got := b.blocked["203.0.113.7"]; got != tt.wantDuration {
+				t.Errorf("banned for %v, want %v", got, tt.wantDuration)
+			}
+			if got := b.reasons["203.0.113.7"]; !reflect.DeepEqual(got, []string{tt.wantReason}) {
+				t.Errorf("reasons %q, want one ban for %q", got, tt.wantReason)
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package network
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/samber/lo"
 
 	"github.com/safepointcloud/safepanel/internal/blocker"
 	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
 )
 
 type AnalyzerManager struct {
@@ -15,6 +19,17 @@ type AnalyzerManager struct {
 	blocker   blocker.IPBlocker
 	checker   IPChecker
 	collector *models.StatsCollector
+	autoBlock map[models.EventType]time.Duration
+	scans     *scanDetector
+	scanEvery time.Duration
+	tunnels   *dnsTunnelDetector
+	dga       *dgaDetector
+	blocklist FingerprintBlocklist
+	probes    *httpProbeDetector
+	db        *dbDetector
+	rules     *SignatureEngine
+	attacks   *webAttackDetector
+	jails     []*jail
 }
 
 func NewAnalyzerManager(analyzer IPAnalyzer, blocker blocker.IPBlocker, checker IPChecker) *AnalyzerManager {
@@ -23,9 +38,102 @@ func NewAnalyzerManager(analyzer IPAnalyzer, blocker blocker.IPBlocker, checker
 		blocker:   blocker,
 		checker:   checker,
 		collector: models.NewStatsCollector(),
+		autoBlock: make(map[models.EventType]time.Duration),
 	}
 }
 
+// SetScanDetection enables the port scan detector, must be called before Start
+func (m *AnalyzerManager) SetScanDetection(config ScanConfig) {
+	if !config.Enabled {
+		m.scans = nil
+		return
+	}
+	m.scans = newScanDetector(config)
+	m.scanEvery = m.scans.config.Interval
+}
+
+// SetDNSTunnelDetection enables the DNS tunneling detector, must be called
+// before Start
+func (m *AnalyzerManager) SetDNSTunnelDetection(config DNSTunnelConfig) {
+	if !config.Enabled {
+		m.tunnels = nil
+		return
+	}
+	m.tunnels = newDNSTunnelDetector(config)
+}
+
+// SetDGADetection enables the DGA classifier, must be called before Start
+func (m *AnalyzerManager) SetDGADetection(config DGAConfig) {
+	if !config.Enabled {
+		m.dga = nil
+		return
+	}
+	m.dga = newDGADetector(config)
+}
+
+// SetFingerprintBlocklist reports TLS clients whose JA3 or JA4 fingerprint
+// is listed, must be called before Start
+func (m *AnalyzerManager) SetFingerprintBlocklist(blocklist FingerprintBlocklist) {
+	m.blocklist = blocklist
+}
+
+// SetHTTPProbeDetection enables the detector for sources probing sensitive
+// paths over plaintext HTTP, must be called before Start
+func (m *AnalyzerManager) SetHTTPProbeDetection(config HTTPProbeConfig) {
+	if !config.Enabled {
+		m.probes = nil
+		return
+	}
+	m.probes = newHTTPProbeDetector(config)
+}
+
+// SetDBDetection enables the detector for brute force logins and
+// exploitation of database servers, must be called before Start
+func (m *AnalyzerManager) SetDBDetection(config DBConfig) {
+	if !config.Enabled {
+		m.db = nil
+		return
+	}
+	m.db = newDBDetector(config)
+}
+
+// SetWebAttackDetection matches plaintext HTTP requests and access log
+// requests against the signature rules and scores their sources, must be
+// called before Start
+func (m *AnalyzerManager) SetWebAttackDetection(rules *SignatureEngine, config WebAttackConfig) {
+	if !config.Enabled || rules == nil {
+		m.rules, m.attacks = nil, nil
+		return
+	}
+	m.rules = rules
+	m.attacks = newWebAttackDetector(config)
+}
+
+// SetAutoBlock blocks the source of events of the given type for duration,
+// must be called before Start
+func (m *AnalyzerManager) SetAutoBlock(eventType models.EventType, duration time.Duration) {
+	m.autoBlock[eventType] = duration
+}
+
+// SetJails bans the sources of events matching the jails, must be called
+// before Start
+func (m *AnalyzerManager) SetJails(configs []JailConfig) error {
+	names := make(map[string]struct{}, len(configs))
+	m.jails = nil
+	for _, config := range configs {
+		if _, ok := names[config.Name]; ok {
+			return fmt.Errorf("duplicate jail %s", config.Name)
+		}
+		names[config.Name] = struct{}{}
+		j, err := newJail(config)
+		if err != nil {
+			return err
+		}
+		m.jails = append(m.jails, j)
+	}
+	return nil
+}
+
 func (m *AnalyzerManager) Start(ctx context.Context) error {
 	// Set new connection callback
 	m.analyzer.SetNewConnectionCallback(func(stats *models.NewConnectionStats) {
@@ -41,20 +149,92 @@ func (m *AnalyzerManager) Start(ctx context.Context) error {
 
 	// Set DNS callback
 	m.analyzer.SetDNSQueryCallback(func(query *models.DNSQueryStats) {
+		// the score is stored with the query, so it is set before adding it
+		var dgaEvent *models.Event
+		if m.dga != nil {
+			dgaEvent = m.dga.addQuery(query)
+		}
 		m.collector.AddDNSQuery(query)
+		if dgaEvent != nil {
+			m.handleEvent(dgaEvent)
+		}
+		if m.tunnels != nil {
+			if event := m.tunnels.addQuery(query); event != nil {
+				m.handleEvent(event)
+			}
+		}
 	})
 
 	// Set DNS response callback
 	m.analyzer.SetDNSResponseCallback(func(response *models.DNSResponse) {
 		m.collector.AddDNSResponse(response)
+		if m.dga != nil {
+			if event := m.dga.addResponse(response); event != nil {
+				m.handleEvent(event)
+			}
+		}
+	})
+
+	// Set flow closed callback
+	m.analyzer.SetFlowClosedCallback(func(record *models.FlowRecord) {
+		m.collector.AddFlow(record)
+	})
+
+	// Set TLS session callback
+	m.analyzer.SetTLSSessionCallback(func(session *models.TLSSession) {
+		m.collector.AddTLSSession(session)
+		if event := m.checkFingerprint(session); event != nil {
+			m.checker.AddToStats(session.ClientIP, event.Details["reason"])
+			m.handleEvent(event)
+		}
 	})
 
+	// Set HTTP request callback
+	m.analyzer.SetHTTPRequestCallback(func(req *models.HTTPRequest) {
+		// the matches are stored with the request, so they are set before adding it
+		var attackEvent *models.Event
+		if m.rules != nil && req.Direction == models.DirectionInbound {
+			path, query, _ := strings.Cut(req.Path, "?")
+			req.Signatures = m.rules.Match(req.Method, req.Host, path, query, req.UserAgent, req.Referer)
+			target := req.Host
+			if target == "" {
+				target = utils.FormatAddr(req.ServerIP, req.ServerPort)
+			}
+			attackEvent = m.attacks.addMatches(req.ClientIP, target, req.Method+" "+req.Path, req.Signatures, req.Timestamp)
+		}
+		m.collector.AddHTTPRequest(req)
+		if attackEvent != nil {
+			m.handleEvent(attackEvent)
+		}
+		if m.probes != nil {
+			if event := m.probes.addRequest(req); event != nil {
+				m.handleEvent(event)
+			}
+		}
+	})
+
+	// Set database request callback
+	m.analyzer.SetDBRequestCallback(func(req *models.DBRequest) {
+		m.collector.AddDBRequest(req)
+		if m.db != nil {
+			if event := m.db.addRequest(req); event != nil {
+				m.handleEvent(event)
+			}
+		}
+	})
+
+	// Set event callback
+	m.analyzer.SetEventCallback(m.handleEvent)
+
 	// Start the analyzer
 	if err := m.analyzer.Start(ctx); err != nil {
 		return err
 	}
 
 	go m.runCleanup(ctx)
+	if m.scans != nil {
+		go m.runScanDetection(ctx)
+	}
 
 	return nil
 }
@@ -67,6 +247,20 @@ func (m *AnalyzerManager) GetDNSQueries() ([]*models.DNSQueryStats, error) {
 	return m.collector.GetDNSQueries(), nil
 }
 
+// GetDNSDomainStats returns the DNS response codes per base domain
+func (m *AnalyzerManager) GetDNSDomainStats() ([]*models.DNSRcodeStats, error) {
+	return lo.Values(m.collector.GetDNSDomainStats()), nil
+}
+
+// GetDNSClientStats returns the DNS response codes per client
+func (m *AnalyzerManager) GetDNSClientStats() ([]*models.DNSRcodeStats, error) {
+	return lo.Values(m.collector.GetDNSClientStats()), nil
+}
+
+func (m *AnalyzerManager) GetFlows() ([]*models.FlowRecord, error) {
+	return m.collector.GetFlows(), nil
+}
+
 func (m *AnalyzerManager) GetConnectionWindowStats() ([]*models.ConnectionWindowStats, error) {
 	return lo.Values(m.collector.GetConnectionWindows()), nil
 }
@@ -75,6 +269,222 @@ func (m *AnalyzerManager) GetPortWindowStats() ([]*models.PortWindowStats, error
 	return lo.Values(m.collector.GetPortWindows()), nil
 }
 
+func (m *AnalyzerManager) GetTLSSessions() ([]*models.TLSSession, error) {
+	return m.collector.GetTLSSessions(), nil
+}
+
+// GetTLSHostStats returns the TLS connections per client and server name
+func (m *AnalyzerManager) GetTLSHostStats() ([]*models.TLSHostStats, error) {
+	return lo.Values(m.collector.GetTLSHostStats()), nil
+}
+
+func (m *AnalyzerManager) GetHTTPRequests() ([]*models.HTTPRequest, error) {
+	return m.collector.GetHTTPRequests(), nil
+}
+
+func (m *AnalyzerManager) GetDBRequests() ([]*models.DBRequest, error) {
+	return m.collector.GetDBRequests(), nil
+}
+
+// GetDBServerStats returns the logins and commands per database server
+func (m *AnalyzerManager) GetDBServerStats() ([]*models.DBServerStats, error) {
+	return lo.Values(m.collector.GetDBServerStats()), nil
+}
+
+// AddSSHAuthEvent records an SSH authentication attempt read by the log
+// analyzer
+func (m *AnalyzerManager) AddSSHAuthEvent(auth *models.SSHAuthEvent) {
+	m.collector.AddSSHAuthEvent(auth)
+}
+
+func (m *AnalyzerManager) GetSSHAuthEvents() ([]*models.SSHAuthEvent, error) {
+	return m.collector.GetSSHAuthEvents(), nil
+}
+
+// GetSSHAuthSourceStats returns the SSH authentication attempts per source
+func (m *AnalyzerManager) GetSSHAuthSourceStats() ([]*models.SSHAuthSourceStats, error) {
+	return lo.Values(m.collector.GetSSHAuthSourceStats()), nil
+}
+
+// GetSSHAuthUserStats returns the SSH authentication attempts per user name
+func (m *AnalyzerManager) GetSSHAuthUserStats() ([]*models.SSHAuthUserStats, error) {
+	return lo.Values(m.collector.GetSSHAuthUserStats()), nil
+}
+
+// AddWebLogRequest records a request read by the web log analyzer and
+// matches it against the signature rules
+func (m *AnalyzerManager) AddWebLogRequest(req *models.WebLogRequest) {
+	var attackEvent *models.Event
+	if m.rules != nil {
+		req.Signatures = m.rules.Match(req.Method, req.Host, req.Path, req.Query, req.UserAgent, req.Referer)
+		target := req.Host
+		if target == "" {
+			target = req.Log
+		}
+		request := req.Method + " " + req.Path
+		if req.Query != "" {
+			request += "?" + req.Query
+		}
+		attackEvent = m.attacks.addMatches(req.ClientIP, target, strings.TrimSpace(request), req.Signatures, req.Timestamp)
+	}
+	m.collector.AddWebLogRequest(req)
+	if attackEvent != nil {
+		m.handleEvent(attackEvent)
+	}
+}
+
+func (m *AnalyzerManager) GetWebLogRequests() ([]*models.WebLogRequest, error) {
+	return m.collector.GetWebLogRequests(), nil
+}
+
+// GetWebClientStats returns the access log requests per client
+func (m *AnalyzerManager) GetWebClientStats() ([]*models.WebClientStats, error) {
+	return lo.Values(m.collector.GetWebClientStats()), nil
+}
+
+// GetWebAttackStats returns the signature matches per client
+func (m *AnalyzerManager) GetWebAttackStats() ([]*models.WebAttackStats, error) {
+	return lo.Values(m.collector.GetWebAttackStats()), nil
+}
+
+// AddLogMatch records a match of a user-defined log source
+func (m *AnalyzerManager) AddLogMatch(match *models.LogMatch) {
+	m.collector.AddLogMatch(match)
+}
+
+func (m *AnalyzerManager) GetLogMatches() ([]*models.LogMatch, error) {
+	return m.collector.GetLogMatches(), nil
+}
+
+// GetLogMatchStats returns the matches per user-defined log source and
+// address
+func (m *AnalyzerManager) GetLogMatchStats() ([]*models.LogMatchStats, error) {
+	return lo.Values(m.collector.GetLogMatchStats()), nil
+}
+
+// GetWebLogStats returns the requests per access log
+func (m *AnalyzerManager) GetWebLogStats() ([]*models.WebLogStats, error) {
+	return lo.Values(m.collector.GetWebLogStats()), nil
+}
+
+// GetTLSFingerprintStats returns the TLS connections per client fingerprint
+func (m *AnalyzerManager) GetTLSFingerprintStats() ([]*models.TLSFingerprintStats, error) {
+	return lo.Values(m.collector.GetTLSFingerprintStats()), nil
+}
+
+// checkFingerprint returns an event if the client fingerprint of the session
+// is on the blocklist
+func (m *AnalyzerManager) checkFingerprint(session *models.TLSSession) *models.Event {
+	fingerprint, description, ok := m.blocklist.match(session.JA3, session.JA4)
+	if !ok {
+		return nil
+	}
+
+	reason := "TLS fingerprint " + fingerprint
+	if description != "" {
+		reason += ": " + description
+	}
+	target := utils.FormatAddr(session.ServerIP, session.ServerPort)
+	if session.ServerName != "" {
+		target = session.ServerName
+	}
+	return &models.Event{
+		Type:     models.EventTLSFingerprint,
+		Severity: models.SeverityHigh,
+		SrcIP:    session.ClientIP,
+		Sources:  []string{session.ClientIP},
+		Target:   target,
+		Score:    1,
+		Count:    1,
+		Message:  fmt.Sprintf("Blocklisted TLS client %s contacted %s (%s)", session.ClientIP, target, reason),
+		Details: map[string]string{
+			"reason":      reason,
+			"ja3":         session.JA3,
+			"ja4":         session.JA4,
+			"server_name": session.ServerName,
+		},
+		Timestamp: session.Timestamp,
+	}
+}
+
+// HandleEvent records an event raised outside the network analyzers, like
+// by the log analyzers, and blocks its source if configured
+func (m *AnalyzerManager) HandleEvent(event *models.Event) {
+	m.handleEvent(event)
+}
+
+func (m *AnalyzerManager) handleEvent(event *models.Event) {
+	m.collector.AddEvent(event)
+	log.Printf("Event %s [%s]: %s", event.Type, event.Severity, event.Message)
+
+	// only events with a single source are blocked, sources of distributed
+	// events are likely spoofed
+	if event.SrcIP == "" {
+		return
+	}
+
+	// auto block and the jails the event fills ban once, for the longest
+	// of their durations, so an event counts as a single offence
+	var reasons []string
+	duration, ok := m.autoBlock[event.Type]
+	if ok {
+		reasons = append(reasons, string(event.Type))
+	}
+	for _, j := range m.jails {
+		if j.addEvent(event) {
+			log.Printf("Jail %s bans %s for %s", j.config.Name, event.SrcIP, j.config.BanTime)
+			if len(reasons) == 0 {
+				duration = j.config.BanTime
+			} else {
+				duration = longerBan(duration, j.config.BanTime)
+			}
+			reasons = append(reasons, j.config.Name)
+		}
+	}
+	if len(reasons) > 0 {
+		m.block(event.SrcIP, duration, strings.Join(reasons, ", "))
+	}
+}
+
+// longerBan returns the longer of two ban durations, 0 is permanent
+func longerBan(a, b time.Duration) time.Duration {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return max(a, b)
+}
+
+// block blocks ip in the background unless it is blocked already
+func (m *AnalyzerManager) block(ip string, duration time.Duration, reason string) {
+	if m.blocker.IsBlocked(ip) {
+		return
+	}
+	go func() {
+		if err := m.blocker.Block(ip, duration, reason); err != nil {
+			log.Printf("Failed to block %s: %v", ip, err)
+		}
+	}()
+}
+
+// GetJailStats returns the state of the jails
+func (m *AnalyzerManager) GetJailStats() ([]*models.JailStats, error) {
+	stats := make([]*models.JailStats, len(m.jails))
+	for i, j := range m.jails {
+		stats[i] = j.stats()
+	}
+	return stats, nil
+}
+
+// GetOffenders returns the ban history kept by the blocker, for a network or
+// a single address if filter is set
+func (m *AnalyzerManager) GetOffenders(filter string) ([]*models.OffenderStats, error) {
+	return m.blocker.GetOffenders(filter)
+}
+
+func (m *AnalyzerManager) GetEvents() ([]*models.Event, error) {
+	return m.collector.GetEvents(), nil
+}
+
 // Add cleanup goroutine
 func (m *AnalyzerManager) runCleanup(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Minute)
@@ -86,10 +496,56 @@ func (m *AnalyzerManager) runCleanup(ctx context.Context) {
 			return
 		case <-ticker.C:
 			m.collector.CleanupOldStats()
+			if m.tunnels != nil {
+				m.tunnels.cleanup(time.Now())
+			}
+			if m.dga != nil {
+				m.dga.cleanup(time.Now())
+			}
+			if m.probes != nil {
+				m.probes.cleanup(time.Now())
+			}
+			if m.db != nil {
+				m.db.cleanup(time.Now())
+			}
+			if m.attacks != nil {
+				m.attacks.cleanup(time.Now())
+			}
+			for _, j := range m.jails {
+				j.cleanup(time.Now())
+			}
 		}
 	}
 }
 
+// runScanDetection periodically checks the connection windows for port scans
+func (m *AnalyzerManager) runScanDetection(ctx context.Context) {
+	ticker := time.NewTicker(m.scanEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.detectScans()
+		}
+	}
+}
+
+// detectScans checks the current connection windows for port scans
+func (m *AnalyzerManager) detectScans() {
+	connWindows := lo.Values(m.collector.GetConnectionWindows())
+	portWindows := lo.Values(m.collector.GetPortWindows())
+	for _, event := range m.scans.detect(connWindows, portWindows) {
+		m.handleEvent(event)
+	}
+}
+
+func (m *AnalyzerManager) GetCaptureFilters() map[string]string {
+	return m.analyzer.Filters()
+}
+
 func (m *AnalyzerManager) GetBlackStats() []*models.IPCheckResult {
 	return m.checker.GetStats()
 }
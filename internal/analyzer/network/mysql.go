@@ -0,0 +1,241 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// MySQL capability flags and commands, see the client/server protocol
+// documentation
+const (
+	mysqlClientConnectWithDB  = 0x00000008
+	mysqlClientProtocol41     = 0x00000200
+	mysqlClientSSL            = 0x00000800
+	mysqlClientSecureConn     = 0x00008000
+	mysqlClientPluginAuthData = 0x00200000
+
+	mysqlComQuit             = 0x01
+	mysqlComInitDB           = 0x02
+	mysqlComQuery            = 0x03
+	mysqlComStmtPrepare      = 0x16
+	mysqlComStmtSendLongData = 0x18
+	mysqlComStmtClose        = 0x19
+
+	mysqlOK  = 0x00
+	mysqlERR = 0xff
+)
+
+// mysqlCommands names the commands recorded besides queries
+var mysqlCommands = map[byte]string{
+	0x08: "SHUTDOWN",
+	0x0c: "PROCESS_KILL",
+	0x0d: "DEBUG",
+	0x0e: "PING",
+	0x11: "CHANGE_USER",
+	0x17: "EXECUTE",
+	0x1f: "RESET_CONNECTION",
+}
+
+// mysqlStreamParser extracts the login and commands of a MySQL connection.
+// The protocol is strictly request and response, so the first server packet
+// after a command tells whether it failed.
+type mysqlStreamParser struct {
+	session   *dbSession
+	buf       [2][]byte // unparsed data per direction, indexed by toServer
+	skip      [2]int    // bytes left of a packet beyond maxDBMessage
+	handshake bool      // the server greeting was seen
+	login     bool      // the login waits for the server's verdict
+	authed    bool
+	expect    bool // a command waits for its response
+}
+
+func (a *ipAnalyzer) newMySQLStreamParser(conn *streamConn) streamParser {
+	return &mysqlStreamParser{
+		session: newDBSession(a, conn, dbProtocolMySQL),
+	}
+}
+
+func (p *mysqlStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	buf := append(p.buf[dir], data...)
+
+	for len(buf) > 0 {
+		if p.skip[dir] > 0 {
+			n := min(p.skip[dir], len(buf))
+			p.skip[dir] -= n
+			buf = buf[n:]
+			continue
+		}
+		if len(buf) < 4 {
+			break
+		}
+		length := int(buf[0]) | int(buf[1])<<8 | int(buf[2])<<16
+		seq := buf[3]
+		n := min(length, maxDBMessage)
+		if len(buf) < 4+n {
+			break
+		}
+		if !p.packet(toServer, seq, buf[4:4+n], ts) {
+			// not MySQL or encrypted, the rest of the stream is ignored
+			p.session.flush()
+			return false
+		}
+		buf = buf[4+n:]
+		p.skip[dir] = length - n
+	}
+
+	p.buf[dir] = append([]byte(nil), buf...)
+	return true
+}
+
+func (p *mysqlStreamParser) packet(toServer bool, seq byte, payload []byte, ts time.Time) bool {
+	if len(payload) == 0 {
+		return true
+	}
+
+	if !p.handshake {
+		// the server speaks first with protocol version 10
+		if toServer || seq != 0 || payload[0] != 10 {
+			return false
+		}
+		p.handshake = true
+		return true
+	}
+
+	if toServer {
+		switch {
+		case !p.authed && !p.login && seq == 1:
+			return p.handshakeResponse(payload, ts)
+		case p.authed && seq == 0:
+			p.command(payload, ts)
+		}
+		return true
+	}
+
+	switch {
+	case p.login:
+		// auth switch and more data packets continue the exchange
+		switch payload[0] {
+		case mysqlOK:
+			p.login, p.authed = false, true
+			p.session.respond("", ts)
+		case mysqlERR:
+			p.login = false
+			p.session.respond(mysqlError(payload), ts)
+		}
+	case p.expect:
+		p.expect = false
+		if payload[0] == mysqlERR {
+			p.session.respond(mysqlError(payload), ts)
+		} else {
+			p.session.respond("", ts)
+		}
+	}
+	return true
+}
+
+// handshakeResponse records the login of a HandshakeResponse41, it fails
+// for TLS, whose SSLRequest is the response cut after the filler
+func (p *mysqlStreamParser) handshakeResponse(payload []byte, ts time.Time) bool {
+	if len(payload) < 32 {
+		return false
+	}
+	caps := binary.LittleEndian.Uint32(payload)
+	if caps&mysqlClientProtocol41 == 0 || caps&mysqlClientSSL != 0 {
+		return false
+	}
+
+	r := payload[32:]
+	user, r := cstring(r)
+	switch {
+	case caps&mysqlClientPluginAuthData != 0:
+		length, size := lengthEncodedInt(r)
+		r = r[min(size+length, len(r)):]
+	case caps&mysqlClientSecureConn != 0 && len(r) > 0:
+		r = r[min(1+int(r[0]), len(r)):]
+	default:
+		_, r = cstring(r)
+	}
+	if caps&mysqlClientConnectWithDB != 0 {
+		p.session.database, _ = cstring(r)
+	}
+
+	p.session.user = user
+	p.session.request(models.DBCommandLogin, "", ts)
+	p.login = true
+	return true
+}
+
+func (p *mysqlStreamParser) command(payload []byte, ts time.Time) {
+	cmd, arg := payload[0], string(payload[1:])
+	switch cmd {
+	case mysqlComQuit, mysqlComStmtSendLongData, mysqlComStmtClose:
+		// no response follows
+		return
+	case mysqlComInitDB:
+		p.session.database = arg
+		p.session.request("USE", arg, ts)
+	case mysqlComQuery, mysqlComStmtPrepare:
+		command := sqlCommand(arg)
+		if command == "USE" {
+			i := strings.Index(strings.ToUpper(arg), "USE")
+			p.session.database = strings.Trim(arg[i+len("USE"):], " \t\r\n`;")
+		}
+		p.session.request(command, normalizeSQL(arg, true), ts)
+	default:
+		if name, ok := mysqlCommands[cmd]; ok {
+			p.session.request(name, "", ts)
+		}
+	}
+	p.expect = true
+}
+
+func (p *mysqlStreamParser) close() {
+	p.session.flush()
+}
+
+// mysqlError returns the code and message of an ERR packet
+// ("1045 Access denied for user 'root'@'10.0.0.1'")
+func mysqlError(payload []byte) string {
+	if len(payload) < 3 {
+		return "error"
+	}
+	code := binary.LittleEndian.Uint16(payload[1:])
+	message := payload[3:]
+	if len(message) >= 6 && message[0] == '#' {
+		// SQL state marker
+		message = message[6:]
+	}
+	return strconv.Itoa(int(code)) + " " + string(message)
+}
+
+// cstring returns the NUL terminated string at the start of b and the rest
+func cstring(b []byte) (string, []byte) {
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return string(b), nil
+	}
+	return string(b[:end]), b[end+1:]
+}
+
+// lengthEncodedInt returns a MySQL length encoded integer and its size
+func lengthEncodedInt(b []byte) (int, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	switch {
+	case b[0] < 0xfb:
+		return int(b[0]), 1
+	case b[0] == 0xfc && len(b) >= 3:
+		return int(binary.LittleEndian.Uint16(b[1:])), 3
+	case b[0] == 0xfd && len(b) >= 4:
+		return int(b[1]) | int(b[2])<<8 | int(b[3])<<16, 4
+	default:
+		return 0, len(b)
+	}
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/gopacket/gopacket"
 	"github.com/gopacket/gopacket/layers"
-	"github.com/gopacket/gopacket/pcapgo"
 
 	"github.com/safepointcloud/safepanel/pkg/models"
 )
@@ -19,74 +19,247 @@ type IPAnalyzer interface {
 	SetNewConnectionCallback(callback func(*models.NewConnectionStats))
 	SetDNSQueryCallback(callback func(*models.DNSQueryStats))
 	SetDNSResponseCallback(callback func(*models.DNSResponse))
+	SetFlowClosedCallback(callback func(*models.FlowRecord))
+	SetEventCallback(callback func(*models.Event))
+	SetTLSSessionCallback(callback func(*models.TLSSession))
+	SetHTTPRequestCallback(callback func(*models.HTTPRequest))
+	SetDBRequestCallback(callback func(*models.DBRequest))
+	// Done is closed once the capture loop has exited, e.g. at the end of a replay file
+	Done() <-chan struct{}
+	// Filters returns the effective capture filter of each interface
+	Filters() map[string]string
 }
 
 type Config struct {
-	Interface   string
-	BufferSize  int32
-	Promiscuous bool
+	Interfaces []InterfaceConfig
+	// Filter applies to interfaces without their own filter and to replays,
+	// DefaultFilter is used if empty
+	Filter string
+
+	// ReplayFile reads packets from a pcap/pcapng file instead of the interface
+	ReplayFile string
+	// ReplayRealtime replays packets at their original timing instead of as fast as possible
+	ReplayRealtime bool
+
+	SYNFlood SYNFloodConfig
+	// SSHBruteForce detects password guessing from short sessions to SSHPorts
+	SSHBruteForce SSHBruteForceConfig
+
+	// TLSPorts are the server ports whose TLS handshakes are parsed,
+	// DefaultTLSPorts is used if empty
+	TLSPorts []uint16
+	// HTTPPorts are the server ports whose plaintext HTTP requests are
+	// parsed, DefaultHTTPPorts is used if empty
+	HTTPPorts []uint16
+	// MySQLPorts, PostgresPorts and RedisPorts are the server ports whose
+	// logins and commands are parsed, the Default ports are used if empty
+	MySQLPorts    []uint16
+	PostgresPorts []uint16
+	RedisPorts    []uint16
+	// SSHPorts are the server ports whose banners are read and whose
+	// sessions are checked for brute force, DefaultSSHPorts is used if empty
+	SSHPorts []uint16
+}
+
+// InterfaceConfig holds the capture settings of a single interface
+type InterfaceConfig struct {
+	Name    string
+	SnapLen int32 // maximum number of bytes captured per frame, 0 uses the interface MTU
+	// Promiscuous also captures traffic not addressed to the interface if
+	// true, drops it even if the interface is promiscuous anyway if false
+	// and leaves the interface as is if nil
+	Promiscuous *bool
+	Filter      string // capture filter expression, see compileFilter
+}
+
+// effectiveFilter returns the filter expression used for the interface
+func (c *Config) effectiveFilter(iface InterfaceConfig) string {
+	if iface.Filter != "" {
+		return iface.Filter
+	}
+	if c.Filter != "" {
+		return c.Filter
+	}
+	return DefaultFilter
 }
 
+// replayInterface is the interface name reported for replayed packets
+const replayInterface = "replay"
+
 type ipAnalyzer struct {
 	config   *Config
-	handle   *pcapgo.EthernetHandle
+	sources  map[string]CaptureSource
+	filters  map[string]string
 	stopChan chan struct{}
+	doneChan chan struct{}
 	localIPs []net.IP
+	flows    *flowTable
+	synFlood *synFloodDetector
+	sshBrute *sshBruteForceDetector
+	dns      *dnsTracker
+	streams  *streamDispatcher
 
 	// callback
 	onNewConnection func(*models.NewConnectionStats)
 	onDNSQuery      func(*models.DNSQueryStats)
 	onDNSResponse   func(*models.DNSResponse)
+	onFlowClosed    func(*models.FlowRecord)
+	onEvent         func(*models.Event)
+	onTLSSession    func(*models.TLSSession)
+	onHTTPRequest   func(*models.HTTPRequest)
+	onDBRequest     func(*models.DBRequest)
 }
 
 func NewIPAnalyzer(config *Config) (IPAnalyzer, error) {
+	if config.ReplayFile == "" && len(config.Interfaces) == 0 {
+		return nil, fmt.Errorf("no capture interface configured")
+	}
+
+	// resolve and validate the filters before any interface is opened
+	filters := make(map[string]string)
+	if config.ReplayFile != "" {
+		filters[replayInterface] = config.effectiveFilter(InterfaceConfig{})
+	} else {
+		for _, iface := range config.Interfaces {
+			filters[iface.Name] = config.effectiveFilter(iface)
+		}
+	}
+	for name, filter := range filters {
+		if _, err := compileFilter(filter); err != nil {
+			return nil, fmt.Errorf("invalid filter %q for %s: %v", filter, name, err)
+		}
+	}
+
 	localIPs, err := getLocalIPs()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get local IPs: %v", err)
 	}
 
-	return &ipAnalyzer{
+	a := &ipAnalyzer{
 		config:   config,
 		localIPs: localIPs,
+		sources:  make(map[string]CaptureSource),
+		filters:  filters,
+		flows:    newFlowTable(),
+		synFlood: newSYNFloodDetector(config.SYNFlood),
+		dns:      newDNSTracker(),
 		stopChan: make(chan struct{}),
-	}, nil
+		doneChan: make(chan struct{}),
+	}
+	a.streams = newStreamDispatcher(a.direction)
+	a.streams.register(53, a.newDNSStreamParser)
+	a.registerPorts(config.TLSPorts, DefaultTLSPorts, a.newTLSStreamParser)
+	a.registerPorts(config.HTTPPorts, DefaultHTTPPorts, a.newHTTPStreamParser)
+	a.registerPorts(config.MySQLPorts, DefaultMySQLPorts, a.newMySQLStreamParser)
+	a.registerPorts(config.PostgresPorts, DefaultPostgresPorts, a.newPostgresStreamParser)
+	a.registerPorts(config.RedisPorts, DefaultRedisPorts, a.newRedisStreamParser)
+	sshPorts := config.SSHPorts
+	if len(sshPorts) == 0 {
+		sshPorts = DefaultSSHPorts
+	}
+	for _, port := range sshPorts {
+		a.streams.register(port, a.newSSHStreamParser)
+	}
+	a.sshBrute = newSSHBruteForceDetector(config.SSHBruteForce, sshPorts)
+
+	return a, nil
+}
+
+// registerPorts attaches a parser to the configured server ports, or to the
+// defaults if none are configured
+func (a *ipAnalyzer) registerPorts(ports, defaults []uint16, factory streamParserFactory) {
+	if len(ports) == 0 {
+		ports = defaults
+	}
+	for _, port := range ports {
+		a.streams.register(port, factory)
+	}
 }
 
 func (a *ipAnalyzer) Start(ctx context.Context) error {
-	handle, err := pcapgo.NewEthernetHandle(a.config.Interface)
-	if err != nil {
-		return fmt.Errorf("failed to open interface: %v", err)
+	if a.config.ReplayFile != "" {
+		source, err := newFileSource(a.config.ReplayFile, a.config.ReplayRealtime, a.filters[replayInterface])
+		if err != nil {
+			return err
+		}
+		a.sources[replayInterface] = source
+	} else {
+		for _, iface := range a.config.Interfaces {
+			iface.Filter = a.filters[iface.Name]
+			source, err := newLiveSource(iface)
+			if err != nil {
+				a.closeSources()
+				return fmt.Errorf("%s: %v", iface.Name, err)
+			}
+			a.sources[iface.Name] = source
+		}
 	}
-	a.handle = handle
 
-	// start packet capture
-	go a.capture(ctx)
+	// start packet capture, one goroutine per source
+	var wg sync.WaitGroup
+	for name, source := range a.sources {
+		wg.Add(1)
+		go func(name string, source CaptureSource) {
+			defer wg.Done()
+			a.capture(ctx, name, source)
+		}(name, source)
+	}
+	go func() {
+		wg.Wait()
+		close(a.doneChan)
+	}()
+
+	go a.expireFlows(ctx)
 
 	return nil
 }
 
-func (a *ipAnalyzer) capture(ctx context.Context) {
-	packetSource := gopacket.NewPacketSource(a.handle, layers.LayerTypeEthernet)
+func (a *ipAnalyzer) capture(ctx context.Context, iface string, source CaptureSource) {
+	packetSource := gopacket.NewPacketSource(source, source.LinkType())
+	packets := packetSource.Packets()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-a.stopChan:
 			return
-		case packet := <-packetSource.Packets():
-			a.processPacket(packet)
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			a.processPacket(iface, packet)
 		}
 	}
 }
 
-func (a *ipAnalyzer) processPacket(packet gopacket.Packet) {
-	ipLayer := packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer == nil {
-		return
+// packetInfo holds the network layer fields shared by IPv4 and IPv6 packets
+type packetInfo struct {
+	Interface string
+	SrcIP     net.IP
+	DstIP     net.IP
+	Length    int // length of the IP packet including headers
+	Timestamp time.Time
+}
+
+func (a *ipAnalyzer) processPacket(iface string, packet gopacket.Packet) {
+	info := &packetInfo{
+		Interface: iface,
+		Timestamp: packet.Metadata().Timestamp,
+	}
+	if info.Timestamp.IsZero() {
+		info.Timestamp = time.Now()
 	}
 
-	ip, ok := ipLayer.(*layers.IPv4)
-	if !ok {
+	// extension headers are decoded as separate layers by gopacket, so the
+	// transport layer lookups below work the same for IPv4 and IPv6
+	switch ip := packet.NetworkLayer().(type) {
+	case *layers.IPv4:
+		info.SrcIP, info.DstIP = ip.SrcIP, ip.DstIP
+		info.Length = int(ip.Length)
+	case *layers.IPv6:
+		info.SrcIP, info.DstIP = ip.SrcIP, ip.DstIP
+		info.Length = len(ip.Contents) + int(ip.Length)
+	default:
 		return
 	}
 
@@ -94,87 +267,279 @@ func (a *ipAnalyzer) processPacket(packet gopacket.Packet) {
 	switch {
 	case packet.Layer(layers.LayerTypeTCP) != nil:
 		tcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
-		a.handleTCPPacket(ip, tcp)
+		a.handleTCPPacket(info, tcp, packet)
 	case packet.Layer(layers.LayerTypeUDP) != nil:
 		udp := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
-		a.handleUDPPacket(ip, udp, packet)
+		a.handleUDPPacket(info, udp, packet)
+	case packet.Layer(layers.LayerTypeICMPv4) != nil:
+		icmp := packet.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+		a.handleICMPv4Packet(info, icmp)
+	case packet.Layer(layers.LayerTypeICMPv6) != nil:
+		icmp := packet.Layer(layers.LayerTypeICMPv6).(*layers.ICMPv6)
+		a.handleICMPv6Packet(info, icmp, packet)
 	}
 }
 
-func (a *ipAnalyzer) handleTCPPacket(ip *layers.IPv4, tcp *layers.TCP) {
-	if tcp.SYN && !tcp.ACK {
-		direction := models.DirectionOutbound
-		for _, localIP := range a.localIPs {
-			if ip.DstIP.Equal(localIP) {
-				direction = models.DirectionInbound
-				break
-			}
-		}
+func (a *ipAnalyzer) handleTCPPacket(info *packetInfo, tcp *layers.TCP, packet gopacket.Packet) {
+	direction := a.direction(info)
 
-		newConn := &models.NewConnectionStats{
-			SrcIP:     ip.SrcIP.String(),
+	// the initial SYN and the ACK completing the handshake are both sent by
+	// the side that opened the connection
+	newConn := func() *models.NewConnectionStats {
+		return &models.NewConnectionStats{
+			SrcIP:     info.SrcIP.String(),
 			SrcPort:   uint16(tcp.SrcPort),
-			DstIP:     ip.DstIP.String(),
+			DstIP:     info.DstIP.String(),
 			DstPort:   uint16(tcp.DstPort),
 			Protocol:  models.ProtocolTCP,
 			Direction: direction,
-			Timestamp: time.Now(),
+			Interface: info.Interface,
+			Timestamp: info.Timestamp,
 		}
+	}
+
+	if tcp.SYN && !tcp.ACK && a.onNewConnection != nil {
+		a.onNewConnection(newConn())
+	}
 
-		if a.onNewConnection != nil {
-			a.onNewConnection(newConn)
+	transition := a.flows.trackTCP(info, tcp, direction)
+	if a.config.SYNFlood.Enabled {
+		switch {
+		case transition.opened:
+			for _, event := range a.synFlood.addSYN(newConn()) {
+				a.emitEvent(event)
+			}
+		case transition.established:
+			a.synFlood.addHandshake(newConn())
+		}
+	}
+	if transition.closed != nil {
+		a.flowClosed(transition.closed)
+	}
+
+	// application protocols on registered ports are parsed from the
+	// reassembled stream
+	if a.streams.handles(tcp) {
+		a.streams.assemble(info, packet.NetworkLayer().NetworkFlow(), tcp)
+	}
+}
+
+func (a *ipAnalyzer) emitEvent(event *models.Event) {
+	if a.onEvent != nil {
+		a.onEvent(event)
+	}
+}
+
+func (a *ipAnalyzer) flowClosed(record *models.FlowRecord) {
+	if a.config.SSHBruteForce.Enabled {
+		if event := a.sshBrute.addFlow(record); event != nil {
+			a.emitEvent(event)
+		}
+	}
+	if a.onFlowClosed != nil {
+		a.onFlowClosed(record)
+	}
+}
+
+// expireFlows periodically closes idle flows and streams and times out
+// unanswered DNS queries and stale detector state
+func (a *ipAnalyzer) expireFlows(ctx context.Context) {
+	ticker := time.NewTicker(flowSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.stopChan:
+			return
+		case now := <-ticker.C:
+			for _, record := range a.flows.expire(now) {
+				a.flowClosed(record)
+			}
+			a.synFlood.cleanup(now)
+			a.sshBrute.cleanup(now)
+			for _, response := range a.dns.expire(now) {
+				a.dnsResponse(response)
+			}
+			a.streams.flush(now)
 		}
 	}
 }
 
-func (a *ipAnalyzer) handleUDPPacket(ip *layers.IPv4, udp *layers.UDP, packet gopacket.Packet) {
+func (a *ipAnalyzer) handleUDPPacket(info *packetInfo, udp *layers.UDP, packet gopacket.Packet) {
+	key := newFlowKey(models.ProtocolUDP, info, uint16(udp.SrcPort), uint16(udp.DstPort))
+	a.trackDatagram(key, info, 0, 0)
+
 	if udp.DstPort == 53 || udp.SrcPort == 53 {
-		dnsLayer := packet.Layer(layers.LayerTypeDNS)
-		if dnsLayer != nil {
-			dns, _ := dnsLayer.(*layers.DNS)
-
-			if !dns.QR { // DNS query
-				if a.onDNSQuery != nil {
-					for _, question := range dns.Questions {
-						query := &models.DNSQueryStats{
-							ID:        dns.ID,
-							Domain:    string(question.Name),
-							SrcIP:     ip.SrcIP.String(),
-							DNSServer: ip.DstIP.String(),
-							QueryType: question.Type.String(),
-							Timestamp: time.Now(),
-						}
-						a.onDNSQuery(query)
-					}
-				}
-			} else { // DNS response
-				if a.onDNSResponse != nil {
-					var IPs []string
-					for _, answer := range dns.Answers {
-						IPs = append(IPs, answer.String())
-					}
-					if len(IPs) > 0 {
-						response := &models.DNSResponse{
-							QueryID:   dns.ID,
-							Response:  IPs,
-							Timestamp: time.Now(),
-						}
-						a.onDNSResponse(response)
-					}
+		if dns, ok := packet.Layer(layers.LayerTypeDNS).(*layers.DNS); ok {
+			a.handleDNS(info, uint16(udp.SrcPort), uint16(udp.DstPort), dns)
+		}
+	}
+}
+
+// handleDNS correlates queries and responses and reports them, it is used
+// for both DNS over UDP and TCP
+func (a *ipAnalyzer) handleDNS(info *packetInfo, srcPort, dstPort uint16, dns *layers.DNS) {
+	if !dns.QR { // DNS query
+		if a.direction(info) == models.DirectionInbound {
+			for i := range dns.Questions {
+				if event := zoneTransferEvent(info, &dns.Questions[i]); event != nil {
+					a.emitEvent(event)
 				}
 			}
 		}
+		for _, query := range a.dns.addQuery(info, srcPort, dstPort, dns) {
+			if a.onDNSQuery != nil {
+				a.onDNSQuery(query)
+			}
+		}
+		return
+	}
+
+	// DNS response
+	for _, response := range a.dns.addResponse(info, srcPort, dstPort, dns) {
+		a.dnsResponse(response)
 	}
 }
 
-func (a *ipAnalyzer) Stop() error {
-	if a.handle != nil {
-		a.handle.Close()
+func (a *ipAnalyzer) dnsResponse(response *models.DNSResponse) {
+	if a.onDNSResponse != nil {
+		a.onDNSResponse(response)
+	}
+}
+
+// tlsSession attaches the handshake metadata to the flow of the connection
+// and reports it
+func (a *ipAnalyzer) tlsSession(conn *streamConn, session *models.TLSSession) {
+	info := conn.packetInfo(true, 0, session.Timestamp)
+	key := newFlowKey(models.ProtocolTCP, info, conn.ClientPort, conn.ServerPort)
+	tlsInfo := session.TLSInfo
+	a.flows.setTLS(key, &tlsInfo)
+
+	if a.onTLSSession != nil {
+		a.onTLSSession(session)
+	}
+}
+
+// sshBanners attaches the banners of an SSH connection to its flow
+func (a *ipAnalyzer) sshBanners(conn *streamConn, ssh *models.SSHInfo) {
+	info := conn.packetInfo(true, 0, time.Time{})
+	key := newFlowKey(models.ProtocolTCP, info, conn.ClientPort, conn.ServerPort)
+	a.flows.setSSH(key, ssh)
+}
+
+func (a *ipAnalyzer) httpRequest(req *models.HTTPRequest) {
+	if a.onHTTPRequest != nil {
+		a.onHTTPRequest(req)
+	}
+}
+
+func (a *ipAnalyzer) dbRequest(req *models.DBRequest) {
+	if a.onDBRequest != nil {
+		a.onDBRequest(req)
+	}
+}
+
+func (a *ipAnalyzer) handleICMPv4Packet(info *packetInfo, icmp *layers.ICMPv4) {
+	icmpType, icmpCode := icmp.TypeCode.Type(), icmp.TypeCode.Code()
+
+	// echo requests and replies share the identifier, other messages are
+	// grouped by type and code
+	var srcPort, dstPort uint16
+	switch icmpType {
+	case layers.ICMPv4TypeEchoRequest, layers.ICMPv4TypeEchoReply:
+		srcPort, dstPort = icmp.Id, icmp.Id
+	default:
+		dstPort = uint16(icmpType)<<8 | uint16(icmpCode)
+	}
+
+	key := newFlowKey(models.ProtocolICMP, info, srcPort, dstPort)
+	a.trackDatagram(key, info, icmpType, icmpCode)
+}
+
+func (a *ipAnalyzer) handleICMPv6Packet(info *packetInfo, icmp *layers.ICMPv6, packet gopacket.Packet) {
+	icmpType, icmpCode := icmp.TypeCode.Type(), icmp.TypeCode.Code()
+
+	var srcPort, dstPort uint16
+	switch icmpType {
+	case layers.ICMPv6TypeRouterSolicitation, layers.ICMPv6TypeRouterAdvertisement,
+		layers.ICMPv6TypeNeighborSolicitation, layers.ICMPv6TypeNeighborAdvertisement,
+		layers.ICMPv6TypeRedirect,
+		layers.ICMPv6TypeMLDv1MulticastListenerQueryMessage,
+		layers.ICMPv6TypeMLDv1MulticastListenerReportMessage,
+		layers.ICMPv6TypeMLDv1MulticastListenerDoneMessage,
+		layers.ICMPv6TypeMLDv2MulticastListenerReportMessageV2:
+		// link-local housekeeping, not interesting as flows
+		return
+	case layers.ICMPv6TypeEchoRequest, layers.ICMPv6TypeEchoReply:
+		if echo, ok := packet.Layer(layers.LayerTypeICMPv6Echo).(*layers.ICMPv6Echo); ok {
+			srcPort, dstPort = echo.Identifier, echo.Identifier
+		}
+	default:
+		dstPort = uint16(icmpType)<<8 | uint16(icmpCode)
 	}
+
+	key := newFlowKey(models.ProtocolICMPv6, info, srcPort, dstPort)
+	a.trackDatagram(key, info, icmpType, icmpCode)
+}
+
+// trackDatagram tracks UDP and ICMP pseudo-flows and reports the first
+// packet of each as a new connection
+func (a *ipAnalyzer) trackDatagram(key flowKey, info *packetInfo, icmpType, icmpCode uint8) {
+	record := a.flows.trackDatagram(key, info, a.direction(info), icmpType, icmpCode)
+	if record == nil || a.onNewConnection == nil {
+		return
+	}
+
+	a.onNewConnection(&models.NewConnectionStats{
+		SrcIP:     record.SrcIP,
+		SrcPort:   record.SrcPort,
+		DstIP:     record.DstIP,
+		DstPort:   record.DstPort,
+		Protocol:  record.Protocol,
+		ICMPType:  record.ICMPType,
+		ICMPCode:  record.ICMPCode,
+		Direction: record.Direction,
+		Interface: record.Interface,
+		Timestamp: record.StartTime,
+	})
+}
+
+// direction reports whether the packet is addressed to one of the local IPs
+func (a *ipAnalyzer) direction(info *packetInfo) models.Direction {
+	for _, localIP := range a.localIPs {
+		if info.DstIP.Equal(localIP) {
+			return models.DirectionInbound
+		}
+	}
+	return models.DirectionOutbound
+}
+
+func (a *ipAnalyzer) Stop() error {
+	a.closeSources()
 	close(a.stopChan)
 	return nil
 }
 
+func (a *ipAnalyzer) closeSources() {
+	for _, source := range a.sources {
+		source.Close()
+	}
+}
+
+func (a *ipAnalyzer) Done() <-chan struct{} {
+	return a.doneChan
+}
+
+func (a *ipAnalyzer) Filters() map[string]string {
+	filters := make(map[string]string, len(a.filters))
+	for name, filter := range a.filters {
+		filters[name] = filter
+	}
+	return filters
+}
+
 func (a *ipAnalyzer) SetNewConnectionCallback(callback func(*models.NewConnectionStats)) {
 	a.onNewConnection = callback
 }
@@ -187,6 +552,26 @@ func (a *ipAnalyzer) SetDNSResponseCallback(callback func(*models.DNSResponse))
 	a.onDNSResponse = callback
 }
 
+func (a *ipAnalyzer) SetFlowClosedCallback(callback func(*models.FlowRecord)) {
+	a.onFlowClosed = callback
+}
+
+func (a *ipAnalyzer) SetEventCallback(callback func(*models.Event)) {
+	a.onEvent = callback
+}
+
+func (a *ipAnalyzer) SetTLSSessionCallback(callback func(*models.TLSSession)) {
+	a.onTLSSession = callback
+}
+
+func (a *ipAnalyzer) SetHTTPRequestCallback(callback func(*models.HTTPRequest)) {
+	a.onHTTPRequest = callback
+}
+
+func (a *ipAnalyzer) SetDBRequestCallback(callback func(*models.DBRequest)) {
+	a.onDBRequest = callback
+}
+
 func getLocalIPs() ([]net.IP, error) {
 	var ips []net.IP
 	ifaces, err := net.Interfaces()
@@ -214,6 +599,8 @@ func getLocalIPs() ([]net.IP, error) {
 			case *net.IPNet:
 				if ip4 := v.IP.To4(); ip4 != nil {
 					ips = append(ips, ip4)
+				} else if ip6 := v.IP.To16(); ip6 != nil {
+					ips = append(ips, ip6)
 				}
 			}
 		}
@@ -0,0 +1,227 @@
+package network
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// PostgreSQL startup codes and message types, see the frontend/backend
+// protocol documentation
+const (
+	postgresProtocol3  = 196608
+	postgresSSLRequest = 80877103
+	postgresGSSRequest = 80877104
+	postgresAuthOK     = 0
+
+	postgresQuery       = 'Q'
+	postgresParse       = 'P'
+	postgresSync        = 'S'
+	postgresAuth        = 'R'
+	postgresError       = 'E'
+	postgresReady       = 'Z'
+	postgresEncryptDeny = 'N'
+
+	// maxPostgresStartup bounds the startup message, larger ones are not
+	// PostgreSQL
+	maxPostgresStartup = 10000
+)
+
+// postgresStreamParser extracts the login and statements of a PostgreSQL
+// connection. Statements complete with the ReadyForQuery that follows the
+// simple query, or the Sync of an extended query batch.
+type postgresStreamParser struct {
+	session  *dbSession
+	buf      [2][]byte // unparsed data per direction, indexed by toServer
+	skip     [2]int    // bytes left of a message beyond maxDBMessage
+	started  bool      // the startup message was seen
+	encrypt  bool      // an SSL or GSS request waits for the server's single byte answer
+	login    bool      // the login waits for the server's verdict
+	unsynced int       // extended query statements since the last Sync
+	batches  []int     // statements answered by each expected ReadyForQuery
+	err      string    // first error of the current batch
+}
+
+func (a *ipAnalyzer) newPostgresStreamParser(conn *streamConn) streamParser {
+	return &postgresStreamParser{
+		session: newDBSession(a, conn, dbProtocolPostgres),
+	}
+}
+
+func (p *postgresStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	buf := append(p.buf[dir], data...)
+
+	for len(buf) > 0 {
+		if p.skip[dir] > 0 {
+			n := min(p.skip[dir], len(buf))
+			p.skip[dir] -= n
+			buf = buf[n:]
+			continue
+		}
+
+		if !toServer && p.encrypt {
+			// 'S' or 'G' accept encryption, the rest of the stream is opaque
+			p.encrypt = false
+			if buf[0] != postgresEncryptDeny {
+				p.session.flush()
+				return false
+			}
+			buf = buf[1:]
+			continue
+		}
+
+		// only the startup message has no type byte
+		header := 5
+		if toServer && !p.started {
+			header = 4
+		}
+		if len(buf) < header {
+			break
+		}
+		length := int(binary.BigEndian.Uint32(buf[header-4:])) - 4
+		if length < 0 || !p.started && toServer && length > maxPostgresStartup {
+			p.session.flush()
+			return false
+		}
+		n := min(length, maxDBMessage)
+		if len(buf) < header+n {
+			break
+		}
+
+		var ok bool
+		switch {
+		case header == 4:
+			ok = p.startup(buf[4:4+n], ts)
+		case toServer:
+			ok = p.frontend(buf[0], buf[5:5+n], ts)
+		default:
+			ok = p.backend(buf[0], buf[5:5+n], ts)
+		}
+		if !ok {
+			// not PostgreSQL, the rest of the stream is ignored
+			p.session.flush()
+			return false
+		}
+		buf = buf[header+n:]
+		p.skip[dir] = length - n
+	}
+
+	p.buf[dir] = append([]byte(nil), buf...)
+	return true
+}
+
+// startup handles the untyped first messages of the client
+func (p *postgresStreamParser) startup(body []byte, ts time.Time) bool {
+	if len(body) < 4 {
+		return false
+	}
+	switch binary.BigEndian.Uint32(body) {
+	case postgresSSLRequest, postgresGSSRequest:
+		p.encrypt = true
+		return true
+	case postgresProtocol3:
+	default:
+		// cancel requests and older protocols carry nothing of interest
+		return false
+	}
+
+	params := make(map[string]string)
+	rest := body[4:]
+	for len(rest) > 0 && rest[0] != 0 {
+		var key, value string
+		key, rest = cstring(rest)
+		value, rest = cstring(rest)
+		params[key] = value
+	}
+	p.session.user = params["user"]
+	p.session.database = params["database"]
+	if p.session.database == "" {
+		p.session.database = p.session.user
+	}
+
+	p.started, p.login = true, true
+	p.session.request(models.DBCommandLogin, "", ts)
+	return true
+}
+
+func (p *postgresStreamParser) frontend(msgType byte, body []byte, ts time.Time) bool {
+	switch msgType {
+	case postgresQuery:
+		query, _ := cstring(body)
+		p.session.request(sqlCommand(query), normalizeSQL(query, false), ts)
+		p.sync(p.unsynced + 1)
+	case postgresParse:
+		_, rest := cstring(body) // statement name
+		query, _ := cstring(rest)
+		p.session.request(sqlCommand(query), normalizeSQL(query, false), ts)
+		p.unsynced++
+	case postgresSync:
+		p.sync(p.unsynced)
+	}
+	return true
+}
+
+// sync expects a ReadyForQuery answering n statements
+func (p *postgresStreamParser) sync(n int) {
+	if len(p.batches) >= maxDBPending {
+		p.batches = p.batches[1:]
+	}
+	p.batches = append(p.batches, n)
+	p.unsynced = 0
+}
+
+func (p *postgresStreamParser) backend(msgType byte, body []byte, ts time.Time) bool {
+	switch msgType {
+	case postgresAuth:
+		if p.login && len(body) >= 4 && binary.BigEndian.Uint32(body) == postgresAuthOK {
+			p.login = false
+			p.session.respond("", ts)
+		}
+	case postgresError:
+		message := postgresErrorMessage(body)
+		if p.login {
+			p.login = false
+			p.session.respond(message, ts)
+		} else if p.err == "" {
+			p.err = message
+		}
+	case postgresReady:
+		// the first ReadyForQuery follows the login
+		if len(p.batches) == 0 {
+			break
+		}
+		for i := 0; i < p.batches[0]; i++ {
+			p.session.respond(p.err, ts)
+		}
+		p.batches = p.batches[1:]
+		p.err = ""
+	}
+	return true
+}
+
+func (p *postgresStreamParser) close() {
+	p.session.flush()
+}
+
+// postgresErrorMessage returns the SQLSTATE and message of an ErrorResponse
+// ("28P01 password authentication failed for user \"postgres\"")
+func postgresErrorMessage(body []byte) string {
+	var code, message string
+	for len(body) > 0 && body[0] != 0 {
+		field := body[0]
+		var value string
+		value, body = cstring(body[1:])
+		switch field {
+		case 'C':
+			code = value
+		case 'M':
+			message = value
+		}
+	}
+	if code == "" && message == "" {
+		return "error"
+	}
+	return code + " " + message
+}
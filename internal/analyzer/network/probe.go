@@ -0,0 +1,202 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// HTTPProbeConfig configures the detector for sources probing well known
+// sensitive paths of local web servers
+type HTTPProbeConfig struct {
+	Enabled   bool
+	Window    time.Duration // window the probes of a source are counted in
+	MinProbes int           // distinct probed paths per window that trigger an event
+	Paths     []string      // additional path prefixes considered probes
+}
+
+// DefaultHTTPProbeConfig returns the settings used for unset values
+func DefaultHTTPProbeConfig() HTTPProbeConfig {
+	return HTTPProbeConfig{
+		Enabled:   true,
+		Window:    10 * time.Minute,
+		MinProbes: 3,
+	}
+}
+
+// defaultProbePaths are path prefixes no legitimate client asks a server
+// for that does not serve them, scanners try them on every host
+var defaultProbePaths = []string{
+	"/.env", "/.git/", "/.svn/", "/.hg/", "/.ds_store", "/.aws/", "/.ssh/", "/.htpasswd",
+	"/wp-admin", "/wp-login.php", "/wp-config.php", "/xmlrpc.php", "/wp-content/plugins/",
+	"/phpmyadmin", "/pma/", "/myadmin/", "/adminer", "/phpinfo.php", "/info.php",
+	"/config.php", "/config.json", "/configuration.php", "/web.config", "/backup", "/dump.sql",
+	"/server-status", "/actuator", "/jmx-console", "/manager/html", "/solr/admin",
+	"/cgi-bin/", "/boaform/", "/hnap1", "/vendor/phpunit/", "/owa/", "/autodiscover/",
+	"/console/", "/_ignition/", "/telescope/",
+}
+
+const (
+	// maxProbeSources bounds the number of sources tracked
+	maxProbeSources = 10000
+	// maxProbePaths bounds the distinct paths remembered per source
+	maxProbePaths = 256
+)
+
+// probeStats holds the probed paths of a source within the window
+type probeStats struct {
+	start     time.Time
+	last      time.Time
+	paths     map[string]int
+	targets   map[string]struct{}
+	agents    map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// httpProbeDetector counts requests of inbound sources for sensitive paths
+type httpProbeDetector struct {
+	config  HTTPProbeConfig
+	paths   []string
+	sources map[string]*probeStats
+	mutex   sync.Mutex
+}
+
+func newHTTPProbeDetector(config HTTPProbeConfig) *httpProbeDetector {
+	defaults := DefaultHTTPProbeConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinProbes <= 0 {
+		config.MinProbes = defaults.MinProbes
+	}
+
+	paths := append([]string(nil), defaultProbePaths...)
+	for _, path := range config.Paths {
+		paths = append(paths, strings.ToLower(path))
+	}
+
+	return &httpProbeDetector{
+		config:  config,
+		paths:   paths,
+		sources: make(map[string]*probeStats),
+	}
+}
+
+// probePath returns the probe prefix the request path matches
+func (d *httpProbeDetector) probePath(path string) (string, bool) {
+	path, _, _ = strings.Cut(strings.ToLower(path), "?")
+	for _, prefix := range d.paths {
+		if strings.HasPrefix(path, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// addRequest returns an event once a source probed enough distinct paths
+// within the window, and again when it has doubled since
+func (d *httpProbeDetector) addRequest(req *models.HTTPRequest) *models.Event {
+	if req.Direction != models.DirectionInbound {
+		return nil
+	}
+	prefix, ok := d.probePath(req.Path)
+	if !ok {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats, ok := d.sources[req.ClientIP]
+	if !ok {
+		if len(d.sources) >= maxProbeSources {
+			return nil
+		}
+		stats = &probeStats{}
+		d.sources[req.ClientIP] = stats
+	}
+
+	ts := req.Timestamp
+	if stats.start.IsZero() || ts.Sub(stats.start) >= d.config.Window {
+		*stats = probeStats{
+			start:     ts,
+			paths:     make(map[string]int),
+			targets:   make(map[string]struct{}),
+			agents:    make(map[string]struct{}),
+			lastAlert: stats.lastAlert,
+			alerted:   stats.alerted,
+		}
+	}
+	stats.last = ts
+	if _, ok := stats.paths[prefix]; ok || len(stats.paths) < maxProbePaths {
+		stats.paths[prefix]++
+	}
+	stats.targets[req.ServerIP] = struct{}{}
+	if req.UserAgent != "" && len(stats.agents) < maxScanEvidence {
+		stats.agents[req.UserAgent] = struct{}{}
+	}
+
+	probes := len(stats.paths)
+	if probes < d.config.MinProbes {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < d.config.Window && probes < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = probes
+
+	severity := models.SeverityMedium
+	if probes >= 4*d.config.MinProbes {
+		severity = models.SeverityHigh
+	}
+
+	paths := make([]string, 0, probes)
+	requests := 0
+	for path, count := range stats.paths {
+		paths = append(paths, path)
+		requests += count
+	}
+	sort.Strings(paths)
+	agents := make([]string, 0, len(stats.agents))
+	for agent := range stats.agents {
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+
+	return &models.Event{
+		Type:     models.EventHTTPProbe,
+		Severity: severity,
+		SrcIP:    req.ClientIP,
+		Sources:  []string{req.ClientIP},
+		Target:   req.ServerIP,
+		Score:    scanScore(probes, requests, d.config.MinProbes),
+		Count:    probes,
+		Message: fmt.Sprintf("HTTP probing from %s: %d sensitive paths on %d hosts in %s",
+			req.ClientIP, probes, len(stats.targets), ts.Sub(stats.start).Round(time.Second)),
+		Details: map[string]string{
+			"paths":       formatEvidence(paths),
+			"requests":    strconv.Itoa(requests),
+			"user_agents": strings.Join(agents, " | "),
+		},
+		Timestamp: ts,
+	}
+}
+
+// cleanup forgets sources without probes for two windows
+func (d *httpProbeDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for ip, stats := range d.sources {
+		if now.Sub(stats.last) >= 2*d.config.Window {
+			delete(d.sources, ip)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package network
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+const (
+	// maxRedisArgs bounds the arguments kept per command
+	maxRedisArgs = 16
+	// maxRedisLine bounds an inline command or a reply line
+	maxRedisLine = 64 << 10
+	// maxRedisDepth bounds the nesting of replies
+	maxRedisDepth = 32
+)
+
+// redisSubcommands are the commands whose first argument selects the
+// operation, they are recorded as "CONFIG SET"
+var redisSubcommands = map[string]struct{}{
+	"ACL": {}, "CLIENT": {}, "CLUSTER": {}, "COMMAND": {}, "CONFIG": {}, "DEBUG": {},
+	"FUNCTION": {}, "LATENCY": {}, "MEMORY": {}, "MODULE": {}, "OBJECT": {}, "SCRIPT": {},
+	"SLOWLOG": {}, "XGROUP": {}, "XINFO": {},
+}
+
+// redisSecretConfigs are the configuration parameters holding credentials
+var redisSecretConfigs = map[string]struct{}{
+	"requirepass": {}, "masterauth": {}, "masteruser": {},
+}
+
+// redisStreamParser extracts the commands of a Redis connection, RESP
+// arrays as well as inline commands, and matches them with the replies in
+// order. AUTH and HELLO with credentials are recorded as logins.
+type redisStreamParser struct {
+	session   *dbSession
+	buf       [2][]byte // unparsed data per direction, indexed by toServer
+	skip      [2]int    // bytes left of a bulk string beyond what is kept
+	args      []string  // arguments of the command being read
+	remaining int       // bulk strings left of the command being read
+	nested    []int     // elements left per nesting level of the reply being read
+	err       string    // error of the reply being read
+}
+
+func (a *ipAnalyzer) newRedisStreamParser(conn *streamConn) streamParser {
+	return &redisStreamParser{
+		session: newDBSession(a, conn, dbProtocolRedis),
+	}
+}
+
+func (p *redisStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	buf := append(p.buf[dir], data...)
+
+	for len(buf) > 0 {
+		if p.skip[dir] > 0 {
+			n := min(p.skip[dir], len(buf))
+			p.skip[dir] -= n
+			buf = buf[n:]
+			continue
+		}
+
+		var n int
+		var ok bool
+		if toServer {
+			n, ok = p.request(buf, ts)
+		} else {
+			n, ok = p.reply(buf, ts)
+		}
+		if !ok {
+			// not RESP, the rest of the stream is ignored
+			p.session.flush()
+			return false
+		}
+		if n == 0 {
+			break
+		}
+		buf = buf[n:]
+	}
+
+	p.buf[dir] = append([]byte(nil), buf...)
+	return true
+}
+
+// request consumes the next element of a command and returns the bytes
+// consumed, 0 if more data is needed
+func (p *redisStreamParser) request(buf []byte, ts time.Time) (int, bool) {
+	line, n := redisLine(buf)
+	if n == 0 {
+		return 0, len(buf) <= maxRedisLine
+	}
+
+	if p.remaining == 0 {
+		if line == "" {
+			return n, true
+		}
+		if line[0] != '*' {
+			// inline commands are sent by telnet and many exploit scripts
+			if !isAlphanumeric(line[0]) {
+				return 0, false
+			}
+			p.command(strings.Fields(line), ts)
+			return n, true
+		}
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return 0, false
+		}
+		p.remaining, p.args = max(count, 0), p.args[:0]
+		return n, true
+	}
+
+	if line == "" || line[0] != '$' {
+		return 0, false
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return 0, false
+	}
+	kept := min(length, maxDBArgs)
+	if len(buf) < n+kept {
+		return 0, true
+	}
+	if len(p.args) < maxRedisArgs {
+		p.args = append(p.args, string(buf[n:n+kept]))
+	}
+	p.skip[dirIndex(true)] = length - kept + 2
+	p.remaining--
+	if p.remaining == 0 {
+		p.command(p.args, ts)
+	}
+	return n + kept, true
+}
+
+// command queues a complete command for its reply
+func (p *redisStreamParser) command(args []string, ts time.Time) {
+	if len(args) == 0 {
+		return
+	}
+	name, rest := strings.ToUpper(args[0]), args[1:]
+	if _, ok := redisSubcommands[name]; ok && len(rest) > 0 {
+		name, rest = name+" "+strings.ToUpper(rest[0]), rest[1:]
+	}
+
+	switch name {
+	case "AUTH":
+		// AUTH [username] password
+		user := "default"
+		if len(rest) > 1 {
+			user = rest[0]
+		}
+		p.session.request(models.DBCommandLogin, "", ts).User = user
+		return
+	case "HELLO":
+		// HELLO [protover [AUTH username password] [SETNAME clientname]]
+		for i := 0; i+2 < len(rest); i++ {
+			if strings.EqualFold(rest[i], "AUTH") {
+				p.session.request(models.DBCommandLogin, "", ts).User = rest[i+1]
+				return
+			}
+		}
+	case "CONFIG SET":
+		for i := 0; i+1 < len(rest); i += 2 {
+			if _, ok := redisSecretConfigs[strings.ToLower(rest[i])]; ok {
+				rest = redactArg(rest, i+1)
+			}
+		}
+	case "ACL SETUSER":
+		// rules starting with > or # set passwords or their hashes
+		for i, rule := range rest {
+			if strings.HasPrefix(rule, ">") || strings.HasPrefix(rule, "#") {
+				rest = redactArg(rest, i)
+			}
+		}
+	}
+	p.session.request(name, strings.Join(rest, " "), ts)
+}
+
+// reply consumes the next line of a reply and returns the bytes consumed, 0
+// if more data is needed. RESP3 types are understood as well.
+func (p *redisStreamParser) reply(buf []byte, ts time.Time) (int, bool) {
+	line, n := redisLine(buf)
+	if n == 0 {
+		return 0, len(buf) <= maxRedisLine
+	}
+	if line == "" {
+		return 0, false
+	}
+
+	switch line[0] {
+	case '+', ':', '_', '#', ',', '(':
+		// simple values
+	case '-':
+		// errors nested in the reply of EXEC belong to queued commands
+		if len(p.nested) == 0 {
+			p.err = line[1:]
+		}
+	case '$', '=', '!':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return 0, false
+		}
+		if length >= 0 {
+			p.skip[dirIndex(false)] = length + 2
+		}
+		if line[0] == '!' && len(p.nested) == 0 {
+			p.err = "ERR blob error"
+		}
+	case '*', '~', '>', '%', '|':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return 0, false
+		}
+		if line[0] == '%' || line[0] == '|' {
+			// maps and attributes hold key and value pairs
+			count *= 2
+		}
+		if count > 0 {
+			if len(p.nested) >= maxRedisDepth {
+				return 0, false
+			}
+			p.nested = append(p.nested, count)
+			return n, true
+		}
+	default:
+		return 0, false
+	}
+
+	p.element(ts)
+	return n, true
+}
+
+// element completes a value of the reply, and the reply once no nesting
+// level has elements left
+func (p *redisStreamParser) element(ts time.Time) {
+	for len(p.nested) > 0 {
+		top := len(p.nested) - 1
+		p.nested[top]--
+		if p.nested[top] > 0 {
+			return
+		}
+		p.nested = p.nested[:top]
+	}
+
+	req := p.session.respond(p.err, ts)
+	if req != nil && req.Command == models.DBCommandLogin && req.Success {
+		p.session.user = req.User
+	}
+	p.err = ""
+}
+
+func (p *redisStreamParser) close() {
+	p.session.flush()
+}
+
+// redisLine returns the line at the start of buf without its terminator and
+// the bytes it spans, 0 if the line is incomplete
+func redisLine(buf []byte) (string, int) {
+	end := bytes.IndexByte(buf, '\n')
+	if end < 0 {
+		return "", 0
+	}
+	return strings.TrimSuffix(string(buf[:end]), "\r"), end + 1
+}
+
+// redactArg returns args with the argument at i replaced
+func redactArg(args []string, i int) []string {
+	result := append([]string(nil), args...)
+	result[i] = "***"
+	return result
+}
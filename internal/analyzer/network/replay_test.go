@@ -0,0 +1,313 @@
+package network
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// replayLocalIPs are the addresses of the monitored host in the fixtures
+var replayLocalIPs = []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1")}
+
+// newReplayAnalyzer returns an analyzer replaying a capture file of testdata
+func newReplayAnalyzer(t *testing.T, name string, config Config) *ipAnalyzer {
+	t.Helper()
+	config.ReplayFile = "testdata/" + name
+	a, err := NewIPAnalyzer(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzer := a.(*ipAnalyzer)
+	analyzer.localIPs = replayLocalIPs
+	return analyzer
+}
+
+// runReplay starts the analyzer and waits until the file has been replayed,
+// the callbacks have all returned by then
+func runReplay(t *testing.T, a IPAnalyzer) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := a.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitReplay(t, a)
+}
+
+func waitReplay(t *testing.T, a IPAnalyzer) {
+	t.Helper()
+	select {
+	case <-a.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("replay did not finish")
+	}
+	a.Stop()
+}
+
+// replayManager replays a capture file of testdata through a manager, setup
+// enables its detectors before the replay starts
+func replayManager(t *testing.T, name string, config Config, setup func(m *AnalyzerManager)) (*AnalyzerManager, *testBlocker) {
+	t.Helper()
+	a := newReplayAnalyzer(t, name, config)
+	b := &testBlocker{blocked: make(map[string]time.Duration), reasons: make(map[string][]string)}
+	m := NewAnalyzerManager(a, b, testChecker{})
+	if setup != nil {
+		setup(m)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitReplay(t, a)
+	return m, b
+}
+
+// testBlocker records bans instead of changing the firewall
+type testBlocker struct {
+	mutex   sync.Mutex
+	blocked map[string]time.Duration
+	reasons map[string][]string // reasons of every Block call per address
+}
+
+func (b *testBlocker) Block(ip string, duration time.Duration, reason string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.blocked[ip] = duration
+	b.reasons[ip] = append(b.reasons[ip], reason)
+	return nil
+}
+
+func (b *testBlocker) Unblock(ip string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.blocked, ip)
+	return nil
+}
+
+func (b *testBlocker) IsBlocked(ip string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	_, ok := b.blocked[ip]
+	return ok
+}
+
+func (b *testBlocker) GetBlockList() ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return lo.Keys(b.blocked), nil
+}
+
+func (b *testBlocker) GetOffenders(filter string) ([]*models.OffenderStats, error) {
+	return nil, nil
+}
+
+// testChecker never looks addresses up
+type testChecker struct{}
+
+func (testChecker) CheckAndAddToBlacklist(ip string)    {}
+func (testChecker) AddToStats(ip string, reason string) {}
+func (testChecker) GetStats() []*models.IPCheckResult   { return nil }
+
+// eventsOf returns the events of the given type
+func eventsOf(m *AnalyzerManager, eventType models.EventType) []*models.Event {
+	events, _ := m.GetEvents()
+	return lo.Filter(events, func(event *models.Event, _ int) bool {
+		return event.Type == eventType
+	})
+}
+
+func TestReplay(t *testing.T) {
+	m, _ := replayManager(t, "replay.pcap", Config{}, nil)
+
+	flows, _ := m.GetFlows()
+	if len(flows) != 1 {
+		t.Fatalf("got %d closed flows, want 1", len(flows))
+	}
+	flow := flows[0]
+	if flow.SrcIP != "192.0.2.10" || flow.DstIP != "198.51.100.1" || flow.DstPort != 80 ||
+		flow.Direction != models.DirectionInbound {
+		t.Errorf("flow %s:%d -> %s:%d %v", flow.SrcIP, flow.SrcPort, flow.DstIP, flow.DstPort, flow.Direction)
+	}
+	if flow.State != models.FlowStateClosed || flow.CloseReason != models.FlowCloseFIN || !flow.Handshake {
+		t.Errorf("flow state %s, close reason %s, handshake %v", flow.State, flow.CloseReason, flow.Handshake)
+	}
+	// the flow closes with the second FIN, before the last ACK
+	if flow.SrcPackets != 5 || flow.DstPackets != 3 {
+		t.Errorf("flow packets %d/%d, want 5/3", flow.SrcPackets, flow.DstPackets)
+	}
+
+	requests, _ := m.GetHTTPRequests()
+	if len(requests) != 1 {
+		t.Fatalf("got %d HTTP requests, want 1", len(requests))
+	}
+	if req := requests[0]; req.Method != "GET" || req.Host != "www.example.com" || req.Path != "/index.html" ||
+		req.UserAgent != "curl/8.5.0" || req.Status != 200 {
+		t.Errorf("HTTP request %+v", req)
+	}
+
+	queries, _ := m.GetDNSQueries()
+	domains := lo.Map(queries, func(query *models.DNSQueryStats, _ int) string {
+		return query.Domain + " " + query.QueryType
+	})
+	sort.Strings(domains)
+	if want := []string{"example.com AXFR", "www.example.com A"}; !reflect.DeepEqual(domains, want) {
+		t.Fatalf("DNS queries %v, want %v", domains, want)
+	}
+	for _, query := range queries {
+		if query.Domain != "www.example.com" {
+			continue
+		}
+		if query.Rcode != models.DNSRcodeNoError || !reflect.DeepEqual(query.Response, []string{"93.184.216.34"}) {
+			t.Errorf("DNS response %s %v", query.Rcode, query.Response)
+		}
+	}
+
+	events, _ := m.GetEvents()
+	if len(events) != 1 || events[0].Type != models.EventZoneTransfer || events[0].SrcIP != "203.0.113.5" {
+		t.Errorf("events %v, want a zone transfer from 203.0.113.5", events)
+	}
+}
+
+func TestReplayPortScan(t *testing.T) {
+	m, b := replayManager(t, "portscan.pcap", Config{}, func(m *AnalyzerManager) {
+		// scans are checked once the replay is done
+		m.SetScanDetection(ScanConfig{Enabled: true, Interval: time.Hour})
+		m.SetAutoBlock(models.EventVerticalScan, time.Hour)
+	})
+	m.detectScans()
+
+	events := eventsOf(m, models.EventVerticalScan)
+	if len(events) != 1 || events[0].SrcIP != "203.0.113.8" || events[0].Target != "198.51.100.1" {
+		t.Fatalf("vertical scans %v, want one from 203.0.113.8", events)
+	}
+	if events[0].Count != 30 {
+		t.Errorf("scan of %d ports, want 30", events[0].Count)
+	}
+	waitBlocked(t, b, "203.0.113.8")
+}
+
+func TestReplayDNSTunnel(t *testing.T) {
+	m, _ := replayManager(t, "dnstunnel.pcap", Config{}, func(m *AnalyzerManager) {
+		m.SetDNSTunnelDetection(DNSTunnelConfig{Enabled: true})
+	})
+
+	events := eventsOf(m, models.EventDNSTunnel)
+	if len(events) != 1 || events[0].SrcIP != "198.51.100.1" || events[0].Target != "tunnel.example" {
+		t.Fatalf("DNS tunnel events %v, want one from 198.51.100.1 to tunnel.example", events)
+	}
+}
+
+func TestReplaySSHBruteForce(t *testing.T) {
+	config := Config{SSHBruteForce: SSHBruteForceConfig{Enabled: true}}
+	m, _ := replayManager(t, "sshbrute.pcap", config, nil)
+
+	events := eventsOf(m, models.EventSSHBruteForce)
+	if len(events) != 1 || events[0].SrcIP != "203.0.113.9" {
+		t.Fatalf("SSH brute force events %v, want one from 203.0.113.9", events)
+	}
+	flows, _ := m.GetFlows()
+	for _, flow := range flows {
+		if flow.SSH == nil || flow.SSH.ClientBanner != "SSH-2.0-libssh_0.9.6" {
+			t.Fatalf("flow %d has SSH banners %+v", flow.SrcPort, flow.SSH)
+		}
+	}
+}
+
+func TestReplaySYNFlood(t *testing.T) {
+	config := Config{SYNFlood: SYNFloodConfig{Enabled: true}}
+	m, b := replayManager(t, "synflood.pcap", config, func(m *AnalyzerManager) {
+		m.SetAutoBlock(models.EventSYNFlood, time.Hour)
+		err := m.SetJails([]JailConfig{{Name: "flood", Events: []string{string(models.EventSYNFlood)}, BanTime: 2 * time.Hour}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// the unanswered SYNs the local host sent are no flood
+	events := eventsOf(m, models.EventSYNFlood)
+	if len(events) != 1 || events[0].SrcIP != "203.0.113.7" {
+		t.Fatalf("SYN flood events %v, want one from 203.0.113.7", events)
+	}
+	waitBlocked(t, b, "203.0.113.7")
+	if b.IsBlocked("198.51.100.1") {
+		t.Error("the local host blocked itself")
+	}
+
+	// auto block and the jail ban once, for the longer duration
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	want := []string{string(models.EventSYNFlood) + ", flood"}
+	if reasons := b.reasons["203.0.113.7"]; !reflect.DeepEqual(reasons, want) {
+		t.Errorf("bans %q, want %q", reasons, want)
+	}
+	if duration := b.blocked["203.0.113.7"]; duration != 2*time.Hour {
+		t.Errorf("banned for %s, want 2h", duration)
+	}
+}
+
+// waitBlocked waits for the ban of ip, which is made in the background
+func waitBlocked(t *testing.T, b *testBlocker, ip string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if b.IsBlocked(ip) {
+			return
+		}
+	}
+	t.Fatalf("%s was not blocked", ip)
+}
+
+// TestReplayIPv6ExtensionHeaders replays the filter corner cases through
+// DefaultFilter, the packets behind extension headers and VLAN tags must
+// reach the analyzer
+func TestReplayIPv6ExtensionHeaders(t *testing.T) {
+	a := newReplayAnalyzer(t, "ipv6_ext.pcap", Config{})
+
+	var conns, queries []string
+	a.SetNewConnectionCallback(func(conn *models.NewConnectionStats) {
+		if conn.Protocol == models.ProtocolTCP && conn.Direction == models.DirectionInbound {
+			conns = append(conns, net.JoinHostPort(conn.DstIP, itoa(conn.DstPort)))
+		}
+	})
+	a.SetDNSQueryCallback(func(query *models.DNSQueryStats) {
+		queries = append(queries, query.Domain+" "+itoa(query.SrcPort))
+	})
+	runReplay(t, a)
+
+	sort.Strings(conns)
+	sort.Strings(queries)
+	wantConns := []string{
+		"198.51.100.1:443",
+		"198.51.100.1:80",
+		"[2001:db8::1]:22",
+		// five extension headers hide the transport from the kernel filter,
+		// DefaultFilter lets the SYN through and the decoder finds it
+		"[2001:db8::1]:22",
+		"[2001:db8::1]:443",
+	}
+	wantQueries := []string{
+		"example.com 40001",
+		"example.com 40002",
+		"example.com 40007",
+	}
+	if !reflect.DeepEqual(conns, wantConns) {
+		t.Errorf("connections %v, want %v", conns, wantConns)
+	}
+	if !reflect.DeepEqual(queries, wantQueries) {
+		t.Errorf("DNS queries %v, want %v", queries, wantQueries)
+	}
+}
+
+func itoa(port uint16) string {
+	return strconv.Itoa(int(port))
+}
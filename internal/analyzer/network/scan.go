@@ -0,0 +1,415 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// ScanConfig configures the port scan detector
+type ScanConfig struct {
+	Enabled         bool
+	Interval        time.Duration // how often the connection windows are checked
+	VerticalPorts   int           // distinct ports of one host per 10 minutes
+	HorizontalHosts int           // distinct hosts on one port per 10 minutes
+	SlowTargets     int           // distinct host/port pairs within the slow scan horizon
+	SlowHorizon     time.Duration // how long targets are remembered for slow scans
+	IncludeOutbound bool          // also detect scans started by local hosts
+}
+
+// DefaultScanConfig returns the settings used for unset values
+func DefaultScanConfig() ScanConfig {
+	return ScanConfig{
+		Enabled:         true,
+		Interval:        10 * time.Second,
+		VerticalPorts:   20,
+		HorizontalHosts: 20,
+		SlowTargets:     15,
+		SlowHorizon:     time.Hour,
+	}
+}
+
+const (
+	// scanRatePeriod is the period the vertical and horizontal thresholds
+	// apply to, scans spread wider than their threshold per period are slow
+	scanRatePeriod = 10 * time.Minute
+	// maxScanSources bounds the number of sources remembered for slow scans
+	maxScanSources = 10000
+	// maxScanTargets bounds the number of targets remembered per source
+	maxScanTargets = 4096
+	// maxScanEvidence bounds the number of ports or hosts listed in an event
+	maxScanEvidence = 50
+)
+
+// scanAlert remembers the size of the last reported scan
+type scanAlert struct {
+	count int
+	time  time.Time
+}
+
+// scanHistory holds the targets of a source within the slow scan horizon
+type scanHistory struct {
+	targets  map[string]time.Time // "protocol dst:port" -> first seen
+	lastSeen time.Time
+	lastFast time.Time // last vertical or horizontal scan of the source
+}
+
+// scanGroup collects the hosts a source contacted on one port
+type scanGroup struct {
+	protocol models.Protocol
+	srcIP    string
+	port     uint16
+	hosts    map[string]struct{}
+	conns    int64
+	first    time.Time
+	last     time.Time
+}
+
+// scanDetector interprets the connection and port windows of the stats
+// collector. It is driven by a single goroutine and not safe for concurrent use.
+type scanDetector struct {
+	config  ScanConfig
+	alerts  map[string]*scanAlert
+	history map[string]*scanHistory
+	now     time.Time
+}
+
+func newScanDetector(config ScanConfig) *scanDetector {
+	defaults := DefaultScanConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.VerticalPorts <= 0 {
+		config.VerticalPorts = defaults.VerticalPorts
+	}
+	if config.HorizontalHosts <= 0 {
+		config.HorizontalHosts = defaults.HorizontalHosts
+	}
+	if config.SlowTargets <= 0 {
+		config.SlowTargets = defaults.SlowTargets
+	}
+	if config.SlowHorizon <= 0 {
+		config.SlowHorizon = defaults.SlowHorizon
+	}
+
+	return &scanDetector{
+		config:  config,
+		alerts:  make(map[string]*scanAlert),
+		history: make(map[string]*scanHistory),
+	}
+}
+
+// detect checks the current windows and returns the events of new or grown scans
+func (d *scanDetector) detect(connWindows []*models.ConnectionWindowStats, portWindows []*models.PortWindowStats) []*models.Event {
+	var events []*models.Event
+
+	// window timestamps come from the packets, which keeps replays consistent
+	for _, w := range connWindows {
+		if w.WindowEnd.After(d.now) {
+			d.now = w.WindowEnd
+		}
+	}
+
+	for _, w := range connWindows {
+		if !d.watched(w.Direction) {
+			continue
+		}
+		d.record(w)
+		if event := d.checkVertical(w); event != nil {
+			events = append(events, event)
+		}
+	}
+
+	for _, g := range d.groupByPort(portWindows) {
+		if event := d.checkHorizontal(g); event != nil {
+			events = append(events, event)
+		}
+	}
+
+	for srcIP, h := range d.history {
+		if event := d.checkSlow(srcIP, h); event != nil {
+			events = append(events, event)
+		}
+	}
+
+	d.cleanup()
+	return events
+}
+
+func (d *scanDetector) watched(direction models.Direction) bool {
+	return direction == models.DirectionInbound || d.config.IncludeOutbound
+}
+
+// checkVertical reports a source probing many ports of one host
+func (d *scanDetector) checkVertical(w *models.ConnectionWindowStats) *models.Event {
+	if len(w.Ports) < d.config.VerticalPorts || !isFast(len(w.Ports), w.WindowStart, w.WindowEnd, d.config.VerticalPorts) {
+		return nil
+	}
+
+	key := fmt.Sprintf("vertical %s %s->%s", w.Protocol, w.SrcIP, w.DstIP)
+	if !d.shouldAlert(key, len(w.Ports)) {
+		return nil
+	}
+	d.source(w.SrcIP).lastFast = w.WindowEnd
+
+	ports := make([]uint16, 0, len(w.Ports))
+	for port := range w.Ports {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	evidence := make([]string, len(ports))
+	for i, port := range ports {
+		evidence[i] = strconv.Itoa(int(port))
+	}
+
+	event := newScanEvent(models.EventVerticalScan, len(ports), w.TotalConns, d.config.VerticalPorts, w.WindowStart, w.WindowEnd)
+	event.SrcIP = w.SrcIP
+	event.Sources = []string{w.SrcIP}
+	event.Target = w.DstIP
+	event.Details["protocol"] = string(w.Protocol)
+	event.Details["ports"] = formatEvidence(evidence)
+	event.Message = fmt.Sprintf("%s vertical scan from %s against %s: %d ports in %s",
+		w.Protocol, w.SrcIP, w.DstIP, len(ports), w.WindowEnd.Sub(w.WindowStart).Round(time.Second))
+	return event
+}
+
+// groupByPort collects the hosts each source contacted per port
+func (d *scanDetector) groupByPort(portWindows []*models.PortWindowStats) map[string]*scanGroup {
+	groups := make(map[string]*scanGroup)
+	for _, w := range portWindows {
+		if !d.watched(w.Direction) {
+			continue
+		}
+		for srcIP := range w.UniqueIPs {
+			key := fmt.Sprintf("%s %d %s", w.Protocol, w.DstPort, srcIP)
+			g, ok := groups[key]
+			if !ok {
+				g = &scanGroup{
+					protocol: w.Protocol,
+					srcIP:    srcIP,
+					port:     w.DstPort,
+					hosts:    make(map[string]struct{}),
+					first:    w.WindowStart,
+					last:     w.WindowEnd,
+				}
+				groups[key] = g
+			}
+			g.hosts[w.DstIP] = struct{}{}
+			// the window does not tell connections apart by source
+			g.conns++
+			if w.WindowStart.Before(g.first) {
+				g.first = w.WindowStart
+			}
+			if w.WindowEnd.After(g.last) {
+				g.last = w.WindowEnd
+			}
+		}
+	}
+	return groups
+}
+
+// checkHorizontal reports a source probing one port on many hosts
+func (d *scanDetector) checkHorizontal(g *scanGroup) *models.Event {
+	if len(g.hosts) < d.config.HorizontalHosts || !isFast(len(g.hosts), g.first, g.last, d.config.HorizontalHosts) {
+		return nil
+	}
+
+	key := fmt.Sprintf("horizontal %s %d %s", g.protocol, g.port, g.srcIP)
+	if !d.shouldAlert(key, len(g.hosts)) {
+		return nil
+	}
+	d.source(g.srcIP).lastFast = g.last
+
+	hosts := make([]string, 0, len(g.hosts))
+	for host := range g.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	// ICMP has no ports, a horizontal ICMP scan is a ping sweep
+	target, service := fmt.Sprintf("%s/%d", g.protocol, g.port), fmt.Sprintf("port %d", g.port)
+	if g.protocol == models.ProtocolICMP || g.protocol == models.ProtocolICMPv6 {
+		target, service = string(g.protocol), "ping sweep"
+	}
+
+	event := newScanEvent(models.EventHorizontalScan, len(hosts), int(g.conns), d.config.HorizontalHosts, g.first, g.last)
+	event.SrcIP = g.srcIP
+	event.Sources = []string{g.srcIP}
+	event.Target = target
+	event.Details["protocol"] = string(g.protocol)
+	event.Details["port"] = strconv.Itoa(int(g.port))
+	event.Details["hosts"] = formatEvidence(hosts)
+	event.Message = fmt.Sprintf("%s horizontal scan from %s, %s: %d hosts in %s",
+		g.protocol, g.srcIP, service, len(hosts), g.last.Sub(g.first).Round(time.Second))
+	return event
+}
+
+// record adds the ports of a window to the slow scan history of its source
+func (d *scanDetector) record(w *models.ConnectionWindowStats) {
+	if _, ok := d.history[w.SrcIP]; !ok && len(d.history) >= maxScanSources {
+		return
+	}
+	h := d.source(w.SrcIP)
+	for port := range w.Ports {
+		target := fmt.Sprintf("%s %s", w.Protocol, utils.FormatAddr(w.DstIP, port))
+		if _, ok := h.targets[target]; !ok && len(h.targets) < maxScanTargets {
+			h.targets[target] = w.WindowStart
+		}
+	}
+	if w.WindowEnd.After(h.lastSeen) {
+		h.lastSeen = w.WindowEnd
+	}
+}
+
+func (d *scanDetector) source(srcIP string) *scanHistory {
+	h, ok := d.history[srcIP]
+	if !ok {
+		h = &scanHistory{
+			targets: make(map[string]time.Time),
+		}
+		d.history[srcIP] = h
+	}
+	return h
+}
+
+// checkSlow reports sources probing many targets too slowly to show up in
+// the vertical and horizontal checks
+func (d *scanDetector) checkSlow(srcIP string, h *scanHistory) *models.Event {
+	if len(h.targets) < d.config.SlowTargets {
+		return nil
+	}
+	// scans already reported as vertical or horizontal are not repeated
+	if !h.lastFast.IsZero() && d.now.Sub(h.lastFast) < d.config.SlowHorizon {
+		return nil
+	}
+
+	targets := make([]string, 0, len(h.targets))
+	for target := range h.targets {
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		ti, tj := h.targets[targets[i]], h.targets[targets[j]]
+		if ti.Equal(tj) {
+			return targets[i] < targets[j]
+		}
+		return ti.Before(tj)
+	})
+
+	first := h.targets[targets[0]]
+	if h.lastSeen.Sub(first) < scanRatePeriod {
+		return nil
+	}
+
+	key := "slow " + srcIP
+	if !d.shouldAlert(key, len(targets)) {
+		return nil
+	}
+
+	event := newScanEvent(models.EventSlowScan, len(targets), len(targets), d.config.SlowTargets, first, h.lastSeen)
+	event.Severity = models.SeverityLow
+	if len(targets) >= 4*d.config.SlowTargets {
+		event.Severity = models.SeverityMedium
+	}
+	event.SrcIP = srcIP
+	event.Sources = []string{srcIP}
+	event.Details["targets"] = formatEvidence(targets)
+	event.Message = fmt.Sprintf("Slow scan from %s: %d targets in %s",
+		srcIP, len(targets), h.lastSeen.Sub(first).Round(time.Second))
+	return event
+}
+
+// shouldAlert reports whether a scan is new or has at least doubled in size
+// since it was last reported
+func (d *scanDetector) shouldAlert(key string, count int) bool {
+	if alert, ok := d.alerts[key]; ok && count < 2*alert.count {
+		return false
+	}
+	d.alerts[key] = &scanAlert{count: count, time: d.now}
+	return true
+}
+
+// cleanup forgets targets and alerts older than the slow scan horizon
+func (d *scanDetector) cleanup() {
+	threshold := d.now.Add(-d.config.SlowHorizon)
+
+	for srcIP, h := range d.history {
+		for target, seen := range h.targets {
+			if seen.Before(threshold) {
+				delete(h.targets, target)
+			}
+		}
+		if len(h.targets) == 0 && h.lastFast.Before(threshold) {
+			delete(d.history, srcIP)
+		}
+	}
+
+	for key, alert := range d.alerts {
+		if alert.time.Before(threshold) {
+			delete(d.alerts, key)
+		}
+	}
+}
+
+// isFast reports whether distinct targets were probed at least at the
+// threshold rate per scanRatePeriod
+func isFast(distinct int, first, last time.Time, threshold int) bool {
+	span := last.Sub(first)
+	if span <= scanRatePeriod {
+		return true
+	}
+	return float64(distinct)*float64(scanRatePeriod)/float64(span) >= float64(threshold)
+}
+
+func newScanEvent(eventType models.EventType, distinct, conns, threshold int, first, last time.Time) *models.Event {
+	severity := models.SeverityMedium
+	switch {
+	case distinct >= 10*threshold:
+		severity = models.SeverityCritical
+	case distinct >= 4*threshold:
+		severity = models.SeverityHigh
+	}
+
+	rate := float64(distinct)
+	if minutes := last.Sub(first).Minutes(); minutes > 1 {
+		rate /= minutes
+	}
+
+	return &models.Event{
+		Type:     eventType,
+		Severity: severity,
+		Score:    scanScore(distinct, conns, threshold),
+		Count:    distinct,
+		Details: map[string]string{
+			"connections": strconv.Itoa(conns),
+			"first_seen":  first.Format(time.RFC3339),
+			"last_seen":   last.Format(time.RFC3339),
+			"rate":        fmt.Sprintf("%.1f/min", rate),
+		},
+		Timestamp: last,
+	}
+}
+
+// scanScore rates a scan from 0 to 1 by its breadth relative to the
+// threshold and by how many targets were only probed once, as scanners do
+func scanScore(distinct, conns, threshold int) float64 {
+	breadth := math.Min(1, float64(distinct)/float64(4*threshold))
+	uniqueness := 1.0
+	if conns > 0 {
+		uniqueness = math.Min(1, float64(distinct)/float64(conns))
+	}
+	return math.Round((0.6*breadth+0.4*uniqueness)*100) / 100
+}
+
+// formatEvidence joins up to maxScanEvidence items
+func formatEvidence(items []string) string {
+	if len(items) <= maxScanEvidence {
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprintf("%s,... (%d more)", strings.Join(items[:maxScanEvidence], ","), len(items)-maxScanEvidence)
+}
@@ -0,0 +1,346 @@
+package network
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// request fields a signature rule can match
+const (
+	fieldURI       = "uri" // decoded path and query
+	fieldPath      = "path"
+	fieldQuery     = "query"
+	fieldUserAgent = "user_agent"
+	fieldReferer   = "referer"
+	fieldHost      = "host"
+	fieldMethod    = "method"
+	fieldAny       = "any" // every field above
+)
+
+var signatureFields = []string{fieldURI, fieldPath, fieldQuery, fieldUserAgent, fieldReferer, fieldHost, fieldMethod}
+
+// severityScores are the scores of matches of rules without their own
+var severityScores = map[models.Severity]float64{
+	models.SeverityLow:      1,
+	models.SeverityMedium:   3,
+	models.SeverityHigh:     5,
+	models.SeverityCritical: 10,
+}
+
+// SignatureRule is a pattern of attacks on web applications
+type SignatureRule struct {
+	ID       string          `mapstructure:"id"`
+	Name     string          `mapstructure:"name"`
+	Category string          `mapstructure:"category"` // "sqli", "xss", "traversal", "jndi", "cmdi", "scanner"...
+	Severity models.Severity `mapstructure:"severity"`
+	Score    float64         `mapstructure:"score"`  // added to the score of the source, defaults by severity
+	Fields   []string        `mapstructure:"fields"` // fields matched, defaults to the uri
+	Pattern  string          `mapstructure:"pattern"`
+	re       *regexp.Regexp
+}
+
+// SignatureEngine matches requests against the signature rules
+type SignatureEngine struct {
+	rules []*SignatureRule
+}
+
+// LoadSignatureRules reads the rules of a YAML file with a list of rules
+// under the rules key
+func LoadSignatureRules(path string) (*SignatureEngine, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var file struct {
+		Rules []*SignatureRule `mapstructure:"rules"`
+	}
+	if err := v.Unmarshal(&file); err != nil {
+		return nil, err
+	}
+	return NewSignatureEngine(file.Rules)
+}
+
+// NewSignatureEngine checks and compiles the rules
+func NewSignatureEngine(rules []*SignatureRule) (*SignatureEngine, error) {
+	ids := make(map[string]struct{}, len(rules))
+	for i, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("rule %d: missing id", i+1)
+		}
+		if _, ok := ids[rule.ID]; ok {
+			return nil, fmt.Errorf("rule %s: duplicate id", rule.ID)
+		}
+		ids[rule.ID] = struct{}{}
+
+		if rule.Category == "" {
+			return nil, fmt.Errorf("rule %s: missing category", rule.ID)
+		}
+		rule.Severity = models.Severity(strings.ToLower(string(rule.Severity)))
+		score, ok := severityScores[rule.Severity]
+		if !ok {
+			return nil, fmt.Errorf("rule %s: invalid severity %q", rule.ID, rule.Severity)
+		}
+		if rule.Score <= 0 {
+			rule.Score = score
+		}
+
+		if len(rule.Fields) == 0 {
+			rule.Fields = []string{fieldURI}
+		}
+		var fields []string
+		for _, field := range rule.Fields {
+			switch field {
+			case fieldAny:
+				fields = append(fields, signatureFields...)
+			case fieldURI, fieldPath, fieldQuery, fieldUserAgent, fieldReferer, fieldHost, fieldMethod:
+				fields = append(fields, field)
+			default:
+				return nil, fmt.Errorf("rule %s: invalid field %q", rule.ID, field)
+			}
+		}
+		rule.Fields = fields
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil || rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %s: invalid pattern %q: %v", rule.ID, rule.Pattern, err)
+		}
+		rule.re = re
+	}
+	return &SignatureEngine{rules: rules}, nil
+}
+
+// Len returns the number of rules
+func (e *SignatureEngine) Len() int {
+	return len(e.rules)
+}
+
+// Match returns the rules a request matches. The path and query are
+// matched after percent decoding them twice, which undoes the double
+// encoding used to slip past filters.
+func (e *SignatureEngine) Match(method, host, path, query, userAgent, referer string) []models.SignatureMatch {
+	path, query = percentDecode(path, false), percentDecode(query, true)
+	uri := path
+	if query != "" {
+		uri += "?" + query
+	}
+	fields := map[string]string{
+		fieldURI:       uri,
+		fieldPath:      path,
+		fieldQuery:     query,
+		fieldUserAgent: userAgent,
+		fieldReferer:   percentDecode(referer, true),
+		fieldHost:      host,
+		fieldMethod:    method,
+	}
+
+	var matches []models.SignatureMatch
+	for _, rule := range e.rules {
+		for _, field := range rule.Fields {
+			if value := fields[field]; value != "" && rule.re.MatchString(value) {
+				matches = append(matches, models.SignatureMatch{
+					RuleID:   rule.ID,
+					Category: rule.Category,
+					Severity: rule.Severity,
+					Score:    rule.Score,
+				})
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// percentDecode decodes %XX escapes twice and + in queries, invalid escapes
+// are kept
+func percentDecode(s string, query bool) string {
+	for i := 0; i < 2 && (strings.IndexByte(s, '%') >= 0 || query && strings.IndexByte(s, '+') >= 0); i++ {
+		var b strings.Builder
+		for j := 0; j < len(s); j++ {
+			switch {
+			case s[j] == '%' && j+2 < len(s):
+				if n, err := strconv.ParseUint(s[j+1:j+3], 16, 8); err == nil {
+					b.WriteByte(byte(n))
+					j += 2
+					continue
+				}
+			case s[j] == '+' && query && i == 0:
+				b.WriteByte(' ')
+				continue
+			}
+			b.WriteByte(s[j])
+		}
+		s = b.String()
+	}
+	return s
+}
+
+// WebAttackConfig configures the attack scores of sources sending requests
+// that match signature rules
+type WebAttackConfig struct {
+	Enabled  bool
+	Window   time.Duration // window the scores of a source are summed over
+	MinScore float64       // score per source and window that triggers an event
+}
+
+// DefaultWebAttackConfig returns the settings used for unset values
+func DefaultWebAttackConfig() WebAttackConfig {
+	return WebAttackConfig{
+		Enabled:  true,
+		Window:   10 * time.Minute,
+		MinScore: 10,
+	}
+}
+
+// maxAttackSources bounds the number of sources tracked
+const maxAttackSources = 10000
+
+// attackStats sums the matches of a source within the window
+type attackStats struct {
+	start      time.Time
+	last       time.Time
+	score      float64
+	requests   int
+	severity   models.Severity
+	rules      map[string]struct{}
+	categories map[string]struct{}
+	targets    map[string]struct{}
+	sample     string
+	lastAlert  time.Time
+	alerted    float64
+}
+
+// webAttackDetector sums the scores of the signature matches per source
+type webAttackDetector struct {
+	config  WebAttackConfig
+	sources map[string]*attackStats
+	mutex   sync.Mutex
+}
+
+func newWebAttackDetector(config WebAttackConfig) *webAttackDetector {
+	defaults := DefaultWebAttackConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinScore <= 0 {
+		config.MinScore = defaults.MinScore
+	}
+
+	return &webAttackDetector{
+		config:  config,
+		sources: make(map[string]*attackStats),
+	}
+}
+
+// addMatches adds the matches of a request to the score of its source and
+// returns an event once it exceeds the limit within the window, and again
+// when it has doubled since
+func (d *webAttackDetector) addMatches(ip, target, request string, matches []models.SignatureMatch, ts time.Time) *models.Event {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	stats, ok := d.sources[ip]
+	if !ok {
+		if len(d.sources) >= maxAttackSources {
+			return nil
+		}
+		stats = &attackStats{}
+		d.sources[ip] = stats
+	}
+	if stats.start.IsZero() || ts.Sub(stats.start) >= d.config.Window {
+		*stats = attackStats{
+			start:      ts,
+			severity:   models.SeverityLow,
+			rules:      make(map[string]struct{}),
+			categories: make(map[string]struct{}),
+			targets:    make(map[string]struct{}),
+			lastAlert:  stats.lastAlert,
+			alerted:    stats.alerted,
+		}
+	}
+	stats.last = ts
+	stats.requests++
+	for _, match := range matches {
+		stats.score += match.Score
+		stats.rules[match.RuleID] = struct{}{}
+		stats.categories[match.Category] = struct{}{}
+		if severityScores[match.Severity] > severityScores[stats.severity] {
+			stats.severity = match.Severity
+			stats.sample = request
+		}
+	}
+	if stats.sample == "" {
+		stats.sample = request
+	}
+	if len(stats.targets) < maxScanEvidence {
+		stats.targets[target] = struct{}{}
+	}
+
+	if stats.score < d.config.MinScore {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < d.config.Window && stats.score < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = stats.score
+
+	rules := sortedKeys(stats.rules)
+	categories := sortedKeys(stats.categories)
+	targets := sortedKeys(stats.targets)
+
+	return &models.Event{
+		Type:     models.EventWebAttack,
+		Severity: stats.severity,
+		SrcIP:    ip,
+		Sources:  []string{ip},
+		Target:   target,
+		Score:    min(1, stats.score/(5*d.config.MinScore)),
+		Count:    stats.requests,
+		Message: fmt.Sprintf("Web attacks from %s: %d requests matching %s, score %.0f",
+			ip, stats.requests, strings.Join(categories, ","), stats.score),
+		Details: map[string]string{
+			"score":      strconv.FormatFloat(stats.score, 'f', 1, 64),
+			"rules":      strings.Join(rules, ","),
+			"categories": strings.Join(categories, ","),
+			"targets":    formatEvidence(targets),
+			"sample":     stats.sample,
+			"window":     d.config.Window.String(),
+		},
+		Timestamp: ts,
+	}
+}
+
+// cleanup forgets sources without matches for two windows
+func (d *webAttackDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for ip, stats := range d.sources {
+		if now.Sub(stats.last) >= 2*d.config.Window {
+			delete(d.sources, ip)
+		}
+	}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
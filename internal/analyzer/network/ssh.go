@@ -0,0 +1,263 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// DefaultSSHPorts are the server ports whose connections are checked for
+// brute force if none are configured
+var DefaultSSHPorts = []uint16{22}
+
+const (
+	// maxSSHBanner bounds the identification line, RFC 4253 allows 255 bytes
+	maxSSHBanner = 255
+	// maxSSHPreface bounds the lines a server may send before its banner
+	maxSSHPreface = 8 << 10
+)
+
+// sshStreamParser reads the identification lines both sides of an SSH
+// connection send in the clear before the key exchange
+type sshStreamParser struct {
+	analyzer *ipAnalyzer
+	conn     *streamConn
+	buf      [2][]byte // unparsed data per direction, indexed by toServer
+	seen     [2]int    // bytes read per direction
+	banner   [2]string
+	done     [2]bool
+}
+
+func (a *ipAnalyzer) newSSHStreamParser(conn *streamConn) streamParser {
+	return &sshStreamParser{
+		analyzer: a,
+		conn:     conn,
+	}
+}
+
+func (p *sshStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	if p.done[dir] {
+		return !p.done[0] || !p.done[1]
+	}
+
+	buf := append(p.buf[dir], data...)
+	p.seen[dir] += len(data)
+	for !p.done[dir] {
+		end := bytes.IndexByte(buf, '\n')
+		if end < 0 {
+			if len(buf) > maxSSHBanner || p.seen[dir] > maxSSHPreface {
+				p.done[dir] = true
+			}
+			break
+		}
+		line := strings.TrimSuffix(string(buf[:end]), "\r")
+		buf = buf[end+1:]
+
+		switch {
+		case strings.HasPrefix(line, "SSH-") && len(line) <= maxSSHBanner:
+			p.banner[dir] = line
+			p.done[dir] = true
+		case toServer || p.seen[dir] > maxSSHPreface:
+			// only servers may send other lines before their banner
+			p.done[dir] = true
+		}
+	}
+	p.buf[dir] = append([]byte(nil), buf...)
+
+	if p.done[0] && p.done[1] {
+		p.report()
+		return false
+	}
+	return true
+}
+
+// report attaches the banners to the flow while it is still open, the
+// brute force detector reads them once it closes
+func (p *sshStreamParser) report() {
+	if p.banner[0] == "" && p.banner[1] == "" {
+		return
+	}
+	p.analyzer.sshBanners(p.conn, &models.SSHInfo{
+		ClientBanner: p.banner[dirIndex(true)],
+		ServerBanner: p.banner[dirIndex(false)],
+	})
+	p.banner = [2]string{}
+}
+
+func (p *sshStreamParser) close() {
+	p.report()
+}
+
+// SSHBruteForceConfig configures the detector for password guessing against
+// SSH servers. The traffic is encrypted, so attempts are recognised as short
+// sessions that end after exchanging little more than the key exchange.
+type SSHBruteForceConfig struct {
+	Enabled     bool
+	Window      time.Duration // sliding window the attempts are counted in
+	MinAttempts int           // short sessions per source and window that trigger an event
+	MaxDuration time.Duration // sessions lasting less count as attempts
+	MaxBytes    int64         // sessions exchanging fewer bytes count as attempts
+}
+
+// DefaultSSHBruteForceConfig returns the settings used for unset values
+func DefaultSSHBruteForceConfig() SSHBruteForceConfig {
+	return SSHBruteForceConfig{
+		Enabled:     true,
+		Window:      5 * time.Minute,
+		MinAttempts: 20,
+		MaxDuration: 30 * time.Second,
+		MaxBytes:    32 << 10,
+	}
+}
+
+// maxSSHSources bounds the number of sources tracked
+const maxSSHSources = 10000
+
+// sshSourceStats counts the short sessions of a source
+type sshSourceStats struct {
+	attempts  slidingCounter
+	targets   map[string]struct{}
+	banners   map[string]struct{}
+	lastAlert time.Time
+	alerted   int
+}
+
+// sshBruteForceDetector counts short inbound sessions to the SSH ports per
+// source
+type sshBruteForceDetector struct {
+	config  SSHBruteForceConfig
+	ports   map[uint16]struct{}
+	sources map[string]*sshSourceStats
+	mutex   sync.Mutex
+}
+
+func newSSHBruteForceDetector(config SSHBruteForceConfig, ports []uint16) *sshBruteForceDetector {
+	defaults := DefaultSSHBruteForceConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinAttempts <= 0 {
+		config.MinAttempts = defaults.MinAttempts
+	}
+	if config.MaxDuration <= 0 {
+		config.MaxDuration = defaults.MaxDuration
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaults.MaxBytes
+	}
+
+	portSet := make(map[uint16]struct{}, len(ports))
+	for _, port := range ports {
+		portSet[port] = struct{}{}
+	}
+
+	return &sshBruteForceDetector{
+		config:  config,
+		ports:   portSet,
+		sources: make(map[string]*sshSourceStats),
+	}
+}
+
+// addFlow counts a closed flow if it was a short SSH session and returns an
+// event once the source exceeds the attempts within the window, and again
+// when they have doubled since
+func (d *sshBruteForceDetector) addFlow(record *models.FlowRecord) *models.Event {
+	if record.Protocol != models.ProtocolTCP || record.Direction != models.DirectionInbound || !record.Handshake {
+		return nil
+	}
+	if _, ok := d.ports[record.DstPort]; !ok {
+		return nil
+	}
+	if record.Duration >= d.config.MaxDuration || record.SrcBytes+record.DstBytes >= d.config.MaxBytes {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ts, window := record.EndTime, d.config.Window
+	stats, ok := d.sources[record.SrcIP]
+	if !ok {
+		if len(d.sources) >= maxSSHSources {
+			return nil
+		}
+		stats = &sshSourceStats{}
+		d.sources[record.SrcIP] = stats
+	}
+	if stats.targets == nil || stats.attempts.idle(ts, window) {
+		stats.targets = make(map[string]struct{})
+		stats.banners = make(map[string]struct{})
+	}
+	stats.attempts.add(ts, window, 1)
+	if len(stats.targets) < maxScanEvidence {
+		stats.targets[utils.FormatAddr(record.DstIP, record.DstPort)] = struct{}{}
+	}
+	if record.SSH != nil && record.SSH.ClientBanner != "" && len(stats.banners) < maxScanEvidence {
+		stats.banners[record.SSH.ClientBanner] = struct{}{}
+	}
+
+	attempts := int(stats.attempts.value(ts, window))
+	if attempts < d.config.MinAttempts {
+		return nil
+	}
+	if ts.Sub(stats.lastAlert) < window && attempts < 2*stats.alerted {
+		return nil
+	}
+	stats.lastAlert = ts
+	stats.alerted = attempts
+
+	severity := models.SeverityMedium
+	if attempts >= 5*d.config.MinAttempts {
+		severity = models.SeverityHigh
+	}
+
+	targets := make([]string, 0, len(stats.targets))
+	for target := range stats.targets {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	banners := make([]string, 0, len(stats.banners))
+	for banner := range stats.banners {
+		banners = append(banners, banner)
+	}
+	sort.Strings(banners)
+
+	return &models.Event{
+		Type:     models.EventSSHBruteForce,
+		Severity: severity,
+		SrcIP:    record.SrcIP,
+		Sources:  []string{record.SrcIP},
+		Target:   utils.FormatAddr(record.DstIP, record.DstPort),
+		Score:    scanScore(attempts, attempts, d.config.MinAttempts),
+		Count:    attempts,
+		Message: fmt.Sprintf("SSH brute force from %s: %d short sessions to %d hosts within %s",
+			record.SrcIP, attempts, len(targets), window),
+		Details: map[string]string{
+			"attempts": strconv.Itoa(attempts),
+			"targets":  formatEvidence(targets),
+			"banners":  strings.Join(banners, " | "),
+			"window":   window.String(),
+		},
+		Timestamp: ts,
+	}
+}
+
+// cleanup forgets sources without attempts for two windows
+func (d *sshBruteForceDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for ip, stats := range d.sources {
+		if stats.attempts.idle(now, d.config.Window) {
+			delete(d.sources, ip)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/reassembly"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+const (
+	// streamTimeout closes reassembled streams without traffic
+	streamTimeout = 2 * time.Minute
+	// maxStreamPages bounds the out-of-order data buffered for all streams
+	maxStreamPages = 4096
+	// maxStreamPagesPerConn bounds the out-of-order data buffered per stream
+	maxStreamPagesPerConn = 64
+)
+
+// streamConn describes the TCP connection a parser is attached to, the
+// server is the side listening on the registered port
+type streamConn struct {
+	Interface  string
+	ClientIP   net.IP
+	ClientPort uint16
+	ServerIP   net.IP
+	ServerPort uint16
+	Direction  models.Direction
+}
+
+// packetInfo returns the packet info of data sent in the given direction
+func (c *streamConn) packetInfo(toServer bool, length int, ts time.Time) *packetInfo {
+	info := &packetInfo{
+		Interface: c.Interface,
+		SrcIP:     c.ClientIP,
+		DstIP:     c.ServerIP,
+		Length:    length,
+		Timestamp: ts,
+	}
+	if !toServer {
+		info.SrcIP, info.DstIP = c.ServerIP, c.ClientIP
+	}
+	return info
+}
+
+// ports returns the source and destination port of data sent in the given direction
+func (c *streamConn) ports(toServer bool) (uint16, uint16) {
+	if toServer {
+		return c.ClientPort, c.ServerPort
+	}
+	return c.ServerPort, c.ClientPort
+}
+
+// streamParser consumes the reassembled payload of a TCP connection
+type streamParser interface {
+	// feed is called with the next in-order bytes of one direction, it
+	// returns false once the parser is not interested in more data
+	feed(data []byte, toServer bool, ts time.Time) bool
+	// close is called once the connection is closed or timed out
+	close()
+}
+
+// streamParserFactory creates the parser of a new connection
+type streamParserFactory func(conn *streamConn) streamParser
+
+// streamDispatcher reassembles the TCP connections of registered ports and
+// hands their payload to the parser registered for the port
+type streamDispatcher struct {
+	parsers   map[uint16]streamParserFactory
+	direction func(*packetInfo) models.Direction
+	assembler *reassembly.Assembler
+	lastSeen  time.Time
+	mutex     sync.Mutex
+}
+
+func newStreamDispatcher(direction func(*packetInfo) models.Direction) *streamDispatcher {
+	d := &streamDispatcher{
+		parsers:   make(map[uint16]streamParserFactory),
+		direction: direction,
+	}
+	d.assembler = reassembly.NewAssembler(reassembly.NewStreamPool(d))
+	d.assembler.MaxBufferedPagesTotal = maxStreamPages
+	d.assembler.MaxBufferedPagesPerConnection = maxStreamPagesPerConn
+	return d
+}
+
+// register attaches a parser to the connections of a server port, must be
+// called before the capture starts
+func (d *streamDispatcher) register(port uint16, factory streamParserFactory) {
+	d.parsers[port] = factory
+}
+
+// handles reports whether the packet belongs to a registered port
+func (d *streamDispatcher) handles(tcp *layers.TCP) bool {
+	_, src := d.parsers[uint16(tcp.SrcPort)]
+	_, dst := d.parsers[uint16(tcp.DstPort)]
+	return src || dst
+}
+
+// streamContext passes the interface of a packet to the stream factory
+type streamContext struct {
+	ci    gopacket.CaptureInfo
+	iface string
+}
+
+func (c *streamContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return c.ci
+}
+
+func (d *streamDispatcher) assemble(info *packetInfo, netFlow gopacket.Flow, tcp *layers.TCP) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if info.Timestamp.After(d.lastSeen) {
+		d.lastSeen = info.Timestamp
+	}
+
+	ctx := &streamContext{
+		ci:    gopacket.CaptureInfo{Timestamp: info.Timestamp},
+		iface: info.Interface,
+	}
+	d.assembler.AssembleWithContext(netFlow, tcp, ctx)
+}
+
+// flush closes the streams without traffic for streamTimeout
+func (d *streamDispatcher) flush(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.lastSeen.After(now) {
+		now = d.lastSeen
+	}
+	d.assembler.FlushCloseOlderThan(now.Add(-streamTimeout))
+}
+
+// New implements reassembly.StreamFactory
+func (d *streamDispatcher) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	src, dst := net.IP(netFlow.Src().Raw()), net.IP(netFlow.Dst().Raw())
+	srcPort, dstPort := uint16(tcp.SrcPort), uint16(tcp.DstPort)
+
+	// the first packet may come from either side, the server is the side
+	// on the registered port
+	conn := &streamConn{
+		ClientIP:   src,
+		ClientPort: srcPort,
+		ServerIP:   dst,
+		ServerPort: dstPort,
+	}
+	factory, ok := d.parsers[dstPort]
+	firstToServer := ok
+	if !ok {
+		factory = d.parsers[srcPort]
+		conn.ClientIP, conn.ServerIP = dst, src
+		conn.ClientPort, conn.ServerPort = dstPort, srcPort
+	}
+	if ctx, ok := ac.(*streamContext); ok {
+		conn.Interface = ctx.iface
+	}
+	conn.Direction = d.direction(&packetInfo{SrcIP: conn.ClientIP, DstIP: conn.ServerIP})
+
+	return &tcpStream{
+		parser:        factory(conn),
+		firstToServer: firstToServer,
+	}
+}
+
+// tcpStream passes the in-order payload of both directions to its parser.
+// Directions are only followed from their SYN and dropped after a gap, as
+// parsers cannot resynchronise within the byte stream.
+type tcpStream struct {
+	parser        streamParser
+	firstToServer bool
+	synced        [2]bool // per direction, indexed by toServer
+	done          bool
+}
+
+func (s *tcpStream) toServer(dir reassembly.TCPFlowDirection) bool {
+	return (dir == reassembly.TCPDirClientToServer) == s.firstToServer
+}
+
+func (s *tcpStream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if tcp.SYN {
+		s.synced[dirIndex(s.toServer(dir))] = true
+	}
+	// connections picked up midway are still tracked so they can be closed
+	*start = true
+	return true
+}
+
+func (s *tcpStream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	toServer := s.toServer(dir)
+	if skip != 0 {
+		s.synced[dirIndex(toServer)] = false
+	}
+
+	length, _ := sg.Lengths()
+	if s.done || length == 0 || !s.synced[dirIndex(toServer)] {
+		return
+	}
+
+	ts := sg.CaptureInfo(0).Timestamp
+	if !s.parser.feed(sg.Fetch(length), toServer, ts) {
+		s.done = true
+	}
+}
+
+func (s *tcpStream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	s.parser.close()
+	return true
+}
+
+func dirIndex(toServer bool) int {
+	if toServer {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,222 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// SYNFloodConfig configures the SYN flood detector
+type SYNFloodConfig struct {
+	Enabled       bool
+	Window        time.Duration // sliding window the SYNs are counted in
+	MinSYNs       int           // SYNs per source before it is considered
+	PortMinSYNs   int           // SYNs per local port before it is considered
+	HalfOpenRatio float64       // share of SYNs without completed handshake that triggers an event
+}
+
+// DefaultSYNFloodConfig returns the settings used for unset values
+func DefaultSYNFloodConfig() SYNFloodConfig {
+	return SYNFloodConfig{
+		Enabled:       true,
+		Window:        30 * time.Second,
+		MinSYNs:       100,
+		PortMinSYNs:   500,
+		HalfOpenRatio: 0.8,
+	}
+}
+
+const (
+	// maxFloodSources bounds the number of sources remembered per local port
+	maxFloodSources = 100
+	// maxSYNSources bounds the number of sources SYNs are counted for,
+	// spoofed floods bring a new source with almost every SYN
+	maxSYNSources = 10000
+)
+
+// synStats counts SYNs and completed handshakes for a source or local port
+type synStats struct {
+	syns      slidingCounter
+	completed slidingCounter
+	sources   map[string]int
+	lastAlert time.Time
+}
+
+// synFloodDetector correlates SYNs with completed handshakes per source IP
+// and per local port
+type synFloodDetector struct {
+	config  SYNFloodConfig
+	sources map[string]*synStats
+	ports   map[string]*synStats
+	mutex   sync.Mutex
+}
+
+func newSYNFloodDetector(config SYNFloodConfig) *synFloodDetector {
+	defaults := DefaultSYNFloodConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinSYNs <= 0 {
+		config.MinSYNs = defaults.MinSYNs
+	}
+	if config.PortMinSYNs <= 0 {
+		config.PortMinSYNs = defaults.PortMinSYNs
+	}
+	if config.HalfOpenRatio <= 0 {
+		config.HalfOpenRatio = defaults.HalfOpenRatio
+	}
+
+	return &synFloodDetector{
+		config:  config,
+		sources: make(map[string]*synStats),
+		ports:   make(map[string]*synStats),
+	}
+}
+
+func (d *synFloodDetector) stats(table map[string]*synStats, key string) *synStats {
+	stats, ok := table[key]
+	if !ok {
+		stats = &synStats{}
+		table[key] = stats
+	}
+	return stats
+}
+
+// addSYN records the initial SYN of a flow and returns the events it
+// triggered. Only inbound SYNs are counted, a local host opening many
+// connections to unresponsive servers is no flood and must never get its
+// own address blocked.
+func (d *synFloodDetector) addSYN(record *models.NewConnectionStats) []*models.Event {
+	if record.Direction != models.DirectionInbound {
+		return nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ts, window := record.Timestamp, d.config.Window
+	var events []*models.Event
+
+	// once the table is full new sources are only counted per port
+	if _, ok := d.sources[record.SrcIP]; ok || len(d.sources) < maxSYNSources {
+		src := d.stats(d.sources, record.SrcIP)
+		src.syns.add(ts, window, 1)
+		if event := d.check(src, ts, float64(d.config.MinSYNs)); event != nil {
+			event.SrcIP = record.SrcIP
+			event.Sources = []string{record.SrcIP}
+			event.Message = fmt.Sprintf("SYN flood from %s: %d SYNs, %.0f%% half-open",
+				record.SrcIP, event.Count, event.Score*100)
+			events = append(events, event)
+		}
+	}
+
+	// spoofed floods spread over many sources and only show up per port
+	target := utils.FormatAddr(record.DstIP, record.DstPort)
+	port := d.stats(d.ports, target)
+	if port.syns.idle(ts, window) {
+		port.sources = nil
+	}
+	port.syns.add(ts, window, 1)
+	if port.sources == nil {
+		port.sources = make(map[string]int)
+	}
+	if _, ok := port.sources[record.SrcIP]; ok || len(port.sources) < maxFloodSources {
+		port.sources[record.SrcIP]++
+	}
+	if event := d.check(port, ts, float64(d.config.PortMinSYNs)); event != nil {
+		event.Target = target
+		event.Sources = topSources(port.sources, 20)
+		event.Message = fmt.Sprintf("SYN flood against %s: %d SYNs from %d sources, %.0f%% half-open",
+			target, event.Count, len(port.sources), event.Score*100)
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// addHandshake records a completed inbound three-way handshake
+func (d *synFloodDetector) addHandshake(record *models.NewConnectionStats) {
+	if record.Direction != models.DirectionInbound {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ts, window := record.Timestamp, d.config.Window
+	if src, ok := d.sources[record.SrcIP]; ok {
+		src.completed.add(ts, window, 1)
+	}
+	if port, ok := d.ports[utils.FormatAddr(record.DstIP, record.DstPort)]; ok {
+		port.completed.add(ts, window, 1)
+	}
+}
+
+// check returns an event if the half-open ratio is exceeded, at most once per window
+func (d *synFloodDetector) check(stats *synStats, ts time.Time, minSYNs float64) *models.Event {
+	window := d.config.Window
+	syns := stats.syns.value(ts, window)
+	if syns < minSYNs || ts.Sub(stats.lastAlert) < window {
+		return nil
+	}
+
+	halfOpen := syns - stats.completed.value(ts, window)
+	ratio := halfOpen / syns
+	if ratio < d.config.HalfOpenRatio {
+		return nil
+	}
+	stats.lastAlert = ts
+
+	severity := models.SeverityHigh
+	if syns >= 10*minSYNs {
+		severity = models.SeverityCritical
+	}
+
+	return &models.Event{
+		Type:     models.EventSYNFlood,
+		Severity: severity,
+		Score:    ratio,
+		Count:    int(syns),
+		Details: map[string]string{
+			"half_open": fmt.Sprintf("%.0f", halfOpen),
+			"window":    window.String(),
+		},
+		Timestamp: ts,
+	}
+}
+
+// cleanup forgets sources and ports without recent SYNs
+func (d *synFloodDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, table := range []map[string]*synStats{d.sources, d.ports} {
+		for key, stats := range table {
+			if stats.syns.idle(now, d.config.Window) {
+				delete(table, key)
+			}
+		}
+	}
+}
+
+// topSources returns up to n sources ordered by count
+func topSources(sources map[string]int, n int) []string {
+	result := make([]string, 0, len(sources))
+	for ip := range sources {
+		result = append(result, ip)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if sources[result[i]] == sources[result[j]] {
+			return result[i] < result[j]
+		}
+		return sources[result[i]] > sources[result[j]]
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
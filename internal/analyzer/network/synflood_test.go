@@ -0,0 +1,38 @@
+package network
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+func TestSYNFloodSpoofedSources(t *testing.T) {
+	d := newSYNFloodDetector(SYNFloodConfig{Enabled: true})
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var events []*models.Event
+	for i := 0; i < maxSYNSources+5000; i++ {
+		events = append(events, d.addSYN(&models.NewConnectionStats{
+			SrcIP:     fmt.Sprintf("10.%d.%d.%d", i>>16, i>>8&0xff, i&0xff),
+			SrcPort:   40000,
+			DstIP:     "198.51.100.1",
+			DstPort:   80,
+			Protocol:  models.ProtocolTCP,
+			Direction: models.DirectionInbound,
+			Timestamp: start.Add(time.Duration(i) * time.Millisecond),
+		})...)
+	}
+
+	if len(d.sources) != maxSYNSources {
+		t.Errorf("%d sources tracked, want %d", len(d.sources), maxSYNSources)
+	}
+	// the SYNs of the sources that no longer fit are still counted per port
+	if syns := d.ports["198.51.100.1:80"].syns.value(start.Add(15*time.Second), d.config.Window); syns != maxSYNSources+5000 {
+		t.Errorf("port counted %.0f SYNs, want %d", syns, maxSYNSources+5000)
+	}
+	if len(events) != 1 || events[0].Target != "198.51.100.1:80" {
+		t.Fatalf("events %v, want one flood against 198.51.100.1:80", events)
+	}
+}
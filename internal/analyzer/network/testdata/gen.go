@@ -0,0 +1,425 @@
+//go:build ignore
+
+// gen writes the capture files replayed by the network analyzer tests, run
+// it from the package directory after changing a fixture:
+//
+//	go run testdata/gen.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gopacket/gopacket"
+	"github.com/gopacket/gopacket/layers"
+	"github.com/gopacket/gopacket/pcapgo"
+)
+
+var (
+	localMAC  = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	remoteMAC = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+
+	// start is the timestamp of the first packet of every file
+	start = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+// dnsTypeAXFR is the zone transfer query type, gopacket has no name for it
+const dnsTypeAXFR = layers.DNSType(252)
+
+var fixtures = map[string]func(w *writer){
+	"ipv6_ext.pcap":  ipv6Ext,
+	"replay.pcap":    replay,
+	"portscan.pcap":  portScan,
+	"dnstunnel.pcap": dnsTunnel,
+	"sshbrute.pcap":  sshBrute,
+	"synflood.pcap":  synFlood,
+}
+
+func main() {
+	for name, generate := range fixtures {
+		f, err := os.Create(filepath.Join("testdata", name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		w := &writer{w: pcapgo.NewWriter(f), ts: start}
+		if err := w.w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+			log.Fatal(err)
+		}
+		generate(w)
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+type writer struct {
+	w  *pcapgo.Writer
+	ts time.Time
+}
+
+// write appends a frame sent gap after the previous one
+func (w *writer) write(gap time.Duration, frame []byte) {
+	w.ts = w.ts.Add(gap)
+	ci := gopacket.CaptureInfo{Timestamp: w.ts, CaptureLength: len(frame), Length: len(frame)}
+	if err := w.w.WritePacket(ci, frame); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serialize(l ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, l...); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ether(etherType layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{SrcMAC: remoteMAC, DstMAC: localMAC, EthernetType: etherType}
+}
+
+// network returns the IPv4 or IPv6 header of a packet from src to dst
+// carrying transport, which gets it for its checksum
+func network(src, dst string, transport gopacket.SerializableLayer) (layers.EthernetType, gopacket.SerializableLayer) {
+	var proto layers.IPProtocol
+	switch transport.(type) {
+	case *layers.TCP:
+		proto = layers.IPProtocolTCP
+	case *layers.UDP:
+		proto = layers.IPProtocolUDP
+	case *layers.ICMPv4:
+		proto = layers.IPProtocolICMPv4
+	case *layers.ICMPv6:
+		proto = layers.IPProtocolICMPv6
+	}
+
+	var etherType layers.EthernetType
+	var ip interface {
+		gopacket.SerializableLayer
+		gopacket.NetworkLayer
+	}
+	srcIP, dstIP := net.ParseIP(src), net.ParseIP(dst)
+	if srcIP.To4() != nil {
+		etherType = layers.EthernetTypeIPv4
+		ip = &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: proto, SrcIP: srcIP.To4(), DstIP: dstIP.To4()}
+	} else {
+		etherType = layers.EthernetTypeIPv6
+		ip = &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: srcIP, DstIP: dstIP}
+	}
+
+	switch t := transport.(type) {
+	case *layers.TCP:
+		t.SetNetworkLayerForChecksum(ip)
+	case *layers.UDP:
+		t.SetNetworkLayerForChecksum(ip)
+	case *layers.ICMPv6:
+		t.SetNetworkLayerForChecksum(ip)
+	}
+	return etherType, ip
+}
+
+// packet returns an Ethernet frame of a packet from src to dst
+func packet(src, dst string, transport gopacket.SerializableLayer, payload []byte) []byte {
+	etherType, ip := network(src, dst, transport)
+	return serialize(ether(etherType), ip, transport, gopacket.Payload(payload))
+}
+
+func tcp(srcPort, dstPort uint16, flags string, seq, ack uint32) *layers.TCP {
+	t := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort), Seq: seq, Ack: ack, Window: 64240}
+	for _, flag := range flags {
+		switch flag {
+		case 'S':
+			t.SYN = true
+		case 'A':
+			t.ACK = true
+		case 'F':
+			t.FIN = true
+		case 'R':
+			t.RST = true
+		case 'P':
+			t.PSH = true
+		}
+	}
+	return t
+}
+
+func udp(srcPort, dstPort uint16) *layers.UDP {
+	return &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+}
+
+// dnsQuery returns the wire format of a query, or of its response if
+// answers are given
+func dnsQuery(id uint16, name string, qtype layers.DNSType, answers ...net.IP) []byte {
+	dns := &layers.DNS{
+		ID:        id,
+		RD:        true,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: qtype, Class: layers.DNSClassIN}},
+	}
+	if answers != nil {
+		dns.QR, dns.RA = true, true
+		for _, ip := range answers {
+			dns.Answers = append(dns.Answers, layers.DNSResourceRecord{
+				Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: ip,
+			})
+		}
+	}
+	return serialize(dns)
+}
+
+// extension is a raw IPv6 extension header, its first byte is filled with
+// the protocol of the following header
+type extension struct {
+	proto layers.IPProtocol
+	data  []byte
+}
+
+func options() extension {
+	// next header, length, PadN option of 4 bytes
+	return extension{data: []byte{0, 0, 1, 4, 0, 0, 0, 0}}
+}
+
+func hopByHop() extension {
+	e := options()
+	e.proto = layers.IPProtocolIPv6HopByHop
+	return e
+}
+
+func destination() extension {
+	e := options()
+	e.proto = layers.IPProtocolIPv6Destination
+	return e
+}
+
+func routing() extension {
+	// type 0 routing header without addresses
+	return extension{proto: layers.IPProtocolIPv6Routing, data: []byte{0, 0, 0, 0, 0, 0, 0, 0}}
+}
+
+func fragment(offset uint16, more bool) extension {
+	field := offset << 3
+	if more {
+		field |= 1
+	}
+	return extension{proto: layers.IPProtocolIPv6Fragment, data: []byte{0, 0, byte(field >> 8), byte(field), 0, 0, 0x12, 0x34}}
+}
+
+func authentication() extension {
+	// payload length of 4 words counted from the third, SPI, sequence
+	// number and a 96 bit ICV
+	data := make([]byte, 24)
+	data[1] = 4
+	data[7] = 1
+	data[11] = 1
+	return extension{proto: layers.IPProtocolAH, data: data}
+}
+
+// packetExt returns an IPv6 frame whose transport header follows exts
+func packetExt(src, dst string, exts []extension, transport gopacket.SerializableLayer, payload []byte) []byte {
+	_, ip := network(src, dst, transport)
+	ip6 := ip.(*layers.IPv6)
+	return rawExt(ip6, exts, serialize(transport, gopacket.Payload(payload)))
+}
+
+// rawExt returns an IPv6 frame carrying body after exts, the next header of
+// ip6 is the protocol of body
+func rawExt(ip6 *layers.IPv6, exts []extension, body []byte) []byte {
+	next := ip6.NextHeader
+	var headers []byte
+	for i := len(exts) - 1; i >= 0; i-- {
+		header := append([]byte(nil), exts[i].data...)
+		header[0] = byte(next)
+		headers = append(header, headers...)
+		next = exts[i].proto
+	}
+	ip6.NextHeader = next
+	return serialize(ether(layers.EthernetTypeIPv6), ip6, gopacket.Payload(append(headers, body...)))
+}
+
+// ipv6Ext holds one frame per capture filter corner case, the filter tests
+// refer to them by index
+func ipv6Ext(w *writer) {
+	query := dnsQuery(0x0101, "example.com", layers.DNSTypeA)
+
+	// 0: hop-by-hop options before a TCP SYN
+	w.write(0, packetExt("2001:db8::10", "2001:db8::1", []extension{hopByHop()},
+		tcp(40000, 22, "S", 1000, 0), nil))
+	// 1: destination options before a DNS query
+	w.write(time.Millisecond, packetExt("2001:db8::10", "2001:db8::1", []extension{destination()},
+		udp(40001, 53), query))
+	// 2: hop-by-hop, destination options and routing before a DNS query
+	w.write(time.Millisecond, packetExt("2001:db8::10", "2001:db8::1", []extension{hopByHop(), destination(), routing()},
+		udp(40002, 53), query))
+	// 3: first fragment of a DNS query
+	w.write(time.Millisecond, packetExt("2001:db8::10", "2001:db8::1", []extension{fragment(0, true)},
+		udp(40003, 53), query))
+	// 4: second fragment of a UDP datagram, the bytes where a header would
+	// be look like port 53 again
+	w.write(time.Millisecond, rawExt(&layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("2001:db8::10"), DstIP: net.ParseIP("2001:db8::1")},
+		[]extension{fragment(16, false)}, []byte{0x00, 0x35, 0x00, 0x35, 0, 0, 0, 0}))
+	// 5: authentication header before a TCP SYN
+	w.write(time.Millisecond, packetExt("2001:db8::10", "2001:db8::1", []extension{authentication()},
+		tcp(40005, 443, "S", 5000, 0), nil))
+
+	// 6: IPv4 TCP SYN tagged with VLAN 100
+	transport := tcp(40006, 443, "S", 6000, 0)
+	_, ip := network("192.0.2.10", "198.51.100.1", transport)
+	w.write(time.Millisecond, serialize(ether(layers.EthernetTypeDot1Q),
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv4}, ip, transport))
+
+	// 7: IPv6 DNS query tagged with VLANs 200 and 100
+	transport2 := udp(40007, 53)
+	_, ip = network("2001:db8::10", "2001:db8::1", transport2)
+	w.write(time.Millisecond, serialize(ether(layers.EthernetTypeQinQ),
+		&layers.Dot1Q{VLANIdentifier: 200, Type: layers.EthernetTypeDot1Q},
+		&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv6}, ip, transport2, gopacket.Payload(query)))
+
+	// 8: ARP request
+	w.write(time.Millisecond, serialize(ether(layers.EthernetTypeARP), &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+		HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+		SourceHwAddress: remoteMAC, SourceProtAddress: net.ParseIP("192.0.2.10").To4(),
+		DstHwAddress: make([]byte, 6), DstProtAddress: net.ParseIP("198.51.100.1").To4(),
+	}))
+
+	// 9: second fragment of an IPv4 UDP datagram
+	w.write(time.Millisecond, serialize(ether(layers.EthernetTypeIPv4),
+		&layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolUDP, FragOffset: 185,
+			SrcIP: net.ParseIP("192.0.2.10").To4(), DstIP: net.ParseIP("198.51.100.1").To4()},
+		gopacket.Payload([]byte{0x00, 0x35, 0x00, 0x35, 0, 0, 0, 0})))
+
+	// 10: IPv4 TCP SYN after a router alert option
+	transport = tcp(40010, 80, "S", 10000, 0)
+	ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.ParseIP("192.0.2.10").To4(), DstIP: net.ParseIP("198.51.100.1").To4(),
+		Options: []layers.IPv4Option{{OptionType: 148, OptionLength: 4, OptionData: []byte{0, 0}}}}
+	transport.SetNetworkLayerForChecksum(ip4)
+	w.write(time.Millisecond, serialize(ether(layers.EthernetTypeIPv4), ip4, transport))
+
+	// 11: more extension headers than the filter follows before a TCP SYN
+	w.write(time.Millisecond, packetExt("2001:db8::10", "2001:db8::1",
+		[]extension{hopByHop(), destination(), routing(), destination(), destination()},
+		tcp(40011, 22, "S", 11000, 0), nil))
+}
+
+// conn writes the packets of a TCP connection and tracks its sequence numbers
+type conn struct {
+	w                      *writer
+	client, server         string
+	clientPort, serverPort uint16
+	clientSeq, serverSeq   uint32
+}
+
+func newConn(w *writer, client string, clientPort uint16, server string, serverPort uint16) *conn {
+	return &conn{w: w, client: client, clientPort: clientPort, server: server, serverPort: serverPort,
+		clientSeq: 1000, serverSeq: 5000}
+}
+
+func (c *conn) send(gap time.Duration, toServer bool, flags string, payload []byte) {
+	src, dst, srcPort, dstPort := c.client, c.server, c.clientPort, c.serverPort
+	seq, ack := &c.clientSeq, c.serverSeq
+	if !toServer {
+		src, dst, srcPort, dstPort = c.server, c.client, c.serverPort, c.clientPort
+		seq, ack = &c.serverSeq, c.clientSeq
+	}
+	c.w.write(gap, packet(src, dst, tcp(srcPort, dstPort, flags, *seq, ack), payload))
+
+	*seq += uint32(len(payload))
+	for _, flag := range flags {
+		if flag == 'S' || flag == 'F' {
+			*seq++
+		}
+	}
+}
+
+func (c *conn) handshake(gap time.Duration) {
+	c.send(gap, true, "S", nil)
+	c.send(time.Millisecond, false, "SA", nil)
+	c.send(time.Millisecond, true, "A", nil)
+}
+
+// close ends the connection from the client side
+func (c *conn) close(gap time.Duration) {
+	c.send(gap, true, "FA", nil)
+	c.send(time.Millisecond, false, "FA", nil)
+	c.send(time.Millisecond, true, "A", nil)
+}
+
+// replay holds a complete HTTP connection, a resolved DNS query, a ping and
+// a zone transfer request to the local host 198.51.100.1
+func replay(w *writer) {
+	w.write(0, packet("198.51.100.1", "192.0.2.53", udp(40000, 53),
+		dnsQuery(0x1234, "www.example.com", layers.DNSTypeA)))
+	w.write(20*time.Millisecond, packet("192.0.2.53", "198.51.100.1", udp(53, 40000),
+		dnsQuery(0x1234, "www.example.com", layers.DNSTypeA, net.ParseIP("93.184.216.34").To4())))
+
+	c := newConn(w, "192.0.2.10", 50000, "198.51.100.1", 80)
+	c.handshake(10 * time.Millisecond)
+	c.send(time.Millisecond, true, "PA", []byte("GET /index.html HTTP/1.1\r\nHost: www.example.com\r\nUser-Agent: curl/8.5.0\r\n\r\n"))
+	c.send(5*time.Millisecond, false, "PA", []byte("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 2\r\n\r\nok"))
+	c.send(time.Millisecond, true, "A", nil)
+	c.close(10 * time.Millisecond)
+
+	w.write(10*time.Millisecond, packet("198.51.100.1", "192.0.2.1",
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1, Seq: 1}, nil))
+	w.write(time.Millisecond, packet("192.0.2.1", "198.51.100.1",
+		&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0), Id: 1, Seq: 1}, nil))
+
+	w.write(10*time.Millisecond, packet("203.0.113.5", "198.51.100.1", udp(40100, 53),
+		dnsQuery(0x5678, "example.com", dnsTypeAXFR)))
+}
+
+// portScan holds 203.0.113.8 probing 30 ports of the local host, closed
+// ports answer with a reset
+func portScan(w *writer) {
+	for port := uint16(1); port <= 30; port++ {
+		c := newConn(w, "203.0.113.8", 60000, "198.51.100.1", port)
+		c.send(10*time.Millisecond, true, "S", nil)
+		c.send(time.Millisecond, false, "RA", nil)
+	}
+}
+
+// dnsTunnel holds the local host sending 60 TXT queries with random 48
+// character labels below one domain
+func dnsTunnel(w *writer) {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 60; i++ {
+		label := make([]byte, 48)
+		for j := range label {
+			label[j] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		name := fmt.Sprintf("%s.t.tunnel.example", label)
+		w.write(50*time.Millisecond, packet("198.51.100.1", "192.0.2.53", udp(41000+uint16(i), 53),
+			dnsQuery(uint16(i), name, layers.DNSTypeTXT)))
+	}
+}
+
+// sshBrute holds 25 short SSH sessions from 203.0.113.9 that end after the
+// banners, as password guessing tools do after a failed login
+func sshBrute(w *writer) {
+	for i := uint16(0); i < 25; i++ {
+		c := newConn(w, "203.0.113.9", 42000+i, "198.51.100.1", 22)
+		c.handshake(200 * time.Millisecond)
+		c.send(time.Millisecond, false, "PA", []byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		c.send(time.Millisecond, true, "PA", []byte("SSH-2.0-libssh_0.9.6\r\n"))
+		c.send(50*time.Millisecond, true, "PA", make([]byte, 512))
+		c.send(time.Millisecond, false, "PA", make([]byte, 256))
+		c.close(10 * time.Millisecond)
+	}
+}
+
+// synFlood holds 150 unanswered SYNs from 203.0.113.7 to the local host,
+// interleaved with as many unanswered SYNs the local host sends itself
+func synFlood(w *writer) {
+	for i := uint16(0); i < 150; i++ {
+		w.write(10*time.Millisecond, packet("203.0.113.7", "198.51.100.1", tcp(30000+i, 80, "S", 1000, 0), nil))
+		w.write(10*time.Millisecond, packet("198.51.100.1", "192.0.2.99", tcp(50000+i, 443, "S", 1000, 0), nil))
+	}
+}
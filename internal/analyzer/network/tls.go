@@ -0,0 +1,383 @@
+package network
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// DefaultTLSPorts are the server ports whose handshakes are parsed if none
+// are configured
+var DefaultTLSPorts = []uint16{443, 8443}
+
+// TLS record and handshake types, see RFC 8446
+const (
+	tlsRecordChangeCipherSpec = 20
+	tlsRecordHandshake        = 22
+	tlsRecordApplicationData  = 23
+
+	tlsClientHello = 1
+	tlsServerHello = 2
+	tlsCertificate = 11
+
+	tlsExtServerName          = 0
+	tlsExtSupportedGroups     = 10
+	tlsExtECPointFormats      = 11
+	tlsExtSignatureAlgorithms = 13
+	tlsExtALPN                = 16
+	tlsExtSupportedVersions   = 43
+
+	// maxTLSHandshake bounds the buffered handshake data per direction,
+	// certificate chains rarely exceed a few kilobytes
+	maxTLSHandshake = 64 << 10
+)
+
+// clientHello holds the fields of a ClientHello, extension values are kept
+// in the order they were sent
+type clientHello struct {
+	version      uint16
+	cipherSuites []uint16
+	extensions   []uint16
+	groups       []uint16
+	pointFormats []uint8
+	sigAlgs      []uint16
+	serverName   string
+	alpn         []string
+	versions     []uint16
+}
+
+// serverHello holds the fields of a ServerHello
+type serverHello struct {
+	version     uint16
+	cipherSuite uint16
+	alpn        string
+}
+
+// tlsReader consumes the length prefixed fields of TLS messages, once a
+// read runs past the end all following reads fail
+type tlsReader struct {
+	data []byte
+	ok   bool
+}
+
+func newTLSReader(data []byte) *tlsReader {
+	return &tlsReader{data: data, ok: true}
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if !r.ok || n > len(r.data) {
+		r.ok = false
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *tlsReader) u8() uint8 {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *tlsReader) u16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tlsReader) u24() int {
+	if b := r.bytes(3); b != nil {
+		return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	}
+	return 0
+}
+
+// vec8 and vec16 return a vector with a one or two byte length prefix
+func (r *tlsReader) vec8() *tlsReader {
+	return newTLSReader(r.bytes(int(r.u8())))
+}
+
+func (r *tlsReader) vec16() *tlsReader {
+	return newTLSReader(r.bytes(int(r.u16())))
+}
+
+func (r *tlsReader) empty() bool {
+	return len(r.data) == 0
+}
+
+// u16s reads the remaining data as a list of 16 bit values
+func (r *tlsReader) u16s() []uint16 {
+	var values []uint16
+	for r.ok && len(r.data) >= 2 {
+		values = append(values, r.u16())
+	}
+	return values
+}
+
+func parseClientHello(data []byte) (*clientHello, bool) {
+	r := newTLSReader(data)
+	hello := &clientHello{version: r.u16()}
+	r.bytes(32) // random
+	r.vec8()    // session id
+	hello.cipherSuites = r.vec16().u16s()
+	r.vec8() // compression methods
+	if !r.ok {
+		return nil, false
+	}
+	if r.empty() {
+		return hello, true
+	}
+
+	extensions := r.vec16()
+	for extensions.ok && !extensions.empty() {
+		extType := extensions.u16()
+		ext := extensions.vec16()
+		hello.extensions = append(hello.extensions, extType)
+
+		switch extType {
+		case tlsExtServerName:
+			names := ext.vec16()
+			for names.ok && !names.empty() {
+				nameType, name := names.u8(), names.vec16()
+				if nameType == 0 && name.ok {
+					hello.serverName = string(name.data)
+				}
+			}
+		case tlsExtSupportedGroups:
+			hello.groups = ext.vec16().u16s()
+		case tlsExtECPointFormats:
+			hello.pointFormats = ext.vec8().data
+		case tlsExtSignatureAlgorithms:
+			hello.sigAlgs = ext.vec16().u16s()
+		case tlsExtALPN:
+			protocols := ext.vec16()
+			for protocols.ok && !protocols.empty() {
+				if protocol := protocols.vec8(); protocol.ok {
+					hello.alpn = append(hello.alpn, string(protocol.data))
+				}
+			}
+		case tlsExtSupportedVersions:
+			hello.versions = ext.vec8().u16s()
+		}
+	}
+	return hello, extensions.ok
+}
+
+func parseServerHello(data []byte) (*serverHello, bool) {
+	r := newTLSReader(data)
+	hello := &serverHello{version: r.u16()}
+	r.bytes(32) // random
+	r.vec8()    // session id
+	hello.cipherSuite = r.u16()
+	r.u8() // compression method
+	if !r.ok {
+		return nil, false
+	}
+
+	extensions := r.vec16()
+	for extensions.ok && !extensions.empty() {
+		extType := extensions.u16()
+		ext := extensions.vec16()
+		switch extType {
+		case tlsExtALPN:
+			if protocol := ext.vec16().vec8(); protocol.ok {
+				hello.alpn = string(protocol.data)
+			}
+		case tlsExtSupportedVersions:
+			// TLS 1.3 keeps the legacy version at 1.2 and selects here
+			if version := ext.u16(); ext.ok {
+				hello.version = version
+			}
+		}
+	}
+	return hello, true
+}
+
+// parseCertificate returns the leaf of a TLS 1.2 Certificate message
+func parseCertificate(data []byte) (*x509.Certificate, bool) {
+	r := newTLSReader(data)
+	chain := newTLSReader(r.bytes(r.u24()))
+	leaf := chain.bytes(chain.u24())
+	if !chain.ok {
+		return nil, false
+	}
+	cert, err := x509.ParseCertificate(leaf)
+	return cert, err == nil
+}
+
+// tlsStreamParser extracts the handshake metadata of a TLS connection. The
+// client direction is done after the ClientHello, the server direction once
+// its certificate was seen or the handshake continues encrypted.
+type tlsStreamParser struct {
+	analyzer *ipAnalyzer
+	conn     *streamConn
+	buf      [2][]byte // undecoded records per direction, indexed by toServer
+	hs       [2][]byte // incomplete handshake message per direction
+	done     [2]bool
+	hello    *clientHello
+	session  *models.TLSSession
+	reported bool
+}
+
+func (a *ipAnalyzer) newTLSStreamParser(conn *streamConn) streamParser {
+	return &tlsStreamParser{
+		analyzer: a,
+		conn:     conn,
+	}
+}
+
+func (p *tlsStreamParser) feed(data []byte, toServer bool, ts time.Time) bool {
+	dir := dirIndex(toServer)
+	if p.done[dir] {
+		return !p.done[0] || !p.done[1]
+	}
+
+	buf := append(p.buf[dir], data...)
+	for len(buf) >= 5 && !p.done[dir] {
+		recordType, length := buf[0], int(binary.BigEndian.Uint16(buf[3:5]))
+		if recordType < tlsRecordChangeCipherSpec || recordType > tlsRecordApplicationData || buf[1] != 3 {
+			// not TLS, the rest of the stream is ignored
+			p.done[0], p.done[1] = true, true
+			break
+		}
+		if len(buf) < 5+length {
+			break
+		}
+		body := buf[5 : 5+length]
+		buf = buf[5+length:]
+
+		if recordType != tlsRecordHandshake {
+			// anything after the handshake records is encrypted
+			p.done[dir] = true
+			break
+		}
+		p.hs[dir] = append(p.hs[dir], body...)
+		p.handshake(toServer, ts)
+	}
+
+	if len(buf)+len(p.hs[dir]) > maxTLSHandshake {
+		p.done[dir] = true
+	}
+	p.buf[dir] = append([]byte(nil), buf...)
+
+	if p.done[0] && p.done[1] {
+		p.report()
+		return false
+	}
+	return true
+}
+
+// handshake consumes the complete handshake messages of a direction
+func (p *tlsStreamParser) handshake(toServer bool, ts time.Time) {
+	dir := dirIndex(toServer)
+	hs := p.hs[dir]
+	for len(hs) >= 4 && !p.done[dir] {
+		msgType := hs[0]
+		length := int(hs[1])<<16 | int(hs[2])<<8 | int(hs[3])
+		if length > maxTLSHandshake {
+			p.done[dir] = true
+			break
+		}
+		if len(hs) < 4+length {
+			break
+		}
+		p.message(toServer, msgType, hs[4:4+length], ts)
+		hs = hs[4+length:]
+	}
+	p.hs[dir] = append([]byte(nil), hs...)
+}
+
+func (p *tlsStreamParser) message(toServer bool, msgType uint8, data []byte, ts time.Time) {
+	switch {
+	case toServer && msgType == tlsClientHello:
+		p.done[dirIndex(true)] = true
+		hello, ok := parseClientHello(data)
+		if !ok {
+			return
+		}
+		p.hello = hello
+		session := p.sessionAt(ts)
+		session.ServerName = hello.serverName
+		session.ALPN = hello.alpn
+		session.JA3 = ja3(hello)
+		session.JA4 = ja4(hello)
+		for _, version := range hello.versions {
+			if !isGREASE(version) {
+				session.Versions = append(session.Versions, tls.VersionName(version))
+			}
+		}
+		if len(session.Versions) == 0 {
+			session.Versions = []string{tls.VersionName(hello.version)}
+		}
+
+	case !toServer && msgType == tlsServerHello:
+		hello, ok := parseServerHello(data)
+		if !ok {
+			p.done[dirIndex(false)] = true
+			return
+		}
+		session := p.sessionAt(ts)
+		session.Version = tls.VersionName(hello.version)
+		session.CipherSuite = tls.CipherSuiteName(hello.cipherSuite)
+		session.ServerALPN = hello.alpn
+		if hello.version >= tls.VersionTLS13 {
+			// the certificate is encrypted
+			p.done[dirIndex(false)] = true
+		}
+
+	case !toServer && msgType == tlsCertificate:
+		p.done[dirIndex(false)] = true
+		cert, ok := parseCertificate(data)
+		if !ok {
+			return
+		}
+		session := p.sessionAt(ts)
+		session.CertSubject = cert.Subject.String()
+		session.CertIssuer = cert.Issuer.String()
+		session.CertSANs = cert.DNSNames
+		session.CertNotAfter = cert.NotAfter
+	}
+}
+
+// sessionAt returns the session of the connection, created at the first
+// handshake message
+func (p *tlsStreamParser) sessionAt(ts time.Time) *models.TLSSession {
+	if p.session == nil {
+		p.session = &models.TLSSession{
+			ClientIP:   p.conn.ClientIP.String(),
+			ClientPort: p.conn.ClientPort,
+			ServerIP:   p.conn.ServerIP.String(),
+			ServerPort: p.conn.ServerPort,
+			Direction:  p.conn.Direction,
+			Interface:  p.conn.Interface,
+			Timestamp:  ts,
+		}
+	}
+	return p.session
+}
+
+// report hands the session to the analyzer once, sessions without a
+// ClientHello are not reported
+func (p *tlsStreamParser) report() {
+	if p.reported || p.hello == nil {
+		return
+	}
+	p.reported = true
+	p.analyzer.tlsSession(p.conn, p.session)
+}
+
+func (p *tlsStreamParser) close() {
+	p.report()
+}
+
+// isGREASE reports whether a value is one of the reserved GREASE values
+// clients send to keep servers tolerant of unknown values, see RFC 8701
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
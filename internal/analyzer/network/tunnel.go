@@ -0,0 +1,271 @@
+package network
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
+)
+
+// DNSTunnelConfig configures the DNS tunneling detector
+type DNSTunnelConfig struct {
+	Enabled        bool
+	Window         time.Duration // window the queries of a client and base domain are scored in
+	MinQueries     int           // distinct subdomains per window before a pair is scored
+	Entropy        float64       // subdomain entropy in bits per character considered random
+	LabelLength    int           // label length considered long, DNS allows up to 63
+	ScoreThreshold float64       // score from 0 to 1 that triggers an event
+	Ignore         []string      // base domains never reported, e.g. CDNs or anti-virus lookups
+}
+
+// DefaultDNSTunnelConfig returns the settings used for unset values
+func DefaultDNSTunnelConfig() DNSTunnelConfig {
+	return DNSTunnelConfig{
+		Enabled:        true,
+		Window:         5 * time.Minute,
+		MinQueries:     50,
+		Entropy:        3.5,
+		LabelLength:    40,
+		ScoreThreshold: 0.6,
+	}
+}
+
+const (
+	// maxTunnelPairs bounds the number of client and base domain pairs tracked
+	maxTunnelPairs = 10000
+	// maxTunnelSubdomains bounds the distinct subdomains remembered per pair
+	maxTunnelSubdomains = 4096
+	// minEntropyLength is the subdomain length below which the entropy is
+	// not meaningful, short names like "www" are never random
+	minEntropyLength = 12
+)
+
+// tunnelStats holds the queries of a client for one base domain within the window
+type tunnelStats struct {
+	start       time.Time
+	last        time.Time
+	queries     int
+	subdomains  map[string]struct{}
+	random      int // queries with a high entropy subdomain
+	long        int // queries with a long label
+	records     int // TXT and NULL queries
+	maxLength   int
+	scored      int // queries long enough for their entropy to count
+	entropySum  float64
+	lastAlert   time.Time
+	alertedSize int
+}
+
+// dnsTunnelDetector scores the shape of the queries each client sends to a
+// base domain, tunnels encode their payload in long random subdomains and
+// carry the responses in TXT or NULL records
+type dnsTunnelDetector struct {
+	config DNSTunnelConfig
+	ignore map[string]struct{}
+	pairs  map[string]*tunnelStats
+	mutex  sync.Mutex
+}
+
+func newDNSTunnelDetector(config DNSTunnelConfig) *dnsTunnelDetector {
+	defaults := DefaultDNSTunnelConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.MinQueries <= 0 {
+		config.MinQueries = defaults.MinQueries
+	}
+	if config.Entropy <= 0 {
+		config.Entropy = defaults.Entropy
+	}
+	if config.LabelLength <= 0 {
+		config.LabelLength = defaults.LabelLength
+	}
+	if config.ScoreThreshold <= 0 {
+		config.ScoreThreshold = defaults.ScoreThreshold
+	}
+
+	ignore := make(map[string]struct{}, len(config.Ignore))
+	for _, domain := range config.Ignore {
+		ignore[utils.BaseDomain(domain)] = struct{}{}
+	}
+
+	return &dnsTunnelDetector{
+		config: config,
+		ignore: ignore,
+		pairs:  make(map[string]*tunnelStats),
+	}
+}
+
+// addQuery scores a query and returns an event if its client and base
+// domain look like a tunnel
+func (d *dnsTunnelDetector) addQuery(query *models.DNSQueryStats) *models.Event {
+	name := strings.ToLower(strings.TrimSuffix(query.Domain, "."))
+	base := utils.BaseDomain(name)
+	if _, ok := d.ignore[base]; ok || isReverseLookup(name) {
+		return nil
+	}
+	subdomain := strings.TrimSuffix(strings.TrimSuffix(name, base), ".")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	key := query.SrcIP + " " + base
+	stats, ok := d.pairs[key]
+	if !ok {
+		if len(d.pairs) >= maxTunnelPairs {
+			return nil
+		}
+		stats = &tunnelStats{}
+		d.pairs[key] = stats
+	}
+
+	ts := query.Timestamp
+	if stats.start.IsZero() || ts.Sub(stats.start) >= d.config.Window {
+		*stats = tunnelStats{
+			start:       ts,
+			subdomains:  make(map[string]struct{}),
+			lastAlert:   stats.lastAlert,
+			alertedSize: stats.alertedSize,
+		}
+	}
+	stats.last = ts
+	stats.queries++
+	if len(stats.subdomains) < maxTunnelSubdomains {
+		stats.subdomains[subdomain] = struct{}{}
+	}
+
+	chars := strings.ReplaceAll(subdomain, ".", "")
+	if len(chars) >= minEntropyLength {
+		entropy := shannonEntropy(chars)
+		stats.scored++
+		stats.entropySum += entropy
+		if entropy >= d.config.Entropy {
+			stats.random++
+		}
+	}
+	longest := 0
+	for _, label := range strings.Split(subdomain, ".") {
+		longest = max(longest, len(label))
+	}
+	if longest >= d.config.LabelLength {
+		stats.long++
+	}
+	stats.maxLength = max(stats.maxLength, len(subdomain))
+	if query.QueryType == "TXT" || query.QueryType == "NULL" {
+		stats.records++
+	}
+
+	return d.check(query.SrcIP, base, stats)
+}
+
+// check returns an event once per window, or again if the pair has at least
+// doubled since
+func (d *dnsTunnelDetector) check(client, base string, stats *tunnelStats) *models.Event {
+	distinct := len(stats.subdomains)
+	if distinct < d.config.MinQueries {
+		return nil
+	}
+	if stats.last.Sub(stats.lastAlert) < d.config.Window && distinct < 2*stats.alertedSize {
+		return nil
+	}
+
+	score := tunnelScore(stats)
+	if score < d.config.ScoreThreshold {
+		return nil
+	}
+	stats.lastAlert = stats.last
+	stats.alertedSize = distinct
+
+	severity := models.SeverityMedium
+	switch {
+	case score >= 0.9 || distinct >= 20*d.config.MinQueries:
+		severity = models.SeverityCritical
+	case score >= 0.75 || distinct >= 4*d.config.MinQueries:
+		severity = models.SeverityHigh
+	}
+
+	avgEntropy := 0.0
+	if stats.scored > 0 {
+		avgEntropy = stats.entropySum / float64(stats.scored)
+	}
+
+	return &models.Event{
+		Type:     models.EventDNSTunnel,
+		Severity: severity,
+		SrcIP:    client,
+		Sources:  []string{client},
+		Target:   base,
+		Score:    score,
+		Count:    stats.queries,
+		Message: fmt.Sprintf("Possible DNS tunnel from %s via %s: %d queries, %d distinct subdomains in %s",
+			client, base, stats.queries, distinct, stats.last.Sub(stats.start).Round(time.Second)),
+		Details: map[string]string{
+			"base_domain":    base,
+			"subdomains":     strconv.Itoa(distinct),
+			"random":         strconv.Itoa(stats.random),
+			"long_labels":    strconv.Itoa(stats.long),
+			"txt_null":       strconv.Itoa(stats.records),
+			"avg_entropy":    fmt.Sprintf("%.2f", avgEntropy),
+			"max_length":     strconv.Itoa(stats.maxLength),
+			"window_started": stats.start.Format(time.RFC3339),
+		},
+		Timestamp: stats.last,
+	}
+}
+
+// cleanup forgets pairs without queries for two windows
+func (d *dnsTunnelDetector) cleanup(now time.Time) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for key, stats := range d.pairs {
+		if now.Sub(stats.last) >= 2*d.config.Window {
+			delete(d.pairs, key)
+		}
+	}
+}
+
+// tunnelScore rates a pair from 0 to 1 by the share of random subdomains,
+// long labels and TXT/NULL records among its queries
+func tunnelScore(stats *tunnelStats) float64 {
+	if stats.queries == 0 {
+		return 0
+	}
+	n := float64(stats.queries)
+	random := float64(stats.random) / n
+	long := float64(stats.long) / n
+	records := float64(stats.records) / n
+	// most subdomains being distinct is what sets tunnels apart from
+	// chatty but repetitive clients
+	distinct := math.Min(1, float64(len(stats.subdomains))/n)
+	return math.Round((0.35*random+0.25*long+0.2*records+0.2*distinct)*100) / 100
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	entropy, n := 0.0, float64(len(s))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// isReverseLookup reports whether the name is a PTR query of an address
+func isReverseLookup(name string) bool {
+	return strings.HasSuffix(name, ".in-addr.arpa") || strings.HasSuffix(name, ".ip6.arpa")
+}
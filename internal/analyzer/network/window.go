@@ -0,0 +1,52 @@
+package network
+
+import "time"
+
+// slidingCounter approximates the number of events within the last window by
+// weighting the count of the previous fixed window with its remaining overlap
+type slidingCounter struct {
+	start time.Time
+	prev  float64
+	cur   float64
+}
+
+// rotate moves the counter to the fixed window containing ts
+func (c *slidingCounter) rotate(ts time.Time, window time.Duration) {
+	if c.start.IsZero() {
+		c.start = ts.Truncate(window)
+		return
+	}
+
+	elapsed := ts.Sub(c.start)
+	switch {
+	case elapsed < window:
+		return
+	case elapsed < 2*window:
+		c.prev, c.cur = c.cur, 0
+		c.start = c.start.Add(window)
+	default:
+		c.prev, c.cur = 0, 0
+		c.start = ts.Truncate(window)
+	}
+}
+
+func (c *slidingCounter) add(ts time.Time, window time.Duration, n float64) {
+	c.rotate(ts, window)
+	c.cur += n
+}
+
+func (c *slidingCounter) value(ts time.Time, window time.Duration) float64 {
+	c.rotate(ts, window)
+	overlap := 1 - float64(ts.Sub(c.start))/float64(window)
+	if overlap < 0 {
+		overlap = 0
+	} else if overlap > 1 {
+		overlap = 1
+	}
+	return c.prev*overlap + c.cur
+}
+
+// idle reports whether the counter has seen nothing for two windows
+func (c *slidingCounter) idle(ts time.Time, window time.Duration) bool {
+	return ts.Sub(c.start) >= 2*window
+}
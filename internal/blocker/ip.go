@@ -2,17 +2,25 @@ package blocker
 
 import (
 	"fmt"
+	"log"
+	"net"
 	"os/exec"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
 )
 
 // IPBlocker defines the behavior of the IP blocker
 type IPBlocker interface {
-	Block(ip string, duration time.Duration) error
+	Block(ip string, duration time.Duration, reason string) error
 	Unblock(ip string) error
 	IsBlocked(ip string) bool
 	GetBlockList() ([]string, error)
+	// GetOffenders returns the ban history of each address, or of the
+	// addresses of a network or a single address if filter is set
+	GetOffenders(filter string) ([]*models.OffenderStats, error)
 }
 
 type BlockRecord struct {
@@ -23,9 +31,12 @@ type BlockRecord struct {
 }
 
 type ipBlocker struct {
-	blocked map[string]*BlockRecord
-	mutex   sync.RWMutex
-	config  *BlockerConfig
+	blocked  map[string]*BlockRecord
+	mutex    sync.RWMutex
+	config   *BlockerConfig
+	history  *offenceHistory
+	saves    chan struct{}   // wakes saveHistory, a pending save covers later changes
+	nftSetUp map[string]bool // nftables families whose filter table and input chain exist
 }
 
 type BlockerConfig struct {
@@ -33,21 +44,29 @@ type BlockerConfig struct {
 	NFTables   bool     // Whether to use nftables
 	Whitelist  []string // Whitelist
 	DefaultTTL time.Duration
+	Escalation EscalationConfig // ban durations of repeat offenders
 }
 
 func NewIPBlocker(config *BlockerConfig) IPBlocker {
 	blocker := &ipBlocker{
-		blocked: make(map[string]*BlockRecord),
-		config:  config,
+		blocked:  make(map[string]*BlockRecord),
+		config:   config,
+		history:  newOffenceHistory(config.Escalation),
+		saves:    make(chan struct{}, 1),
+		nftSetUp: make(map[string]bool),
+	}
+	if err := blocker.history.load(); err != nil {
+		log.Printf("Failed to load offence history: %v", err)
 	}
 
 	// Start goroutine to clean up expired records
 	go blocker.cleanupExpired()
+	go blocker.saveHistory()
 
 	return blocker
 }
 
-func (b *ipBlocker) Block(ip string, duration time.Duration) error {
+func (b *ipBlocker) Block(ip string, duration time.Duration, reason string) error {
 	if b.isWhitelisted(ip) {
 		return fmt.Errorf("IP %s is whitelisted", ip)
 	}
@@ -55,23 +74,40 @@ func (b *ipBlocker) Block(ip string, duration time.Duration) error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	// If already blocked, update duration
-	if record, exists := b.blocked[ip]; exists {
-		record.Duration = duration
-		record.StartTime = time.Now()
+	// If already blocked, extend the block, it is never shortened. This is
+	// no new offence and does not escalate.
+	now := time.Now()
+	record, exists := b.blocked[ip]
+	if exists && (record.Duration == 0 || now.Before(record.StartTime.Add(record.Duration))) {
+		end := record.StartTime.Add(record.Duration)
+		if duration == 0 {
+			record.Duration = 0
+		} else if record.Duration > 0 && now.Add(duration).After(end) {
+			record.Duration = now.Add(duration).Sub(record.StartTime)
+		}
+		record.Reason = reason
 		return nil
 	}
 
-	// Add firewall rules
-	if err := b.addFirewallRules(ip); err != nil {
-		return fmt.Errorf("failed to add firewall rules: %v", err)
+	// Add firewall rules, an expired block keeps its rules until the
+	// cleanup removes it
+	if !exists {
+		if err := b.addFirewallRules(ip); err != nil {
+			return err
+		}
+	}
+
+	if b.config.Escalation.Enabled {
+		duration = b.history.escalate(ip, duration, reason, now)
+		b.requestSave()
 	}
 
 	// Record block information
 	b.blocked[ip] = &BlockRecord{
 		IP:        ip,
-		StartTime: time.Now(),
+		StartTime: now,
 		Duration:  duration,
+		Reason:    reason,
 	}
 
 	return nil
@@ -81,8 +117,8 @@ func (b *ipBlocker) Unblock(ip string) error {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	if err := b.removeIPTablesRule(ip); err != nil {
-		return fmt.Errorf("failed to remove iptables rule: %v", err)
+	if err := b.removeFirewallRules(ip); err != nil {
+		return err
 	}
 
 	delete(b.blocked, ip)
@@ -117,14 +153,53 @@ func (b *ipBlocker) GetBlockList() ([]string, error) {
 	return ips, nil
 }
 
+func (b *ipBlocker) GetOffenders(filter string) ([]*models.OffenderStats, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.history.offenders(filter, b.blocked, time.Now()), nil
+}
+
+// firewallFamily returns the iptables command and the nftables family, table
+// and address match for ip. IPv6 rules go to ip6tables and to the inet
+// table, as the ip table only sees IPv4.
+func firewallFamily(ip string) (iptables, family, match, addr string, err error) {
+	parsed := net.ParseIP(ip)
+	switch {
+	case parsed == nil:
+		return "", "", "", "", fmt.Errorf("invalid IP address %q", ip)
+	case parsed.To4() != nil:
+		return "iptables", "ip", "ip", parsed.String(), nil
+	default:
+		return "ip6tables", "inet", "ip6", parsed.String(), nil
+	}
+}
+
+// runFirewall runs a firewall command, its output is part of the error
+func runFirewall(name string, args ...string) ([]byte, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
+
 func (b *ipBlocker) addIPTablesRule(ip string) error {
-	cmd := exec.Command("iptables", "-A", "INPUT", "-s", ip, "-j", "DROP")
-	return cmd.Run()
+	iptables, _, _, addr, err := firewallFamily(ip)
+	if err != nil {
+		return err
+	}
+	_, err = runFirewall(iptables, "-A", "INPUT", "-s", addr, "-j", "DROP")
+	return err
 }
 
 func (b *ipBlocker) removeIPTablesRule(ip string) error {
-	cmd := exec.Command("iptables", "-D", "INPUT", "-s", ip, "-j", "DROP")
-	return cmd.Run()
+	iptables, _, _, addr, err := firewallFamily(ip)
+	if err != nil {
+		return err
+	}
+	_, err = runFirewall(iptables, "-D", "INPUT", "-s", addr, "-j", "DROP")
+	return err
 }
 
 func (b *ipBlocker) cleanupExpired() {
@@ -133,14 +208,44 @@ func (b *ipBlocker) cleanupExpired() {
 		b.mutex.Lock()
 		for ip, record := range b.blocked {
 			if record.Duration > 0 && time.Since(record.StartTime) > record.Duration {
-				b.removeIPTablesRule(ip)
+				if err := b.removeFirewallRules(ip); err != nil {
+					log.Printf("Failed to unblock expired IP %s: %v", ip, err)
+				}
 				delete(b.blocked, ip)
 			}
 		}
+		if b.history.prune(time.Now()) {
+			b.requestSave()
+		}
 		b.mutex.Unlock()
 	}
 }
 
+// requestSave has saveHistory write the offence history, the caller holds
+// the lock
+func (b *ipBlocker) requestSave() {
+	select {
+	case b.saves <- struct{}{}:
+	default:
+	}
+}
+
+// saveHistory writes the offence history when requested, the file is
+// written without holding the lock so bans never wait for the disk
+func (b *ipBlocker) saveHistory() {
+	for range b.saves {
+		b.mutex.RLock()
+		data, err := b.history.marshal()
+		b.mutex.RUnlock()
+		if err == nil {
+			err = b.history.write(data)
+		}
+		if err != nil {
+			log.Printf("Failed to save offence history: %v", err)
+		}
+	}
+}
+
 func (b *ipBlocker) addFirewallRules(ip string) error {
 	var errs []error
 
@@ -163,9 +268,90 @@ func (b *ipBlocker) addFirewallRules(ip string) error {
 	return nil
 }
 
+// removeFirewallRules removes the rules addFirewallRules added
+func (b *ipBlocker) removeFirewallRules(ip string) error {
+	var errs []error
+
+	if b.config.IPTables {
+		if err := b.removeIPTablesRule(ip); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if b.config.NFTables {
+		if err := b.removeNFTablesRule(ip); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to remove firewall rules: %v", errs)
+	}
+
+	return nil
+}
+
 func (b *ipBlocker) addNFTablesRule(ip string) error {
-	cmd := exec.Command("nft", "add", "rule", "ip", "filter", "input", "ip", "saddr", ip, "drop")
-	return cmd.Run()
+	_, family, match, addr, err := firewallFamily(ip)
+	if err != nil {
+		return err
+	}
+	if err := b.setUpNFTables(family); err != nil {
+		return err
+	}
+	_, err = runFirewall("nft", "add", "rule", family, "filter", "input", match, "saddr", addr, "drop")
+	return err
+}
+
+// setUpNFTables creates the filter table and input chain of family, a stock
+// host may have neither, like the inet table next to an existing ip table.
+// Adding them is a no-op if they exist, it is done once per family.
+func (b *ipBlocker) setUpNFTables(family string) error {
+	if b.nftSetUp[family] {
+		return nil
+	}
+	if _, err := runFirewall("nft", "add", "table", family, "filter"); err != nil {
+		return err
+	}
+	if _, err := runFirewall("nft", "add", "chain", family, "filter", "input",
+		"{", "type", "filter", "hook", "input", "priority", "0", ";", "}"); err != nil {
+		return err
+	}
+	b.nftSetUp[family] = true
+	return nil
+}
+
+// removeNFTablesRule deletes the drop rules of ip, nftables deletes rules by
+// their handle only
+func (b *ipBlocker) removeNFTablesRule(ip string) error {
+	_, family, match, addr, err := firewallFamily(ip)
+	if err != nil {
+		return err
+	}
+	out, err := runFirewall("nft", "-a", "list", "chain", family, "filter", "input")
+	if err != nil {
+		return err
+	}
+
+	for _, handle := range nftRuleHandles(out, match, addr) {
+		if _, err := runFirewall("nft", "delete", "rule", family, "filter", "input", "handle", handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nftRuleHandles returns the handles of the drop rules of addr in the output
+// of nft -a list chain
+func nftRuleHandles(out []byte, match, addr string) []string {
+	var handles []string
+	rule := match + " saddr " + addr + " drop # handle "
+	for _, line := range strings.Split(string(out), "\n") {
+		if handle, ok := strings.CutPrefix(strings.TrimSpace(line), rule); ok {
+			handles = append(handles, handle)
+		}
+	}
+	return handles
 }
 
 func (b *ipBlocker) isWhitelisted(ip string) bool {
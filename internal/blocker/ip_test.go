@@ -0,0 +1,58 @@
+package blocker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFirewallFamily(t *testing.T) {
+	tests := []struct {
+		ip                            string
+		iptables, family, match, addr string
+		wantErr                       bool
+	}{
+		{ip: "203.0.113.1", iptables: "iptables", family: "ip", match: "ip", addr: "203.0.113.1"},
+		{ip: "::ffff:203.0.113.1", iptables: "iptables", family: "ip", match: "ip", addr: "203.0.113.1"},
+		{ip: "2001:DB8:0::1", iptables: "ip6tables", family: "inet", match: "ip6", addr: "2001:db8::1"},
+		{ip: "203.0.113.1; reboot", wantErr: true},
+		{ip: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		iptables, family, match, addr, err := firewallFamily(tt.ip)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("firewallFamily(%q) error = %v, want error %v", tt.ip, err, tt.wantErr)
+			continue
+		}
+		if iptables != tt.iptables || family != tt.family || match != tt.match || addr != tt.addr {
+			t.Errorf("firewallFamily(%q) = %s, %s, %s, %s", tt.ip, iptables, family, match, addr)
+		}
+	}
+}
+
+func TestNFTRuleHandles(t *testing.T) {
+	out := []byte(`table inet filter {
+	chain input { # handle 1
+		type filter hook input priority filter; policy accept;
+		ip6 saddr 2001:db8::1 drop # handle 4
+		ip6 saddr 2001:db8::10 drop # handle 5
+		ip6 saddr 2001:db8::1 counter packets 3 bytes 240 drop # handle 6
+		tcp dport 22 accept # handle 7
+		ip6 saddr 2001:db8::1 drop # handle 12
+	}
+}
+`)
+	tests := []struct {
+		match, addr string
+		want        []string
+	}{
+		{"ip6", "2001:db8::1", []string{"4", "12"}},
+		{"ip6", "2001:db8::10", []string{"5"}},
+		{"ip6", "2001:db8::2", nil},
+		{"ip", "2001:db8::1", nil},
+	}
+	for _, tt := range tests {
+		if got := nftRuleHandles(out, tt.match, tt.addr); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("nftRuleHandles(%s saddr %s) = %v, want %v", tt.match, tt.addr, got, tt.want)
+		}
+	}
+}
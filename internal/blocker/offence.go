@@ -0,0 +1,234 @@
+package blocker
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/models"
+)
+
+// maxOffences bounds the offences kept per address, the oldest are dropped
+const maxOffences = 50
+
+// EscalationConfig configures the ban durations of repeat offenders. The
+// n-th ban of an address lasts Factor^(n-1) times the requested duration,
+// prior bans of other addresses of its /24 or /64 count NetworkWeight each.
+type EscalationConfig struct {
+	Enabled       bool
+	Factor        float64       // a ban lasts this much longer than the previous one
+	NetworkWeight float64       // weight of the prior bans of the network
+	MaxDuration   time.Duration // longer bans are permanent, never permanent if 0
+	HistoryTTL    time.Duration // offences are forgotten after this long
+	HistoryFile   string        // persisted offences, kept in memory only if empty
+}
+
+// DefaultEscalationConfig returns the settings used for unset values
+func DefaultEscalationConfig() EscalationConfig {
+	return EscalationConfig{
+		Enabled:       true,
+		Factor:        2,
+		NetworkWeight: 0.5,
+		MaxDuration:   7 * 24 * time.Hour,
+		HistoryTTL:    30 * 24 * time.Hour,
+		HistoryFile:   "/var/lib/safepanel/offences.json",
+	}
+}
+
+// offenceHistory holds the bans of each address within the history TTL,
+// the caller holds the blocker lock
+type offenceHistory struct {
+	config   EscalationConfig
+	offences map[string][]models.Offence // address -> bans, oldest first
+}
+
+func newOffenceHistory(config EscalationConfig) *offenceHistory {
+	defaults := DefaultEscalationConfig()
+	if config.Factor < 1 {
+		config.Factor = defaults.Factor
+	}
+	if config.NetworkWeight < 0 {
+		config.NetworkWeight = defaults.NetworkWeight
+	}
+	if config.HistoryTTL <= 0 {
+		config.HistoryTTL = defaults.HistoryTTL
+	}
+
+	return &offenceHistory{
+		config:   config,
+		offences: make(map[string][]models.Offence),
+	}
+}
+
+// offenceNetwork returns the /24 of an IPv4 or the /64 of an IPv6 address
+func offenceNetwork(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	if v4 := addr.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: addr.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// level returns the escalation level of the next ban of ip
+func (h *offenceHistory) level(ip string) int {
+	network := offenceNetwork(ip)
+	others := 0
+	for addr, offences := range h.offences {
+		if addr != ip && network != "" && offenceNetwork(addr) == network {
+			others += len(offences)
+		}
+	}
+	return len(h.offences[ip]) + int(float64(others)*h.config.NetworkWeight)
+}
+
+// escalate returns the duration of a ban of ip requested for duration and
+// records it, 0 stands for a permanent ban
+func (h *offenceHistory) escalate(ip string, duration time.Duration, reason string, now time.Time) time.Duration {
+	level := h.level(ip)
+	if duration > 0 {
+		scaled := float64(duration) * math.Pow(h.config.Factor, float64(level))
+		switch {
+		case h.config.MaxDuration > 0 && scaled > float64(h.config.MaxDuration):
+			duration = 0
+		case scaled >= math.MaxInt64:
+			duration = 0
+		default:
+			duration = time.Duration(scaled)
+		}
+	}
+
+	offences := append(h.offences[ip], models.Offence{
+		Time:     now,
+		Reason:   reason,
+		Duration: duration,
+		Level:    level,
+	})
+	if len(offences) > maxOffences {
+		offences = offences[len(offences)-maxOffences:]
+	}
+	h.offences[ip] = offences
+	return duration
+}
+
+// prune forgets the offences older than the history TTL and reports
+// whether any were
+func (h *offenceHistory) prune(now time.Time) bool {
+	pruned := false
+	for ip, offences := range h.offences {
+		i := 0
+		for i < len(offences) && now.Sub(offences[i].Time) >= h.config.HistoryTTL {
+			i++
+		}
+		switch {
+		case i == len(offences):
+			delete(h.offences, ip)
+		case i > 0:
+			h.offences[ip] = offences[i:]
+		default:
+			continue
+		}
+		pruned = true
+	}
+	return pruned
+}
+
+// offenders returns the history of each address, or of the addresses of a
+// network or a single address if filter is set
+func (h *offenceHistory) offenders(filter string, blocked map[string]*BlockRecord, now time.Time) []*models.OffenderStats {
+	networkBans := make(map[string]int)
+	for ip, offences := range h.offences {
+		networkBans[offenceNetwork(ip)] += len(offences)
+	}
+
+	var result []*models.OffenderStats
+	for ip, offences := range h.offences {
+		network := offenceNetwork(ip)
+		if filter != "" && filter != ip && filter != network {
+			continue
+		}
+		stats := &models.OffenderStats{
+			IP:          ip,
+			Network:     network,
+			Bans:        len(offences),
+			NetworkBans: networkBans[network],
+			NextLevel:   h.level(ip),
+			Offences:    append([]models.Offence(nil), offences...),
+		}
+		if record, ok := blocked[ip]; ok {
+			switch {
+			case record.Duration == 0:
+				stats.Blocked, stats.Permanent = true, true
+			case now.Before(record.StartTime.Add(record.Duration)):
+				stats.Blocked = true
+				stats.BlockedUntil = record.StartTime.Add(record.Duration)
+			}
+		}
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Bans == result[j].Bans {
+			return result[i].IP < result[j].IP
+		}
+		return result[i].Bans > result[j].Bans
+	})
+	return result
+}
+
+func (h *offenceHistory) load() error {
+	if h.config.HistoryFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(h.config.HistoryFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &h.offences)
+}
+
+// marshal encodes the history for write, it is nil if the history is kept
+// in memory only
+func (h *offenceHistory) marshal() ([]byte, error) {
+	if h.config.HistoryFile == "" {
+		return nil, nil
+	}
+	return json.Marshal(h.offences)
+}
+
+// write writes the encoded history through a rename, so a crash never
+// leaves a partial file behind. It does not touch the history and is
+// called without the blocker lock.
+func (h *offenceHistory) write(data []byte) error {
+	if h.config.HistoryFile == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(h.config.HistoryFile)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".offences-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.config.HistoryFile)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package blocker
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testHistory(config EscalationConfig) *offenceHistory {
+	config.Enabled = true
+	if config.Factor == 0 {
+		config.Factor = 2
+	}
+	return newOffenceHistory(config)
+}
+
+func TestEscalateGeometric(t *testing.T) {
+	h := testHistory(EscalationConfig{})
+
+	want := []time.Duration{time.Hour, 2 * time.Hour, 4 * time.Hour, 8 * time.Hour}
+	for i, w := range want {
+		now := testNow.Add(time.Duration(i) * time.Minute)
+		if got := h.escalate("203.0.113.1", time.Hour, "ssh", now); got != w {
+			t.Errorf("ban %d lasts %v, want %v", i+1, got, w)
+		}
+	}
+	offences := h.offences["203.0.113.1"]
+	if len(offences) != len(want) {
+		t.Fatalf("%d offences recorded, want %d", len(offences), len(want))
+	}
+	for i, offence := range offences {
+		if offence.Level != i || offence.Duration != want[i] || offence.Reason != "ssh" {
+			t.Errorf("offence %d = %+v", i, offence)
+		}
+	}
+	if got := h.escalate("203.0.113.1", 0, "manual", testNow); got != 0 {
+		t.Errorf("permanent ban escalated to %v", got)
+	}
+}
+
+func TestEscalateNetworkWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		prior  []string // addresses banned before
+		ip     string
+		weight float64
+		want   time.Duration
+	}{
+		{"no history", nil, "203.0.113.1", 0.5, time.Hour},
+		{"two bans in the /24", []string{"203.0.113.2", "203.0.113.3"}, "203.0.113.1", 0.5, 2 * time.Hour},
+		{"four bans in the /24", []string{"203.0.113.2", "203.0.113.2", "203.0.113.3", "203.0.113.4"}, "203.0.113.1", 0.5, 4 * time.Hour},
+		{"other /24", []string{"203.0.114.2", "203.0.114.3"}, "203.0.113.1", 0.5, time.Hour},
+		{"own bans count fully", []string{"203.0.113.1", "203.0.113.2"}, "203.0.113.1", 1, 4 * time.Hour},
+		{"weight 0", []string{"203.0.113.2", "203.0.113.3"}, "203.0.113.1", 0, time.Hour},
+		{"two bans in the /64", []string{"2001:db8::2", "2001:db8::3:1"}, "2001:db8::1", 0.5, 2 * time.Hour},
+		{"other /64", []string{"2001:db8:0:1::2", "2001:db8:0:1::3"}, "2001:db8::1", 0.5, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHistory(EscalationConfig{NetworkWeight: tt.weight})
+			for _, ip := range tt.prior {
+				h.escalate(ip, time.Hour, "scan", testNow)
+			}
+			if got := h.escalate(tt.ip, time.Hour, "scan", testNow); got != tt.want {
+				t.Errorf("ban lasts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscalateMaxDuration(t *testing.T) {
+	h := testHistory(EscalationConfig{MaxDuration: 6 * time.Hour})
+
+	want := []time.Duration{2 * time.Hour, 4 * time.Hour, 0, 0}
+	for i, w := range want {
+		if got := h.escalate("198.51.100.7", 2*time.Hour, "flood", testNow); got != w {
+			t.Errorf("ban %d lasts %v, want %v", i+1, got, w)
+		}
+	}
+
+	// without a maximum bans grow until the duration overflows
+	h = testHistory(EscalationConfig{})
+	for i := 0; i < maxOffences; i++ {
+		h.escalate("198.51.100.8", time.Hour, "flood", testNow)
+	}
+	if got := h.escalate("198.51.100.8", time.Hour, "flood", testNow); got != 0 {
+		t.Errorf("overflowing ban lasts %v, want permanent", got)
+	}
+	if got := len(h.offences["198.51.100.8"]); got != maxOffences {
+		t.Errorf("%d offences kept, want %d", got, maxOffences)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	h := testHistory(EscalationConfig{HistoryTTL: 24 * time.Hour})
+	h.escalate("203.0.113.1", time.Hour, "old", testNow)
+	h.escalate("203.0.113.2", time.Hour, "old", testNow)
+	h.escalate("203.0.113.2", time.Hour, "new", testNow.Add(12*time.Hour))
+
+	if h.prune(testNow.Add(23 * time.Hour)) {
+		t.Error("pruned offences within the TTL")
+	}
+	if !h.prune(testNow.Add(24 * time.Hour)) {
+		t.Fatal("kept offences past the TTL")
+	}
+	if _, ok := h.offences["203.0.113.1"]; ok {
+		t.Error("kept an address without offences")
+	}
+	offences := h.offences["203.0.113.2"]
+	if len(offences) != 1 || offences[0].Reason != "new" {
+		t.Errorf("offences of 203.0.113.2 = %+v, want the new one", offences)
+	}
+	// the pruned ban no longer escalates
+	if got := h.escalate("203.0.113.2", time.Hour, "again", testNow.Add(24*time.Hour)); got != 2*time.Hour {
+		t.Errorf("ban after pruning lasts %v, want 2h", got)
+	}
+}
+
+func TestHistoryPersistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "offences.json")
+	h := testHistory(EscalationConfig{HistoryFile: file})
+	h.escalate("203.0.113.1", time.Hour, "ssh", testNow)
+	h.escalate("203.0.113.1", time.Hour, "ssh", testNow.Add(time.Hour))
+	h.escalate("2001:db8::1", 0, "manual", testNow)
+
+	data, err := h.marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.write(data); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(file), ".offences-*"))
+	if len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	loaded := testHistory(EscalationConfig{HistoryFile: file})
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.offences, h.offences) {
+		t.Errorf("loaded %+v, want %+v", loaded.offences, h.offences)
+	}
+	if got := loaded.escalate("203.0.113.1", time.Hour, "ssh", testNow); got != 4*time.Hour {
+		t.Errorf("ban after loading lasts %v, want 4h", got)
+	}
+
+	missing := testHistory(EscalationConfig{HistoryFile: filepath.Join(t.TempDir(), "offences.json")})
+	if err := missing.load(); err != nil || len(missing.offences) != 0 {
+		t.Errorf("loading a missing file: %v, %d offences", err, len(missing.offences))
+	}
+
+	memory := testHistory(EscalationConfig{})
+	memory.escalate("203.0.113.1", time.Hour, "ssh", testNow)
+	if data, err := memory.marshal(); data != nil || err != nil {
+		t.Errorf("in-memory history marshalled to %q, %v", data, err)
+	}
+}
+
+func TestBlockExtendDoesNotEscalate(t *testing.T) {
+	b := &ipBlocker{
+		blocked: make(map[string]*BlockRecord),
+		config:  &BlockerConfig{Escalation: EscalationConfig{Enabled: true}},
+		history: testHistory(EscalationConfig{}),
+		saves:   make(chan struct{}, 1),
+	}
+
+	if err := b.Block("203.0.113.1", time.Hour, "ssh"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Block("203.0.113.1", 3*time.Hour, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(b.history.offences["203.0.113.1"]); got != 1 {
+		t.Errorf("%d offences recorded, want 1", got)
+	}
+	record := b.blocked["203.0.113.1"]
+	if record.Duration < 3*time.Hour || record.Duration > 3*time.Hour+time.Minute || record.Reason != "web" {
+		t.Errorf("extended ban = %+v, want 3h for web", record)
+	}
+
+	// a shorter ban never shortens the active one
+	if err := b.Block("203.0.113.1", time.Minute, "scan"); err != nil {
+		t.Fatal(err)
+	}
+	if record.Duration < 3*time.Hour {
+		t.Errorf("ban shortened to %v", record.Duration)
+	}
+	if err := b.Block("203.0.113.1", 0, "manual"); err != nil {
+		t.Fatal(err)
+	}
+	if record.Duration != 0 || len(b.history.offences["203.0.113.1"]) != 1 {
+		t.Errorf("permanent extension = %+v, %d offences", record, len(b.history.offences["203.0.113.1"]))
+	}
+}
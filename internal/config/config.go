@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
@@ -18,31 +20,265 @@ type Config struct {
 type AnalyzerConfig struct {
 	Network struct {
 		IP struct {
-			Enabled     bool   `mapstructure:"enabled"`
-			Interface   string `mapstructure:"interface"`
-			BufferSize  int32  `mapstructure:"buffer_size"`
-			Promiscuous bool   `mapstructure:"promiscuous"`
+			Enabled     bool              `mapstructure:"enabled"`
+			Interface   string            `mapstructure:"interface"`
+			SnapLen     int32             `mapstructure:"snaplen"`
+			Promiscuous *bool             `mapstructure:"promiscuous"`
+			Filter      string            `mapstructure:"filter"`
+			Interfaces  []InterfaceConfig `mapstructure:"interfaces"`
+			// Deprecated: BufferSize is the old name of SnapLen
+			BufferSize int32 `mapstructure:"buffer_size"`
 		} `mapstructure:"ip"`
 		DNS struct {
 			Enabled bool `mapstructure:"enabled"`
 			Port    int  `mapstructure:"port"`
 		} `mapstructure:"dns"`
+		TLS struct {
+			Ports []uint16 `mapstructure:"ports"`
+		} `mapstructure:"tls"`
+		HTTP      HTTPConfig      `mapstructure:"http"`
+		Database  DatabaseConfig  `mapstructure:"database"`
+		SSH       SSHConfig       `mapstructure:"ssh"`
+		SYNFlood  SYNFloodConfig  `mapstructure:"syn_flood"`
+		PortScan  PortScanConfig  `mapstructure:"port_scan"`
+		DNSTunnel DNSTunnelConfig `mapstructure:"dns_tunnel"`
+		DGA       DGAConfig       `mapstructure:"dga"`
 	} `mapstructure:"network"`
+	WebAttack WebAttackConfig `mapstructure:"web_attack"`
+	Log       struct {
+		SSH     SSHLogConfig      `mapstructure:"ssh"`
+		Web     WebLogConfig      `mapstructure:"web"`
+		Journal JournalLogConfig  `mapstructure:"journal"`
+		Syslog  SyslogLogConfig   `mapstructure:"syslog"`
+		Custom  []CustomLogConfig `mapstructure:"custom"`
+	} `mapstructure:"log"`
+}
+
+// SYNFloodConfig configures the SYN flood detector, unset values use the
+// detector defaults
+type SYNFloodConfig struct {
+	Enabled       *bool         `mapstructure:"enabled"`
+	Window        time.Duration `mapstructure:"window"`
+	MinSYNs       int           `mapstructure:"min_syns"`
+	PortMinSYNs   int           `mapstructure:"port_min_syns"`
+	HalfOpenRatio float64       `mapstructure:"half_open_ratio"`
+	AutoBlock     bool          `mapstructure:"auto_block"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// InterfaceConfig configures capture on one interface. Unset values fall
+// back to the snaplen/promiscuous settings of the ip section.
+type InterfaceConfig struct {
+	Name        string `mapstructure:"name"`
+	SnapLen     int32  `mapstructure:"snaplen"`
+	Promiscuous *bool  `mapstructure:"promiscuous"`
+	Filter      string `mapstructure:"filter"`
+	// Deprecated: BufferSize is the old name of SnapLen
+	BufferSize int32 `mapstructure:"buffer_size"`
+}
+
+// PortScanConfig configures the port scan detector, unset values use the
+// detector defaults
+type PortScanConfig struct {
+	Enabled         *bool         `mapstructure:"enabled"`
+	Interval        time.Duration `mapstructure:"interval"`
+	VerticalPorts   int           `mapstructure:"vertical_ports"`
+	HorizontalHosts int           `mapstructure:"horizontal_hosts"`
+	SlowTargets     int           `mapstructure:"slow_targets"`
+	SlowHorizon     time.Duration `mapstructure:"slow_horizon"`
+	IncludeOutbound bool          `mapstructure:"include_outbound"`
+	AutoBlock       bool          `mapstructure:"auto_block"`
+	BlockDuration   time.Duration `mapstructure:"block_duration"`
+}
+
+// DNSTunnelConfig configures the DNS tunneling detector, unset values use
+// the detector defaults
+type DNSTunnelConfig struct {
+	Enabled        *bool         `mapstructure:"enabled"`
+	Window         time.Duration `mapstructure:"window"`
+	MinQueries     int           `mapstructure:"min_queries"`
+	Entropy        float64       `mapstructure:"entropy"`
+	LabelLength    int           `mapstructure:"label_length"`
+	ScoreThreshold float64       `mapstructure:"score_threshold"`
+	Ignore         []string      `mapstructure:"ignore"`
+}
+
+// DGAConfig configures the classifier for generated domains, unset values
+// use the classifier defaults
+type DGAConfig struct {
+	Enabled       *bool         `mapstructure:"enabled"`
+	Threshold     float64       `mapstructure:"threshold"`
+	Window        time.Duration `mapstructure:"window"`
+	NXDomainBurst int           `mapstructure:"nxdomain_burst"`
+	MinSuspicious float64       `mapstructure:"min_suspicious"`
+	Ignore        []string      `mapstructure:"ignore"`
+}
+
+// HTTPConfig configures plaintext HTTP parsing and the probe detector,
+// unset values use the detector defaults
+type HTTPConfig struct {
+	Ports         []uint16      `mapstructure:"ports"`
+	ProbeEnabled  *bool         `mapstructure:"probe_enabled"`
+	ProbeWindow   time.Duration `mapstructure:"probe_window"`
+	MinProbes     int           `mapstructure:"min_probes"`
+	ProbePaths    []string      `mapstructure:"probe_paths"`
+	AutoBlock     bool          `mapstructure:"auto_block"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// DatabaseConfig configures MySQL, PostgreSQL and Redis parsing and the
+// brute force and exploitation detector, unset values use the detector defaults
+type DatabaseConfig struct {
+	MySQLPorts       []uint16      `mapstructure:"mysql_ports"`
+	PostgresPorts    []uint16      `mapstructure:"postgres_ports"`
+	RedisPorts       []uint16      `mapstructure:"redis_ports"`
+	DetectionEnabled *bool         `mapstructure:"detection_enabled"`
+	Window           time.Duration `mapstructure:"window"`
+	MaxFailedLogins  int           `mapstructure:"max_failed_logins"`
+	ExploitPatterns  []string      `mapstructure:"exploit_patterns"`
+	AutoBlock        bool          `mapstructure:"auto_block"`
+	BlockDuration    time.Duration `mapstructure:"block_duration"`
+}
+
+// SSHConfig configures SSH banner parsing and the brute force detector,
+// unset values use the detector defaults
+type SSHConfig struct {
+	Ports             []uint16      `mapstructure:"ports"`
+	BruteForceEnabled *bool         `mapstructure:"brute_force_enabled"`
+	Window            time.Duration `mapstructure:"window"`
+	MinAttempts       int           `mapstructure:"min_attempts"`
+	MaxDuration       time.Duration `mapstructure:"max_duration"`
+	MaxBytes          int64         `mapstructure:"max_bytes"`
+	AutoBlock         bool          `mapstructure:"auto_block"`
+	BlockDuration     time.Duration `mapstructure:"block_duration"`
+}
+
+// WebAttackConfig configures the signature rules web requests are matched
+// against, unset values use the detector defaults
+type WebAttackConfig struct {
+	RulesPath     string        `mapstructure:"rules_path"`
+	Window        time.Duration `mapstructure:"window"`
+	MinScore      float64       `mapstructure:"min_score"`
+	AutoBlock     bool          `mapstructure:"auto_block"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// SSHLogConfig configures the analyzer of the sshd authentication log,
+// unset values use the analyzer defaults
+type SSHLogConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Paths         []string      `mapstructure:"paths"`
+	FromStart     bool          `mapstructure:"from_start"`
+	Window        time.Duration `mapstructure:"window"`
+	MaxFailures   int           `mapstructure:"max_failures"`
+	AutoBlock     bool          `mapstructure:"auto_block"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// WebLogConfig configures the analyzer of web server access logs, unset
+// values use the analyzer defaults
+type WebLogConfig struct {
+	Enabled       bool           `mapstructure:"enabled"`
+	Logs          []WebLogSource `mapstructure:"logs"`
+	FromStart     bool           `mapstructure:"from_start"`
+	Window        time.Duration  `mapstructure:"window"`
+	MaxRequests   int            `mapstructure:"max_requests"`
+	MinErrors     int            `mapstructure:"min_errors"`
+	SpikeFactor   float64        `mapstructure:"spike_factor"`
+	AutoBlock     bool           `mapstructure:"auto_block"`
+	BlockDuration time.Duration  `mapstructure:"block_duration"`
+}
+
+// JournalLogConfig configures the reader feeding systemd journal messages to
+// the log analyzers, unset values use the reader defaults
+type JournalLogConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	Command     []string `mapstructure:"command"`
+	Directory   string   `mapstructure:"directory"`
+	Files       []string `mapstructure:"files"`
+	Identifiers []string `mapstructure:"identifiers"`
+	Kernel      *bool    `mapstructure:"kernel"`
+	CursorFile  string   `mapstructure:"cursor_file"`
+	FromStart   bool     `mapstructure:"from_start"`
+}
+
+// SyslogLogConfig configures the receiver feeding syslog messages of other
+// machines to the log analyzers, unset values use the receiver defaults
+type SyslogLogConfig struct {
+	Enabled        bool     `mapstructure:"enabled"`
+	UDP            *string  `mapstructure:"udp"`
+	TCP            *string  `mapstructure:"tcp"`
+	Allow          []string `mapstructure:"allow"`
+	MaxConnections int      `mapstructure:"max_connections"`
+}
+
+// CustomLogConfig declares a log source whose lines matching one of the
+// patterns are counted per offending address, unset values use the analyzer
+// defaults
+type CustomLogConfig struct {
+	Name          string        `mapstructure:"name"`
+	Path          string        `mapstructure:"path"`
+	Program       string        `mapstructure:"program"`
+	FromStart     bool          `mapstructure:"from_start"`
+	Patterns      []string      `mapstructure:"patterns"`
+	IPField       string        `mapstructure:"ip_field"`
+	Window        time.Duration `mapstructure:"window"`
+	MaxMatches    int           `mapstructure:"max_matches"`
+	Severity      string        `mapstructure:"severity"`
+	AutoBlock     bool          `mapstructure:"auto_block"`
+	BlockDuration time.Duration `mapstructure:"block_duration"`
+}
+
+// WebLogSource is an access log and its format, logs received as messages
+// set the program they are logged under instead of the path
+type WebLogSource struct {
+	Name    string `mapstructure:"name"`
+	Path    string `mapstructure:"path"`
+	Program string `mapstructure:"program"`
+	Format  string `mapstructure:"format"`
 }
 
 type BlockerConfig struct {
 	IP struct {
-		Enabled         bool     `mapstructure:"enabled"`
-		DefaultDuration string   `mapstructure:"default_duration"`
-		Whitelist       []string `mapstructure:"whitelist"`
-		IPTables        bool     `mapstructure:"iptables"`
-		NFTables        bool     `mapstructure:"nftables"`
+		Enabled         bool             `mapstructure:"enabled"`
+		DefaultDuration string           `mapstructure:"default_duration"`
+		Whitelist       []string         `mapstructure:"whitelist"`
+		IPTables        bool             `mapstructure:"iptables"`
+		NFTables        bool             `mapstructure:"nftables"`
+		Escalation      EscalationConfig `mapstructure:"escalation"`
 	} `mapstructure:"ip"`
+	Jails []JailConfig `mapstructure:"jails"`
+}
+
+// EscalationConfig configures the ban durations of repeat offenders, unset
+// values use the blocker defaults
+type EscalationConfig struct {
+	Enabled       *bool         `mapstructure:"enabled"`
+	Factor        float64       `mapstructure:"factor"`
+	NetworkWeight *float64      `mapstructure:"network_weight"`
+	MaxDuration   time.Duration `mapstructure:"max_duration"`
+	HistoryTTL    time.Duration `mapstructure:"history_ttl"`
+	HistoryFile   *string       `mapstructure:"history_file"`
+}
+
+// JailConfig configures a fail2ban style jail banning the sources of
+// matching analyzer events, unset values use the jail defaults
+type JailConfig struct {
+	Name        string            `mapstructure:"name"`
+	Events      []string          `mapstructure:"events"`
+	MinSeverity string            `mapstructure:"min_severity"`
+	Details     map[string]string `mapstructure:"details"`
+	MaxRetry    int               `mapstructure:"maxretry"`
+	FindTime    time.Duration     `mapstructure:"findtime"`
+	BanTime     time.Duration     `mapstructure:"bantime"`
+	Whitelist   []string          `mapstructure:"whitelist"`
 }
 
 type CheckerConfig struct {
 	IPDBPath string `mapstructure:"ipdb_path"`
 	MMDBPath string `mapstructure:"mmdb_path"`
+	// TLSBlocklistPath lists JA3/JA4 fingerprints of malicious TLS clients
+	TLSBlocklistPath string `mapstructure:"tls_blocklist_path"`
 }
 
 type StorageConfig struct {
@@ -62,15 +62,21 @@ func (c *Client) reconnectMonitor() {
 	}
 }
 
-func (c *Client) GetStats() (*Stats, error) {
+// GetStats returns the given sections of the stats, see SectionConnections
+// and the following, or all of them if no section is given
+func (c *Client) GetStats(sections ...string) (*Stats, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	cmd := struct {
-		Command string `json:"command"`
+		Command string         `json:"command"`
+		Params  map[string]any `json:"params,omitempty"`
 	}{
 		Command: "GET_STATS",
 	}
+	if len(sections) > 0 {
+		cmd.Params = map[string]any{"sections": sections}
+	}
 
 	if err := json.NewEncoder(c.conn).Encode(cmd); err != nil {
 		select {
@@ -108,6 +114,63 @@ func (c *Client) GetStats() (*Stats, error) {
 	if response.Stats.DNSQueries == nil {
 		response.Stats.DNSQueries = []*models.DNSQueryStats{}
 	}
+	if response.Stats.DNSDomains == nil {
+		response.Stats.DNSDomains = []*models.DNSRcodeStats{}
+	}
+	if response.Stats.DNSClients == nil {
+		response.Stats.DNSClients = []*models.DNSRcodeStats{}
+	}
+	if response.Stats.Flows == nil {
+		response.Stats.Flows = []*models.FlowRecord{}
+	}
+	if response.Stats.HTTPRequests == nil {
+		response.Stats.HTTPRequests = []*models.HTTPRequest{}
+	}
+	if response.Stats.TLSSessions == nil {
+		response.Stats.TLSSessions = []*models.TLSSession{}
+	}
+	if response.Stats.TLSHosts == nil {
+		response.Stats.TLSHosts = []*models.TLSHostStats{}
+	}
+	if response.Stats.TLSFingerprints == nil {
+		response.Stats.TLSFingerprints = []*models.TLSFingerprintStats{}
+	}
+	if response.Stats.DBRequests == nil {
+		response.Stats.DBRequests = []*models.DBRequest{}
+	}
+	if response.Stats.DBServers == nil {
+		response.Stats.DBServers = []*models.DBServerStats{}
+	}
+	if response.Stats.SSHAuthEvents == nil {
+		response.Stats.SSHAuthEvents = []*models.SSHAuthEvent{}
+	}
+	if response.Stats.SSHAuthSources == nil {
+		response.Stats.SSHAuthSources = []*models.SSHAuthSourceStats{}
+	}
+	if response.Stats.SSHAuthUsers == nil {
+		response.Stats.SSHAuthUsers = []*models.SSHAuthUserStats{}
+	}
+	if response.Stats.WebRequests == nil {
+		response.Stats.WebRequests = []*models.WebLogRequest{}
+	}
+	if response.Stats.WebClients == nil {
+		response.Stats.WebClients = []*models.WebClientStats{}
+	}
+	if response.Stats.WebLogs == nil {
+		response.Stats.WebLogs = []*models.WebLogStats{}
+	}
+	if response.Stats.WebAttacks == nil {
+		response.Stats.WebAttacks = []*models.WebAttackStats{}
+	}
+	if response.Stats.LogMatches == nil {
+		response.Stats.LogMatches = []*models.LogMatch{}
+	}
+	if response.Stats.LogSources == nil {
+		response.Stats.LogSources = []*models.LogMatchStats{}
+	}
+	if response.Stats.Jails == nil {
+		response.Stats.Jails = []*models.JailStats{}
+	}
 	if response.Stats.IPStats == nil {
 		response.Stats.IPStats = []*models.ConnectionWindowStats{}
 	}
@@ -144,6 +207,112 @@ func (c *Client) GetBlackStats() ([]*models.IPCheckResult, error) {
 	return response.Stats, nil
 }
 
+// GetCaptureFilters returns the effective capture filter per interface
+func (c *Client) GetCaptureFilters() (map[string]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cmd := struct {
+		Command string `json:"command"`
+	}{
+		Command: "GET_CAPTURE_FILTERS",
+	}
+
+	if err := json.NewEncoder(c.conn).Encode(cmd); err != nil {
+		return nil, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	var response struct {
+		Error   string            `json:"error,omitempty"`
+		Filters map[string]string `json:"stats,omitempty"`
+	}
+
+	if err := json.NewDecoder(c.conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("server error: %s", response.Error)
+	}
+
+	return response.Filters, nil
+}
+
+// GetEvents returns the recorded events of the given type, or all events if
+// eventType is empty
+func (c *Client) GetEvents(eventType models.EventType) ([]*models.Event, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cmd := struct {
+		Command string         `json:"command"`
+		Params  map[string]any `json:"params,omitempty"`
+	}{
+		Command: "GET_EVENTS",
+	}
+	if eventType != "" {
+		cmd.Params = map[string]any{"type": string(eventType)}
+	}
+
+	if err := json.NewEncoder(c.conn).Encode(cmd); err != nil {
+		return nil, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	var response struct {
+		Error  string          `json:"error,omitempty"`
+		Events []*models.Event `json:"stats,omitempty"`
+	}
+
+	if err := json.NewDecoder(c.conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("server error: %s", response.Error)
+	}
+
+	return response.Events, nil
+}
+
+// GetOffenders returns the ban history of each address, or of the addresses
+// of a network ("192.0.2.0/24") or a single address if filter is set
+func (c *Client) GetOffenders(filter string) ([]*models.OffenderStats, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	cmd := struct {
+		Command string         `json:"command"`
+		Params  map[string]any `json:"params,omitempty"`
+	}{
+		Command: "GET_OFFENDERS",
+	}
+	if filter != "" {
+		cmd.Params = map[string]any{"ip": filter}
+	}
+
+	if err := json.NewEncoder(c.conn).Encode(cmd); err != nil {
+		return nil, fmt.Errorf("failed to send command: %v", err)
+	}
+
+	var response struct {
+		Error     string                  `json:"error,omitempty"`
+		Offenders []*models.OffenderStats `json:"stats,omitempty"`
+	}
+
+	if err := json.NewDecoder(c.conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if response.Error != "" {
+		return nil, fmt.Errorf("server error: %s", response.Error)
+	}
+	if response.Offenders == nil {
+		response.Offenders = []*models.OffenderStats{}
+	}
+
+	return response.Offenders, nil
+}
+
 func (c *Client) GetBlockedIPs() ([]string, error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -8,6 +8,8 @@ import (
 	"os"
 	"sync"
 
+	"github.com/samber/lo"
+
 	"github.com/safepointcloud/safepanel/internal/analyzer/network"
 	"github.com/safepointcloud/safepanel/pkg/models"
 )
@@ -21,10 +23,50 @@ type StatsServer struct {
 }
 
 type Stats struct {
-	Connections []*models.NewConnectionStats
-	DNSQueries  []*models.DNSQueryStats
-	IPStats     []*models.ConnectionWindowStats
-	PortStats   []*models.PortWindowStats
+	Connections     []*models.NewConnectionStats
+	DNSQueries      []*models.DNSQueryStats
+	DNSDomains      []*models.DNSRcodeStats
+	DNSClients      []*models.DNSRcodeStats
+	Flows           []*models.FlowRecord
+	HTTPRequests    []*models.HTTPRequest
+	TLSSessions     []*models.TLSSession
+	TLSHosts        []*models.TLSHostStats
+	TLSFingerprints []*models.TLSFingerprintStats
+	DBRequests      []*models.DBRequest
+	DBServers       []*models.DBServerStats
+	SSHAuthEvents   []*models.SSHAuthEvent
+	SSHAuthSources  []*models.SSHAuthSourceStats
+	SSHAuthUsers    []*models.SSHAuthUserStats
+	WebRequests     []*models.WebLogRequest
+	WebClients      []*models.WebClientStats
+	WebLogs         []*models.WebLogStats
+	WebAttacks      []*models.WebAttackStats
+	LogMatches      []*models.LogMatch
+	LogSources      []*models.LogMatchStats
+	Jails           []*models.JailStats
+	IPStats         []*models.ConnectionWindowStats
+	PortStats       []*models.PortWindowStats
+}
+
+// Sections of Stats, GET_STATS only fills the sections listed in its
+// "sections" param, or all of them if it has none
+const (
+	SectionConnections = "connections" // Connections
+	SectionDNS         = "dns"         // DNSQueries, DNSDomains and DNSClients
+	SectionFlows       = "flows"       // Flows
+	SectionHTTP        = "http"        // HTTPRequests
+	SectionTLS         = "tls"         // TLSSessions, TLSHosts and TLSFingerprints
+	SectionDB          = "db"          // DBRequests and DBServers
+	SectionSSH         = "ssh"         // SSHAuthEvents, SSHAuthSources and SSHAuthUsers
+	SectionWeb         = "web"         // WebRequests, WebClients, WebLogs and WebAttacks
+	SectionLogs        = "logs"        // LogMatches and LogSources
+	SectionJails       = "jails"       // Jails
+	SectionWindows     = "windows"     // IPStats and PortStats
+)
+
+var statsSections = []string{
+	SectionConnections, SectionDNS, SectionFlows, SectionHTTP, SectionTLS, SectionDB,
+	SectionSSH, SectionWeb, SectionLogs, SectionJails, SectionWindows,
 }
 
 func NewStatsServer(manager *network.AnalyzerManager) *StatsServer {
@@ -104,7 +146,8 @@ func (s *StatsServer) handleConnection(conn net.Conn) {
 
 		switch cmd.Command {
 		case "GET_STATS":
-			stats, err := s.handleGetStats()
+			sections, _ := cmd.Params["sections"].([]any)
+			stats, err := s.handleGetStats(sections)
 			if err != nil {
 				response.Error = err.Error()
 			} else {
@@ -119,6 +162,24 @@ func (s *StatsServer) handleConnection(conn net.Conn) {
 			} else {
 				response.Stats = stats
 			}
+		case "GET_CAPTURE_FILTERS":
+			response.Stats = s.manager.GetCaptureFilters()
+		case "GET_EVENTS":
+			eventType, _ := cmd.Params["type"].(string)
+			events, err := s.handleGetEvents(models.EventType(eventType))
+			if err != nil {
+				response.Error = err.Error()
+			} else {
+				response.Stats = events
+			}
+		case "GET_OFFENDERS":
+			ip, _ := cmd.Params["ip"].(string)
+			offenders, err := s.handleGetOffenders(ip)
+			if err != nil {
+				response.Error = err.Error()
+			} else {
+				response.Stats = offenders
+			}
 		case "BLOCK_IP":
 			response.Error = fmt.Sprintf("unknown command: %s", cmd.Command)
 		case "UNBLOCK_IP":
@@ -134,38 +195,195 @@ func (s *StatsServer) handleConnection(conn net.Conn) {
 	}
 }
 
-func (s *StatsServer) handleGetStats() (*Stats, error) {
+// handleGetStats returns the given sections of the stats, or all of them if
+// sections is empty
+func (s *StatsServer) handleGetStats(sections []any) (*Stats, error) {
+	for _, section := range sections {
+		if name, ok := section.(string); !ok || !lo.Contains(statsSections, name) {
+			return nil, fmt.Errorf("unknown stats section: %v", section)
+		}
+	}
+	want := func(section string) bool {
+		return len(sections) == 0 || lo.Contains(sections, any(section))
+	}
+
 	stats := &Stats{}
 
-	// Get the latest 5 minutes of connections
-	connections, err := s.manager.GetNewConnections()
-	if err != nil {
-		log.Printf("Error getting connections: %v", err)
-		// Don't return an overall error just because one data fetch failed
-		// Continue fetching other data
+	if want(SectionConnections) {
+		// Get the latest 5 minutes of connections
+		connections, err := s.manager.GetNewConnections()
+		if err != nil {
+			log.Printf("Error getting connections: %v", err)
+			// Don't return an overall error just because one data fetch failed
+			// Continue fetching other data
+		}
+		stats.Connections = connections
 	}
-	stats.Connections = connections
 
-	// Get the latest 5 minutes of DNS queries
-	dnsQueries, err := s.manager.GetDNSQueries()
-	if err != nil {
-		log.Printf("Error getting DNS queries: %v", err)
+	if want(SectionDNS) {
+		// Get the latest 5 minutes of DNS queries
+		dnsQueries, err := s.manager.GetDNSQueries()
+		if err != nil {
+			log.Printf("Error getting DNS queries: %v", err)
+		}
+		stats.DNSQueries = dnsQueries
+
+		// Get DNS response codes per domain and client
+		dnsDomains, err := s.manager.GetDNSDomainStats()
+		if err != nil {
+			log.Printf("Error getting DNS domain stats: %v", err)
+		}
+		stats.DNSDomains = dnsDomains
+
+		dnsClients, err := s.manager.GetDNSClientStats()
+		if err != nil {
+			log.Printf("Error getting DNS client stats: %v", err)
+		}
+		stats.DNSClients = dnsClients
 	}
-	stats.DNSQueries = dnsQueries
 
-	// Get IP stats
-	ipStats, err := s.manager.GetConnectionWindowStats()
-	if err != nil {
-		log.Printf("Error getting IP stats: %v", err)
+	if want(SectionFlows) {
+		// Get closed flows
+		flows, err := s.manager.GetFlows()
+		if err != nil {
+			log.Printf("Error getting flows: %v", err)
+		}
+		stats.Flows = flows
 	}
-	stats.IPStats = ipStats
 
-	// Get port stats
-	portStats, err := s.manager.GetPortWindowStats()
-	if err != nil {
-		log.Printf("Error getting port stats: %v", err)
+	if want(SectionHTTP) {
+		// Get plaintext HTTP requests
+		httpRequests, err := s.manager.GetHTTPRequests()
+		if err != nil {
+			log.Printf("Error getting HTTP requests: %v", err)
+		}
+		stats.HTTPRequests = httpRequests
+	}
+
+	if want(SectionTLS) {
+		// Get TLS handshakes and the server names contacted per client
+		tlsSessions, err := s.manager.GetTLSSessions()
+		if err != nil {
+			log.Printf("Error getting TLS sessions: %v", err)
+		}
+		stats.TLSSessions = tlsSessions
+
+		tlsHosts, err := s.manager.GetTLSHostStats()
+		if err != nil {
+			log.Printf("Error getting TLS host stats: %v", err)
+		}
+		stats.TLSHosts = tlsHosts
+
+		tlsFingerprints, err := s.manager.GetTLSFingerprintStats()
+		if err != nil {
+			log.Printf("Error getting TLS fingerprint stats: %v", err)
+		}
+		stats.TLSFingerprints = tlsFingerprints
+	}
+
+	if want(SectionDB) {
+		// Get database logins and commands and the counts per server
+		dbRequests, err := s.manager.GetDBRequests()
+		if err != nil {
+			log.Printf("Error getting database requests: %v", err)
+		}
+		stats.DBRequests = dbRequests
+
+		dbServers, err := s.manager.GetDBServerStats()
+		if err != nil {
+			log.Printf("Error getting database server stats: %v", err)
+		}
+		stats.DBServers = dbServers
+	}
+
+	if want(SectionSSH) {
+		// Get SSH authentication attempts and the counts per source and user
+		sshAuthEvents, err := s.manager.GetSSHAuthEvents()
+		if err != nil {
+			log.Printf("Error getting SSH authentication events: %v", err)
+		}
+		stats.SSHAuthEvents = sshAuthEvents
+
+		sshAuthSources, err := s.manager.GetSSHAuthSourceStats()
+		if err != nil {
+			log.Printf("Error getting SSH authentication source stats: %v", err)
+		}
+		stats.SSHAuthSources = sshAuthSources
+
+		sshAuthUsers, err := s.manager.GetSSHAuthUserStats()
+		if err != nil {
+			log.Printf("Error getting SSH authentication user stats: %v", err)
+		}
+		stats.SSHAuthUsers = sshAuthUsers
+	}
+
+	if want(SectionWeb) {
+		// Get access log requests and the counts per client and log
+		webRequests, err := s.manager.GetWebLogRequests()
+		if err != nil {
+			log.Printf("Error getting web log requests: %v", err)
+		}
+		stats.WebRequests = webRequests
+
+		webClients, err := s.manager.GetWebClientStats()
+		if err != nil {
+			log.Printf("Error getting web client stats: %v", err)
+		}
+		stats.WebClients = webClients
+
+		webLogs, err := s.manager.GetWebLogStats()
+		if err != nil {
+			log.Printf("Error getting web log stats: %v", err)
+		}
+		stats.WebLogs = webLogs
+
+		// Get the signature matches of web requests per client
+		webAttacks, err := s.manager.GetWebAttackStats()
+		if err != nil {
+			log.Printf("Error getting web attack stats: %v", err)
+		}
+		stats.WebAttacks = webAttacks
+	}
+
+	if want(SectionLogs) {
+		// Get the matches of the user-defined log sources
+		logMatches, err := s.manager.GetLogMatches()
+		if err != nil {
+			log.Printf("Error getting log matches: %v", err)
+		}
+		stats.LogMatches = logMatches
+
+		logSources, err := s.manager.GetLogMatchStats()
+		if err != nil {
+			log.Printf("Error getting log match stats: %v", err)
+		}
+		stats.LogSources = logSources
+	}
+
+	if want(SectionJails) {
+		// Get the state of the jails
+		jails, err := s.manager.GetJailStats()
+		if err != nil {
+			log.Printf("Error getting jail stats: %v", err)
+		}
+		stats.Jails = jails
+	}
+
+	if want(SectionWindows) {
+		// Get IP stats
+		ipStats, err := s.manager.GetConnectionWindowStats()
+		if err != nil {
+			log.Printf("Error getting IP stats: %v", err)
+		}
+		stats.IPStats = ipStats
+
+		// Get port stats
+		portStats, err := s.manager.GetPortWindowStats()
+		if err != nil {
+			log.Printf("Error getting port stats: %v", err)
+		}
+		stats.PortStats = portStats
 	}
-	stats.PortStats = portStats
 
 	// Ensure return empty slices instead of nil
 	if stats.Connections == nil {
@@ -174,6 +392,63 @@ func (s *StatsServer) handleGetStats() (*Stats, error) {
 	if stats.DNSQueries == nil {
 		stats.DNSQueries = []*models.DNSQueryStats{}
 	}
+	if stats.DNSDomains == nil {
+		stats.DNSDomains = []*models.DNSRcodeStats{}
+	}
+	if stats.DNSClients == nil {
+		stats.DNSClients = []*models.DNSRcodeStats{}
+	}
+	if stats.Flows == nil {
+		stats.Flows = []*models.FlowRecord{}
+	}
+	if stats.HTTPRequests == nil {
+		stats.HTTPRequests = []*models.HTTPRequest{}
+	}
+	if stats.TLSSessions == nil {
+		stats.TLSSessions = []*models.TLSSession{}
+	}
+	if stats.TLSHosts == nil {
+		stats.TLSHosts = []*models.TLSHostStats{}
+	}
+	if stats.TLSFingerprints == nil {
+		stats.TLSFingerprints = []*models.TLSFingerprintStats{}
+	}
+	if stats.DBRequests == nil {
+		stats.DBRequests = []*models.DBRequest{}
+	}
+	if stats.DBServers == nil {
+		stats.DBServers = []*models.DBServerStats{}
+	}
+	if stats.SSHAuthEvents == nil {
+		stats.SSHAuthEvents = []*models.SSHAuthEvent{}
+	}
+	if stats.SSHAuthSources == nil {
+		stats.SSHAuthSources = []*models.SSHAuthSourceStats{}
+	}
+	if stats.SSHAuthUsers == nil {
+		stats.SSHAuthUsers = []*models.SSHAuthUserStats{}
+	}
+	if stats.WebRequests == nil {
+		stats.WebRequests = []*models.WebLogRequest{}
+	}
+	if stats.WebClients == nil {
+		stats.WebClients = []*models.WebClientStats{}
+	}
+	if stats.WebLogs == nil {
+		stats.WebLogs = []*models.WebLogStats{}
+	}
+	if stats.WebAttacks == nil {
+		stats.WebAttacks = []*models.WebAttackStats{}
+	}
+	if stats.LogMatches == nil {
+		stats.LogMatches = []*models.LogMatch{}
+	}
+	if stats.LogSources == nil {
+		stats.LogSources = []*models.LogMatchStats{}
+	}
+	if stats.Jails == nil {
+		stats.Jails = []*models.JailStats{}
+	}
 	if stats.IPStats == nil {
 		stats.IPStats = []*models.ConnectionWindowStats{}
 	}
@@ -188,6 +463,35 @@ func (s *StatsServer) handleGetBlackStats() ([]*models.IPCheckResult, error) {
 	return s.manager.GetBlackStats(), nil
 }
 
+// handleGetEvents returns the recorded events, optionally only those of one type
+func (s *StatsServer) handleGetEvents(eventType models.EventType) ([]*models.Event, error) {
+	events, err := s.manager.GetEvents()
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*models.Event{}
+	for _, event := range events {
+		if eventType == "" || event.Type == eventType {
+			results = append(results, event)
+		}
+	}
+	return results, nil
+}
+
+// handleGetOffenders returns the ban history of each address, or of the
+// addresses of a network or a single address if filter is set
+func (s *StatsServer) handleGetOffenders(filter string) ([]*models.OffenderStats, error) {
+	offenders, err := s.manager.GetOffenders(filter)
+	if err != nil {
+		return nil, err
+	}
+	if offenders == nil {
+		offenders = []*models.OffenderStats{}
+	}
+	return offenders, nil
+}
+
 func (s *StatsServer) Stop() error {
 	close(s.done)
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/safepointcloud/safepanel/internal/rpc"
 	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
 )
 
 type App struct {
@@ -99,7 +100,7 @@ func (a *App) Run(ctx context.Context) error {
 }
 
 func (a *App) update() {
-	stats, err := a.client.GetStats()
+	stats, err := a.client.GetStats(rpc.SectionConnections)
 	if err != nil {
 		a.app.QueueUpdateDraw(func() {
 			a.statusBar.SetText(fmt.Sprintf("[red]Error: %v", err))
@@ -131,8 +132,8 @@ func (a *App) updateInboundView(connections []*models.NewConnectionStats) {
 
 		fmt.Fprintf(a.inbound, "%-12s %-25s %-25s\n",
 			conn.Timestamp.Format("15:04:05"),
-			fmt.Sprintf("%s:%d", conn.SrcIP, conn.SrcPort),
-			fmt.Sprintf("%s:%d", conn.DstIP, conn.DstPort))
+			utils.FormatAddr(conn.SrcIP, conn.SrcPort),
+			utils.FormatAddr(conn.DstIP, conn.DstPort))
 	}
 }
 
@@ -152,8 +153,8 @@ func (a *App) updateOutboundView(connections []*models.NewConnectionStats) {
 
 		fmt.Fprintf(a.outbound, "%-12s %-25s %-25s\n",
 			conn.Timestamp.Format("15:04:05"),
-			fmt.Sprintf("%s:%d", conn.SrcIP, conn.SrcPort),
-			fmt.Sprintf("%s:%d", conn.DstIP, conn.DstPort))
+			utils.FormatAddr(conn.SrcIP, conn.SrcPort),
+			utils.FormatAddr(conn.DstIP, conn.DstPort))
 	}
 }
 
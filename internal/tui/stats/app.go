@@ -12,6 +12,7 @@ import (
 
 	"github.com/safepointcloud/safepanel/internal/rpc"
 	"github.com/safepointcloud/safepanel/pkg/models"
+	"github.com/safepointcloud/safepanel/pkg/utils"
 )
 
 type App struct {
@@ -21,6 +22,8 @@ type App struct {
 	dns          *tview.TextView
 	ipStats      *tview.TextView
 	portStats    *tview.TextView
+	web          *tview.TextView
+	events       *tview.TextView
 	statusBar    *tview.TextView
 	isPaused     bool
 	currentFocus int
@@ -59,6 +62,18 @@ func (a *App) setupUI() {
 		SetRegions(true).
 		SetScrollable(true)
 
+	a.web = tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetScrollable(true)
+	a.web.SetWrap(false)
+
+	a.events = tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetScrollable(true)
+	a.events.SetWrap(false)
+
 	a.statusBar = tview.NewTextView().
 		SetDynamicColors(true)
 
@@ -73,6 +88,8 @@ func (a *App) setupUI() {
 			AddItem(a.ipStats, 0, 1, false).
 			AddItem(a.portStats, 0, 1, false),
 			0, 2, false).
+		AddItem(a.web, 0, 1, false).
+		AddItem(a.events, 0, 1, false).
 		AddItem(a.statusBar, 1, 1, false)
 
 	a.app.SetRoot(flex, true)
@@ -90,6 +107,12 @@ func (a *App) setupUI() {
 	a.portStats.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		return event
 	})
+	a.web.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		return event
+	})
+	a.events.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		return event
+	})
 }
 
 func (a *App) Run(ctx context.Context) error {
@@ -98,7 +121,7 @@ func (a *App) Run(ctx context.Context) error {
 	}
 
 	if a.app == nil || a.connections == nil || a.dns == nil ||
-		a.ipStats == nil || a.portStats == nil || a.statusBar == nil {
+		a.ipStats == nil || a.portStats == nil || a.web == nil || a.events == nil || a.statusBar == nil {
 		return fmt.Errorf("app components not properly initialized")
 	}
 
@@ -130,7 +153,17 @@ func (a *App) Run(ctx context.Context) error {
 }
 
 func (a *App) update() {
-	stats, err := a.client.GetStats()
+	stats, err := a.client.GetStats(rpc.SectionConnections, rpc.SectionDNS, rpc.SectionWindows, rpc.SectionWeb)
+	if err != nil {
+		a.app.QueueUpdateDraw(func() {
+			if a.statusBar != nil {
+				a.statusBar.SetText(fmt.Sprintf("[red]Error: %v - Retrying...", err))
+			}
+		})
+		return
+	}
+
+	events, err := a.client.GetEvents("")
 	if err != nil {
 		a.app.QueueUpdateDraw(func() {
 			if a.statusBar != nil {
@@ -160,6 +193,12 @@ func (a *App) update() {
 		if a.portStats != nil {
 			a.updatePortStatsView(stats.PortStats)
 		}
+		if a.web != nil {
+			a.updateWebView(stats.WebClients, stats.WebAttacks)
+		}
+		if a.events != nil {
+			a.updateEventsView(events)
+		}
 		if a.statusBar != nil {
 			a.updateStatusBar()
 		}
@@ -168,8 +207,8 @@ func (a *App) update() {
 
 func (a *App) updateConnectionsView(connections []*models.NewConnectionStats) {
 	a.connections.Clear()
-	fmt.Fprintf(a.connections, "[yellow]%-12s %-25s %-25s[-]\n",
-		"Time", "Source", "Destination")
+	fmt.Fprintf(a.connections, "[yellow]%-12s %-7s %-25s %-25s %-30s[-]\n",
+		"Time", "Proto", "Source", "Destination", "Host")
 
 	// sort by timestamp
 	sort.Slice(connections, func(i, j int) bool {
@@ -177,17 +216,23 @@ func (a *App) updateConnectionsView(connections []*models.NewConnectionStats) {
 	})
 
 	for _, conn := range connections {
-		fmt.Fprintf(a.connections, "%-12s %-25s %-25s\n",
+		src, dst := utils.FormatAddr(conn.SrcIP, conn.SrcPort), utils.FormatAddr(conn.DstIP, conn.DstPort)
+		if conn.Protocol == models.ProtocolICMP || conn.Protocol == models.ProtocolICMPv6 {
+			src, dst = conn.SrcIP, fmt.Sprintf("%s (%d/%d)", conn.DstIP, conn.ICMPType, conn.ICMPCode)
+		}
+		fmt.Fprintf(a.connections, "%-12s %-7s %-25s %-25s %-30s\n",
 			conn.Timestamp.Format("15:04:05"),
-			fmt.Sprintf("%s:%d", conn.SrcIP, conn.SrcPort),
-			fmt.Sprintf("%s:%d", conn.DstIP, conn.DstPort))
+			conn.Protocol,
+			src,
+			dst,
+			truncateString(conn.ServerName, 30))
 	}
 }
 
 func (a *App) updateDNSView(queries []*models.DNSQueryStats) {
 	a.dns.Clear()
-	fmt.Fprintf(a.dns, "[yellow]%-12s %-30s %-30s %-15s %-15s[-]\n",
-		"Time", "Domain", "Response", "Client", "DNS Server")
+	fmt.Fprintf(a.dns, "[yellow]%-12s %-30s %-5s %-10s %-8s %-30s %-15s %-15s[-]\n",
+		"Time", "Domain", "DGA", "Rcode", "Latency", "Response", "Client", "DNS Server")
 
 	// sort by timestamp
 	sort.Slice(queries, func(i, j int) bool {
@@ -195,9 +240,21 @@ func (a *App) updateDNSView(queries []*models.DNSQueryStats) {
 	})
 
 	for _, query := range queries {
-		fmt.Fprintf(a.dns, "%-12s %-30s %-30s %-15s %-15s\n",
+		latency := ""
+		if query.Latency > 0 {
+			latency = fmt.Sprintf("%dms", query.Latency.Milliseconds())
+		}
+		dga := ""
+		if query.DGAScore > 0 {
+			dga = fmt.Sprintf("%.2f", query.DGAScore)
+		}
+		fmt.Fprintf(a.dns, "%s%-12s %-30s %-5s %-10s %-8s %-30s %-15s %-15s[-]\n",
+			dgaColor(query.DGAScore),
 			query.Timestamp.Format("15:04:05"),
 			truncateString(query.Domain, 28),
+			dga,
+			query.Rcode,
+			latency,
 			truncateString(strings.Join(query.Response, ","), 28),
 			query.SrcIP,
 			query.DNSServer)
@@ -206,8 +263,8 @@ func (a *App) updateDNSView(queries []*models.DNSQueryStats) {
 
 func (a *App) updateIPStatsView(stats []*models.ConnectionWindowStats) {
 	a.ipStats.Clear()
-	fmt.Fprintf(a.ipStats, "[yellow]%-35s %-15s %-15s[-]\n",
-		"SrcIP -> DstIP", "Unique Ports", "Conns")
+	fmt.Fprintf(a.ipStats, "[yellow]%-7s %-35s %-15s %-15s[-]\n",
+		"Proto", "SrcIP -> DstIP", "Unique Ports", "Conns")
 
 	// sort by connection count
 	sort.Slice(stats, func(i, j int) bool {
@@ -218,7 +275,8 @@ func (a *App) updateIPStatsView(stats []*models.ConnectionWindowStats) {
 	})
 
 	for _, stat := range stats {
-		fmt.Fprintf(a.ipStats, "%-35s %-15d %-15d\n",
+		fmt.Fprintf(a.ipStats, "%-7s %-35s %-15d %-15d\n",
+			stat.Protocol,
 			stat.SrcIP+" -> "+stat.DstIP,
 			len(stat.Ports),
 			stat.TotalConns)
@@ -227,8 +285,8 @@ func (a *App) updateIPStatsView(stats []*models.ConnectionWindowStats) {
 
 func (a *App) updatePortStatsView(stats []*models.PortWindowStats) {
 	a.portStats.Clear()
-	fmt.Fprintf(a.portStats, "[yellow]%-30s %-15s %-15s[-]\n",
-		"DstIP:Port", "Unique IPs", "Conns")
+	fmt.Fprintf(a.portStats, "[yellow]%-7s %-30s %-15s %-15s[-]\n",
+		"Proto", "DstIP:Port", "Unique IPs", "Conns")
 
 	// sort by connection count
 	sort.Slice(stats, func(i, j int) bool {
@@ -239,13 +297,108 @@ func (a *App) updatePortStatsView(stats []*models.PortWindowStats) {
 	})
 
 	for _, stat := range stats {
-		fmt.Fprintf(a.portStats, "%-30s %-15d %-15d\n",
-			fmt.Sprintf("%s:%d", stat.DstIP, stat.DstPort),
+		fmt.Fprintf(a.portStats, "%-7s %-30s %-15d %-15d\n",
+			stat.Protocol,
+			utils.FormatAddr(stat.DstIP, stat.DstPort),
 			len(stat.UniqueIPs),
 			stat.TotalConns)
 	}
 }
 
+func (a *App) updateWebView(stats []*models.WebClientStats, attacks []*models.WebAttackStats) {
+	a.web.Clear()
+	fmt.Fprintf(a.web, "[yellow]%-40s %-9s %-8s %-6s %-6s %-6s %-7s %-40s[-]\n",
+		"Client", "Requests", "Req/s", "2xx", "4xx", "5xx", "Attack", "Top Path")
+
+	scores := make(map[string]float64, len(attacks))
+	for _, attack := range attacks {
+		scores[attack.ClientIP] = attack.Score
+	}
+
+	// sort by request count
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Requests == stats[j].Requests {
+			return stats[i].ClientIP < stats[j].ClientIP
+		}
+		return stats[i].Requests > stats[j].Requests
+	})
+
+	for _, stat := range stats {
+		topPath := ""
+		if top := models.TopWebPaths(stat.Paths, 1); len(top) > 0 {
+			topPath = fmt.Sprintf("%s (%d)", top[0].Path, top[0].Count)
+		}
+		attack := ""
+		if score := scores[stat.ClientIP]; score > 0 {
+			attack = fmt.Sprintf("%.0f", score)
+		}
+		fmt.Fprintf(a.web, "%-40s %-9d %-8.2f %-6d %-6d %-6d %-7s %-40s\n",
+			stat.ClientIP,
+			stat.Requests,
+			stat.RequestRate(),
+			models.StatusClassCount(stat.Statuses, 2),
+			models.StatusClassCount(stat.Statuses, 4),
+			models.StatusClassCount(stat.Statuses, 5),
+			attack,
+			truncateString(topPath, 40))
+	}
+}
+
+func (a *App) updateEventsView(events []*models.Event) {
+	a.events.Clear()
+	fmt.Fprintf(a.events, "[yellow]%-12s %-16s %-9s %-6s %-40s %s[-]\n",
+		"Time", "Type", "Severity", "Score", "Source -> Target", "Details")
+
+	// sort by timestamp
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.After(events[j].Timestamp)
+	})
+
+	for _, event := range events {
+		source := event.SrcIP
+		if source == "" {
+			source = fmt.Sprintf("%d sources", len(event.Sources))
+		}
+		if event.Target != "" {
+			source += " -> " + event.Target
+		}
+		fmt.Fprintf(a.events, "%s%-12s %-16s %-9s %-6.2f %-40s %s[-]\n",
+			severityColor(event.Severity),
+			event.Timestamp.Format("15:04:05"),
+			event.Type,
+			event.Severity,
+			event.Score,
+			truncateString(source, 38),
+			event.Message)
+	}
+}
+
+// severityColor returns the color tag events of the severity are shown in
+func severityColor(severity models.Severity) string {
+	switch severity {
+	case models.SeverityCritical:
+		return "[red]"
+	case models.SeverityHigh:
+		return "[orange]"
+	case models.SeverityMedium:
+		return "[yellow]"
+	default:
+		return "[white]"
+	}
+}
+
+// dgaColor returns the color tag queries of the DGA score are shown in
+func dgaColor(score float64) string {
+	switch {
+	case score >= 0.9:
+		return "[red]"
+	case score >= 0.7:
+		return "[orange]"
+	default:
+		return "[white]"
+	}
+}
+
 // truncateString truncate string and add ellipsis
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -272,7 +425,7 @@ func (a *App) setupKeyBindings() {
 			}()
 			return nil
 		case tcell.KeyTab:
-			a.currentFocus = (a.currentFocus + 1) % 4 // Cycle through 4 views
+			a.currentFocus = (a.currentFocus + 1) % 6 // Cycle through 6 views
 			switch a.currentFocus {
 			case 0:
 				a.app.SetFocus(a.connections)
@@ -282,6 +435,10 @@ func (a *App) setupKeyBindings() {
 				a.app.SetFocus(a.ipStats)
 			case 3:
 				a.app.SetFocus(a.portStats)
+			case 4:
+				a.app.SetFocus(a.web)
+			case 5:
+				a.app.SetFocus(a.events)
 			}
 			return nil
 		case tcell.KeyRune:
@@ -348,6 +505,20 @@ func (a *App) Init() error {
 			SetScrollable(true)
 	}
 
+	if a.web == nil {
+		a.web = tview.NewTextView().
+			SetDynamicColors(true).
+			SetRegions(true).
+			SetScrollable(true)
+	}
+
+	if a.events == nil {
+		a.events = tview.NewTextView().
+			SetDynamicColors(true).
+			SetRegions(true).
+			SetScrollable(true)
+	}
+
 	if a.statusBar == nil {
 		a.statusBar = tview.NewTextView().
 			SetDynamicColors(true)
@@ -358,6 +529,8 @@ func (a *App) Init() error {
 	a.dns.SetTitle(" DNS Queries ").SetBorder(true)
 	a.ipStats.SetTitle(" IP Statistics ").SetBorder(true)
 	a.portStats.SetTitle(" Port Statistics ").SetBorder(true)
+	a.web.SetTitle(" Web Clients ").SetBorder(true)
+	a.events.SetTitle(" Events ").SetBorder(true)
 
 	// create layout
 	flex := tview.NewFlex().
@@ -370,6 +543,8 @@ func (a *App) Init() error {
 			AddItem(a.ipStats, 0, 1, false).
 			AddItem(a.portStats, 0, 1, false),
 			0, 2, false).
+		AddItem(a.web, 0, 1, false).
+		AddItem(a.events, 0, 1, false).
 		AddItem(a.statusBar, 1, 1, false)
 
 	// set root layout
@@ -7,3 +7,24 @@ type BlockRecord struct {
 	StartTime time.Time
 	Duration  time.Duration
 }
+
+// Offence is a ban of an address kept in the offence history
+type Offence struct {
+	Time     time.Time
+	Reason   string
+	Duration time.Duration // 0 for a permanent ban
+	Level    int           // escalation level the duration was derived from
+}
+
+// OffenderStats describes the ban history of an address
+type OffenderStats struct {
+	IP           string
+	Network      string // the /24 or /64 of the address
+	Bans         int    // bans within the history TTL
+	NetworkBans  int    // bans of all addresses of the network
+	NextLevel    int    // escalation level of the next ban
+	Offences     []Offence
+	Blocked      bool
+	Permanent    bool
+	BlockedUntil time.Time
+}
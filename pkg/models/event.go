@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+type EventType string
+
+const (
+	EventSYNFlood             EventType = "syn_flood"
+	EventVerticalScan         EventType = "vertical_scan"
+	EventHorizontalScan       EventType = "horizontal_scan"
+	EventSlowScan             EventType = "slow_scan"
+	EventZoneTransfer         EventType = "zone_transfer"
+	EventDNSTunnel            EventType = "dns_tunnel"
+	EventDGADomain            EventType = "dga_domain"
+	EventNXDomainBurst        EventType = "nxdomain_burst"
+	EventTLSFingerprint       EventType = "tls_fingerprint"
+	EventHTTPProbe            EventType = "http_probe"
+	EventDBBruteForce         EventType = "db_brute_force"
+	EventDBExploit            EventType = "db_exploit"
+	EventSSHBruteForce        EventType = "ssh_brute_force"
+	EventSSHAuthFailure       EventType = "ssh_auth_failure"
+	EventSSHBruteForceSuccess EventType = "ssh_brute_force_success"
+	EventWebRequestFlood      EventType = "web_request_flood"
+	EventWebErrorSpike        EventType = "web_error_spike"
+	EventWebAttack            EventType = "web_attack"
+	EventSudoFailure          EventType = "sudo_failure"
+	EventKernelSYNFlood       EventType = "kernel_syn_flood"
+	EventProcessCrash         EventType = "process_crash"
+)
+
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// LogEventType returns the type of the events of a user-defined log source
+func LogEventType(source string) EventType {
+	return EventType("log:" + source)
+}
+
+// Event represents a detection raised by one of the analyzers
+type Event struct {
+	Type      EventType
+	Severity  Severity
+	SrcIP     string   // offending source, empty if the event has several
+	Sources   []string // all offending sources
+	Target    string   // attacked host or service
+	Score     float64
+	Count     int
+	Message   string
+	Details   map[string]string
+	Timestamp time.Time
+}
@@ -0,0 +1,66 @@
+package models
+
+// ring keeps the latest records of one kind and overwrites the oldest once it
+// is full, the StatsCollector lock guards it. update swaps in a changed copy
+// of a record, so records handed out by items stay as they were.
+type ring[T any] struct {
+	records []*T
+	next    int
+	full    bool
+}
+
+func newRing[T any](size int) ring[T] {
+	return ring[T]{records: make([]*T, size)}
+}
+
+// add stores a record, overwriting the oldest one if the ring is full
+func (r *ring[T]) add(record *T) {
+	r.records[r.next] = record
+	r.next = (r.next + 1) % len(r.records)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// items returns the records from the oldest to the newest
+func (r *ring[T]) items() []*T {
+	if !r.full {
+		return append(make([]*T, 0, r.next), r.records[:r.next]...)
+	}
+	items := make([]*T, 0, len(r.records))
+	items = append(items, r.records[r.next:]...)
+	return append(items, r.records[:r.next]...)
+}
+
+// find returns the newest record match accepts, or nil
+func (r *ring[T]) find(match func(*T) bool) *T {
+	if i := r.index(match); i >= 0 {
+		return r.records[i]
+	}
+	return nil
+}
+
+// update replaces the newest record match accepts with a copy changed by
+// change and reports whether a record matched
+func (r *ring[T]) update(match func(*T) bool, change func(*T)) bool {
+	i := r.index(match)
+	if i < 0 {
+		return false
+	}
+	changed := *r.records[i]
+	change(&changed)
+	r.records[i] = &changed
+	return true
+}
+
+// index returns the slot of the newest record match accepts, or -1
+func (r *ring[T]) index(match func(*T) bool) int {
+	size := len(r.records)
+	for i := 1; i <= size; i++ {
+		idx := (r.next - i + size) % size
+		if record := r.records[idx]; record != nil && match(record) {
+			return idx
+		}
+	}
+	return -1
+}
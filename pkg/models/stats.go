@@ -2,8 +2,11 @@ package models
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/safepointcloud/safepanel/pkg/utils"
 )
 
 type Direction uint8
@@ -16,40 +19,513 @@ const (
 type Protocol string
 
 const (
-	ProtocolTCP Protocol = "TCP"
-	ProtocolUDP Protocol = "UDP"
+	ProtocolTCP    Protocol = "TCP"
+	ProtocolUDP    Protocol = "UDP"
+	ProtocolICMP   Protocol = "ICMP"
+	ProtocolICMPv6 Protocol = "ICMPv6"
 )
 
-// NewConnectionStats represents individual new connection events
+// NewConnectionStats represents individual new connection events, for UDP
+// and ICMP the first packet of a pseudo-flow
 type NewConnectionStats struct {
 	SrcIP     string
 	SrcPort   uint16
 	DstIP     string
 	DstPort   uint16
 	Protocol  Protocol
+	ICMPType  uint8
+	ICMPCode  uint8
 	Direction Direction
+	Interface string
 	Timestamp time.Time
+
+	// ServerName is the TLS SNI, filled in once the ClientHello is seen
+	ServerName string
 }
 
-// DNSQueryStats represents DNS query statistics
+// DNSQueryStats represents DNS query statistics, the response fields are
+// filled in once the query is answered or timed out
 type DNSQueryStats struct {
-	ID        uint16
-	Domain    string
-	SrcIP     string
-	Response  []string
-	DNSServer string
-	QueryType string
+	ID         uint16
+	Domain     string
+	SrcIP      string
+	SrcPort    uint16
+	Response   []string
+	DNSServer  string
+	ServerPort uint16
+	QueryType  string
+	Interface  string
+	Timestamp  time.Time
+
+	Rcode   DNSRcode
+	Answers []DNSAnswer
+	Latency time.Duration
+
+	// DGAScore rates from 0 to 1 how likely the domain was generated by an algorithm
+	DGAScore float64
+}
+
+// DNSRcode is the mnemonic of a DNS response code
+type DNSRcode string
+
+const (
+	DNSRcodeNoError  DNSRcode = "NOERROR"
+	DNSRcodeFormErr  DNSRcode = "FORMERR"
+	DNSRcodeServFail DNSRcode = "SERVFAIL"
+	DNSRcodeNXDomain DNSRcode = "NXDOMAIN"
+	DNSRcodeNotImp   DNSRcode = "NOTIMP"
+	DNSRcodeRefused  DNSRcode = "REFUSED"
+	// DNSRcodeTimeout marks queries that got no response in time
+	DNSRcodeTimeout DNSRcode = "TIMEOUT"
+	// DNSRcodeRetransmit marks queries repeating a pending query, the
+	// response is matched with the original
+	DNSRcodeRetransmit DNSRcode = "RETRANSMIT"
+	// DNSRcodeUntracked marks queries not matched with their response as
+	// too many queries were pending
+	DNSRcodeUntracked DNSRcode = "UNTRACKED"
+)
+
+// DNSAnswer is a single resource record of the answer section
+type DNSAnswer struct {
+	Name string
+	Type string
+	TTL  uint32
+	Data string
+}
+
+type FlowState string
+
+const (
+	FlowStateSynSent     FlowState = "SYN_SENT"
+	FlowStateSynReceived FlowState = "SYN_RECEIVED"
+	FlowStateEstablished FlowState = "ESTABLISHED"
+	FlowStateClosing     FlowState = "CLOSING"
+	FlowStateClosed      FlowState = "CLOSED"
+	FlowStateReset       FlowState = "RESET"
+	// FlowStateActive is used by UDP and ICMP pseudo-flows, which have no connection state
+	FlowStateActive FlowState = "ACTIVE"
+)
+
+type FlowCloseReason string
+
+const (
+	FlowCloseFIN  FlowCloseReason = "fin"
+	FlowCloseRST  FlowCloseReason = "rst"
+	FlowCloseIdle FlowCloseReason = "idle"
+)
+
+// FlowRecord represents a tracked flow, Src is the side that opened it
+type FlowRecord struct {
+	SrcIP       string
+	SrcPort     uint16
+	DstIP       string
+	DstPort     uint16
+	Protocol    Protocol
+	ICMPType    uint8
+	ICMPCode    uint8
+	Direction   Direction
+	Interface   string
+	State       FlowState
+	Handshake   bool // whether the TCP three-way handshake completed
+	SrcPackets  int64
+	SrcBytes    int64
+	DstPackets  int64
+	DstBytes    int64
+	StartTime   time.Time
+	EndTime     time.Time
+	Duration    time.Duration
+	CloseReason FlowCloseReason
+	TLS         *TLSInfo // set for TLS connections whose handshake was seen
+	SSH         *SSHInfo // set for SSH connections whose banners were seen
+}
+
+// SSHInfo holds the identification lines of an SSH connection
+// ("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13")
+type SSHInfo struct {
+	ClientBanner string
+	ServerBanner string
+}
+
+// TLSInfo holds the plaintext metadata of a TLS handshake
+type TLSInfo struct {
+	ServerName  string   // SNI of the ClientHello
+	ALPN        []string // protocols offered by the client
+	Versions    []string // versions offered by the client
+	Version     string   // version selected by the server
+	CipherSuite string   // cipher suite selected by the server
+	ServerALPN  string   // protocol selected by the server
+	JA3         string   // JA3 fingerprint of the ClientHello
+	JA4         string   // JA4 fingerprint of the ClientHello
+
+	// certificate of the server, only visible before TLS 1.3
+	CertSubject  string
+	CertIssuer   string
+	CertSANs     []string
+	CertNotAfter time.Time
+}
+
+// TLSSession is the handshake of a TLS connection, Client is the side that
+// sent the ClientHello
+type TLSSession struct {
+	ClientIP   string
+	ClientPort uint16
+	ServerIP   string
+	ServerPort uint16
+	Direction  Direction
+	Interface  string
+	TLSInfo
 	Timestamp time.Time
 }
 
-type DNSResponse struct {
-	QueryID   uint16
-	Response  []string
+// HTTPRequest is a plaintext HTTP request and the status of its response,
+// Status is 0 if no response was seen
+type HTTPRequest struct {
+	ClientIP    string
+	ClientPort  uint16
+	ServerIP    string
+	ServerPort  uint16
+	Direction   Direction
+	Interface   string
+	Method      string
+	Host        string
+	Path        string
+	Version     string
+	UserAgent   string
+	Referer     string
+	Status      int
+	ContentType string
+	Latency     time.Duration
+	Signatures  []SignatureMatch // signature rules the request matches
+	Timestamp   time.Time
+}
+
+// SignatureMatch is a signature rule a web request matches
+type SignatureMatch struct {
+	RuleID   string
+	Category string // "sqli", "xss", "traversal", "jndi", "cmdi", "scanner"...
+	Severity Severity
+	Score    float64 // added to the attack score of the client
+}
+
+// DBCommandLogin is the command of a DBRequest recording a login attempt
+const DBCommandLogin = "LOGIN"
+
+// DBRequest is a login or command sent to a MySQL, PostgreSQL or Redis
+// server and the outcome of its response, Success is false if none was seen
+type DBRequest struct {
+	Protocol   string // "mysql", "postgres" or "redis"
+	ClientIP   string
+	ClientPort uint16
+	ServerIP   string
+	ServerPort uint16
+	Direction  Direction
+	Interface  string
+	User       string
+	Database   string
+	Command    string // DBCommandLogin, the SQL statement type or the Redis command
+	Args       string // statement with its literals replaced by "?" or the arguments
+	Success    bool
+	Error      string
+	Latency    time.Duration
+	Timestamp  time.Time
+}
+
+// DBServerStats counts the logins and commands a database server received
+// within the window
+type DBServerStats struct {
+	Protocol     string
+	ServerIP     string
+	ServerPort   uint16
+	Logins       int
+	FailedLogins int
+	Commands     int
+	Errors       int
+	CommandTypes map[string]int // command -> count
+	Clients      map[string]struct{}
+	WindowStart  time.Time
+	WindowEnd    time.Time
+}
+
+// LoginFailureRate returns the share of failed login attempts
+func (s *DBServerStats) LoginFailureRate() float64 {
+	if s.Logins == 0 {
+		return 0
+	}
+	return float64(s.FailedLogins) / float64(s.Logins)
+}
+
+// ErrorRate returns the share of commands answered with an error
+func (s *DBServerStats) ErrorRate() float64 {
+	if s.Commands == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Commands)
+}
+
+// SSHAuthResult is the outcome of an SSH authentication attempt
+type SSHAuthResult string
+
+const (
+	SSHAuthAccepted    SSHAuthResult = "accepted"
+	SSHAuthFailed      SSHAuthResult = "failed"
+	SSHAuthInvalidUser SSHAuthResult = "invalid_user"
+	SSHAuthDisconnect  SSHAuthResult = "disconnect" // closed before authenticating
+)
+
+// SSHAuthEvent is an authentication attempt logged by sshd
+type SSHAuthEvent struct {
+	Result      SSHAuthResult
+	Method      string // "password", "publickey", "keyboard-interactive/pam", empty if not logged
+	User        string
+	InvalidUser bool // the user does not exist on the host
+	SrcIP       string
+	SrcPort     uint16
+	Host        string // host that logged the attempt
+	Count       int    // attempts, sshd folds repeated messages
+	Timestamp   time.Time
+}
+
+// SSHAuthSourceStats counts the SSH authentication attempts of a source
+// within the window
+type SSHAuthSourceStats struct {
+	SrcIP        string
+	Accepted     int
+	Failed       int
+	InvalidUsers int
+	Disconnects  int
+	Users        map[string]int // user -> attempts
+	WindowStart  time.Time
+	WindowEnd    time.Time
+}
+
+// SSHAuthUserStats counts the SSH authentication attempts for a user name
+// within the window
+type SSHAuthUserStats struct {
+	User        string
+	InvalidUser bool
+	Accepted    int
+	Failed      int
+	Sources     map[string]int // source -> attempts
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// WebLogRequest is a request read from the access log of a web server
+type WebLogRequest struct {
+	Log        string // name of the access log
+	ClientIP   string
+	User       string // authenticated user
+	Method     string // empty if the request line was malformed, it is kept as the path
+	Host       string
+	Path       string
+	Query      string
+	Version    string
+	Status     int
+	Bytes      int64
+	Referer    string
+	UserAgent  string
+	Latency    time.Duration // request time if logged
+	Signatures []SignatureMatch
+	Timestamp  time.Time
+}
+
+// WebClientStats counts the requests of a client in the access logs within
+// the window
+type WebClientStats struct {
+	ClientIP    string
+	Requests    int
+	Bytes       int64
+	Statuses    map[int]int    // status -> count
+	Paths       map[string]int // path -> count, bounded by maxWebPaths
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// RequestRate returns the requests per second
+func (s *WebClientStats) RequestRate() float64 {
+	return requestRate(s.Requests, s.WindowStart, s.WindowEnd)
+}
+
+// WebLogStats counts the requests of an access log within the window
+type WebLogStats struct {
+	Log         string
+	Requests    int
+	Bytes       int64
+	Statuses    map[int]int    // status -> count
+	Paths       map[string]int // path -> count, bounded by maxWebPaths
+	Clients     map[string]struct{}
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// RequestRate returns the requests per second
+func (s *WebLogStats) RequestRate() float64 {
+	return requestRate(s.Requests, s.WindowStart, s.WindowEnd)
+}
+
+// StatusClassCount returns the responses with a status of the class, 4
+// for 4xx
+func StatusClassCount(statuses map[int]int, class int) int {
+	count := 0
+	for status, n := range statuses {
+		if status/100 == class {
+			count += n
+		}
+	}
+	return count
+}
+
+// WebPathCount is a path and the requests for it
+type WebPathCount struct {
+	Path  string
+	Count int
+}
+
+// TopWebPaths returns the n most requested paths
+func TopWebPaths(paths map[string]int, n int) []WebPathCount {
+	result := make([]WebPathCount, 0, len(paths))
+	for path, count := range paths {
+		result = append(result, WebPathCount{Path: path, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count == result[j].Count {
+			return result[i].Path < result[j].Path
+		}
+		return result[i].Count > result[j].Count
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func requestRate(requests int, start, end time.Time) float64 {
+	seconds := end.Sub(start).Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+	return float64(requests) / seconds
+}
+
+// WebAttackStats sums the signature matches of the web requests of a client
+// within the window, from both captured traffic and access logs
+type WebAttackStats struct {
+	ClientIP    string
+	Score       float64
+	Requests    int            // requests matching at least one rule
+	Rules       map[string]int // rule -> matches
+	Categories  map[string]int // category -> matches
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// LogMatch is a line of a user-defined log source matching one of its
+// patterns
+type LogMatch struct {
+	Source    string            // name of the log source
+	SrcIP     string            // offending address captured by the pattern
+	Host      string            // host that logged the line, empty for local files
+	Fields    map[string]string // named captures of the pattern
+	Line      string
 	Timestamp time.Time
 }
 
+// LogMatchStats counts the matches of an address in a user-defined log
+// source within the window
+type LogMatchStats struct {
+	Source      string
+	SrcIP       string
+	Matches     int
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// JailStats describes a jail banning the sources of matching events
+type JailStats struct {
+	Name     string
+	Events   []string // event type patterns
+	MaxRetry int
+	FindTime time.Duration
+	BanTime  time.Duration
+	Tracked  int // sources with matching events within FindTime
+	Bans     int // bans since startup
+	LastBan  time.Time
+	LastIP   string
+}
+
+// TLSFingerprintStats counts the TLS connections of a client fingerprint
+// within the window
+type TLSFingerprintStats struct {
+	Type        string // "JA3" or "JA4"
+	Fingerprint string
+	Connections int
+	Clients     map[string]struct{}
+	ServerNames map[string]struct{}
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// TLSHostStats counts the TLS connections of a client to a server name
+// within the window
+type TLSHostStats struct {
+	ClientIP    string
+	ServerName  string
+	ServerIPs   map[string]struct{}
+	Connections int
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// DNSResponse is the response, or timeout, of a query. The client, server
+// and question identify the query it belongs to.
+type DNSResponse struct {
+	QueryID    uint16
+	ClientIP   string
+	ClientPort uint16
+	ServerIP   string
+	ServerPort uint16
+	Domain     string
+	QueryType  string
+	Rcode      DNSRcode
+	Answers    []DNSAnswer
+	Response   []string
+	Latency    time.Duration
+	Timestamp  time.Time
+}
+
+// DNSRcodeStats counts the outcome of the queries of a domain or client
+// within the window
+type DNSRcodeStats struct {
+	Key         string // base domain or client IP
+	Queries     int
+	NXDomain    int
+	ServFail    int
+	Timeouts    int
+	Untracked   int // queries not matched with their response
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// NXDomainRate returns the share of queries answered with NXDOMAIN
+func (s *DNSRcodeStats) NXDomainRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.NXDomain) / float64(s.Queries)
+}
+
+// ServFailRate returns the share of queries answered with SERVFAIL
+func (s *DNSRcodeStats) ServFailRate() float64 {
+	if s.Queries == 0 {
+		return 0
+	}
+	return float64(s.ServFail) / float64(s.Queries)
+}
+
 // ConnectionWindowStats represents 10-minute window statistics per source IP->dest IP pair
 type ConnectionWindowStats struct {
+	Protocol    Protocol
+	Direction   Direction
 	SrcIP       string
 	DstIP       string
 	Ports       map[uint16]int // port -> count
@@ -60,6 +536,8 @@ type ConnectionWindowStats struct {
 
 // PortWindowStats represents 10-minute window statistics per destination port
 type PortWindowStats struct {
+	Protocol    Protocol
+	Direction   Direction
 	DstIP       string
 	DstPort     uint16
 	UniqueIPs   map[string]struct{} // set of source IPs
@@ -79,29 +557,78 @@ type IPCheckResult struct {
 
 const maxRecords = 1000
 
+const (
+	// maxWebPaths bounds the paths counted per web client and log
+	maxWebPaths = 200
+	// maxWebPathLength bounds a counted path
+	maxWebPathLength = 256
+)
+
 // StatsCollector handles the collection and aggregation of all statistics
 type StatsCollector struct {
-	NewConnections    []*NewConnectionStats
+	// Deprecated: NewConnections is the storage of the connection ring in
+	// slot order, use GetNewConnections
+	NewConnections []*NewConnectionStats
+	// Deprecated: DNSQueries is the storage of the DNS query ring in slot
+	// order, use GetDNSQueries
 	DNSQueries        []*DNSQueryStats
 	ConnectionWindows map[string]*ConnectionWindowStats
 	PortWindows       map[string]*PortWindowStats
+	DNSDomains        map[string]*DNSRcodeStats
+	DNSClients        map[string]*DNSRcodeStats
+	TLSHosts          map[string]*TLSHostStats
+	TLSFingerprints   map[string]*TLSFingerprintStats
+	DBServers         map[string]*DBServerStats
+	SSHAuthSources    map[string]*SSHAuthSourceStats
+	SSHAuthUsers      map[string]*SSHAuthUserStats
+	WebClients        map[string]*WebClientStats
+	WebLogs           map[string]*WebLogStats
+	WebAttacks        map[string]*WebAttackStats
+	LogSources        map[string]*LogMatchStats
+	connections       ring[NewConnectionStats]
+	dnsQueries        ring[DNSQueryStats]
+	flows             ring[FlowRecord]
+	events            ring[Event]
+	tlsSessions       ring[TLSSession]
+	httpRequests      ring[HTTPRequest]
+	dbRequests        ring[DBRequest]
+	sshAuthEvents     ring[SSHAuthEvent]
+	webLogRequests    ring[WebLogRequest]
+	logMatches        ring[LogMatch]
 	windowDuration    time.Duration
 	mutex             sync.RWMutex
-	maxResults        int
-	connIndex         int
-	dnsIndex          int
-	connIsFull        bool
-	dnsIsFull         bool
 }
 
 func NewStatsCollector() *StatsCollector {
+	connections := newRing[NewConnectionStats](maxRecords)
+	dnsQueries := newRing[DNSQueryStats](maxRecords)
 	return &StatsCollector{
-		NewConnections:    make([]*NewConnectionStats, maxRecords),
-		DNSQueries:        make([]*DNSQueryStats, maxRecords),
+		NewConnections:    connections.records,
+		DNSQueries:        dnsQueries.records,
 		ConnectionWindows: make(map[string]*ConnectionWindowStats),
 		PortWindows:       make(map[string]*PortWindowStats),
+		DNSDomains:        make(map[string]*DNSRcodeStats),
+		DNSClients:        make(map[string]*DNSRcodeStats),
+		TLSHosts:          make(map[string]*TLSHostStats),
+		TLSFingerprints:   make(map[string]*TLSFingerprintStats),
+		DBServers:         make(map[string]*DBServerStats),
+		SSHAuthSources:    make(map[string]*SSHAuthSourceStats),
+		SSHAuthUsers:      make(map[string]*SSHAuthUserStats),
+		WebClients:        make(map[string]*WebClientStats),
+		WebLogs:           make(map[string]*WebLogStats),
+		WebAttacks:        make(map[string]*WebAttackStats),
+		LogSources:        make(map[string]*LogMatchStats),
+		connections:       connections,
+		dnsQueries:        dnsQueries,
+		flows:             newRing[FlowRecord](maxRecords),
+		events:            newRing[Event](maxRecords),
+		tlsSessions:       newRing[TLSSession](maxRecords),
+		httpRequests:      newRing[HTTPRequest](maxRecords),
+		dbRequests:        newRing[DBRequest](maxRecords),
+		sshAuthEvents:     newRing[SSHAuthEvent](maxRecords),
+		webLogRequests:    newRing[WebLogRequest](maxRecords),
+		logMatches:        newRing[LogMatch](maxRecords),
 		windowDuration:    10 * time.Minute,
-		maxResults:        maxRecords,
 	}
 }
 
@@ -109,38 +636,38 @@ func (sc *StatsCollector) AddNewConnection(stats *NewConnectionStats) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 
-	// add new connection using circular index
-	sc.NewConnections[sc.connIndex] = stats
-	sc.connIndex = (sc.connIndex + 1) % sc.maxResults
-	if sc.connIndex == 0 {
-		sc.connIsFull = true
-	}
+	sc.connections.add(stats)
 
 	// update connection window stats
-	key := fmt.Sprintf("%s->%s", stats.SrcIP, stats.DstIP)
+	key := fmt.Sprintf("%s %s->%s", stats.Protocol, stats.SrcIP, stats.DstIP)
 	if cw, exists := sc.ConnectionWindows[key]; exists {
 		cw.AddPort(stats.DstPort)
-		cw.WindowEnd = time.Now()
+		cw.WindowEnd = stats.Timestamp
 	} else {
-		cw = NewConnectionWindowStats(stats.SrcIP, stats.DstIP)
+		cw = NewConnectionWindowStats(stats.Protocol, stats.SrcIP, stats.DstIP)
+		cw.Direction = stats.Direction
+		cw.WindowStart = stats.Timestamp
+		cw.WindowEnd = stats.Timestamp
 		cw.AddPort(stats.DstPort)
 		sc.ConnectionWindows[key] = cw
 	}
 
 	// update port window stats
-	portKey := fmt.Sprintf("%s:%d", stats.DstIP, stats.DstPort)
+	portKey := fmt.Sprintf("%s %s", stats.Protocol, utils.FormatAddr(stats.DstIP, stats.DstPort))
 	if pw, exists := sc.PortWindows[portKey]; exists {
 		pw.TotalConns++
 		pw.UniqueIPs[stats.SrcIP] = struct{}{}
-		pw.WindowEnd = time.Now()
+		pw.WindowEnd = stats.Timestamp
 	} else {
 		sc.PortWindows[portKey] = &PortWindowStats{
+			Protocol:    stats.Protocol,
+			Direction:   stats.Direction,
 			DstIP:       stats.DstIP,
 			DstPort:     stats.DstPort,
 			UniqueIPs:   map[string]struct{}{stats.SrcIP: {}},
 			TotalConns:  1,
-			WindowStart: time.Now(),
-			WindowEnd:   time.Now(),
+			WindowStart: stats.Timestamp,
+			WindowEnd:   stats.Timestamp,
 		}
 	}
 }
@@ -149,37 +676,359 @@ func (sc *StatsCollector) AddDNSQuery(stats *DNSQueryStats) {
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 
-	// add new DNS query using circular index
-	sc.DNSQueries[sc.dnsIndex] = stats
-	sc.dnsIndex = (sc.dnsIndex + 1) % sc.maxResults
-	if sc.dnsIndex == 0 {
-		sc.dnsIsFull = true
+	sc.dnsQueries.add(stats)
+
+	// untracked queries never get a response, so they are counted now
+	if stats.Rcode == DNSRcodeUntracked {
+		response := &DNSResponse{Domain: stats.Domain, ClientIP: stats.SrcIP, Rcode: stats.Rcode, Timestamp: stats.Timestamp}
+		sc.addRcode(sc.DNSDomains, utils.BaseDomain(stats.Domain), response)
+		sc.addRcode(sc.DNSClients, stats.SrcIP, response)
+	}
+}
+
+func (sc *StatsCollector) AddFlow(record *FlowRecord) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.flows.add(record)
+}
+
+func (sc *StatsCollector) AddEvent(event *Event) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.events.add(event)
+}
+
+// AddTLSSession records a TLS handshake, labels the new connection it
+// belongs to with the server name and counts it for the client and name
+func (sc *StatsCollector) AddTLSSession(session *TLSSession) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.tlsSessions.add(session)
+
+	// update fingerprint stats
+	sc.addFingerprint("JA3", session.JA3, session)
+	sc.addFingerprint("JA4", session.JA4, session)
+
+	if session.ServerName == "" {
+		return
+	}
+	sc.connections.update(matchConnection(session.ClientIP, session.ClientPort, session.ServerIP, session.ServerPort),
+		func(conn *NewConnectionStats) {
+			conn.ServerName = session.ServerName
+		})
+
+	key := session.ClientIP + " " + session.ServerName
+	hs, exists := sc.TLSHosts[key]
+	if !exists {
+		hs = &TLSHostStats{
+			ClientIP:    session.ClientIP,
+			ServerName:  session.ServerName,
+			ServerIPs:   make(map[string]struct{}),
+			WindowStart: session.Timestamp,
+		}
+		sc.TLSHosts[key] = hs
+	}
+	hs.Connections++
+	hs.ServerIPs[session.ServerIP] = struct{}{}
+	hs.WindowEnd = session.Timestamp
+}
+
+func (sc *StatsCollector) addFingerprint(fpType, fingerprint string, session *TLSSession) {
+	if fingerprint == "" {
+		return
+	}
+	key := fpType + " " + fingerprint
+	fs, exists := sc.TLSFingerprints[key]
+	if !exists {
+		fs = &TLSFingerprintStats{
+			Type:        fpType,
+			Fingerprint: fingerprint,
+			Clients:     make(map[string]struct{}),
+			ServerNames: make(map[string]struct{}),
+			WindowStart: session.Timestamp,
+		}
+		sc.TLSFingerprints[key] = fs
+	}
+	fs.Connections++
+	fs.Clients[session.ClientIP] = struct{}{}
+	if session.ServerName != "" {
+		fs.ServerNames[session.ServerName] = struct{}{}
+	}
+	fs.WindowEnd = session.Timestamp
+}
+
+// AddHTTPRequest records a plaintext HTTP request and labels the new
+// connection it belongs to with the Host header if it has no TLS name
+func (sc *StatsCollector) AddHTTPRequest(req *HTTPRequest) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.httpRequests.add(req)
+	sc.addWebAttack(req.ClientIP, req.Signatures, req.Timestamp)
+
+	if req.Host == "" {
+		return
+	}
+	sc.connections.update(matchConnection(req.ClientIP, req.ClientPort, req.ServerIP, req.ServerPort),
+		func(conn *NewConnectionStats) {
+			if conn.ServerName == "" {
+				conn.ServerName = req.Host
+			}
+		})
+}
+
+// AddDBRequest records a database login or command and counts it for the
+// server
+func (sc *StatsCollector) AddDBRequest(req *DBRequest) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.dbRequests.add(req)
+
+	key := req.Protocol + " " + utils.FormatAddr(req.ServerIP, req.ServerPort)
+	ds, exists := sc.DBServers[key]
+	if !exists {
+		ds = &DBServerStats{
+			Protocol:     req.Protocol,
+			ServerIP:     req.ServerIP,
+			ServerPort:   req.ServerPort,
+			CommandTypes: make(map[string]int),
+			Clients:      make(map[string]struct{}),
+			WindowStart:  req.Timestamp,
+		}
+		sc.DBServers[key] = ds
+	}
+	if req.Command == DBCommandLogin {
+		ds.Logins++
+		if !req.Success {
+			ds.FailedLogins++
+		}
+	} else {
+		ds.Commands++
+		ds.CommandTypes[req.Command]++
+		if req.Error != "" {
+			ds.Errors++
+		}
+	}
+	ds.Clients[req.ClientIP] = struct{}{}
+	ds.WindowEnd = req.Timestamp
+}
+
+// AddSSHAuthEvent records an SSH authentication attempt and counts it for
+// the source and the user
+func (sc *StatsCollector) AddSSHAuthEvent(auth *SSHAuthEvent) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.sshAuthEvents.add(auth)
+
+	ss, exists := sc.SSHAuthSources[auth.SrcIP]
+	if !exists {
+		ss = &SSHAuthSourceStats{
+			SrcIP:       auth.SrcIP,
+			Users:       make(map[string]int),
+			WindowStart: auth.Timestamp,
+		}
+		sc.SSHAuthSources[auth.SrcIP] = ss
+	}
+	ss.Users[auth.User] += auth.Count
+	ss.WindowEnd = auth.Timestamp
+
+	us, exists := sc.SSHAuthUsers[auth.User]
+	if !exists {
+		us = &SSHAuthUserStats{
+			User:        auth.User,
+			Sources:     make(map[string]int),
+			WindowStart: auth.Timestamp,
+		}
+		sc.SSHAuthUsers[auth.User] = us
+	}
+	us.InvalidUser = auth.InvalidUser
+	us.Sources[auth.SrcIP] += auth.Count
+	us.WindowEnd = auth.Timestamp
+
+	switch auth.Result {
+	case SSHAuthAccepted:
+		ss.Accepted += auth.Count
+		us.Accepted += auth.Count
+	case SSHAuthFailed:
+		ss.Failed += auth.Count
+		us.Failed += auth.Count
+	case SSHAuthInvalidUser:
+		ss.InvalidUsers += auth.Count
+	case SSHAuthDisconnect:
+		ss.Disconnects += auth.Count
+	}
+}
+
+// AddWebLogRequest records a request read from an access log and counts it
+// for the client and the log
+func (sc *StatsCollector) AddWebLogRequest(req *WebLogRequest) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.webLogRequests.add(req)
+
+	path := req.Path
+	if len(path) > maxWebPathLength {
+		path = path[:maxWebPathLength]
+	}
+
+	cs, exists := sc.WebClients[req.ClientIP]
+	if !exists {
+		cs = &WebClientStats{
+			ClientIP:    req.ClientIP,
+			Statuses:    make(map[int]int),
+			Paths:       make(map[string]int),
+			WindowStart: req.Timestamp,
+		}
+		sc.WebClients[req.ClientIP] = cs
+	}
+	cs.Requests++
+	cs.Bytes += req.Bytes
+	cs.Statuses[req.Status]++
+	if _, ok := cs.Paths[path]; ok || len(cs.Paths) < maxWebPaths {
+		cs.Paths[path]++
+	}
+	cs.WindowEnd = req.Timestamp
+
+	ls, exists := sc.WebLogs[req.Log]
+	if !exists {
+		ls = &WebLogStats{
+			Log:         req.Log,
+			Statuses:    make(map[int]int),
+			Paths:       make(map[string]int),
+			Clients:     make(map[string]struct{}),
+			WindowStart: req.Timestamp,
+		}
+		sc.WebLogs[req.Log] = ls
+	}
+	ls.Requests++
+	ls.Bytes += req.Bytes
+	ls.Statuses[req.Status]++
+	if _, ok := ls.Paths[path]; ok || len(ls.Paths) < maxWebPaths {
+		ls.Paths[path]++
+	}
+	ls.Clients[req.ClientIP] = struct{}{}
+	ls.WindowEnd = req.Timestamp
+
+	sc.addWebAttack(req.ClientIP, req.Signatures, req.Timestamp)
+}
+
+// addWebAttack counts the signature matches of a request for the client,
+// the caller holds the lock
+func (sc *StatsCollector) addWebAttack(clientIP string, matches []SignatureMatch, ts time.Time) {
+	if len(matches) == 0 {
+		return
+	}
+
+	as, exists := sc.WebAttacks[clientIP]
+	if !exists {
+		as = &WebAttackStats{
+			ClientIP:    clientIP,
+			Rules:       make(map[string]int),
+			Categories:  make(map[string]int),
+			WindowStart: ts,
+		}
+		sc.WebAttacks[clientIP] = as
+	}
+	as.Requests++
+	for _, match := range matches {
+		as.Score += match.Score
+		as.Rules[match.RuleID]++
+		as.Categories[match.Category]++
+	}
+	as.WindowEnd = ts
+}
+
+// AddLogMatch records a match of a user-defined log source and counts it
+// for the source and address
+func (sc *StatsCollector) AddLogMatch(match *LogMatch) {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	sc.logMatches.add(match)
+
+	key := match.Source + " " + match.SrcIP
+	ls, exists := sc.LogSources[key]
+	if !exists {
+		ls = &LogMatchStats{
+			Source:      match.Source,
+			SrcIP:       match.SrcIP,
+			WindowStart: match.Timestamp,
+		}
+		sc.LogSources[key] = ls
+	}
+	ls.Matches++
+	ls.WindowEnd = match.Timestamp
+}
+
+// matchConnection matches the TCP connections between the client and server
+func matchConnection(clientIP string, clientPort uint16, serverIP string, serverPort uint16) func(*NewConnectionStats) bool {
+	return func(conn *NewConnectionStats) bool {
+		return conn.Protocol == ProtocolTCP &&
+			conn.SrcIP == clientIP && conn.SrcPort == clientPort &&
+			conn.DstIP == serverIP && conn.DstPort == serverPort
 	}
 }
 
 func (c *StatsCollector) AddDNSResponse(stats *DNSResponse) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	query := c.GetMatchedDNSQuery(stats.QueryID)
-	if query != nil {
-		query.Response = append(query.Response, stats.Response...)
-	}
+
+	c.dnsQueries.update(matchDNSQuery(stats), func(query *DNSQueryStats) {
+		query.Rcode = stats.Rcode
+		query.Answers = stats.Answers
+		query.Response = stats.Response
+		query.Latency = stats.Latency
+	})
+
+	// update rcode stats
+	c.addRcode(c.DNSDomains, utils.BaseDomain(stats.Domain), stats)
+	c.addRcode(c.DNSClients, stats.ClientIP, stats)
 }
 
-// add method to associate query and response in StatsCollector
-func (c *StatsCollector) GetMatchedDNSQuery(queryID uint16) *DNSQueryStats {
-	// match query and response by ID
-	var query *DNSQueryStats
+func (c *StatsCollector) addRcode(table map[string]*DNSRcodeStats, key string, stats *DNSResponse) {
+	rs, exists := table[key]
+	if !exists {
+		rs = &DNSRcodeStats{Key: key, WindowStart: stats.Timestamp}
+		table[key] = rs
+	}
+	rs.Queries++
+	rs.WindowEnd = stats.Timestamp
 
-	// find in query list
-	for _, q := range c.DNSQueries {
-		if q.ID == queryID {
-			query = q
-			break
-		}
+	switch stats.Rcode {
+	case DNSRcodeNXDomain:
+		rs.NXDomain++
+	case DNSRcodeServFail:
+		rs.ServFail++
+	case DNSRcodeTimeout:
+		rs.Timeouts++
+	case DNSRcodeUntracked:
+		rs.Untracked++
 	}
+}
 
-	return query
+// GetMatchedDNSQuery returns the latest unanswered query the response belongs
+// to, the caller holds the lock.
+//
+// Deprecated: the query is shared with earlier GetDNSQueries results and
+// must not be changed, AddDNSResponse records the response.
+func (c *StatsCollector) GetMatchedDNSQuery(stats *DNSResponse) *DNSQueryStats {
+	return c.dnsQueries.find(matchDNSQuery(stats))
+}
+
+// matchDNSQuery matches the unanswered queries the response belongs to
+func matchDNSQuery(stats *DNSResponse) func(*DNSQueryStats) bool {
+	return func(q *DNSQueryStats) bool {
+		return q.Rcode == "" && q.ID == stats.QueryID &&
+			q.SrcIP == stats.ClientIP && q.SrcPort == stats.ClientPort &&
+			q.DNSServer == stats.ServerIP && q.ServerPort == stats.ServerPort &&
+			q.Domain == stats.Domain && q.QueryType == stats.QueryType
+	}
 }
 
 func (sc *StatsCollector) CleanupOldStats() {
@@ -201,10 +1050,75 @@ func (sc *StatsCollector) CleanupOldStats() {
 			delete(sc.PortWindows, key)
 		}
 	}
+
+	// cleanup dns rcode stats
+	for _, table := range []map[string]*DNSRcodeStats{sc.DNSDomains, sc.DNSClients} {
+		for key, stats := range table {
+			if stats.WindowEnd.Before(threshold) {
+				delete(table, key)
+			}
+		}
+	}
+
+	// cleanup tls host and fingerprint stats
+	for key, stats := range sc.TLSHosts {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.TLSHosts, key)
+		}
+	}
+	for key, stats := range sc.TLSFingerprints {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.TLSFingerprints, key)
+		}
+	}
+
+	// cleanup database server stats
+	for key, stats := range sc.DBServers {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.DBServers, key)
+		}
+	}
+
+	// cleanup ssh authentication stats
+	for key, stats := range sc.SSHAuthSources {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.SSHAuthSources, key)
+		}
+	}
+	for key, stats := range sc.SSHAuthUsers {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.SSHAuthUsers, key)
+		}
+	}
+
+	// cleanup web client and log stats
+	for key, stats := range sc.WebClients {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.WebClients, key)
+		}
+	}
+	for key, stats := range sc.WebLogs {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.WebLogs, key)
+		}
+	}
+	for key, stats := range sc.WebAttacks {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.WebAttacks, key)
+		}
+	}
+
+	// cleanup log source stats
+	for key, stats := range sc.LogSources {
+		if stats.WindowEnd.Before(threshold) {
+			delete(sc.LogSources, key)
+		}
+	}
 }
 
-func NewConnectionWindowStats(srcIP, dstIP string) *ConnectionWindowStats {
+func NewConnectionWindowStats(protocol Protocol, srcIP, dstIP string) *ConnectionWindowStats {
 	return &ConnectionWindowStats{
+		Protocol:    protocol,
 		SrcIP:       srcIP,
 		DstIP:       dstIP,
 		Ports:       make(map[uint16]int),
@@ -230,6 +1144,8 @@ func (sc *StatsCollector) GetConnectionWindows() map[string]*ConnectionWindowSta
 	for k, v := range sc.ConnectionWindows {
 		// deep copy ConnectionWindowStats
 		newStats := &ConnectionWindowStats{
+			Protocol:    v.Protocol,
+			Direction:   v.Direction,
 			SrcIP:       v.SrcIP,
 			DstIP:       v.DstIP,
 			Ports:       make(map[uint16]int),
@@ -254,6 +1170,8 @@ func (sc *StatsCollector) GetPortWindows() map[string]*PortWindowStats {
 	for k, v := range sc.PortWindows {
 		// deep copy PortWindowStats
 		newStats := &PortWindowStats{
+			Protocol:    v.Protocol,
+			Direction:   v.Direction,
 			DstIP:       v.DstIP,
 			DstPort:     v.DstPort,
 			UniqueIPs:   make(map[string]struct{}),
@@ -273,36 +1191,259 @@ func (sc *StatsCollector) GetNewConnections() []*NewConnectionStats {
 	sc.mutex.RLock()
 	defer sc.mutex.RUnlock()
 
-	var size int
-	if sc.connIsFull {
-		size = sc.maxResults
-	} else {
-		size = sc.connIndex
+	return sc.connections.items()
+}
+
+func (sc *StatsCollector) GetTLSSessions() []*TLSSession {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.tlsSessions.items()
+}
+
+func (sc *StatsCollector) GetHTTPRequests() []*HTTPRequest {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.httpRequests.items()
+}
+
+func (sc *StatsCollector) GetDBRequests() []*DBRequest {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.dbRequests.items()
+}
+
+// GetDBServerStats returns a copy of the login and command counts per
+// database server
+func (sc *StatsCollector) GetDBServerStats() map[string]*DBServerStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*DBServerStats, len(sc.DBServers))
+	for k, v := range sc.DBServers {
+		stats := *v
+		stats.CommandTypes = make(map[string]int, len(v.CommandTypes))
+		for command, count := range v.CommandTypes {
+			stats.CommandTypes[command] = count
+		}
+		stats.Clients = make(map[string]struct{}, len(v.Clients))
+		for ip := range v.Clients {
+			stats.Clients[ip] = struct{}{}
+		}
+		result[k] = &stats
 	}
+	return result
+}
+
+func (sc *StatsCollector) GetSSHAuthEvents() []*SSHAuthEvent {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
 
-	results := make([]*NewConnectionStats, size)
-	for i := 0; i < size; i++ {
-		idx := (sc.connIndex - size + i + sc.maxResults) % sc.maxResults
-		results[i] = sc.NewConnections[idx]
+	return sc.sshAuthEvents.items()
+}
+
+// GetSSHAuthSourceStats returns a copy of the SSH authentication attempts
+// per source
+func (sc *StatsCollector) GetSSHAuthSourceStats() map[string]*SSHAuthSourceStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*SSHAuthSourceStats, len(sc.SSHAuthSources))
+	for k, v := range sc.SSHAuthSources {
+		stats := *v
+		stats.Users = make(map[string]int, len(v.Users))
+		for user, count := range v.Users {
+			stats.Users[user] = count
+		}
+		result[k] = &stats
 	}
-	return results
+	return result
 }
 
-func (sc *StatsCollector) GetDNSQueries() []*DNSQueryStats {
+// GetSSHAuthUserStats returns a copy of the SSH authentication attempts per
+// user name
+func (sc *StatsCollector) GetSSHAuthUserStats() map[string]*SSHAuthUserStats {
 	sc.mutex.RLock()
 	defer sc.mutex.RUnlock()
 
-	var size int
-	if sc.dnsIsFull {
-		size = sc.maxResults
-	} else {
-		size = sc.dnsIndex
+	result := make(map[string]*SSHAuthUserStats, len(sc.SSHAuthUsers))
+	for k, v := range sc.SSHAuthUsers {
+		stats := *v
+		stats.Sources = make(map[string]int, len(v.Sources))
+		for ip, count := range v.Sources {
+			stats.Sources[ip] = count
+		}
+		result[k] = &stats
+	}
+	return result
+}
+
+func (sc *StatsCollector) GetWebLogRequests() []*WebLogRequest {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.webLogRequests.items()
+}
+
+// GetWebClientStats returns a copy of the access log requests per client
+func (sc *StatsCollector) GetWebClientStats() map[string]*WebClientStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*WebClientStats, len(sc.WebClients))
+	for k, v := range sc.WebClients {
+		stats := *v
+		stats.Statuses = copyCounts(v.Statuses)
+		stats.Paths = copyCounts(v.Paths)
+		result[k] = &stats
+	}
+	return result
+}
+
+// GetWebLogStats returns a copy of the requests per access log
+func (sc *StatsCollector) GetWebLogStats() map[string]*WebLogStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*WebLogStats, len(sc.WebLogs))
+	for k, v := range sc.WebLogs {
+		stats := *v
+		stats.Statuses = copyCounts(v.Statuses)
+		stats.Paths = copyCounts(v.Paths)
+		stats.Clients = make(map[string]struct{}, len(v.Clients))
+		for ip := range v.Clients {
+			stats.Clients[ip] = struct{}{}
+		}
+		result[k] = &stats
+	}
+	return result
+}
+
+// GetWebAttackStats returns a copy of the signature matches per client
+func (sc *StatsCollector) GetWebAttackStats() map[string]*WebAttackStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*WebAttackStats, len(sc.WebAttacks))
+	for k, v := range sc.WebAttacks {
+		stats := *v
+		stats.Rules = copyCounts(v.Rules)
+		stats.Categories = copyCounts(v.Categories)
+		result[k] = &stats
+	}
+	return result
+}
+
+func (sc *StatsCollector) GetLogMatches() []*LogMatch {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.logMatches.items()
+}
+
+// GetLogMatchStats returns a copy of the match counts per log source and
+// address, keyed by "source ip"
+func (sc *StatsCollector) GetLogMatchStats() map[string]*LogMatchStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*LogMatchStats, len(sc.LogSources))
+	for k, v := range sc.LogSources {
+		stats := *v
+		result[k] = &stats
+	}
+	return result
+}
+
+func copyCounts[K comparable](counts map[K]int) map[K]int {
+	result := make(map[K]int, len(counts))
+	for k, v := range counts {
+		result[k] = v
+	}
+	return result
+}
+
+// GetTLSFingerprintStats returns a copy of the TLS connection counts per
+// client fingerprint
+func (sc *StatsCollector) GetTLSFingerprintStats() map[string]*TLSFingerprintStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*TLSFingerprintStats, len(sc.TLSFingerprints))
+	for k, v := range sc.TLSFingerprints {
+		stats := *v
+		stats.Clients = make(map[string]struct{}, len(v.Clients))
+		for ip := range v.Clients {
+			stats.Clients[ip] = struct{}{}
+		}
+		stats.ServerNames = make(map[string]struct{}, len(v.ServerNames))
+		for name := range v.ServerNames {
+			stats.ServerNames[name] = struct{}{}
+		}
+		result[k] = &stats
+	}
+	return result
+}
+
+// GetTLSHostStats returns a copy of the TLS connection counts per client
+// and server name
+func (sc *StatsCollector) GetTLSHostStats() map[string]*TLSHostStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*TLSHostStats, len(sc.TLSHosts))
+	for k, v := range sc.TLSHosts {
+		stats := *v
+		stats.ServerIPs = make(map[string]struct{}, len(v.ServerIPs))
+		for ip := range v.ServerIPs {
+			stats.ServerIPs[ip] = struct{}{}
+		}
+		result[k] = &stats
 	}
+	return result
+}
 
-	results := make([]*DNSQueryStats, size)
-	for i := 0; i < size; i++ {
-		idx := (sc.dnsIndex - size + i + sc.maxResults) % sc.maxResults
-		results[i] = sc.DNSQueries[idx]
+func (sc *StatsCollector) GetDNSQueries() []*DNSQueryStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.dnsQueries.items()
+}
+
+// GetDNSDomainStats returns a copy of the rcode stats per base domain
+func (sc *StatsCollector) GetDNSDomainStats() map[string]*DNSRcodeStats {
+	return sc.copyRcodeStats(sc.DNSDomains)
+}
+
+// GetDNSClientStats returns a copy of the rcode stats per client IP
+func (sc *StatsCollector) GetDNSClientStats() map[string]*DNSRcodeStats {
+	return sc.copyRcodeStats(sc.DNSClients)
+}
+
+func (sc *StatsCollector) copyRcodeStats(table map[string]*DNSRcodeStats) map[string]*DNSRcodeStats {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	result := make(map[string]*DNSRcodeStats, len(table))
+	for k, v := range table {
+		stats := *v
+		result[k] = &stats
 	}
-	return results
+	return result
+}
+
+func (sc *StatsCollector) GetFlows() []*FlowRecord {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.flows.items()
+}
+
+func (sc *StatsCollector) GetEvents() []*Event {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+
+	return sc.events.items()
 }
@@ -1 +1,24 @@
 package utils
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// FormatAddr joins an IP and port, bracketing IPv6 addresses ("[::1]:53")
+func FormatAddr(ip string, port uint16) string {
+	return net.JoinHostPort(ip, strconv.Itoa(int(port)))
+}
+
+// BaseDomain returns the registered domain of a name ("www.example.co.uk"
+// -> "example.co.uk"), or the name itself if it has none
+func BaseDomain(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if base, err := publicsuffix.EffectiveTLDPlusOne(name); err == nil {
+		return base
+	}
+	return name
+}